@@ -1,14 +1,31 @@
 package main
 
 import (
+	"bookstore/internal/config"
+	"bookstore/internal/health"
+	"bookstore/internal/logging"
+	"bookstore/internal/mail"
+	"bookstore/internal/metrics"
 	"bookstore/internal/middleware"
+	"bookstore/internal/migration"
+	"bookstore/internal/payment"
+	"bookstore/internal/repository"
 	"bookstore/internal/router"
-	"fmt"
+	"bookstore/internal/server"
+	"bookstore/internal/tracing"
+	"bookstore/internal/worker"
+	"bookstore/pkg/utils"
+	"context"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -16,28 +33,39 @@ import (
 func main() {
 	headerLog := "BookStore"
 
-	err := godotenv.Load()
+	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Error loading .env file")
+		log.Fatalf("[%v]Could not load configuration: %v", headerLog, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("[%v]Invalid configuration: %v", headerLog, err)
 	}
 
-	host := os.Getenv("DB_HOST")
-	user := os.Getenv("POSTGRES_USER")
-	password := os.Getenv("POSTGRES_PASSWORD")
-	dbname := os.Getenv("POSTGRES_DB")
-	port := os.Getenv("DB_PORT")
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	logger := logging.New(os.Stdout, level)
+	// the standard log package writes through it too, as info lines
+	slog.SetDefault(logger)
 
-	// connection to db
-	conn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-		host,
-		user,
-		password,
-		dbname,
-		port,
-	)
+	repository.SetQueryTimeout(cfg.Database.QueryTimeout)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:     cfg.Tracing.Exporter,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		File:         cfg.Tracing.File,
+		ServiceName:  cfg.Tracing.ServiceName,
+		SampleRatio:  cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		log.Fatalf("[%v]Could not set up tracing: %v", headerLog, err)
+	}
+
+	// every query is traced, gorm only checks the connection
+	tracedDB, err := tracing.OpenDB("pgx", cfg.Database.DSN())
+	if err != nil {
+		log.Fatalf("[%v]Could not open the database: %v", headerLog, err)
+	}
 
-	db, err := gorm.Open(postgres.Open(conn), &gorm.Config{})
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: tracedDB}), &gorm.Config{})
 
 	if err != nil {
 		log.Fatalf("[%v]Could not connect to the database: %v", headerLog, err)
@@ -48,15 +76,102 @@ func main() {
 		log.Fatalf("Failed to retrieve sql.DB from GORM: %v", err)
 	}
 
-	r := gin.Default()
+	provider, err := payment.NewProvider(cfg.Payment.Provider)
+	if err != nil {
+		log.Fatalf("[%v]Invalid PAYMENT_PROVIDER: %v", headerLog, err)
+	}
+
+	mailer, err := mail.NewSender(cfg.Mail.Sender)
+	if err != nil {
+		log.Fatalf("[%v]Invalid MAIL_SENDER: %v", headerLog, err)
+	}
+
+	migrator, err := migration.NewMigrator(sqlDB)
+	if err != nil {
+		log.Fatalf("[%v]Could not load migrations: %v", headerLog, err)
+	}
+
+	checker := health.NewChecker(health.DefaultCheckTimeout)
+	checker.Add("postgres", health.PingDatabase(sqlDB))
+	checker.Add("migrations", migrator.CheckVersion)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	appMetrics := metrics.New(registry)
+	if err := appMetrics.RegisterDB("postgres", sqlDB); err != nil {
+		log.Fatalf("[%v]Could not register database metrics: %v", headerLog, err)
+	}
+
+	r := gin.New()
+	// Recovery runs inside AccessLog and Metrics so panics are counted as 500s
+	r.Use(
+		middleware.RequestID(logger),
+		middleware.Tracing(),
+		middleware.AccessLog(),
+		middleware.Metrics(appMetrics),
+		middleware.Recovery(),
+	)
+
+	signer := utils.NewTokenSigner(cfg.Auth.SecretKey)
+	tokenRepo := repository.NewTokenRepository(sqlDB)
+	authMiddleware := middleware.AuthMiddleware(signer, tokenRepo)
+	tokenCleanup := worker.NewPeriodic("token-cleanup", cfg.Auth.TokenCleanupInterval, func(ctx context.Context) error {
+		deleted, err := tokenRepo.DeleteExpiredTokens(ctx)
+		if err == nil && deleted > 0 {
+			logger.Info("[Auth] Deleted expired tokens", "deleted", deleted)
+		}
+		return err
+	})
+	idempotencyRepo := repository.NewIdempotencyRepository(sqlDB)
+	idempotencyMiddleware := middleware.Idempotency(idempotencyRepo, cfg.Idempotency.KeyTTL)
+	idempotencyCleanup := worker.NewPeriodic("idempotency-cleanup", cfg.Idempotency.CleanupInterval, func(ctx context.Context) error {
+		deleted, err := idempotencyRepo.DeleteExpiredIdempotencyKeys(ctx)
+		if err == nil && deleted > 0 {
+			logger.Info("[Idempotency] Deleted expired keys", "deleted", deleted)
+		}
+		return err
+	})
+
+	router.HealthRouter(r, checker, authMiddleware)
+	router.MetricsRouter(r, registry)
+	router.BookRouter(r, sqlDB, authMiddleware)
+	router.CustomerRouter(r, sqlDB, authMiddleware, mailer, signer)
+	router.OrderRouter(r, sqlDB, authMiddleware, idempotencyMiddleware, provider, appMetrics, cfg.Orders.CancelWindow)
 
-	authMiddleware := middleware.AuthMiddleware()
+	srv := server.New(r, server.Config{
+		Addr:              cfg.HTTP.Addr,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+		MaxHeaderBytes:    cfg.HTTP.MaxHeaderBytes,
+		ShutdownTimeout:   cfg.HTTP.ShutdownTimeout,
+		DrainDelay:        cfg.HTTP.DrainDelay,
+	})
+	// Fail readiness first so no new traffic arrives while requests drain
+	srv.RegisterOnShutdown(checker.Drain)
+	// workers use the database, they stop before it closes
+	idempotencyCleanup.Start()
+	tokenCleanup.Start()
+	srv.AddCloser("idempotency-cleanup", idempotencyCleanup.Stop)
+	srv.AddCloser("token-cleanup", tokenCleanup.Stop)
+	srv.AddCloser("database", sqlDB.Close)
+	srv.AddCloser("tracing", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return shutdownTracing(ctx)
+	})
 
-	router.BookRouter(r, sqlDB)
-	router.CustomerRouter(r, sqlDB)
-	router.OrderRouter(r, sqlDB, authMiddleware)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// a second signal kills the process without waiting
+		<-ctx.Done()
+		stop()
+	}()
 
-	if err := r.Run(":8080"); err != nil {
+	if err := srv.Run(ctx); err != nil {
 		log.Fatalf("[%v]Could not run server: %v", headerLog, err)
 	}
+	log.Printf("[%v]Server stopped", headerLog)
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bookstore/internal/config"
+	"bookstore/internal/migration"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const usage = `Usage: migrate <command>
+
+Commands:
+  up        apply all pending migrations
+  down [N]  roll back the last N migrations (default 1)
+  status    list migrations and whether they are applied
+  redo      roll back and re-apply the latest migration
+`
+
+func main() {
+	logHeader := "Migrate"
+
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("[%v] Could not load configuration: %v", logHeader, err)
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		log.Fatalf("[%v] Invalid configuration: %v", logHeader, err)
+	}
+
+	db, err := gorm.Open(postgres.Open(cfg.Database.DSN()), &gorm.Config{})
+	if err != nil {
+		log.Fatalf("[%v] Could not connect to the database: %v", logHeader, err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatalf("[%v] Failed to retrieve sql.DB from GORM: %v", logHeader, err)
+	}
+	defer sqlDB.Close()
+
+	migrator, err := migration.NewMigrator(sqlDB)
+	if err != nil {
+		log.Fatalf("[%v] Could not load migrations: %v", logHeader, err)
+	}
+
+	switch command := flag.Arg(0); command {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			log.Fatalf("[%v] %v", logHeader, err)
+		}
+		log.Printf("[%v] Applied %d migration(s)", logHeader, applied)
+
+	case "down":
+		steps := 1
+		if flag.NArg() > 1 {
+			steps, err = strconv.Atoi(flag.Arg(1))
+			if err != nil || steps < 1 {
+				log.Fatalf("[%v] down expects a positive number of steps, got %q", logHeader, flag.Arg(1))
+			}
+		}
+		reverted, err := migrator.Down(steps)
+		if err != nil {
+			log.Fatalf("[%v] %v", logHeader, err)
+		}
+		log.Printf("[%v] Rolled back %d migration(s)", logHeader, reverted)
+
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatalf("[%v] %v", logHeader, err)
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if status.Modified {
+				state += " (modified since applied)"
+			}
+			fmt.Printf("%04d  %-40s %s\n", status.Version, status.Name, state)
+		}
+
+	case "redo":
+		if err := migrator.Redo(); err != nil {
+			log.Fatalf("[%v] %v", logHeader, err)
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
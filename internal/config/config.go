@@ -0,0 +1,380 @@
+package config
+
+import (
+	"bookstore/internal/logging"
+	"bookstore/pkg/utils"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// MinSecretKeyLength is the shortest accepted JWT signing key, in bytes.
+const MinSecretKeyLength = 32
+
+// Config is the whole application configuration. Values are read, from
+// lowest to highest precedence, from the defaults, the CONFIG_FILE
+// (YAML or JSON), the .env file and the process environment.
+type Config struct {
+	Database    DatabaseConfig    `yaml:"database"`
+	Auth        AuthConfig        `yaml:"auth"`
+	HTTP        HTTPConfig        `yaml:"http"`
+	Payment     PaymentConfig     `yaml:"payment"`
+	Orders      OrdersConfig      `yaml:"orders"`
+	Mail        MailConfig        `yaml:"mail"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Log         LogConfig         `yaml:"log"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	AdminEmail  string            `yaml:"adminEmail"` // Customer promoted to the first admin by the seed script
+}
+
+type DatabaseConfig struct {
+	URL          string        `yaml:"url"` // Used as is when set, instead of the other fields
+	Host         string        `yaml:"host"`
+	Port         string        `yaml:"port"`
+	User         string        `yaml:"user"`
+	Password     string        `yaml:"password"`
+	Name         string        `yaml:"name"`
+	SSLMode      string        `yaml:"sslMode"`
+	QueryTimeout time.Duration `yaml:"queryTimeout"` // 0 disables the limit
+}
+
+type AuthConfig struct {
+	SecretKey            string        `yaml:"secretKey"`            // HMAC key signing the access tokens
+	TokenCleanupInterval time.Duration `yaml:"tokenCleanupInterval"` // How often expired refresh and revoked tokens are deleted
+}
+
+type HTTPConfig struct {
+	Addr              string        `yaml:"addr"`
+	ReadTimeout       time.Duration `yaml:"readTimeout"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout"`
+	MaxHeaderBytes    int           `yaml:"maxHeaderBytes"`
+	ShutdownTimeout   time.Duration `yaml:"shutdownTimeout"`
+	DrainDelay        time.Duration `yaml:"drainDelay"` // Readiness fails this long before the listener closes, 0 disables
+}
+
+type PaymentConfig struct {
+	Provider string `yaml:"provider"`
+}
+
+type MailConfig struct {
+	Sender string `yaml:"sender"`
+}
+
+type OrdersConfig struct {
+	CancelWindow time.Duration `yaml:"cancelWindow"` // How long after paying a customer may cancel
+}
+
+type IdempotencyConfig struct {
+	KeyTTL          time.Duration `yaml:"keyTTL"`
+	CleanupInterval time.Duration `yaml:"cleanupInterval"` // How often expired keys are deleted
+}
+
+type LogConfig struct {
+	Level string `yaml:"level"` // debug, info, warn or error
+}
+
+type TracingConfig struct {
+	Exporter     string  `yaml:"exporter"`     // none, stdout, file or otlp
+	OTLPEndpoint string  `yaml:"otlpEndpoint"` // Collector URL, empty uses the OTEL_EXPORTER_OTLP_* variables
+	File         string  `yaml:"file"`         // Written by the file exporter
+	ServiceName  string  `yaml:"serviceName"`
+	SampleRatio  float64 `yaml:"sampleRatio"` // 0 to 1
+}
+
+// Default returns the configuration used for every value that is not set.
+// Payments get enough write time to reach the provider and back.
+func Default() Config {
+	return Config{
+		Database: DatabaseConfig{
+			Port:         "5432",
+			SSLMode:      "disable",
+			QueryTimeout: 5 * time.Second,
+		},
+		HTTP: HTTPConfig{
+			Addr:              ":8080",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			ShutdownTimeout:   30 * time.Second,
+			DrainDelay:        5 * time.Second,
+		},
+		Auth:    AuthConfig{TokenCleanupInterval: time.Hour},
+		Payment: PaymentConfig{Provider: "fake"},
+		Orders:  OrdersConfig{CancelWindow: 24 * time.Hour},
+		Mail:    MailConfig{Sender: "log"},
+		Idempotency: IdempotencyConfig{
+			KeyTTL:          24 * time.Hour,
+			CleanupInterval: time.Hour,
+		},
+		Log: LogConfig{Level: "info"},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			File:        "traces.json",
+			ServiceName: "bookstore",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Load reads the configuration of the process. A missing .env file is not an
+// error, containers usually pass the variables directly. The result is not
+// validated, see Validate.
+func Load() (*Config, error) {
+	return LoadFrom(".env", os.LookupEnv)
+}
+
+// LoadFrom is Load with the .env path and the environment lookup given.
+func LoadFrom(dotEnvPath string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	dotEnv := map[string]string{}
+	if dotEnvPath != "" {
+		values, err := godotenv.Read(dotEnvPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("could not read %s: %w", dotEnvPath, err)
+		}
+		if values != nil {
+			dotEnv = values
+		}
+	}
+
+	// the process environment wins over .env
+	lookup := func(name string) (string, bool) {
+		if value, ok := lookupEnv(name); ok {
+			return value, true
+		}
+		value, ok := dotEnv[name]
+		return value, ok
+	}
+
+	cfg := Default()
+	if path, ok := lookup("CONFIG_FILE"); ok && path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(lookup); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// readFile overlays a YAML or JSON file, JSON being read as the YAML subset it is.
+// Unknown keys are rejected so typos do not go unnoticed.
+func (c *Config) readFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %s: %v", utils.ErrInvalidConfig, path, err)
+	}
+	return nil
+}
+
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+
+	str := func(name string, dst *string) {
+		if value, ok := lookup(name); ok {
+			*dst = strings.TrimSpace(value)
+		}
+	}
+	duration := func(name string, dst *time.Duration) {
+		if value, ok := lookup(name); ok && strings.TrimSpace(value) != "" {
+			parsed, err := time.ParseDuration(strings.TrimSpace(value))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%w: %s %q is not a duration", utils.ErrInvalidConfig, name, value))
+				return
+			}
+			*dst = parsed
+		}
+	}
+	integer := func(name string, dst *int) {
+		if value, ok := lookup(name); ok && strings.TrimSpace(value) != "" {
+			parsed, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%w: %s %q is not a number", utils.ErrInvalidConfig, name, value))
+				return
+			}
+			*dst = parsed
+		}
+	}
+	float := func(name string, dst *float64) {
+		if value, ok := lookup(name); ok && strings.TrimSpace(value) != "" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%w: %s %q is not a number", utils.ErrInvalidConfig, name, value))
+				return
+			}
+			*dst = parsed
+		}
+	}
+
+	str("DATABASE_URL", &c.Database.URL)
+	str("DB_HOST", &c.Database.Host)
+	str("DB_PORT", &c.Database.Port)
+	str("POSTGRES_USER", &c.Database.User)
+	str("POSTGRES_PASSWORD", &c.Database.Password)
+	str("POSTGRES_DB", &c.Database.Name)
+	str("DB_SSLMODE", &c.Database.SSLMode)
+	duration("DB_QUERY_TIMEOUT", &c.Database.QueryTimeout)
+
+	str("SECRET_KEY", &c.Auth.SecretKey)
+	duration("TOKEN_CLEANUP_INTERVAL", &c.Auth.TokenCleanupInterval)
+
+	str("HTTP_ADDR", &c.HTTP.Addr)
+	duration("HTTP_READ_TIMEOUT", &c.HTTP.ReadTimeout)
+	duration("HTTP_READ_HEADER_TIMEOUT", &c.HTTP.ReadHeaderTimeout)
+	duration("HTTP_WRITE_TIMEOUT", &c.HTTP.WriteTimeout)
+	duration("HTTP_IDLE_TIMEOUT", &c.HTTP.IdleTimeout)
+	integer("HTTP_MAX_HEADER_BYTES", &c.HTTP.MaxHeaderBytes)
+	duration("SHUTDOWN_TIMEOUT", &c.HTTP.ShutdownTimeout)
+	duration("SHUTDOWN_DRAIN_DELAY", &c.HTTP.DrainDelay)
+
+	str("PAYMENT_PROVIDER", &c.Payment.Provider)
+	duration("ORDER_CANCEL_WINDOW", &c.Orders.CancelWindow)
+	str("MAIL_SENDER", &c.Mail.Sender)
+	duration("IDEMPOTENCY_KEY_TTL", &c.Idempotency.KeyTTL)
+	duration("IDEMPOTENCY_CLEANUP_INTERVAL", &c.Idempotency.CleanupInterval)
+
+	str("LOG_LEVEL", &c.Log.Level)
+
+	str("TRACING_EXPORTER", &c.Tracing.Exporter)
+	str("TRACING_OTLP_ENDPOINT", &c.Tracing.OTLPEndpoint)
+	str("TRACING_FILE", &c.Tracing.File)
+	str("TRACING_SERVICE_NAME", &c.Tracing.ServiceName)
+	float("TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio)
+
+	str("ADMIN_EMAIL", &c.AdminEmail)
+
+	return errors.Join(errs...)
+}
+
+// Validate checks everything the server needs, every problem is reported at once.
+func (c *Config) Validate() error {
+	errs := []error{c.Database.Validate(), validateSecretKey(c.Auth.SecretKey)}
+
+	positive := map[string]time.Duration{
+		"auth.tokenCleanupInterval":   c.Auth.TokenCleanupInterval,
+		"http.readTimeout":            c.HTTP.ReadTimeout,
+		"http.readHeaderTimeout":      c.HTTP.ReadHeaderTimeout,
+		"http.writeTimeout":           c.HTTP.WriteTimeout,
+		"http.idleTimeout":            c.HTTP.IdleTimeout,
+		"http.shutdownTimeout":        c.HTTP.ShutdownTimeout,
+		"orders.cancelWindow":         c.Orders.CancelWindow,
+		"idempotency.keyTTL":          c.Idempotency.KeyTTL,
+		"idempotency.cleanupInterval": c.Idempotency.CleanupInterval,
+	}
+	for _, name := range sortedKeys(positive) {
+		if positive[name] <= 0 {
+			errs = append(errs, fmt.Errorf("%w: %s must be positive", utils.ErrInvalidConfig, name))
+		}
+	}
+	if c.HTTP.DrainDelay < 0 {
+		errs = append(errs, fmt.Errorf("%w: http.drainDelay cannot be negative", utils.ErrInvalidConfig))
+	}
+	if c.HTTP.MaxHeaderBytes <= 0 {
+		errs = append(errs, fmt.Errorf("%w: http.maxHeaderBytes must be positive", utils.ErrInvalidConfig))
+	}
+	if c.HTTP.Addr == "" {
+		errs = append(errs, fmt.Errorf("%w: http.addr is required", utils.ErrInvalidConfig))
+	}
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("%w: log.level (LOG_LEVEL) %q is not one of debug, info, warn or error", utils.ErrInvalidConfig, c.Log.Level))
+	}
+	errs = append(errs, c.Tracing.Validate())
+
+	return errors.Join(errs...)
+}
+
+// Validate checks the connection settings, which is all the migrate and
+// seed commands need.
+func (d DatabaseConfig) Validate() error {
+	var errs []error
+	if d.QueryTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%w: database.queryTimeout cannot be negative", utils.ErrInvalidConfig))
+	}
+	if d.URL != "" {
+		return errors.Join(errs...)
+	}
+
+	required := map[string]string{
+		"database.host (DB_HOST)":       d.Host,
+		"database.user (POSTGRES_USER)": d.User,
+		"database.name (POSTGRES_DB)":   d.Name,
+	}
+	for _, name := range sortedKeys(required) {
+		if required[name] == "" {
+			errs = append(errs, fmt.Errorf("%w: %s is required", utils.ErrInvalidConfig, name))
+		}
+	}
+	if _, err := strconv.Atoi(d.Port); err != nil {
+		errs = append(errs, fmt.Errorf("%w: database.port %q is not a number", utils.ErrInvalidConfig, d.Port))
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the ratio only, the exporter and its settings are checked
+// by tracing.Setup, like the payment provider and mail sender by theirs.
+func (t TracingConfig) Validate() error {
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return fmt.Errorf("%w: tracing.sampleRatio must be between 0 and 1", utils.ErrInvalidConfig)
+	}
+	return nil
+}
+
+// DSN is the connection string for the Postgres driver.
+func (d DatabaseConfig) DSN() string {
+	if d.URL != "" {
+		return d.URL
+	}
+	return fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
+		d.Host,
+		d.User,
+		d.Password,
+		d.Name,
+		d.Port,
+		d.SSLMode,
+	)
+}
+
+// validateSecretKey rejects keys that are short or made of a single repeated
+// character, e.g. the empty SECRET_KEY of cp.env.
+func validateSecretKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: auth.secretKey (SECRET_KEY) is required", utils.ErrInvalidConfig)
+	}
+	if len(key) < MinSecretKeyLength {
+		return fmt.Errorf("%w: SECRET_KEY must be at least %d bytes long", utils.ErrWeakSecretKey, MinSecretKeyLength)
+	}
+	if strings.Count(key, key[:1]) == len(key) {
+		return fmt.Errorf("%w: SECRET_KEY repeats a single character", utils.ErrWeakSecretKey)
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,8 +1,10 @@
 package handler
 
 import (
+	"bookstore/internal/handler/request"
 	"bookstore/internal/model"
 	"bookstore/internal/service"
+	"bookstore/pkg/money"
 	"bookstore/pkg/utils"
 	"errors"
 	"net/http"
@@ -20,13 +22,63 @@ func NewBookHandler(service service.BookService) *BookHandler {
 }
 
 func (h *BookHandler) GetBooks(c *gin.Context) {
-	books, err := h.Service.GetBooks()
+	var request request.BookListRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	query, err := toBookQuery(request)
+	if err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "Invalid price filter")
+		return
+	}
+
+	page, err := h.Service.GetBooks(c.Request.Context(), query)
 	if err != nil {
+		if errors.Is(err, utils.ErrInvalidBookQuery) {
+			ErrorHandler(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrInvalidCursor) {
+			ErrorHandler(c, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
 		ErrorHandler(c, http.StatusInternalServerError, "Failed to retrieve books")
 		return
 	}
 
-	c.JSON(http.StatusOK, books)
+	c.JSON(http.StatusOK, page)
+}
+
+func toBookQuery(request request.BookListRequest) (model.BookQuery, error) {
+	query := model.BookQuery{
+		Title:   request.Title,
+		Author:  request.Author,
+		SortBy:  model.BookSortField(request.Sort),
+		SortDir: model.SortDirection(request.Order),
+		Limit:   request.Limit,
+		Offset:  request.Offset,
+		Cursor:  request.Cursor,
+	}
+
+	if request.MinPrice != "" {
+		price, err := money.Parse(request.MinPrice, money.DefaultCurrency)
+		if err != nil {
+			return query, err
+		}
+		query.MinPrice = &price
+	}
+
+	if request.MaxPrice != "" {
+		price, err := money.Parse(request.MaxPrice, money.DefaultCurrency)
+		if err != nil {
+			return query, err
+		}
+		query.MaxPrice = &price
+	}
+
+	return query, nil
 }
 
 func (h *BookHandler) GetBookById(c *gin.Context) {
@@ -36,7 +88,7 @@ func (h *BookHandler) GetBookById(c *gin.Context) {
 		return
 	}
 
-	book, err := h.Service.GetBookById(id)
+	book, err := h.Service.GetBookById(c.Request.Context(), id)
 	if err != nil {
 		if errors.Is(err, utils.ErrBookNotFound) {
 			ErrorHandler(c, http.StatusNotFound, "Book not found")
@@ -54,8 +106,12 @@ func (h *BookHandler) CreateBook(c *gin.Context) {
 		ErrorHandler(c, http.StatusBadRequest, "")
 		return
 	}
-	err := h.Service.CreateBook(&book)
+	err := h.Service.CreateBook(c.Request.Context(), &book)
 	if err != nil {
+		if errors.Is(err, utils.ErrInvalidBookPrice) {
+			ErrorHandler(c, http.StatusBadRequest, "Price must be greater than zero")
+			return
+		}
 		ErrorHandler(c, http.StatusInternalServerError, "Failed to create book")
 		return
 	}
@@ -72,14 +128,118 @@ func (h *BookHandler) UpdateBook(c *gin.Context) {
 		return
 	}
 
-	err := h.Service.UpdateBook(&book)
+	err := h.Service.UpdateBook(c.Request.Context(), &book)
+	if err != nil {
+		if errors.Is(err, utils.ErrBookNotFound) {
+			ErrorHandler(c, http.StatusNotFound, "Book not found")
+			return
+		}
+		if errors.Is(err, utils.ErrInvalidBookPrice) {
+			ErrorHandler(c, http.StatusBadRequest, "Price must be greater than zero")
+			return
+		}
+		ErrorHandler(c, http.StatusInternalServerError, "Failed to update book")
+		return
+	}
+
+	c.JSON(http.StatusOK, book)
+}
+
+func (h *BookHandler) AdjustStock(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	var request request.AdjustStockRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	movement := model.StockMovement{
+		BookID: int64(id),
+		Change: request.Change,
+		Reason: model.StockReason(request.Reason),
+		Note:   request.Note,
+	}
+
+	if err := h.Service.AdjustStock(c.Request.Context(), &movement); err != nil {
+		if errors.Is(err, utils.ErrBookNotFound) {
+			ErrorHandler(c, http.StatusNotFound, "Book not found")
+		} else if errors.Is(err, utils.ErrInsufficientStock) {
+			ErrorHandler(c, http.StatusConflict, "Not enough stock for this adjustment")
+		} else if errors.Is(err, utils.ErrInvalidStockChange) {
+			ErrorHandler(c, http.StatusBadRequest, err.Error())
+		} else {
+			ErrorHandler(c, http.StatusInternalServerError, "Failed to adjust stock")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, movement)
+}
+
+// ArchiveBook withdraws a book from sale, it stays visible in order history.
+func (h *BookHandler) ArchiveBook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	book, err := h.Service.ArchiveBook(c.Request.Context(), id)
 	if err != nil {
 		if errors.Is(err, utils.ErrBookNotFound) {
 			ErrorHandler(c, http.StatusNotFound, "Book not found")
 			return
 		}
+		ErrorHandler(c, http.StatusInternalServerError, "Failed to archive book")
+		return
+	}
 
+	c.JSON(http.StatusOK, book)
+}
+
+func (h *BookHandler) RestoreBook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	book, err := h.Service.RestoreBook(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, utils.ErrBookNotFound) {
+			ErrorHandler(c, http.StatusNotFound, "Book not found")
+			return
+		}
+		ErrorHandler(c, http.StatusInternalServerError, "Failed to restore book")
+		return
 	}
 
 	c.JSON(http.StatusOK, book)
 }
+
+// DeleteBook removes a book for good, only possible while no order refers to it.
+func (h *BookHandler) DeleteBook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	if err := h.Service.DeleteBook(c.Request.Context(), id); err != nil {
+		if errors.Is(err, utils.ErrBookNotFound) {
+			ErrorHandler(c, http.StatusNotFound, "Book not found")
+		} else if errors.Is(err, utils.ErrBookReferenced) {
+			ErrorHandler(c, http.StatusConflict, "Book is part of existing orders, archive it instead")
+		} else {
+			ErrorHandler(c, http.StatusInternalServerError, "Failed to delete book")
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
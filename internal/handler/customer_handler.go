@@ -6,6 +6,8 @@ import (
 	"bookstore/internal/service"
 	"bookstore/pkg/utils"
 	"errors"
+	"strconv"
+	"strings"
 
 	"net/http"
 
@@ -28,7 +30,7 @@ func (h *CustomerHandler) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := h.Service.Login(request.Email, request.Password)
+	tokens, err := h.Service.Login(c.Request.Context(), request.Email, request.Password)
 	if err != nil {
 		if errors.Is(err, utils.ErrEmptyEmailOrPassword) {
 			ErrorHandler(c, http.StatusBadRequest, "Email or password cannot be empty")
@@ -44,8 +46,73 @@ func (h *CustomerHandler) Login(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Login successful",
-		"token":   token,
+		"message":      "Login successful",
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+	})
+}
+
+func (h *CustomerHandler) RefreshToken(c *gin.Context) {
+	var request request.RefreshTokenRequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	tokens, err := h.Service.RefreshToken(c.Request.Context(), request.RefreshToken)
+	if err != nil {
+		if errors.Is(err, utils.ErrRefreshTokenReused) {
+			ErrorHandler(c, http.StatusUnauthorized, "Refresh token was already used, please log in again")
+		} else if errors.Is(err, utils.ErrRefreshTokenExpired) {
+			ErrorHandler(c, http.StatusUnauthorized, "Refresh token expired, please log in again")
+		} else if errors.Is(err, utils.ErrInvalidRefreshToken) {
+			ErrorHandler(c, http.StatusUnauthorized, "Invalid refresh token")
+		} else {
+			ErrorHandler(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *CustomerHandler) Logout(c *gin.Context) {
+	var request request.LogoutRequest
+
+	id, exists := c.Get("customerID")
+	if !exists {
+		ErrorHandler(c, http.StatusUnauthorized, "")
+		return
+	}
+
+	// the refresh token is optional, an empty body only revokes the access token
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			ErrorHandler(c, http.StatusBadRequest, "")
+			return
+		}
+	}
+
+	err := h.Service.Logout(
+		c.Request.Context(),
+		id.(int),
+		c.GetString("tokenID"),
+		c.GetTime("tokenExpiresAt"),
+		request.RefreshToken,
+	)
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidRefreshToken) {
+			ErrorHandler(c, http.StatusBadRequest, "Invalid refresh token")
+		} else {
+			ErrorHandler(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Logged out",
 	})
 }
 
@@ -57,7 +124,7 @@ func (h *CustomerHandler) Register(c *gin.Context) {
 		return
 	}
 
-	err := h.Service.Register(&request)
+	err := h.Service.Register(c.Request.Context(), &request)
 
 	if err != nil {
 		if errors.Is(err, utils.ErrDuplicateEmail) {
@@ -72,3 +139,156 @@ func (h *CustomerHandler) Register(c *gin.Context) {
 		"message": "Customer registered successfully",
 	})
 }
+
+func (h *CustomerHandler) UpdateRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	var request request.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	if err := h.Service.UpdateRole(c.Request.Context(), id, model.Role(request.Role)); err != nil {
+		if errors.Is(err, utils.ErrCustomerNotFound) {
+			ErrorHandler(c, http.StatusNotFound, "Customer not found")
+		} else if errors.Is(err, utils.ErrInvalidRole) {
+			ErrorHandler(c, http.StatusBadRequest, "Invalid role")
+		} else {
+			ErrorHandler(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Role updated",
+	})
+}
+
+func (h *CustomerHandler) GetProfile(c *gin.Context) {
+	id, exists := c.Get("customerID")
+	if !exists {
+		ErrorHandler(c, http.StatusUnauthorized, "")
+		return
+	}
+
+	profile, err := h.Service.GetProfile(c.Request.Context(), id.(int))
+	if err != nil {
+		profileErrorHandler(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func (h *CustomerHandler) UpdateProfile(c *gin.Context) {
+	id, exists := c.Get("customerID")
+	if !exists {
+		ErrorHandler(c, http.StatusUnauthorized, "")
+		return
+	}
+
+	var request request.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	profile, err := h.Service.UpdateProfile(c.Request.Context(), id.(int), request)
+	if err != nil {
+		profileErrorHandler(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func (h *CustomerHandler) ChangePassword(c *gin.Context) {
+	id, exists := c.Get("customerID")
+	if !exists {
+		ErrorHandler(c, http.StatusUnauthorized, "")
+		return
+	}
+
+	var request request.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	if err := h.Service.ChangePassword(c.Request.Context(), id.(int), request); err != nil {
+		profileErrorHandler(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password changed, please log in again",
+	})
+}
+
+func (h *CustomerHandler) ChangeEmail(c *gin.Context) {
+	id, exists := c.Get("customerID")
+	if !exists {
+		ErrorHandler(c, http.StatusUnauthorized, "")
+		return
+	}
+
+	var request request.ChangeEmailRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	if err := h.Service.ChangeEmail(c.Request.Context(), id.(int), request); err != nil {
+		profileErrorHandler(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":      "Verification sent to the new email",
+		"pendingEmail": strings.ToLower(request.Email),
+	})
+}
+
+func (h *CustomerHandler) VerifyEmail(c *gin.Context) {
+	var request request.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	profile, err := h.Service.VerifyEmail(c.Request.Context(), request.Token)
+	if err != nil {
+		profileErrorHandler(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email changed",
+		"profile": profile,
+	})
+}
+
+// profileErrorHandler answers the errors shared by the /me endpoints.
+func profileErrorHandler(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrCustomerNotFound):
+		ErrorHandler(c, http.StatusNotFound, "Customer not found")
+	case errors.Is(err, utils.ErrNothingToUpdate):
+		ErrorHandler(c, http.StatusBadRequest, "Nothing to update")
+	case errors.Is(err, utils.ErrWrongPassword):
+		ErrorHandler(c, http.StatusForbidden, "Current password is wrong")
+	case errors.Is(err, utils.ErrDuplicateEmail):
+		ErrorHandler(c, http.StatusBadRequest, "Email already registered")
+	case errors.Is(err, utils.ErrVerificationTokenExpired):
+		ErrorHandler(c, http.StatusBadRequest, "Verification token expired, please request the change again")
+	case errors.Is(err, utils.ErrInvalidVerificationToken):
+		ErrorHandler(c, http.StatusBadRequest, "Invalid verification token")
+	default:
+		ErrorHandler(c, http.StatusInternalServerError, err.Error())
+	}
+}
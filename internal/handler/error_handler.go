@@ -7,8 +7,9 @@ import (
 )
 
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message"`
+	Error   string      `json:"error"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
 }
 
 func ErrorHandler(c *gin.Context, statusCode int, errMsg string) {
@@ -27,3 +28,14 @@ func ErrorHandler(c *gin.Context, statusCode int, errMsg string) {
 	})
 	c.Abort()
 }
+
+// ErrorHandlerWithDetails is ErrorHandler for errors that carry data the client
+// needs to act on, e.g. which books are out of stock.
+func ErrorHandlerWithDetails(c *gin.Context, statusCode int, errMsg string, details interface{}) {
+	c.JSON(statusCode, ErrorResponse{
+		Error:   http.StatusText(statusCode),
+		Message: errMsg,
+		Details: details,
+	})
+	c.Abort()
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"bookstore/internal/health"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthHandler struct {
+	checker *health.Checker
+}
+
+func NewHealthHandler(checker *health.Checker) *HealthHandler {
+	return &HealthHandler{checker: checker}
+}
+
+// Liveness only tells the process is serving requests, it never touches dependencies.
+func (h *HealthHandler) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusUp})
+}
+
+// Readiness fails while a dependency is down or the server is shutting down.
+// Check errors are left out, they are only shown by the detailed report.
+func (h *HealthHandler) Readiness(c *gin.Context) {
+	if h.checker.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": health.StatusShuttingDown})
+		return
+	}
+
+	report := h.checker.Run(c.Request.Context())
+	c.JSON(reportStatusCode(report), gin.H{"status": report.Status})
+}
+
+// Report returns the outcome and duration of every check.
+func (h *HealthHandler) Report(c *gin.Context) {
+	report := h.checker.Run(c.Request.Context())
+	c.JSON(reportStatusCode(report), report)
+}
+
+func reportStatusCode(report health.Report) int {
+	if report.Status != health.StatusUp {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
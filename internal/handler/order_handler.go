@@ -2,10 +2,17 @@ package handler
 
 import (
 	"bookstore/internal/handler/request"
+	"bookstore/internal/model"
+	"bookstore/internal/payment"
 	"bookstore/internal/service"
+	"bookstore/pkg/money"
 	"bookstore/pkg/utils"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -25,9 +32,65 @@ func (h *OrderHandler) PayOrder(c *gin.Context) {
 		return
 	}
 
-	err := h.service.PayOrder(id.(int))
+	var request request.PayOrderRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	paid, err := h.service.PayOrder(c.Request.Context(), id.(int), payment.Card{Number: request.CardNumber})
 
 	if err != nil {
+		var outOfStock *utils.OutOfStockError
+		if errors.As(err, &outOfStock) {
+			ErrorHandlerWithDetails(
+				c,
+				http.StatusConflict,
+				"Some books in your cart are out of stock",
+				outOfStock.Items,
+			)
+			return
+		}
+		var priceChanged *utils.PriceChangedError
+		if errors.As(err, &priceChanged) {
+			ErrorHandlerWithDetails(
+				c,
+				http.StatusConflict,
+				"Some prices in your cart have changed, please review and reprice the cart",
+				priceChanged.Items,
+			)
+			return
+		}
+		var unavailable *utils.UnavailableItemsError
+		if errors.As(err, &unavailable) {
+			ErrorHandlerWithDetails(
+				c,
+				http.StatusConflict,
+				"Some books in your cart are no longer available, please remove them",
+				unavailable.Items,
+			)
+			return
+		}
+		if errors.Is(err, utils.WarnCartEmpty) {
+			ErrorHandler(c, http.StatusBadRequest, "Cart is empty")
+			return
+		}
+		if errors.Is(err, utils.ErrOrderNotPayable) {
+			ErrorHandler(c, http.StatusUnprocessableEntity, "Order total must be greater than zero")
+			return
+		}
+		if errors.Is(err, utils.ErrPaymentDeclined) {
+			ErrorHandler(c, http.StatusPaymentRequired, "Payment was declined")
+			return
+		}
+		if errors.Is(err, utils.ErrPaymentTimeout) {
+			ErrorHandler(c, http.StatusGatewayTimeout, "Payment provider did not respond. Please try again.")
+			return
+		}
+		if errors.Is(err, utils.ErrPaymentFailed) {
+			ErrorHandler(c, http.StatusBadGateway, "Payment could not be processed. Please try again later.")
+			return
+		}
 		ErrorHandler(
 			c,
 			http.StatusInternalServerError,
@@ -36,7 +99,11 @@ func (h *OrderHandler) PayOrder(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Order paid successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Order paid successfully",
+		"order":   paid.Order,
+		"payment": paid.Payment,
+	})
 }
 
 func (h *OrderHandler) GetCart(c *gin.Context) {
@@ -46,7 +113,7 @@ func (h *OrderHandler) GetCart(c *gin.Context) {
 		return
 	}
 
-	response, err := h.service.GetCart(id.(int))
+	response, err := h.service.GetCart(c.Request.Context(), id.(int))
 
 	if err != nil {
 		if errors.Is(err, utils.WarnCartEmpty) {
@@ -66,8 +133,33 @@ func (h *OrderHandler) GetCart(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+func (h *OrderHandler) RepriceCart(c *gin.Context) {
+	id, exists := c.Get("customerID")
+	if !exists {
+		ErrorHandler(c, http.StatusUnauthorized, "")
+		return
+	}
+
+	response, err := h.service.RepriceCart(c.Request.Context(), id.(int))
+
+	if err != nil {
+		if errors.Is(err, utils.WarnCartEmpty) {
+			ErrorHandler(c, http.StatusBadRequest, "Cart is empty")
+		} else {
+			ErrorHandler(
+				c,
+				http.StatusInternalServerError,
+				"Unable to reprice cart. Please try again later.",
+			)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 func (h *OrderHandler) GetOrderHistory(c *gin.Context) {
-	var request request.HistoryRequest
+	var request request.OrderHistoryRequest
 
 	id, exists := c.Get("customerID")
 	if !exists {
@@ -75,18 +167,23 @@ func (h *OrderHandler) GetOrderHistory(c *gin.Context) {
 		return
 	}
 
-	if err := c.ShouldBindJSON(&request); err != nil {
+	if err := c.ShouldBindQuery(&request); err != nil {
 		ErrorHandler(c, http.StatusBadRequest, "")
 		return
 	}
 
-	response, err := h.service.GetOrderHistory(id.(int), request)
+	query, err := toOrderHistoryQuery(request)
+	if err != nil {
+		ErrorHandler(c, http.StatusBadRequest, err.Error())
+		return
+	}
 
+	page, err := h.service.GetOrderHistory(c.Request.Context(), id.(int), query)
 	if err != nil {
-		if errors.Is(err, utils.WarnCartEmpty) {
-			c.JSON(http.StatusOK, gin.H{
-				"message": "History is empty",
-			})
+		if errors.Is(err, utils.ErrInvalidOrderQuery) {
+			ErrorHandler(c, http.StatusBadRequest, err.Error())
+		} else if errors.Is(err, utils.ErrInvalidCursor) {
+			ErrorHandler(c, http.StatusBadRequest, "Invalid cursor")
 		} else {
 			ErrorHandler(
 				c,
@@ -97,7 +194,66 @@ func (h *OrderHandler) GetOrderHistory(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, page)
+}
+
+func toOrderHistoryQuery(request request.OrderHistoryRequest) (model.OrderHistoryQuery, error) {
+	query := model.OrderHistoryQuery{
+		Limit:  request.Limit,
+		Cursor: request.Cursor,
+	}
+
+	if request.From != "" {
+		from, _, err := parseHistoryTime(request.From)
+		if err != nil {
+			return query, fmt.Errorf("%w: invalid from", utils.ErrInvalidOrderQuery)
+		}
+		query.From = &from
+	}
+
+	if request.To != "" {
+		to, dateOnly, err := parseHistoryTime(request.To)
+		if err != nil {
+			return query, fmt.Errorf("%w: invalid to", utils.ErrInvalidOrderQuery)
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		query.To = &to
+	}
+
+	if request.State != "" {
+		for _, name := range strings.Split(request.State, ",") {
+			state, ok := model.ParseOrderState(strings.TrimSpace(name))
+			if !ok {
+				return query, fmt.Errorf("%w: unknown state %q", utils.ErrInvalidOrderQuery, name)
+			}
+			query.States = append(query.States, state)
+		}
+	}
+
+	if request.MinTotal != "" {
+		total, err := money.Parse(request.MinTotal, money.DefaultCurrency)
+		if err != nil {
+			return query, fmt.Errorf("%w: invalid minTotal", utils.ErrInvalidOrderQuery)
+		}
+		query.MinTotal = &total
+	}
+
+	if request.BookId != 0 {
+		query.BookID = &request.BookId
+	}
+
+	return query, nil
+}
+
+// parseHistoryTime accepts a full RFC 3339 time or a plain date.
+func parseHistoryTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
 }
 
 func (h *OrderHandler) RemoveFromCart(c *gin.Context) {
@@ -114,7 +270,7 @@ func (h *OrderHandler) RemoveFromCart(c *gin.Context) {
 		return
 	}
 
-	err := h.service.RemoveFromCart(id.(int), int(request.BookId))
+	err := h.service.RemoveFromCart(c.Request.Context(), id.(int), int(request.BookId))
 
 	if err != nil {
 		ErrorHandler(
@@ -144,7 +300,16 @@ func (h *OrderHandler) AddToCart(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.AddToCart(id.(int), request); err != nil {
+	if request.Price != nil {
+		c.Header("Deprecation", "true")
+		c.Header("Warning", `299 - "price is deprecated and ignored, prices are set by the server"`)
+	}
+
+	if err := h.service.AddToCart(c.Request.Context(), id.(int), request); err != nil {
+		if errors.Is(err, utils.ErrBookUnavailable) {
+			ErrorHandler(c, http.StatusNotFound, "Book not available")
+			return
+		}
 		ErrorHandler(
 			c,
 			http.StatusInternalServerError,
@@ -157,3 +322,176 @@ func (h *OrderHandler) AddToCart(c *gin.Context) {
 		"message": "Cart updated",
 	})
 }
+
+func (h *OrderHandler) AdvanceOrder(c *gin.Context) {
+	actorID, exists := c.Get("customerID")
+	if !exists {
+		ErrorHandler(c, http.StatusUnauthorized, "")
+		return
+	}
+
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	var request request.AdvanceOrderRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	state, ok := model.ParseOrderState(request.State)
+	if !ok {
+		ErrorHandler(c, http.StatusBadRequest, "Unknown order state")
+		return
+	}
+
+	transition, err := h.service.AdvanceOrder(c.Request.Context(), orderID, state, actorID.(int), request.Note)
+	if err != nil {
+		var invalid *utils.InvalidTransitionError
+		if errors.As(err, &invalid) {
+			ErrorHandlerWithDetails(c, http.StatusConflict, invalid.Error(), invalid)
+		} else if errors.Is(err, utils.ErrOrderNotFound) {
+			ErrorHandler(c, http.StatusNotFound, "Order not found")
+		} else {
+			ErrorHandler(c, http.StatusInternalServerError, "Failed to update order state")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, transition)
+}
+
+// CancelPendingOrder cancels an order stuck waiting for payment and releases
+// its stock.
+func (h *OrderHandler) CancelPendingOrder(c *gin.Context) {
+	actorID, exists := c.Get("customerID")
+	if !exists {
+		ErrorHandler(c, http.StatusUnauthorized, "")
+		return
+	}
+
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	var request request.CancelPendingOrderRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	transition, err := h.service.CancelPendingOrder(c.Request.Context(), orderID, actorID.(int), request.Note)
+	if err != nil {
+		var invalid *utils.InvalidTransitionError
+		if errors.As(err, &invalid) {
+			ErrorHandlerWithDetails(c, http.StatusConflict, invalid.Error(), invalid)
+		} else if errors.Is(err, utils.ErrOrderNotFound) {
+			ErrorHandler(c, http.StatusNotFound, "Order not found")
+		} else {
+			ErrorHandler(c, http.StatusInternalServerError, "Failed to cancel order")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, transition)
+}
+
+func (h *OrderHandler) GetOrderTransitions(c *gin.Context) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	transitions, err := h.service.GetOrderTransitions(c.Request.Context(), orderID)
+	if err != nil {
+		if errors.Is(err, utils.ErrOrderNotFound) {
+			ErrorHandler(c, http.StatusNotFound, "Order not found")
+		} else {
+			ErrorHandler(c, http.StatusInternalServerError, "Unable to retrieve order history")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, transitions)
+}
+
+func (h *OrderHandler) CancelOrder(c *gin.Context) {
+	id, exists := c.Get("customerID")
+	if !exists {
+		ErrorHandler(c, http.StatusUnauthorized, "")
+		return
+	}
+
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	refund, err := h.service.CancelOrder(c.Request.Context(), id.(int), orderID)
+	if err != nil {
+		if errors.Is(err, utils.ErrCancelWindowOver) {
+			ErrorHandler(c, http.StatusConflict, "The order can no longer be cancelled")
+			return
+		}
+		refundErrorHandler(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Order cancelled", "refund": refund})
+}
+
+func (h *OrderHandler) RefundOrder(c *gin.Context) {
+	actorID, exists := c.Get("customerID")
+	if !exists {
+		ErrorHandler(c, http.StatusUnauthorized, "")
+		return
+	}
+
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	var request request.RefundOrderRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		ErrorHandler(c, http.StatusBadRequest, "")
+		return
+	}
+
+	refund, err := h.service.RefundOrder(c.Request.Context(), orderID, actorID.(int), request)
+	if err != nil {
+		refundErrorHandler(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, refund)
+}
+
+// refundErrorHandler answers the errors cancelling and refunding have in common.
+func refundErrorHandler(c *gin.Context, err error) {
+	var invalid *utils.InvalidTransitionError
+	switch {
+	case errors.As(err, &invalid):
+		ErrorHandlerWithDetails(c, http.StatusConflict, invalid.Error(), invalid)
+	case errors.Is(err, utils.ErrOrderNotFound):
+		ErrorHandler(c, http.StatusNotFound, "Order not found")
+	case errors.Is(err, utils.ErrInvalidRefund):
+		ErrorHandler(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, utils.ErrNothingToRefund), errors.Is(err, utils.ErrPaymentNotFound):
+		ErrorHandler(c, http.StatusConflict, "Nothing is left to refund on this order")
+	case errors.Is(err, utils.ErrPaymentTimeout):
+		ErrorHandler(c, http.StatusGatewayTimeout, "Payment provider did not respond. Please try again.")
+	case errors.Is(err, utils.ErrPaymentDeclined), errors.Is(err, utils.ErrPaymentFailed):
+		ErrorHandler(c, http.StatusBadGateway, "Refund could not be processed. Please try again later.")
+	default:
+		ErrorHandler(c, http.StatusInternalServerError, "Failed to process refund. Please try again later.")
+	}
+}
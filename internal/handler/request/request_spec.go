@@ -6,16 +6,102 @@ type LoginRequest struct {
 }
 
 type AddToCartRequest struct {
-	BookId   int64   `json:"bookId"   binding:"required"`
-	Quantity int64   `json:"quantity" binding:"required,gte=1"`
-	Price    float64 `json:"price"    binding:"required"`
+	BookId   int64 `json:"bookId"   binding:"required"`
+	Quantity int64 `json:"quantity" binding:"required,gte=1"`
+
+	// Deprecated: the price is looked up server-side. The field is still
+	// accepted so older clients keep working, but its value is ignored.
+	Price *float64 `json:"price,omitempty"`
+}
+
+type PayOrderRequest struct {
+	CardNumber string `json:"cardNumber" binding:"required,min=12,max=23"`
 }
 
 type RemoveItemFromCartRequest struct {
 	BookId int64 `json:"bookId" binding:"required"`
 }
 
-type HistoryRequest struct {
-	Page  int `json:"page"  binding:"gte=0"`
-	Limit int `json:"limit" binding:"gte=0"`
+type OrderHistoryRequest struct {
+	From     string `form:"from"`  // RFC 3339 time or YYYY-MM-DD
+	To       string `form:"to"`    // RFC 3339 time or YYYY-MM-DD, a date includes the whole day
+	State    string `form:"state"` // Comma separated states, e.g. "paid,shipped"
+	MinTotal string `form:"minTotal"`
+	BookId   int64  `form:"bookId" binding:"gte=0"`
+	Limit    int    `form:"limit"  binding:"gte=0"`
+	Cursor   string `form:"cursor"`
+}
+
+type BookListRequest struct {
+	Title    string `form:"title"`
+	Author   string `form:"author"`
+	MinPrice string `form:"minPrice"`
+	MaxPrice string `form:"maxPrice"`
+	Sort     string `form:"sort"   binding:"omitempty,oneof=id title author price"`
+	Order    string `form:"order"  binding:"omitempty,oneof=asc desc"`
+	Limit    int    `form:"limit"  binding:"gte=0"`
+	Offset   int    `form:"offset" binding:"gte=0"`
+	Cursor   string `form:"cursor"`
+}
+
+type AdjustStockRequest struct {
+	Change int64  `json:"change" binding:"required"`
+	Reason string `json:"reason" binding:"required,oneof=restock return damaged lost correction"`
+	Note   string `json:"note"   binding:"max=255"`
+}
+
+// UpdateProfileRequest changes the fields that are set, the others are kept.
+type UpdateProfileRequest struct {
+	Name    *string `json:"name"    binding:"omitempty,min=1,max=255"`
+	Address *string `json:"address" binding:"omitempty,min=1,max=255"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword"     binding:"required,min=8,max=72"` // bcrypt ignores anything past 72 bytes
+}
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email"    binding:"required,email,max=255"`
+	Password string `json:"password" binding:"required"` // Current password
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type UpdateRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=customer staff admin"`
+}
+
+type AdvanceOrderRequest struct {
+	State string `json:"state" binding:"required"`
+	Note  string `json:"note"  binding:"max=255"`
+}
+
+// CancelPendingOrderRequest explains why staff cancel an order stuck
+// waiting for payment.
+type CancelPendingOrderRequest struct {
+	Note string `json:"note" binding:"required,max=255"`
+}
+
+// RefundOrderRequest refunds the listed lines, or everything not refunded yet
+// when there are none.
+type RefundOrderRequest struct {
+	Lines   []RefundLineRequest `json:"lines"   binding:"dive"`
+	Restock bool                `json:"restock"`
+	Reason  string              `json:"reason"  binding:"max=255"`
+}
+
+type RefundLineRequest struct {
+	BookId   int64 `json:"bookId"   binding:"required"`
+	Quantity int64 `json:"quantity" binding:"required,gte=1"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
 }
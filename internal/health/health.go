@@ -0,0 +1,129 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCheckTimeout bounds every dependency check of a readiness probe.
+const DefaultCheckTimeout = 2 * time.Second
+
+const (
+	StatusUp           = "up"
+	StatusDown         = "down"
+	StatusShuttingDown = "shutting_down"
+)
+
+// CheckFunc reports whether a dependency is usable, nil meaning healthy.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+// CheckResult is the outcome of one dependency check.
+type CheckResult struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the outcome of every check, Status is up only if all of them are.
+type Report struct {
+	Status    string        `json:"status"`
+	CheckedAt time.Time     `json:"checkedAt"`
+	Checks    []CheckResult `json:"checks"`
+}
+
+// Checker runs the readiness checks of the application. Once Drain is called
+// it reports the application as shutting down without running them, so load
+// balancers stop sending traffic before the server stops accepting it.
+type Checker struct {
+	timeout  time.Duration
+	checks   []check
+	draining atomic.Bool
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a check. Checks are not safe to add while requests are served.
+func (c *Checker) Add(name string, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+// Drain marks the application as shutting down.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Run executes every check concurrently, each bounded by the checker timeout.
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{
+		Status:    StatusUp,
+		CheckedAt: time.Now().UTC(),
+		Checks:    make([]CheckResult, len(c.checks)),
+	}
+	if c.Draining() {
+		report.Status = StatusShuttingDown
+	}
+
+	var wg sync.WaitGroup
+	for i, chk := range c.checks {
+		wg.Add(1)
+		go func(i int, chk check) {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx, chk)
+		}(i, chk)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusUp && report.Status == StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, chk check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := chk.fn(ctx)
+	result := CheckResult{
+		Name:     chk.name,
+		Status:   StatusUp,
+		Duration: time.Since(start).String(),
+	}
+	if err == nil {
+		// a check ignoring its context still counts as failed once it is late
+		err = ctx.Err()
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// PingDatabase checks that a connection to the database can be used.
+func PingDatabase(db *sql.DB) CheckFunc {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
@@ -0,0 +1,29 @@
+package mail
+
+import (
+	"bookstore/internal/logging"
+	"context"
+)
+
+const LogSenderName = "log"
+
+// LogSender writes emails to the log instead of sending them, for tests and
+// local development. Messages may hold tokens, never use it in production.
+type LogSender struct{}
+
+func NewLogSender() *LogSender {
+	return &LogSender{}
+}
+
+func (s *LogSender) Name() string {
+	return LogSenderName
+}
+
+func (s *LogSender) Send(ctx context.Context, message Message) error {
+	logging.FromContext(ctx).Info("[Mail] Email not sent, log sender in use",
+		"to", message.To,
+		"subject", message.Subject,
+		"body", message.Body,
+	)
+	return nil
+}
@@ -0,0 +1,29 @@
+package mail
+
+import (
+	"bookstore/pkg/utils"
+	"context"
+	"fmt"
+)
+
+// Sender delivers emails to customers.
+type Sender interface {
+	Name() string
+	Send(ctx context.Context, message Message) error
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// NewSender returns the sender configured by name, an empty name selects the log sender.
+func NewSender(name string) (Sender, error) {
+	switch name {
+	case "", LogSenderName:
+		return NewLogSender(), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", utils.ErrUnknownMailSender, name)
+	}
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"bookstore/pkg/money"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "bookstore"
+
+// UnmatchedRoute labels requests that matched no route, so scanners probing
+// random paths cannot create a series per path.
+const UnmatchedRoute = "unmatched"
+
+const (
+	PaymentFailureDeclined = "declined" // The provider refused the card
+	PaymentFailureError    = "error"    // Anything else: provider or database errors, timeouts
+)
+
+// Metrics holds every metric of the application. All of them are registered
+// on the registerer given to New, tests pass their own registry to read the
+// values back. A nil *Metrics records nothing.
+type Metrics struct {
+	registerer prometheus.Registerer
+
+	httpRequests        *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+
+	cartsCreated    prometheus.Counter
+	cartItemsAdded  prometheus.Counter
+	ordersPaid      prometheus.Counter
+	revenue         *prometheus.CounterVec
+	paymentFailures *prometheus.CounterVec
+}
+
+func New(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		registerer: registerer,
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests served, by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve HTTP requests, by method and route template.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		cartsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "carts_created_total",
+			Help:      "Carts created for customers who had none.",
+		}),
+		cartItemsAdded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cart_items_added_total",
+			Help:      "Books added to a cart or whose quantity was changed.",
+		}),
+		ordersPaid: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "orders_paid_total",
+			Help:      "Orders whose payment was captured.",
+		}),
+		revenue: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "revenue_minor_units_total",
+			Help:      "Amount captured for paid orders in minor units, e.g. cents, by currency.",
+		}, []string{"currency"}),
+		paymentFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "payment_failures_total",
+			Help:      "Payments that did not complete, by reason (declined or error).",
+		}, []string{"reason"}),
+	}
+
+	registerer.MustRegister(
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.cartsCreated,
+		m.cartItemsAdded,
+		m.ordersPaid,
+		m.revenue,
+		m.paymentFailures,
+	)
+	return m
+}
+
+// RegisterDB exposes the connection pool statistics of db (db.Stats()),
+// labelled with name.
+func (m *Metrics) RegisterDB(name string, db *sql.DB) error {
+	return m.registerer.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// ObserveRequest records one served HTTP request. An empty route means no
+// route matched.
+func (m *Metrics) ObserveRequest(method, route string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	if route == "" {
+		route = UnmatchedRoute
+	}
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.httpRequestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+func (m *Metrics) CartCreated() {
+	if m == nil {
+		return
+	}
+	m.cartsCreated.Inc()
+}
+
+func (m *Metrics) CartItemAdded() {
+	if m == nil {
+		return
+	}
+	m.cartItemsAdded.Inc()
+}
+
+// OrderPaid records a captured order and its amount.
+func (m *Metrics) OrderPaid(amount money.Money) {
+	if m == nil {
+		return
+	}
+	m.ordersPaid.Inc()
+	m.revenue.WithLabelValues(amount.Currency).Add(float64(amount.Amount))
+}
+
+// PaymentFailed records a payment that did not complete, reason being
+// PaymentFailureDeclined or PaymentFailureError.
+func (m *Metrics) PaymentFailed(reason string) {
+	if m == nil {
+		return
+	}
+	m.paymentFailures.WithLabelValues(reason).Inc()
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"bookstore/internal/logging"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessLog logs one line per request once it is served. It must run after
+// RequestID so the line carries the request ID. The route is the template
+// the request matched, e.g. /orders/:id, so lines can be grouped by endpoint.
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []any{
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		}
+		if customerID, exists := c.Get("customerID"); exists {
+			attrs = append(attrs, "customer_id", customerID)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		logging.FromContext(c.Request.Context()).Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
@@ -1,15 +1,24 @@
 package middleware
 
 import (
+	"bookstore/internal/logging"
+	"bookstore/internal/model"
+	"bookstore/pkg/utils"
+	"context"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// RevocationList tells whether an access token was revoked before it expired.
+type RevocationList interface {
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+func AuthMiddleware(signer *utils.TokenSigner, revocations RevocationList) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -25,12 +34,7 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, http.ErrNotSupported
-			}
-			return []byte(os.Getenv("SECRET_KEY")), nil
-		})
+		token, err := jwt.Parse(tokenString, signer.SigningKey)
 
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: " + err.Error()})
@@ -61,8 +65,66 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		customerID := int(customerIDFloat)
 
+		// tokens issued before jti existed cannot be revoked and simply age out
+		jti, _ := claims["jti"].(string)
+		if jti != "" {
+			revoked, err := revocations.IsTokenRevoked(c.Request.Context(), jti)
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Could not verify token"})
+				c.Abort()
+				return
+			}
+			if revoked {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+				c.Abort()
+				return
+			}
+		}
+
+		var expiresAt time.Time
+		if exp, ok := claims["exp"].(float64); ok {
+			expiresAt = time.Unix(int64(exp), 0)
+		}
+
+		// tokens issued before roles existed carry no role claim
+		role, _ := claims["role"].(string)
+		if role == "" {
+			role = string(model.RoleCustomer)
+		}
+
 		c.Set("customerID", customerID)
+		c.Set("role", model.Role(role))
+		c.Set("tokenID", jti)
+		c.Set("tokenExpiresAt", expiresAt)
+
+		ctx := c.Request.Context()
+		logger := logging.FromContext(ctx).With("customer_id", customerID)
+		c.Request = c.Request.WithContext(logging.WithLogger(ctx, logger))
 
 		c.Next()
 	}
 }
+
+// RequireRole only lets through customers holding one of the given roles.
+// It must run after AuthMiddleware, a request without a role is unauthenticated.
+func RequireRole(roles ...model.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get("role")
+		role, ok := value.(model.Role)
+		if !exists || !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication is required"})
+			c.Abort()
+			return
+		}
+
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to perform this action"})
+		c.Abort()
+	}
+}
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"bookstore/internal/logging"
+	"bookstore/internal/model"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	idempotentResponseContent = "application/json; charset=utf-8" // Used for responses stored without headers
+)
+
+// replayedHeaders are stored with a response and sent again on replay. Headers
+// that belong to one exchange, such as X-Request-ID, are left out.
+var replayedHeaders = []string{"Content-Type", "Location", "Deprecation", "Warning"}
+
+// IdempotencyStore keeps Idempotency-Keys and the responses they produced.
+type IdempotencyStore interface {
+	ClaimIdempotencyKey(ctx context.Context, key *model.IdempotencyKey, ttl time.Duration) (*model.IdempotencyKey, error)
+	SaveIdempotentResponse(ctx context.Context, key *model.IdempotencyKey) error
+	ReleaseIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) error
+}
+
+// Idempotency makes requests carrying an Idempotency-Key header safe to retry.
+// The first request with a key runs normally and its response is kept for ttl,
+// repeating it returns the stored response without running the handler again.
+// Reusing a key for a different request is rejected, and so is a repeat while
+// the first request is still running. Server errors are not stored, the key is
+// released so the client can try again.
+//
+// It must run after AuthMiddleware, keys are scoped to the customer. Requests
+// without the header and read-only methods pass through untouched.
+func Idempotency(store IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" || isReadOnly(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+
+		customerID, exists := c.Get("customerID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication is required"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		record := &model.IdempotencyKey{
+			CustomerID:  int64(customerID.(int)),
+			Key:         key,
+			RequestHash: requestFingerprint(c.Request, body),
+		}
+
+		existing, err := store.ClaimIdempotencyKey(c.Request.Context(), record, ttl)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Could not check Idempotency-Key"})
+			c.Abort()
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.RequestHash != record.RequestHash:
+				c.JSON(http.StatusUnprocessableEntity, gin.H{
+					"error": "Idempotency-Key was already used for a different request",
+				})
+			case existing.StatusCode == 0:
+				c.JSON(http.StatusConflict, gin.H{
+					"error": "A request with this Idempotency-Key is still being processed",
+				})
+			default:
+				replay(c, existing)
+			}
+			c.Abort()
+			return
+		}
+
+		// the outcome is stored even if the client already went away
+		ctx := context.WithoutCancel(c.Request.Context())
+
+		defer func() {
+			if p := recover(); p != nil {
+				store.ReleaseIdempotencyKey(ctx, record)
+				panic(p)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		c.Next()
+
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			store.ReleaseIdempotencyKey(ctx, record)
+			return
+		}
+
+		record.StatusCode = c.Writer.Status()
+		record.ResponseBody = recorder.body.Bytes()
+		record.ResponseHeaders = http.Header{}
+		for _, name := range replayedHeaders {
+			if values := c.Writer.Header().Values(name); len(values) > 0 {
+				record.ResponseHeaders[name] = values
+			}
+		}
+		if err := store.SaveIdempotentResponse(ctx, record); err != nil {
+			logging.FromContext(c.Request.Context()).Error("[Idempotency] Response was not stored, retries will run again", "idempotency_key", key, "error", err)
+			store.ReleaseIdempotencyKey(ctx, record)
+		}
+	}
+}
+
+// replay answers with a stored response and the headers kept with it.
+func replay(c *gin.Context, stored *model.IdempotencyKey) {
+	contentType := idempotentResponseContent
+	for name, values := range stored.ResponseHeaders {
+		if name == "Content-Type" {
+			contentType = values[0]
+			continue
+		}
+		c.Writer.Header()[name] = values
+	}
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Data(stored.StatusCode, contentType, stored.ResponseBody)
+}
+
+func isReadOnly(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// requestFingerprint identifies a request by method, path and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder keeps a copy of everything written to the response.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"bookstore/internal/metrics"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics counts every request and its latency by route template, e.g.
+// /orders/:id, never by raw path.
+func Metrics(m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		m.ObserveRequest(c.Request.Method, c.FullPath(), c.Writer.Status(), time.Since(start))
+	}
+}
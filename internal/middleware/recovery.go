@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"bookstore/internal/logging"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovery turns a panicking handler into a 500 and logs the panic with the
+// request logger instead of gin's plain text output.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, recovered any) {
+		logging.FromContext(c.Request.Context()).Error("[Recovery] handler panicked",
+			"panic", recovered,
+			"stack", string(debug.Stack()),
+		)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	})
+}
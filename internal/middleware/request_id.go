@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"bookstore/internal/logging"
+	"bookstore/pkg/utils"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RequestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+// RequestID tags every request with an ID, the one sent in X-Request-ID when
+// it is safe to log, a random one otherwise. The ID is echoed in the response
+// and carried by the logger stored in the request context, so every line
+// logged while serving the request can be found from it.
+func RequestID(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			generated, err := utils.RandomToken(16)
+			if err != nil {
+				logger.Error("[RequestID] could not generate a request ID", "error", err)
+			}
+			id = generated
+		}
+
+		c.Set("requestID", id)
+		c.Header(RequestIDHeader, id)
+
+		ctx := logging.WithLogger(c.Request.Context(), logger.With("request_id", id))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+// validRequestID only accepts IDs made of letters, digits and -_.:, anything
+// else could forge log lines or bloat them.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"bookstore/internal/logging"
+	"bookstore/internal/metrics"
+	"bookstore/internal/tracing"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span for every request, continuing the trace of
+// the caller when it sends a W3C traceparent header. The span is named after
+// the route template and its trace ID is added to the request logger, so log
+// lines and traces can be joined. It must run after RequestID.
+func Tracing() gin.HandlerFunc {
+	tracer := tracing.Tracer()
+
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = metrics.UnmatchedRoute
+		}
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+				semconv.ClientAddress(c.ClientIP()),
+			),
+		)
+		defer span.End()
+
+		if spanContext := span.SpanContext(); spanContext.IsValid() {
+			logger := logging.FromContext(ctx).With("trace_id", spanContext.TraceID().String())
+			ctx = logging.WithLogger(ctx, logger)
+		}
+		if requestID := c.GetString("requestID"); requestID != "" {
+			span.SetAttributes(attribute.String("http.request.header.x-request-id", requestID))
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		if err := c.Errors.Last(); err != nil {
+			span.RecordError(err)
+		}
+	}
+}
@@ -2,122 +2,138 @@ package migration
 
 import (
 	"bookstore/internal/model"
-	converter "bookstore/pkg/utils"
+	"bookstore/pkg/money"
+	"bookstore/pkg/utils"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 )
 
+// Migrate applies every pending schema migration.
 func Migrate(db *sql.DB) error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS customers (
-            id SERIAL PRIMARY KEY,
-            email VARCHAR(255) UNIQUE NOT NULL,
-            password VARCHAR(255) NOT NULL,
-            name VARCHAR(255) NOT NULL,
-            address VARCHAR(255) NOT NULL
-        )`,
-		`CREATE TABLE IF NOT EXISTS books (
-            id SERIAL PRIMARY KEY,
-            title VARCHAR(255) NOT NULL,
-            author VARCHAR(255) NOT NULL,
-            price bigint
-        )`,
-		`CREATE TABLE IF NOT EXISTS orders (
-            id SERIAL PRIMARY KEY,
-            customer_id INT NOT NULL,
-            updated_at TIMESTAMP DEFAULT NOW(),
-            order_state INT DEFAULT 1,
-            total bigint,
-            CONSTRAINT fk_customer
-                FOREIGN KEY(customer_id) 
-                REFERENCES customers(id)
-        )`,
-		`CREATE TABLE IF NOT EXISTS order_details (
-            id SERIAL PRIMARY KEY,
-            order_id INT NOT NULL,
-            book_id INT NOT NULL,
-            quantity INT NOT NULL,
-            subtotal bigint NOT NULL,
-            CONSTRAINT fk_order
-                FOREIGN KEY(order_id) 
-                REFERENCES orders(id) ON DELETE CASCADE,
-            CONSTRAINT fk_book
-                FOREIGN KEY(book_id) 
-                REFERENCES books(id)
-        )`,
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
 	}
 
-	for _, query := range queries {
-		_, err := db.Exec(query)
-		if err != nil {
-			return fmt.Errorf("failed to execute this query: %v, error: %v", query, err)
-		}
+	applied, err := migrator.Up()
+	if err != nil {
+		return err
+	}
+
+	if applied > 0 {
+		slog.Info("[Migrate] Applied migrations", "applied", applied)
 	}
 	return nil
 }
 
 // SeedBooks when first building docker image
-func SeedBooks(db *sql.DB) {
+func SeedBooks(db *sql.DB) error {
 	// Check if the books table is empty
 	var count int
 	query := "SELECT COUNT(*) FROM books"
 	err := db.QueryRow(query).Scan(&count)
 	if err != nil {
-		log.Fatalf("Error checking books table: %v", err)
+		return fmt.Errorf("could not check books table: %w", err)
 	}
 
 	// If the table is empty, insert seed data
 	if count == 0 {
 		seedBooks := []model.Book{
-			{Title: "1984", Author: "George Orwell", Price: 9.99},
-			{Title: "To Kill a Mockingbird", Author: "Harper Lee", Price: 7.99},
-			{Title: "The Great Gatsby", Author: "F. Scott Fitzgerald", Price: 10.99},
-			{Title: "Moby Dick", Author: "Herman Melville", Price: 11.99},
-			{Title: "War and Peace", Author: "Leo Tolstoy", Price: 12.99},
+			{Title: "1984", Author: "George Orwell", Price: money.New(999, money.USD), Stock: 50},
+			{Title: "To Kill a Mockingbird", Author: "Harper Lee", Price: money.New(799, money.USD), Stock: 50},
+			{Title: "The Great Gatsby", Author: "F. Scott Fitzgerald", Price: money.New(1099, money.USD), Stock: 50},
+			{Title: "Moby Dick", Author: "Herman Melville", Price: money.New(1199, money.USD), Stock: 50},
+			{Title: "War and Peace", Author: "Leo Tolstoy", Price: money.New(1299, money.USD), Stock: 50},
 		}
 
-		// Insert each book into the database
+		// Insert each book into the database, the opening stock is recorded as a restock
 		for _, book := range seedBooks {
-			_, err := db.Exec(
-				"INSERT INTO books (title, author, price) VALUES ($1, $2, $3)",
+			_, err := db.Exec(`
+				WITH inserted AS (
+					INSERT INTO books (title, author, price, stock) VALUES ($1, $2, $3, $4)
+					RETURNING id, stock
+				)
+				INSERT INTO stock_movements (book_id, quantity_change, reason, note, stock_after)
+				SELECT id, stock, $5, 'initial seed', stock FROM inserted`,
 				book.Title,
 				book.Author,
-				converter.ConvertStorePrice(&book.Price),
+				book.Price,
+				book.Stock,
+				model.StockReasonRestock,
 			)
 			if err != nil {
-				log.Fatalf("Error inserting book %s: %v", book.Title, err)
+				return fmt.Errorf("could not insert book %s: %w", book.Title, err)
 			}
 		}
 	}
+	return nil
 }
 
-func AddUniqueConstraintIfNotExists(db *sql.DB) error {
-	// Check if the unique constraint already exists
+// PromoteFirstAdmin gives the admin role to an already registered customer.
+// It only works while the store has no admin, later promotions go through
+// the admin API so this cannot be used to take over an existing store.
+func PromoteFirstAdmin(db *sql.DB, email string) error {
 	var exists bool
-	query := `
-    SELECT EXISTS (
-        SELECT 1 
-        FROM information_schema.table_constraints 
-        WHERE constraint_name = 'order_details_unique_order_book' 
-        AND table_name = 'order_details'
-    )`
-
-	err := db.QueryRow(query).Scan(&exists)
+	query := "SELECT EXISTS (SELECT 1 FROM customers WHERE role = $1)"
+	if err := db.QueryRow(query, model.RoleAdmin).Scan(&exists); err != nil {
+		return fmt.Errorf("could not check for existing admin: %w", err)
+	}
+
+	if exists {
+		return utils.ErrAdminExists
+	}
+
+	result, err := db.Exec(
+		"UPDATE customers SET role = $1 WHERE email = $2",
+		model.RoleAdmin,
+		strings.ToLower(email),
+	)
 	if err != nil {
-		return fmt.Errorf("could not check for constraint existence: %w", err)
+		return fmt.Errorf("could not promote admin: %w", err)
 	}
 
-	// If the constraint does not exist, add it
-	if !exists {
-		_, err = db.Exec(`
-            ALTER TABLE order_details
-            ADD CONSTRAINT order_details_unique_order_book UNIQUE (order_id, book_id)
-        `)
-		if err != nil {
-			return fmt.Errorf("could not add unique constraint: %w", err)
-		}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not promote admin: %w", err)
+	}
+
+	if affected == 0 {
+		return utils.ErrEmailNotFound
 	}
 
 	return nil
 }
+
+// SetOpeningStock gives quantity copies to the books that were never stocked,
+// the ones still at zero without a single stock movement, e.g. books created
+// before stock was tracked. Each is recorded as a restock so the ledger adds
+// up. It returns how many books were stocked.
+func SetOpeningStock(db *sql.DB, quantity int) (int64, error) {
+	if quantity <= 0 {
+		return 0, fmt.Errorf("opening stock must be positive, got %d", quantity)
+	}
+
+	result, err := db.Exec(`
+		WITH stocked AS (
+			UPDATE books b SET stock = $1
+			WHERE b.stock = 0 AND b.archived_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM stock_movements m WHERE m.book_id = b.id)
+			RETURNING b.id, b.stock
+		)
+		INSERT INTO stock_movements (book_id, quantity_change, reason, note, stock_after)
+		SELECT id, stock, $2, 'opening stock', stock FROM stocked`,
+		quantity,
+		model.StockReasonRestock,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("could not set opening stock: %w", err)
+	}
+
+	stocked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not set opening stock: %w", err)
+	}
+	return stocked, nil
+}
@@ -0,0 +1,371 @@
+package migration
+
+import (
+	"bookstore/pkg/utils"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var embeddedMigrations embed.FS
+
+// advisoryLockID is the pg_advisory_lock key held while migrations run,
+// so two starting containers never apply the same migration twice.
+const advisoryLockID int64 = 727_105_001
+
+var migrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one numbered schema change with its up and down SQL.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum identifies the up SQL, an applied migration must never change.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrationStatus describes a known migration and whether it was applied.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+	Modified  bool // Applied with a different checksum than the embedded file
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// Migrator applies and rolls back migrations, recording them in schema_migrations.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator returns a migrator for the migrations embedded in the binary.
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	sub, err := fs.Sub(embeddedMigrations, "sql")
+	if err != nil {
+		return nil, err
+	}
+	return NewMigratorFromFS(db, sub)
+}
+
+// NewMigratorFromFS reads NNNN_name.up.sql and NNNN_name.down.sql pairs from fsys.
+func NewMigratorFromFS(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// LoadMigrations parses the migration files in fsys, ordered by version.
+// Every version needs both an up and a down file.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up applies every pending migration in order and returns how many ran.
+func (m *Migrator) Up() (int, error) {
+	count := 0
+	err := m.withLock(func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		if err := m.verify(applied); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(conn, migration); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down rolls back the n most recently applied migrations.
+func (m *Migrator) Down(n int) (int, error) {
+	count := 0
+	err := m.withLock(func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		if err := m.verify(applied); err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && count < n; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := m.revert(conn, migration); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Redo rolls back the latest applied migration and applies it again.
+func (m *Migrator) Redo() error {
+	return m.withLock(func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		if err := m.verify(applied); err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := m.revert(conn, migration); err != nil {
+				return err
+			}
+			return m.apply(conn, migration)
+		}
+		return utils.ErrNoMigrationsApplied
+	})
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withLock(func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		for _, migration := range m.migrations {
+			status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if record, ok := applied[migration.Version]; ok {
+				appliedAt := record.appliedAt
+				status.Applied = true
+				status.AppliedAt = &appliedAt
+				status.Modified = record.checksum != migration.Checksum()
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Latest returns the newest known migration version, 0 when there are none.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// CheckVersion fails unless the database is at the newest known migration.
+// It does not take the migration lock, so it is cheap enough for probes.
+func (m *Migrator) CheckVersion(ctx context.Context) error {
+	var version int64
+	err := m.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("could not read schema_migrations: %w", err)
+	}
+
+	if latest := m.Latest(); version != latest {
+		return fmt.Errorf("%w: database at %d, expected %d", utils.ErrSchemaVersionMismatch, version, latest)
+	}
+	return nil
+}
+
+// withLock runs fn on a single connection holding the migration advisory lock.
+// Advisory locks belong to a session, so everything must share that connection.
+func (m *Migrator) withLock(fn func(conn *sql.Conn, applied map[int64]appliedMigration) error) error {
+	ctx := context.Background()
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get a connection for migrations: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", advisoryLockID); err != nil {
+		return fmt.Errorf("could not acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", advisoryLockID); err != nil {
+			slog.Error("[Migrator] Could not release migration lock", "error", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return fmt.Errorf("could not create schema_migrations: %w", err)
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("could not read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int64]appliedMigration{}
+	for rows.Next() {
+		var version int64
+		var record appliedMigration
+		if err := rows.Scan(&version, &record.checksum, &record.appliedAt); err != nil {
+			return nil, fmt.Errorf("could not read schema_migrations: %w", err)
+		}
+		applied[version] = record
+	}
+
+	return applied, rows.Err()
+}
+
+// verify refuses to continue when the database knows migrations this binary
+// does not, or when an applied migration was edited after it ran.
+func (m *Migrator) verify(applied map[int64]appliedMigration) error {
+	known := map[int64]Migration{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, record := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return fmt.Errorf("%w: version %d", utils.ErrUnknownMigration, version)
+		}
+		if record.checksum != migration.Checksum() {
+			return fmt.Errorf(
+				"%w: %04d_%s",
+				utils.ErrMigrationChecksumMismatch,
+				migration.Version,
+				migration.Name,
+			)
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) apply(conn *sql.Conn, migration Migration) error {
+	return m.inTx(conn, migration, "up", func(tx *sql.Tx) error {
+		if _, err := tx.Exec(migration.Up); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			"INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+			migration.Version,
+			migration.Name,
+			migration.Checksum(),
+		)
+		return err
+	})
+}
+
+func (m *Migrator) revert(conn *sql.Conn, migration Migration) error {
+	return m.inTx(conn, migration, "down", func(tx *sql.Tx) error {
+		if _, err := tx.Exec(migration.Down); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+		return err
+	})
+}
+
+// inTx runs one migration step in its own transaction so a failed step
+// leaves neither schema changes nor a schema_migrations row behind.
+func (m *Migrator) inTx(conn *sql.Conn, migration Migration, direction string, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %04d_%s %s failed: %w", migration.Version, migration.Name, direction, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration %04d_%s %s failed: %w", migration.Version, migration.Name, direction, err)
+	}
+
+	slog.Info("[Migrator] Migration done", "version", migration.Version, "name", migration.Name, "direction", direction)
+	return nil
+}
@@ -0,0 +1,40 @@
+-- IF NOT EXISTS lets databases created before versioned migrations adopt this baseline.
+CREATE TABLE IF NOT EXISTS customers (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) UNIQUE NOT NULL,
+    password VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    address VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS books (
+    id SERIAL PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    author VARCHAR(255) NOT NULL,
+    price bigint
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id SERIAL PRIMARY KEY,
+    customer_id INT NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    order_state INT DEFAULT 1,
+    total bigint,
+    CONSTRAINT fk_customer
+        FOREIGN KEY(customer_id)
+        REFERENCES customers(id)
+);
+
+CREATE TABLE IF NOT EXISTS order_details (
+    id SERIAL PRIMARY KEY,
+    order_id INT NOT NULL,
+    book_id INT NOT NULL,
+    quantity INT NOT NULL,
+    subtotal bigint NOT NULL,
+    CONSTRAINT fk_order
+        FOREIGN KEY(order_id)
+        REFERENCES orders(id) ON DELETE CASCADE,
+    CONSTRAINT fk_book
+        FOREIGN KEY(book_id)
+        REFERENCES books(id)
+);
@@ -0,0 +1 @@
+ALTER TABLE order_details DROP CONSTRAINT IF EXISTS order_details_unique_order_book;
@@ -0,0 +1,5 @@
+-- The constraint may already exist where it was added by the old startup check.
+ALTER TABLE order_details DROP CONSTRAINT IF EXISTS order_details_unique_order_book;
+
+ALTER TABLE order_details
+    ADD CONSTRAINT order_details_unique_order_book UNIQUE (order_id, book_id);
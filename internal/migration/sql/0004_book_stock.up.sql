@@ -0,0 +1,19 @@
+ALTER TABLE books
+    ADD COLUMN IF NOT EXISTS stock INT NOT NULL DEFAULT 0 CHECK (stock >= 0);
+
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id SERIAL PRIMARY KEY,
+    book_id INT NOT NULL,
+    quantity_change INT NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    order_id INT,
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    stock_after INT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT fk_stock_book
+        FOREIGN KEY(book_id)
+        REFERENCES books(id),
+    CONSTRAINT fk_stock_order
+        FOREIGN KEY(order_id)
+        REFERENCES orders(id)
+);
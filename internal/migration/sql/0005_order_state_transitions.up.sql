@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS order_state_transitions (
+    id SERIAL PRIMARY KEY,
+    order_id INT NOT NULL,
+    from_state INT NOT NULL,
+    to_state INT NOT NULL,
+    changed_by INT NOT NULL,
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT fk_transition_order
+        FOREIGN KEY(order_id)
+        REFERENCES orders(id) ON DELETE CASCADE,
+    CONSTRAINT fk_transition_customer
+        FOREIGN KEY(changed_by)
+        REFERENCES customers(id)
+);
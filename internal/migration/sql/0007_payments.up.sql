@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS payments (
+    id SERIAL PRIMARY KEY,
+    order_id INT NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    provider_ref VARCHAR(255) NOT NULL DEFAULT '',
+    amount bigint NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    failure_reason VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_payment_order
+        FOREIGN KEY(order_id)
+        REFERENCES orders(id)
+);
+
+CREATE INDEX IF NOT EXISTS payments_order_idx ON payments (order_id);
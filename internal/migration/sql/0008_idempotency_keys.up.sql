@@ -0,0 +1,16 @@
+-- Rows past expires_at are ignored and overwritten when their key is reused.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    customer_id INT NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INT,
+    response_body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (customer_id, key),
+    CONSTRAINT fk_idempotency_customer
+        FOREIGN KEY(customer_id)
+        REFERENCES customers(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,3 @@
+-- Archived books are hidden from the catalog but kept for order history.
+ALTER TABLE books
+    ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
@@ -0,0 +1,5 @@
+ALTER TABLE order_details
+    DROP COLUMN IF EXISTS currency,
+    DROP COLUMN IF EXISTS author,
+    DROP COLUMN IF EXISTS title,
+    DROP COLUMN IF EXISTS unit_price;
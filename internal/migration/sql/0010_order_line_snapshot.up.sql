@@ -0,0 +1,23 @@
+-- Order lines keep the book as it was sold, so receipts do not change with the catalog.
+ALTER TABLE order_details
+    ADD COLUMN IF NOT EXISTS unit_price bigint,
+    ADD COLUMN IF NOT EXISTS title VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS author VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS currency CHAR(3);
+
+-- Backfill existing lines. The subtotal was priced when the line was added,
+-- so it gives the price actually charged, the live price is only a fallback.
+UPDATE order_details d
+SET unit_price = COALESCE(d.subtotal / NULLIF(d.quantity, 0), b.price, 0),
+    title = b.title,
+    author = b.author,
+    currency = 'USD'
+FROM books b
+WHERE b.id = d.book_id AND d.unit_price IS NULL;
+
+ALTER TABLE order_details
+    ALTER COLUMN unit_price SET NOT NULL,
+    ALTER COLUMN title SET NOT NULL,
+    ALTER COLUMN author SET NOT NULL,
+    ALTER COLUMN currency SET NOT NULL,
+    ALTER COLUMN currency SET DEFAULT 'USD';
@@ -0,0 +1,2 @@
+-- Merged carts stay merged, only the guarantee is dropped.
+DROP INDEX IF EXISTS orders_one_open_cart_per_customer;
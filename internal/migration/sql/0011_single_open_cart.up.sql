@@ -0,0 +1,69 @@
+-- A customer has at most one open cart (order_state 1). Concurrent add-to-cart
+-- calls used to open several, and checkout then paid all of them.
+LOCK TABLE orders IN SHARE ROW EXCLUSIVE MODE;
+
+-- Every open cart but the oldest of its customer is merged into the oldest.
+CREATE TEMPORARY TABLE duplicate_carts ON COMMIT DROP AS
+SELECT o.id, o.customer_id, keeper.id AS keep_id
+FROM orders o
+JOIN (
+    SELECT customer_id, MIN(id) AS id
+    FROM orders
+    WHERE order_state = 1
+    GROUP BY customer_id
+    HAVING COUNT(*) > 1
+) keeper ON keeper.customer_id = o.customer_id
+WHERE o.order_state = 1 AND o.id <> keeper.id;
+
+-- Quantities of a book found in several carts are added up. The newest
+-- snapshot of a book is used for lines the kept cart does not have yet.
+INSERT INTO order_details (order_id, book_id, quantity, subtotal, unit_price, title, author, currency)
+SELECT
+    merged.keep_id,
+    merged.book_id,
+    merged.quantity,
+    merged.unit_price * merged.quantity,
+    merged.unit_price,
+    merged.title,
+    merged.author,
+    merged.currency
+FROM (
+    SELECT
+        dup.keep_id,
+        d.book_id,
+        SUM(d.quantity) AS quantity,
+        (ARRAY_AGG(d.unit_price ORDER BY d.id DESC))[1] AS unit_price,
+        (ARRAY_AGG(d.title ORDER BY d.id DESC))[1] AS title,
+        (ARRAY_AGG(d.author ORDER BY d.id DESC))[1] AS author,
+        (ARRAY_AGG(d.currency ORDER BY d.id DESC))[1] AS currency
+    FROM order_details d
+    JOIN duplicate_carts dup ON dup.id = d.order_id
+    GROUP BY dup.keep_id, d.book_id
+) merged
+ON CONFLICT (order_id, book_id) DO UPDATE
+SET quantity = order_details.quantity + EXCLUDED.quantity,
+    subtotal = order_details.unit_price * (order_details.quantity + EXCLUDED.quantity);
+
+DELETE FROM order_details d
+USING duplicate_carts dup
+WHERE d.order_id = dup.id;
+
+-- The emptied carts are cancelled rather than deleted, payment attempts may
+-- still reference them.
+UPDATE orders o
+SET order_state = 7, total = 0, updated_at = NOW()
+FROM duplicate_carts dup
+WHERE o.id = dup.id;
+
+INSERT INTO order_state_transitions (order_id, from_state, to_state, changed_by, note)
+SELECT id, 1, 7, customer_id, 'merged into cart ' || keep_id
+FROM duplicate_carts;
+
+UPDATE orders o
+SET total = COALESCE((SELECT SUM(d.subtotal) FROM order_details d WHERE d.order_id = o.id), 0),
+    updated_at = NOW()
+WHERE o.id IN (SELECT keep_id FROM duplicate_carts);
+
+CREATE UNIQUE INDEX IF NOT EXISTS orders_one_open_cart_per_customer
+    ON orders (customer_id)
+    WHERE order_state = 1;
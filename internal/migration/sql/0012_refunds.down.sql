@@ -0,0 +1,9 @@
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS refunded_total;
+
+ALTER TABLE order_details
+    DROP CONSTRAINT IF EXISTS order_details_refunded_quantity_check,
+    DROP COLUMN IF EXISTS refunded_quantity;
+
+DROP TABLE IF EXISTS refund_lines;
+DROP TABLE IF EXISTS refunds;
@@ -0,0 +1,52 @@
+-- Refunds give money back against the payment that captured it, either for a
+-- whole order or for some of its lines. The counters on orders and
+-- order_details include refunds still waiting on the provider, so two refunds
+-- can never return more than was paid.
+CREATE TABLE IF NOT EXISTS refunds (
+    id SERIAL PRIMARY KEY,
+    order_id INT NOT NULL,
+    payment_id INT NOT NULL,
+    amount bigint NOT NULL CHECK (amount > 0),
+    status VARCHAR(16) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    failure_reason VARCHAR(255) NOT NULL DEFAULT '',
+    restock BOOLEAN NOT NULL DEFAULT FALSE,
+    created_by INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_refund_order
+        FOREIGN KEY(order_id)
+        REFERENCES orders(id),
+    CONSTRAINT fk_refund_payment
+        FOREIGN KEY(payment_id)
+        REFERENCES payments(id),
+    CONSTRAINT fk_refund_customer
+        FOREIGN KEY(created_by)
+        REFERENCES customers(id)
+);
+
+CREATE INDEX IF NOT EXISTS refunds_order_idx ON refunds (order_id);
+
+CREATE TABLE IF NOT EXISTS refund_lines (
+    refund_id INT NOT NULL,
+    order_detail_id INT NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    amount bigint NOT NULL,
+    PRIMARY KEY (refund_id, order_detail_id),
+    CONSTRAINT fk_refund_line_refund
+        FOREIGN KEY(refund_id)
+        REFERENCES refunds(id) ON DELETE CASCADE,
+    CONSTRAINT fk_refund_line_detail
+        FOREIGN KEY(order_detail_id)
+        REFERENCES order_details(id)
+);
+
+ALTER TABLE order_details
+    ADD COLUMN IF NOT EXISTS refunded_quantity INT NOT NULL DEFAULT 0;
+
+ALTER TABLE order_details
+    ADD CONSTRAINT order_details_refunded_quantity_check
+    CHECK (refunded_quantity >= 0 AND refunded_quantity <= quantity);
+
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS refunded_total bigint NOT NULL DEFAULT 0 CHECK (refunded_total >= 0);
@@ -0,0 +1,16 @@
+-- Email changes wait here until the new address is confirmed, only the
+-- SHA-256 of the token sent to it is kept.
+CREATE TABLE IF NOT EXISTS email_verifications (
+    id SERIAL PRIMARY KEY,
+    customer_id INT NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_email_verification_customer
+        FOREIGN KEY(customer_id)
+        REFERENCES customers(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS email_verifications_customer_idx ON email_verifications (customer_id);
@@ -0,0 +1,3 @@
+-- Headers such as Location or Deprecation are replayed with the stored body.
+-- Expired rows are now deleted periodically, which uses idempotency_keys_expires_idx.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS response_headers JSONB;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS orders_customer_checked_out_idx;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS checked_out_at;
@@ -0,0 +1,19 @@
+-- History is paged on when an order was checked out, which unlike updated_at
+-- does not move when the order changes state.
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS checked_out_at TIMESTAMP;
+
+-- Orders already checked out take their last move out of the cart (state 1)
+-- from the transition log, older ones without a logged checkout fall back to
+-- their last update.
+UPDATE orders o
+SET checked_out_at = COALESCE(
+    (SELECT MAX(t.created_at) FROM order_state_transitions t
+     WHERE t.order_id = o.id AND t.from_state = 1),
+    o.updated_at,
+    NOW()
+)
+WHERE o.order_state <> 1 AND o.checked_out_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS orders_customer_checked_out_idx
+    ON orders (customer_id, checked_out_at DESC, id DESC);
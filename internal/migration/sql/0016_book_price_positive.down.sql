@@ -0,0 +1,6 @@
+-- Repaired prices stay, only the constraints are dropped.
+ALTER TABLE books
+    DROP CONSTRAINT IF EXISTS books_price_positive;
+
+ALTER TABLE books
+    ALTER COLUMN price DROP NOT NULL;
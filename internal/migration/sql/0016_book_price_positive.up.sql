@@ -0,0 +1,21 @@
+-- Books used to be accepted without a price or with a free or negative one.
+-- They are archived so nobody can buy them and take the last price they were
+-- sold at, or the smallest unit, until staff set a real price and restore them.
+UPDATE books b
+SET price = COALESCE(
+        (SELECT d.unit_price FROM order_details d
+         WHERE d.book_id = b.id AND d.unit_price > 0
+         ORDER BY d.id DESC
+         LIMIT 1),
+        1
+    ),
+    archived_at = COALESCE(b.archived_at, NOW())
+WHERE b.price IS NULL OR b.price <= 0;
+
+ALTER TABLE books
+    ALTER COLUMN price SET NOT NULL;
+
+ALTER TABLE books
+    DROP CONSTRAINT IF EXISTS books_price_positive;
+ALTER TABLE books
+    ADD CONSTRAINT books_price_positive CHECK (price > 0);
@@ -1,8 +1,53 @@
 package model
 
+import (
+	"bookstore/pkg/money"
+	"time"
+)
+
 type Book struct {
-	ID     int64   `json:"id"`     // Unique identifier for the book
-	Title  string  `json:"title"`  // Title of the book
-	Author string  `json:"author"` // Author of the book
-	Price  float64 `json:"price"`  // Price of the book
+	ID         int64       `json:"id"`                   // Unique identifier for the book
+	Title      string      `json:"title"`                // Title of the book
+	Author     string      `json:"author"`               // Author of the book
+	Price      money.Money `json:"price"`                // Price of the book
+	Stock      int64       `json:"stock"`                // Copies on hand, only changed through stock movements
+	ArchivedAt *time.Time  `json:"archivedAt,omitempty"` // Set while the book is withdrawn from sale
+}
+
+type BookSortField string
+
+const (
+	BookSortID     BookSortField = "id"
+	BookSortTitle  BookSortField = "title"
+	BookSortAuthor BookSortField = "author"
+	BookSortPrice  BookSortField = "price"
+)
+
+type SortDirection string
+
+const (
+	SortAsc  SortDirection = "asc"
+	SortDesc SortDirection = "desc"
+)
+
+// BookQuery describes which page of the catalog to return.
+// Offset and Cursor are alternative ways to page, only one may be set.
+type BookQuery struct {
+	Title    string       // Case-insensitive substring of the title
+	Author   string       // Case-insensitive substring of the author
+	MinPrice *money.Money // Inclusive lower price bound
+	MaxPrice *money.Money // Inclusive upper price bound
+	SortBy   BookSortField
+	SortDir  SortDirection
+	Limit    int
+	Offset   int
+	Cursor   string // Opaque cursor returned as NextCursor by a previous page
+}
+
+type BookPage struct {
+	Books      []Book `json:"books"`
+	Total      int64  `json:"total"`                // Number of books matching the filters
+	Limit      int    `json:"limit"`                // Page size that was applied
+	Offset     int    `json:"offset"`               // Offset that was applied, 0 in cursor mode
+	NextCursor string `json:"nextCursor,omitempty"` // Empty on the last page
 }
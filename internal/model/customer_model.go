@@ -1,9 +1,43 @@
 package model
 
+import "time"
+
+type Role string
+
+const (
+	RoleCustomer Role = "customer" // Default role, can shop and manage their own orders
+	RoleStaff    Role = "staff"    // Can manage the catalog
+	RoleAdmin    Role = "admin"    // Can manage the catalog and customer roles
+)
+
 type Customer struct {
 	ID       int64  `json:"id"`
 	Email    string `json:"email"    binding:"required,email"` // Email field with validation
 	Password string `json:"password" binding:"required"`       // Password field with validation
 	Name     string `json:"name"     binding:"required"`       // Name field with validation
 	Address  string `json:"address"  binding:"required"`       // Address field with validation
+	Role     Role   `json:"-"`                                 // Never bound from requests, new customers are always RoleCustomer
+}
+
+// CustomerProfile is a customer's own view of their account, it never
+// carries the password hash.
+type CustomerProfile struct {
+	ID           int64  `json:"id"`
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	Address      string `json:"address"`
+	Role         Role   `json:"role"`
+	PendingEmail string `json:"pendingEmail,omitempty"` // Requested new email, not confirmed yet
+}
+
+// EmailVerification is a requested email change. The email only replaces
+// the customer's once the token sent to it comes back, only its SHA-256 is kept.
+type EmailVerification struct {
+	ID         int64
+	CustomerID int64
+	Email      string
+	TokenHash  string
+	ExpiresAt  time.Time
+	UsedAt     *time.Time // Set once the email was confirmed
+	CreatedAt  time.Time
 }
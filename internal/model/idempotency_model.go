@@ -0,0 +1,20 @@
+package model
+
+import (
+	"net/http"
+	"time"
+)
+
+// IdempotencyKey is a stored Idempotency-Key together with the response it
+// produced. Keys are scoped to the customer who sent them.
+type IdempotencyKey struct {
+	CustomerID   int64
+	Key          string
+	RequestHash  string // SHA-256 of method, path and body of the first request
+	StatusCode   int    // Zero while the first request is still being processed
+	ResponseBody []byte
+	// Headers replayed with the body, e.g. Content-Type, Location or Deprecation
+	ResponseHeaders http.Header
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+}
@@ -1,39 +1,164 @@
 package model
 
 import (
+	"bookstore/pkg/money"
+	"fmt"
 	"time"
 )
 
 type Order struct {
-	ID         int64     `json:"id"`
-	CustomerID int64     `json:"customer_id"`
-	UpdatedAt  time.Time `json:"updated_at"`
-	OrderState int64     `json:"order_state"`
-	Total      float64   `json:"total"`
+	ID         int64       `json:"id"`
+	CustomerID int64       `json:"customer_id"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+	OrderState OrderState  `json:"order_state"`
+	Total      money.Money `json:"total"`
 }
 
+// OrderDetail is one line of an order. Title, author and unit price are a
+// snapshot of the book taken when the line was added and again on checkout,
+// later catalog edits do not change them.
 type OrderDetail struct {
-	ID       int64   `json:"id"`
-	OrderID  int64   `json:"order_id"`
-	BookID   int64   `json:"book_id"`
-	Quantity int64   `json:"quantity"`
-	Subtotal float64 `json:"subtotal"`
+	ID        int64       `json:"id"`
+	OrderID   int64       `json:"order_id"`
+	BookID    int64       `json:"book_id"`
+	Quantity  int64       `json:"quantity"`
+	Subtotal  money.Money `json:"subtotal"`
+	UnitPrice money.Money `json:"unit_price"`
+	Title     string      `json:"title"`
+	Author    string      `json:"author"`
 }
 
 type OrderResponse struct {
-	ID          int64                 `json:"id"`
-	OrderDetail []OrderDetailResponse `json:"orderDetails"`
-	Total       float64               `json:"total"`
+	ID            int64                 `json:"id"`
+	State         OrderState            `json:"state"`
+	UpdatedAt     time.Time             `json:"updatedAt"`
+	CheckedOutAt  *time.Time            `json:"checkedOutAt,omitempty"` // Unset while the order is a cart
+	OrderDetail   []OrderDetailResponse `json:"orderDetails"`
+	Total         money.Money           `json:"total"`
+	PricesChanged bool                  `json:"pricesChanged,omitempty"` // Some line has a PriceChange to acknowledge
+	Refund        *OrderRefund          `json:"refund,omitempty"`        // Set once anything was refunded
+}
+
+// OrderRefundStatus tells how much of an order's total was refunded.
+type OrderRefundStatus string
+
+const (
+	OrderRefundPartial OrderRefundStatus = "partial"
+	OrderRefundFull    OrderRefundStatus = "full"
+)
+
+// OrderRefund sums up the refunds of an order, refunds still waiting on the
+// provider included.
+type OrderRefund struct {
+	Status OrderRefundStatus `json:"status"`
+	Amount money.Money       `json:"amount"`
+}
+
+// PaidOrder is the result of a successful checkout: the order as it was paid
+// and the payment that captured it.
+type PaidOrder struct {
+	Order   *OrderResponse `json:"order"`
+	Payment *Payment       `json:"payment"`
 }
 
 type OrderDetailResponse struct {
-	ID       int64   `json:"id"`
-	Book     []Book  `json:"books"`
-	Quantity int64   `json:"quantity"`
-	Subtotal float64 `json:"subtotal"`
+	ID          int64        `json:"id"`
+	Book        []Book       `json:"books"`
+	Quantity    int64        `json:"quantity"`
+	Subtotal    money.Money  `json:"subtotal"`
+	Unavailable bool         `json:"unavailable,omitempty"` // Cart line whose book can no longer be bought
+	PriceChange *PriceChange `json:"priceChange,omitempty"` // Cart line priced differently from the catalog
+	Refunded    int64        `json:"refunded,omitempty"`    // Copies of the line refunded so far
+}
+
+// PriceChange is the price a cart line was added at and the current catalog price.
+type PriceChange struct {
+	OldPrice money.Money `json:"oldPrice"`
+	NewPrice money.Money `json:"newPrice"`
 }
 
 type OrderState int
 
-const OrderState_One OrderState = 1 // Cart state
-const OrderState_Two OrderState = 2 // Paid state
+// Order states as stored in orders.order_state. The numbers are persisted,
+// so existing values must never be renumbered.
+const (
+	OrderStateCart           OrderState = 1 // Still being filled by the customer
+	OrderStatePaid           OrderState = 2 // Payment captured
+	OrderStatePendingPayment OrderState = 3 // Checkout started, waiting for payment
+	OrderStateFulfilling     OrderState = 4 // Being picked and packed
+	OrderStateShipped        OrderState = 5 // Handed to the carrier
+	OrderStateDelivered      OrderState = 6 // Received by the customer
+	OrderStateCancelled      OrderState = 7 // Cancelled before delivery
+	OrderStateRefunded       OrderState = 8 // Money returned to the customer
+)
+
+var orderStateNames = map[OrderState]string{
+	OrderStateCart:           "cart",
+	OrderStatePendingPayment: "pending_payment",
+	OrderStatePaid:           "paid",
+	OrderStateFulfilling:     "fulfilling",
+	OrderStateShipped:        "shipped",
+	OrderStateDelivered:      "delivered",
+	OrderStateCancelled:      "cancelled",
+	OrderStateRefunded:       "refunded",
+}
+
+func (s OrderState) String() string {
+	if name, ok := orderStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("OrderState(%d)", int(s))
+}
+
+// ParseOrderState returns the state with the given name, e.g. "shipped".
+func ParseOrderState(name string) (OrderState, bool) {
+	for state, stateName := range orderStateNames {
+		if stateName == name {
+			return state, true
+		}
+	}
+	return 0, false
+}
+
+func (s OrderState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *OrderState) UnmarshalText(text []byte) error {
+	state, ok := ParseOrderState(string(text))
+	if !ok {
+		return fmt.Errorf("unknown order state %q", text)
+	}
+	*s = state
+	return nil
+}
+
+// OrderStateTransition is one row of the order_state_transitions history.
+type OrderStateTransition struct {
+	ID        int64      `json:"id"`
+	OrderID   int64      `json:"orderId"`
+	From      OrderState `json:"from"`
+	To        OrderState `json:"to"`
+	ChangedBy int64      `json:"changedBy"` // Customer or staff member who made the change
+	Note      string     `json:"note,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// OrderHistoryQuery describes which page of a customer's order history to return.
+type OrderHistoryQuery struct {
+	CustomerID int64
+	From       *time.Time   // Inclusive lower bound on the checkout time
+	To         *time.Time   // Exclusive upper bound on the checkout time
+	States     []OrderState // Any of these states, every state past payment when empty
+	MinTotal   *money.Money // Inclusive lower bound on the order total
+	BookID     *int64       // Only orders containing this book
+	Limit      int
+	Cursor     string // Opaque cursor returned as NextCursor by a previous page
+}
+
+type OrderHistoryPage struct {
+	Orders     []OrderResponse `json:"orders"`
+	Total      int64           `json:"total"`                // Number of orders matching the filters
+	Limit      int             `json:"limit"`                // Page size that was applied
+	NextCursor string          `json:"nextCursor,omitempty"` // Empty on the last page
+}
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"bookstore/pkg/money"
+	"time"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentStatusPending    PaymentStatus = "pending"    // Attempt recorded, provider not called yet
+	PaymentStatusAuthorized PaymentStatus = "authorized" // Funds held by the provider
+	PaymentStatusCaptured   PaymentStatus = "captured"   // Funds taken, the order is paid
+	PaymentStatusDeclined   PaymentStatus = "declined"   // Refused by the provider
+	PaymentStatusFailed     PaymentStatus = "failed"     // Provider error or timeout
+	PaymentStatusVoided     PaymentStatus = "voided"     // Authorization released without capture
+	PaymentStatusRefunded   PaymentStatus = "refunded"   // Captured funds returned
+)
+
+// Payment is one attempt to pay an order, every call to the provider is recorded on it.
+type Payment struct {
+	ID            int64         `json:"id"`
+	OrderID       int64         `json:"orderId"`
+	Provider      string        `json:"provider"`
+	ProviderRef   string        `json:"providerRef,omitempty"` // Reference returned by the provider on authorization
+	Amount        money.Money   `json:"amount"`
+	Status        PaymentStatus `json:"status"`
+	FailureReason string        `json:"failureReason,omitempty"`
+	CreatedAt     time.Time     `json:"createdAt"`
+	UpdatedAt     time.Time     `json:"updatedAt"`
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"bookstore/pkg/money"
+	"time"
+)
+
+type RefundStatus string
+
+const (
+	RefundStatusPending   RefundStatus = "pending"   // Recorded, provider not answered yet
+	RefundStatusSucceeded RefundStatus = "succeeded" // Money returned by the provider
+	RefundStatusFailed    RefundStatus = "failed"    // Refused by the provider, nothing returned
+)
+
+// Refund returns money for some or all lines of an order, against the
+// payment that captured it. A refund with no lines requested covers every
+// copy not refunded yet.
+type Refund struct {
+	ID            int64        `json:"id"`
+	OrderID       int64        `json:"orderId"`
+	PaymentID     int64        `json:"paymentId"`
+	Amount        money.Money  `json:"amount"`
+	Status        RefundStatus `json:"status"`
+	Reason        string       `json:"reason,omitempty"`
+	FailureReason string       `json:"failureReason,omitempty"`
+	Restock       bool         `json:"restock"` // Refunded copies go back in stock
+	CreatedBy     int64        `json:"createdBy"`
+	Lines         []RefundLine `json:"lines"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	UpdatedAt     time.Time    `json:"updatedAt"`
+}
+
+type RefundLine struct {
+	OrderDetailID int64       `json:"orderDetailId"`
+	BookID        int64       `json:"bookId"`
+	Quantity      int64       `json:"quantity"`
+	Amount        money.Money `json:"amount"`
+}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+type StockReason string
+
+const (
+	StockReasonSale       StockReason = "sale"       // Copies taken by a paid order
+	StockReasonRelease    StockReason = "release"    // Copies put back when a payment fails
+	StockReasonRefund     StockReason = "refund"     // Copies put back when an order is cancelled or refunded
+	StockReasonRestock    StockReason = "restock"    // New copies received
+	StockReasonReturn     StockReason = "return"     // Copies returned by a customer
+	StockReasonDamaged    StockReason = "damaged"    // Copies written off as damaged
+	StockReasonLost       StockReason = "lost"       // Copies that went missing
+	StockReasonCorrection StockReason = "correction" // Manual fix after a stock count
+)
+
+// StockMovement is one entry of the stock ledger, every change to books.stock has one.
+type StockMovement struct {
+	ID        int64       `json:"id"`
+	BookID    int64       `json:"bookId"`
+	Change    int64       `json:"change"` // Positive adds copies, negative removes them
+	Reason    StockReason `json:"reason"`
+	OrderID   *int64      `json:"orderId,omitempty"` // Set for sales, releases and refunds
+	Note      string      `json:"note,omitempty"`
+	Stock     int64       `json:"stock"` // Stock level after this movement
+	CreatedAt time.Time   `json:"createdAt"`
+}
@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// RefreshToken is a stored refresh token. Only the SHA-256 of the token is
+// kept, every token issued from one login shares its FamilyID.
+type RefreshToken struct {
+	ID         int64
+	CustomerID int64
+	FamilyID   string
+	TokenHash  string
+	ExpiresAt  time.Time
+	UsedAt     *time.Time // Set once the token was exchanged for a new one
+	RevokedAt  *time.Time // Set on logout or when reuse was detected
+	CreatedAt  time.Time
+}
+
+// TokenPair is returned on login and refresh.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"` // Access token lifetime in seconds
+}
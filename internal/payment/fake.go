@@ -0,0 +1,139 @@
+package payment
+
+import (
+	"bookstore/pkg/money"
+	"bookstore/pkg/utils"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const FakeProviderName = "fake"
+
+// Test cards understood by the fake gateway. Any other number is declined.
+const (
+	FakeCardSuccess  = "4242424242424242" // Authorizes and captures
+	FakeCardDeclined = "4000000000000002" // Declined on authorization
+	FakeCardTimeout  = "4000000000000119" // Authorization times out
+)
+
+type fakeCharge struct {
+	amount   money.Money
+	captured bool
+	voided   bool
+	refunded money.Money
+}
+
+// FakeProvider is an in-process gateway for tests and local development.
+// Outcomes depend only on the card number and no money ever moves.
+type FakeProvider struct {
+	mu      sync.Mutex
+	next    int
+	charges map[string]*fakeCharge
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{charges: map[string]*fakeCharge{}}
+}
+
+func (p *FakeProvider) Name() string {
+	return FakeProviderName
+}
+
+func (p *FakeProvider) Authorize(ctx context.Context, request AuthorizeRequest) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("%w: %v", utils.ErrPaymentTimeout, err)
+	}
+	if request.Amount.IsNegative() || request.Amount.IsZero() {
+		return "", fmt.Errorf("%w: amount must be positive", utils.ErrPaymentFailed)
+	}
+
+	switch strings.ReplaceAll(request.Card.Number, " ", "") {
+	case FakeCardSuccess:
+	case FakeCardDeclined:
+		return "", fmt.Errorf("%w: insufficient funds", utils.ErrPaymentDeclined)
+	case FakeCardTimeout:
+		return "", fmt.Errorf("%w: no answer from the fake gateway", utils.ErrPaymentTimeout)
+	default:
+		return "", fmt.Errorf("%w: unknown test card", utils.ErrPaymentDeclined)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.next++
+	reference := fmt.Sprintf("fake_%06d", p.next)
+	p.charges[reference] = &fakeCharge{
+		amount:   request.Amount,
+		refunded: money.New(0, request.Amount.CurrencyCode()),
+	}
+	return reference, nil
+}
+
+func (p *FakeProvider) Capture(ctx context.Context, reference string, amount money.Money) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	charge, err := p.charge(reference)
+	if err != nil {
+		return err
+	}
+	if charge.captured || charge.voided {
+		return fmt.Errorf("%w: %s is no longer capturable", utils.ErrPaymentFailed, reference)
+	}
+	if amount.Amount != charge.amount.Amount || amount.CurrencyCode() != charge.amount.CurrencyCode() {
+		return fmt.Errorf("%w: capture of %s does not match authorized %s", utils.ErrPaymentFailed, amount, charge.amount)
+	}
+
+	charge.captured = true
+	return nil
+}
+
+func (p *FakeProvider) Void(ctx context.Context, reference string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	charge, err := p.charge(reference)
+	if err != nil {
+		return err
+	}
+	if charge.captured {
+		return fmt.Errorf("%w: %s is already captured", utils.ErrPaymentFailed, reference)
+	}
+
+	charge.voided = true
+	return nil
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, reference string, amount money.Money) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	charge, err := p.charge(reference)
+	if err != nil {
+		return err
+	}
+	if !charge.captured {
+		return fmt.Errorf("%w: %s was never captured", utils.ErrPaymentFailed, reference)
+	}
+
+	refunded, err := charge.refunded.Add(amount)
+	if err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrPaymentFailed, err)
+	}
+	if amount.IsNegative() || refunded.Amount > charge.amount.Amount {
+		return fmt.Errorf("%w: refund of %s exceeds the captured amount", utils.ErrPaymentFailed, amount)
+	}
+
+	charge.refunded = refunded
+	return nil
+}
+
+func (p *FakeProvider) charge(reference string) (*fakeCharge, error) {
+	charge, ok := p.charges[reference]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown reference %s", utils.ErrPaymentFailed, reference)
+	}
+	return charge, nil
+}
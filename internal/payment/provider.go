@@ -0,0 +1,42 @@
+package payment
+
+import (
+	"bookstore/pkg/money"
+	"bookstore/pkg/utils"
+	"context"
+	"fmt"
+)
+
+// PaymentProvider is a card payment gateway. Money is first authorized (held)
+// and only taken on capture, an authorization that is not captured is voided.
+//
+// Errors wrap utils.ErrPaymentDeclined when the card was refused,
+// utils.ErrPaymentTimeout when the gateway did not answer in time and
+// utils.ErrPaymentFailed for anything else.
+type PaymentProvider interface {
+	Name() string
+	Authorize(ctx context.Context, request AuthorizeRequest) (reference string, err error)
+	Capture(ctx context.Context, reference string, amount money.Money) error
+	Void(ctx context.Context, reference string) error
+	Refund(ctx context.Context, reference string, amount money.Money) error
+}
+
+type Card struct {
+	Number string
+}
+
+type AuthorizeRequest struct {
+	Reference string // Our own reference for the attempt, e.g. "order-12-payment-3"
+	Amount    money.Money
+	Card      Card
+}
+
+// NewProvider returns the provider configured by name, an empty name selects the fake gateway.
+func NewProvider(name string) (PaymentProvider, error) {
+	switch name {
+	case "", FakeProviderName:
+		return NewFakeProvider(), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", utils.ErrUnknownPaymentProvider, name)
+	}
+}
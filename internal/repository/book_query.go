@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"bookstore/internal/model"
+	"bookstore/pkg/utils"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Columns a catalog page may be ordered by, keyed by the public sort name.
+// Only these strings are ever interpolated into the generated SQL.
+var bookSortColumns = map[model.BookSortField]string{
+	model.BookSortID:     "id",
+	model.BookSortTitle:  "title",
+	model.BookSortAuthor: "author",
+	model.BookSortPrice:  "price",
+}
+
+var sortDirections = map[model.SortDirection]string{
+	model.SortAsc:  "ASC",
+	model.SortDesc: "DESC",
+}
+
+// queryArgs collects positional arguments and hands out their placeholders.
+type queryArgs []interface{}
+
+func (a *queryArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// bookCursor is the keyset position of the last book on a page. It carries
+// the sort it was issued for so it cannot be replayed against another order.
+type bookCursor struct {
+	SortBy  model.BookSortField `json:"s"`
+	SortDir model.SortDirection `json:"d"`
+	Value   string              `json:"v,omitempty"`
+	ID      int64               `json:"id"`
+}
+
+func encodeBookCursor(query model.BookQuery, last model.Book) string {
+	cursor := bookCursor{SortBy: query.SortBy, SortDir: query.SortDir, ID: last.ID}
+
+	switch query.SortBy {
+	case model.BookSortTitle:
+		cursor.Value = last.Title
+	case model.BookSortAuthor:
+		cursor.Value = last.Author
+	case model.BookSortPrice:
+		cursor.Value = strconv.FormatInt(last.Price.Amount, 10)
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeBookCursor(query model.BookQuery) (*bookCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, utils.ErrInvalidCursor
+	}
+
+	var cursor bookCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, utils.ErrInvalidCursor
+	}
+
+	if cursor.SortBy != query.SortBy || cursor.SortDir != query.SortDir {
+		return nil, fmt.Errorf("%w: issued for a different sort order", utils.ErrInvalidCursor)
+	}
+
+	if cursor.SortBy == model.BookSortPrice {
+		if _, err := strconv.ParseInt(cursor.Value, 10, 64); err != nil {
+			return nil, utils.ErrInvalidCursor
+		}
+	}
+
+	return &cursor, nil
+}
+
+// condition returns the keyset predicate selecting rows after the cursor.
+func (c *bookCursor) condition(args *queryArgs) string {
+	op := ">"
+	if c.SortDir == model.SortDesc {
+		op = "<"
+	}
+
+	if c.SortBy == model.BookSortID {
+		return fmt.Sprintf("id %s %s", op, args.add(c.ID))
+	}
+
+	var value interface{} = c.Value
+	if c.SortBy == model.BookSortPrice {
+		// validated when the cursor was decoded
+		value, _ = strconv.ParseInt(c.Value, 10, 64)
+	}
+
+	return fmt.Sprintf(
+		"(%s, id) %s (%s, %s)",
+		bookSortColumns[c.SortBy],
+		op,
+		args.add(value),
+		args.add(c.ID),
+	)
+}
+
+// bookFilters turns the search and price parts of the query into predicates.
+// Archived books are never listed.
+func bookFilters(query model.BookQuery, args *queryArgs) []string {
+	filters := []string{"archived_at IS NULL"}
+
+	if query.Title != "" {
+		filters = append(filters, "title ILIKE "+args.add(likePattern(query.Title)))
+	}
+	if query.Author != "" {
+		filters = append(filters, "author ILIKE "+args.add(likePattern(query.Author)))
+	}
+	if query.MinPrice != nil {
+		filters = append(filters, "price >= "+args.add(*query.MinPrice))
+	}
+	if query.MaxPrice != nil {
+		filters = append(filters, "price <= "+args.add(*query.MaxPrice))
+	}
+
+	return filters
+}
+
+func whereClause(filters []string) string {
+	if len(filters) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(filters, " AND ")
+}
+
+// likePattern escapes LIKE wildcards so the input only matches as a substring.
+func likePattern(value string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + escaper.Replace(value) + "%"
+}
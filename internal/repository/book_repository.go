@@ -1,18 +1,24 @@
 package repository
 
 import (
+	"bookstore/internal/logging"
 	"bookstore/internal/model"
 	"bookstore/pkg/utils"
+	"context"
 
 	"database/sql"
-	"log"
+	"fmt"
 )
 
 type BookRepository interface {
-	CreateBook(book *model.Book) error
-	GetBooks() ([]model.Book, error)
-	GetBookById(id int) (*model.Book, error)
-	UpdateBook(book *model.Book) error
+	CreateBook(ctx context.Context, book *model.Book) error
+	GetBooks(ctx context.Context, query model.BookQuery) (*model.BookPage, error)
+	GetBookById(ctx context.Context, id int) (*model.Book, error)
+	UpdateBook(ctx context.Context, book *model.Book) error
+	AdjustStock(ctx context.Context, movement *model.StockMovement) error
+	ArchiveBook(ctx context.Context, id int) (*model.Book, error)
+	RestoreBook(ctx context.Context, id int) (*model.Book, error)
+	DeleteBook(ctx context.Context, id int) error
 }
 
 type bookRepository struct {
@@ -24,77 +30,311 @@ func NewBookRepository(db *sql.DB) BookRepository {
 }
 
 // Add new book to the database.
-func (r *bookRepository) CreateBook(book *model.Book) error {
+func (r *bookRepository) CreateBook(ctx context.Context, book *model.Book) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
 	query := "INSERT INTO books (title, author, price) VALUES ($1, $2, $3)"
-	_, err := r.db.Query(query, book.Title, book.Author, utils.ConvertStorePrice(&book.Price))
+	_, err := r.db.ExecContext(ctx, query, book.Title, book.Author, book.Price)
 	if err != nil {
-		log.Printf("[CreateBook] Error inserting book: %v", err)
+		logging.FromContext(ctx).Error("[CreateBook] Error inserting book", "error", err)
 		return err
 	}
 	return nil
 }
 
-// Retrieves list of all books
-func (r *bookRepository) GetBooks() ([]model.Book, error) {
-	query := "SELECT id, title, author, price FROM books"
-	rows, err := r.db.Query(query)
+// Retrieves one page of books matching the query, along with the total
+// number of matches and the cursor for the following page.
+func (r *bookRepository) GetBooks(ctx context.Context, query model.BookQuery) (*model.BookPage, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	column, ok := bookSortColumns[query.SortBy]
+	direction, validDirection := sortDirections[query.SortDir]
+	if !ok || !validDirection || query.Limit < 1 {
+		return nil, utils.ErrInvalidBookQuery
+	}
+
+	var cursor *bookCursor
+	if query.Cursor != "" {
+		var err error
+		if cursor, err = decodeBookCursor(query); err != nil {
+			return nil, err
+		}
+	}
+
+	var args queryArgs
+	filters := bookFilters(query, &args)
+
+	var total int64
+	countQuery := "SELECT COUNT(*) FROM books" + whereClause(filters)
+	if err := r.db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		logging.FromContext(ctx).Error("[GetBooks] Error counting books", "error", err)
+		return nil, err
+	}
+
+	pageFilters := append([]string{}, filters...)
+	if cursor != nil {
+		pageFilters = append(pageFilters, cursor.condition(&args))
+	}
+
+	orderBy := fmt.Sprintf("%s %s", column, direction)
+	if query.SortBy != model.BookSortID {
+		orderBy += fmt.Sprintf(", id %s", direction)
+	}
+
+	// one extra row tells whether there is a next page
+	selectQuery := "SELECT id, title, author, price, stock FROM books" + whereClause(pageFilters) +
+		" ORDER BY " + orderBy +
+		" LIMIT " + args.add(query.Limit+1)
+	if query.Offset > 0 {
+		selectQuery += " OFFSET " + args.add(query.Offset)
+	}
+
+	rows, err := r.db.QueryContext(ctx, selectQuery, args...)
 	if err != nil {
 		// db error
-		log.Printf("[GetBooks] Error retrieving list of books from database: %v", err)
+		logging.FromContext(ctx).Error("[GetBooks] Error retrieving list of books from database", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	var books []model.Book
+	books := []model.Book{}
 	for rows.Next() {
 		var book model.Book
-		var price int64
-		err := rows.Scan(&book.ID, &book.Title, &book.Author, &price)
+		err := rows.Scan(&book.ID, &book.Title, &book.Author, &book.Price, &book.Stock)
 		if err != nil {
-			log.Printf("[GetBooks] Error getting book: %v", err)
+			logging.FromContext(ctx).Error("[GetBooks] Error getting book", "error", err)
 			return nil, err
 		}
-		book.Price = *utils.ConvertToDisplayPrice(&price)
 		books = append(books, book)
 	}
-	return books, nil
+
+	if err := rows.Err(); err != nil {
+		logging.FromContext(ctx).Error("[GetBooks] Error iterating books", "error", err)
+		return nil, err
+	}
+
+	page := &model.BookPage{Total: total, Limit: query.Limit, Offset: query.Offset}
+	if len(books) > query.Limit {
+		books = books[:query.Limit]
+		page.NextCursor = encodeBookCursor(query, books[len(books)-1])
+	}
+	page.Books = books
+
+	return page, nil
 }
 
-// Retrieve a single book define by its id
-func (r *bookRepository) GetBookById(id int) (*model.Book, error) {
+// Retrieve a single book define by its id, archived books included
+func (r *bookRepository) GetBookById(ctx context.Context, id int) (*model.Book, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	var book model.Book
-	var price int64
-	query := "SELECT id, title, author, price FROM books WHERE id = $1"
-	row := r.db.QueryRow(query, id)
+	query := "SELECT id, title, author, price, stock, archived_at FROM books WHERE id = $1"
+	row := r.db.QueryRowContext(ctx, query, id)
 
-	err := row.Scan(&book.ID, &book.Title, &book.Author, &price)
+	err := row.Scan(&book.ID, &book.Title, &book.Author, &book.Price, &book.Stock, &book.ArchivedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Printf("[GetBookById] Book not found with id: %d", id)
+			logging.FromContext(ctx).Warn("[GetBookById] Book not found", "book_id", id)
 			return &book, utils.ErrBookNotFound
 		}
-		log.Printf("[GetBookById] Error retrieving book with id: %d, error: %v", id, err)
+		logging.FromContext(ctx).Error("[GetBookById] Error retrieving book", "book_id", id, "error", err)
 		return &book, err
 	}
 
-	book.Price = *utils.ConvertToDisplayPrice(&price)
 	return &book, nil
 }
 
 // UpdateBook implements Repository.
-func (r *bookRepository) UpdateBook(book *model.Book) error {
+func (r *bookRepository) UpdateBook(ctx context.Context, book *model.Book) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	var updateId int
 	query := "UPDATE books SET title = $1, author = $2, price = $3 WHERE id = $4 RETURNING id"
-	err := r.db.QueryRow(query, book.Title, book.Author, utils.ConvertStorePrice(&book.Price), book.ID).
+	err := r.db.QueryRowContext(ctx, query, book.Title, book.Author, book.Price, book.ID).
 		Scan(&updateId)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Printf("[UpdateBook] Book not found with id: %d", book.ID)
+			logging.FromContext(ctx).Warn("[UpdateBook] Book not found", "book_id", book.ID)
 			return utils.ErrBookNotFound
 		}
-		log.Printf("[UpdateBook] Error updating book with id: %d, error: %v", book.ID, err)
+		logging.FromContext(ctx).Error("[UpdateBook] Error updating book", "book_id", book.ID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// AdjustStock changes the stock of a book and records the movement in the
+// ledger within one transaction. The book row is locked so concurrent
+// adjustments and checkouts see each other's changes.
+func (r *bookRepository) AdjustStock(ctx context.Context, movement *model.StockMovement) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("[AdjustStock] Could not start transaction", "book_id", movement.BookID, "error", err)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx).Error("[AdjustStock] Recovered from panic, rolling back transaction", "panic", p)
+			tx.Rollback()
+		}
+	}()
+
+	var stock int64
+	err = tx.QueryRowContext(ctx, "SELECT stock FROM books WHERE id = $1 FOR UPDATE", movement.BookID).
+		Scan(&stock)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			logging.FromContext(ctx).Warn("[AdjustStock] Book not found", "book_id", movement.BookID)
+			return utils.ErrBookNotFound
+		}
+		logging.FromContext(ctx).Error("[AdjustStock] Error locking book", "book_id", movement.BookID, "error", err)
+		return err
+	}
+
+	if stock+movement.Change < 0 {
+		tx.Rollback()
+		return utils.ErrInsufficientStock
+	}
+	movement.Stock = stock + movement.Change
+
+	_, err = tx.ExecContext(ctx, "UPDATE books SET stock = $1 WHERE id = $2", movement.Stock, movement.BookID)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[AdjustStock] Error updating stock", "book_id", movement.BookID, "error", err)
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO stock_movements (book_id, quantity_change, reason, order_id, note, stock_after)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`,
+		movement.BookID,
+		movement.Change,
+		movement.Reason,
+		movement.OrderID,
+		movement.Note,
+		movement.Stock,
+	).Scan(&movement.ID, &movement.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[AdjustStock] Error recording stock movement", "book_id", movement.BookID, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("[AdjustStock] Could not commit transaction", "book_id", movement.BookID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// ArchiveBook withdraws a book from sale. It disappears from the catalog but
+// stays in order history, archiving an archived book keeps its archive time.
+func (r *bookRepository) ArchiveBook(ctx context.Context, id int) (*model.Book, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	return r.setArchivedAt(ctx, "ArchiveBook", `
+	UPDATE books SET archived_at = COALESCE(archived_at, NOW())
+	WHERE id = $1
+	RETURNING id, title, author, price, stock, archived_at`, id)
+}
+
+// RestoreBook puts an archived book back on sale.
+func (r *bookRepository) RestoreBook(ctx context.Context, id int) (*model.Book, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	return r.setArchivedAt(ctx, "RestoreBook", `
+	UPDATE books SET archived_at = NULL
+	WHERE id = $1
+	RETURNING id, title, author, price, stock, archived_at`, id)
+}
+
+func (r *bookRepository) setArchivedAt(ctx context.Context, logHeader, query string, id int) (*model.Book, error) {
+	var book model.Book
+	err := r.db.QueryRowContext(ctx, query, id).
+		Scan(&book.ID, &book.Title, &book.Author, &book.Price, &book.Stock, &book.ArchivedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logging.FromContext(ctx).Warn("["+logHeader+"] Book not found", "book_id", id)
+			return nil, utils.ErrBookNotFound
+		}
+		logging.FromContext(ctx).Error("["+logHeader+"] Error updating book", "book_id", id, "error", err)
+		return nil, err
+	}
+
+	return &book, nil
+}
+
+// DeleteBook removes a book for good, together with its stock ledger. Books
+// that any order or cart refers to cannot be deleted and ErrBookReferenced is
+// returned, archive them instead. The book row is locked so no cart can
+// add it while the check runs.
+func (r *bookRepository) DeleteBook(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("[DeleteBook] Could not start transaction", "book_id", id, "error", err)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx).Error("[DeleteBook] Recovered from panic, rolling back transaction", "panic", p)
+			tx.Rollback()
+		}
+	}()
+
+	var referenced bool
+	err = tx.QueryRowContext(ctx, `
+	SELECT EXISTS (SELECT 1 FROM order_details WHERE book_id = b.id)
+	FROM books b
+	WHERE b.id = $1
+	FOR UPDATE`, id).Scan(&referenced)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			logging.FromContext(ctx).Warn("[DeleteBook] Book not found", "book_id", id)
+			return utils.ErrBookNotFound
+		}
+		logging.FromContext(ctx).Error("[DeleteBook] Error locking book", "book_id", id, "error", err)
+		return err
+	}
+
+	if referenced {
+		tx.Rollback()
+		return utils.ErrBookReferenced
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM stock_movements WHERE book_id = $1", id); err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[DeleteBook] Error deleting stock movements", "book_id", id, "error", err)
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM books WHERE id = $1", id); err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[DeleteBook] Error deleting book", "book_id", id, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("[DeleteBook] Could not commit transaction", "book_id", id, "error", err)
 		return err
 	}
 
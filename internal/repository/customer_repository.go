@@ -1,18 +1,39 @@
 package repository
 
 import (
+	"bookstore/internal/logging"
 	"bookstore/internal/model"
 	"bookstore/pkg/utils"
+	"context"
 	"database/sql"
-	"log"
 	"strings"
+	"time"
 )
 
 type CustomerRepository interface {
-	Register(customer *model.Customer) error
-	Login(email, password string) (*model.Customer, error)
+	Register(ctx context.Context, customer *model.Customer) error
+	Login(ctx context.Context, email, password string) (*model.Customer, error)
+	UpdateRole(ctx context.Context, customerID int, role model.Role) error
+	GetCustomerById(ctx context.Context, customerID int) (*model.Customer, error)
+	GetProfile(ctx context.Context, customerID int) (*model.CustomerProfile, error)
+	UpdateProfile(ctx context.Context, customerID int, name, address *string) (*model.CustomerProfile, error)
+	GetPasswordHash(ctx context.Context, customerID int) (string, error)
+	UpdatePassword(ctx context.Context, customerID int, passwordHash string) error
+	CreateEmailVerification(ctx context.Context, verification *model.EmailVerification) error
+	ConfirmEmail(ctx context.Context, tokenHash string) (*model.EmailVerification, error)
 }
 
+// profileColumns are selected for a CustomerProfile, the pending email is the
+// latest change still waiting for confirmation.
+const profileColumns = `id, email, name, address, role,
+	COALESCE((
+		SELECT v.email
+		FROM email_verifications v
+		WHERE v.customer_id = customers.id AND v.used_at IS NULL AND v.expires_at > NOW()
+		ORDER BY v.id DESC
+		LIMIT 1
+	), '')`
+
 type customerRepository struct {
 	db *sql.DB
 }
@@ -22,21 +43,21 @@ func NewCustomerRepository(db *sql.DB) CustomerRepository {
 }
 
 // Register implements CustomerRepository.
-func (c *customerRepository) Register(customer *model.Customer) error {
+func (c *customerRepository) Register(ctx context.Context, customer *model.Customer) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
 	query := "INSERT INTO customers (email, password, name, address)  VALUES ($1, $2, $3, $4)"
-	_, err := c.db.Exec(query, customer.Email, customer.Password, customer.Name, customer.Address)
+	_, err := c.db.ExecContext(ctx, query, customer.Email, customer.Password, customer.Name, customer.Address)
 
 	if err != nil {
 
 		// checking the error for duplicate email since email is unique
-		if strings.Contains(err.Error(), "23505") &&
-			strings.Contains(err.Error(), "customers_email_key") {
-
+		if isDuplicateEmail(err) {
 			return utils.ErrDuplicateEmail
 		}
 
-		log.Printf("[Register] Could not register customer: %v", err)
+		logging.FromContext(ctx).Error("[Register] Could not register customer", "error", err)
 		return err
 	}
 
@@ -45,21 +66,306 @@ func (c *customerRepository) Register(customer *model.Customer) error {
 
 // Login implements CustomerRepository.
 // Returning models of customer to be checked in customer service
-func (c *customerRepository) Login(email string, password string) (*model.Customer, error) {
+func (c *customerRepository) Login(ctx context.Context, email string, password string) (*model.Customer, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	var customer model.Customer
 
-	query := `SELECT id, email, password FROM customers WHERE email = $1`
-	err := c.db.QueryRow(query, email).
-		Scan(&customer.ID, &customer.Email, &customer.Password)
+	query := `SELECT id, email, password, role FROM customers WHERE email = $1`
+	err := c.db.QueryRowContext(ctx, query, email).
+		Scan(&customer.ID, &customer.Email, &customer.Password, &customer.Role)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, utils.ErrEmailNotFound
 		}
 
-		log.Printf("[Login] Error getting customer from database: %v", err)
+		logging.FromContext(ctx).Error("[Login] Error getting customer from database", "error", err)
 		return nil, err
 	}
 
 	return &customer, nil
 }
+
+// UpdateRole implements CustomerRepository.
+func (c *customerRepository) UpdateRole(ctx context.Context, customerID int, role model.Role) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := c.db.ExecContext(ctx, "UPDATE customers SET role = $1 WHERE id = $2", role, customerID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[UpdateRole] Could not update role", "customer_id", customerID, "error", err)
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logging.FromContext(ctx).Error("[UpdateRole] Could not check update", "customer_id", customerID, "error", err)
+		return err
+	}
+
+	if affected == 0 {
+		return utils.ErrCustomerNotFound
+	}
+
+	return nil
+}
+
+// GetCustomerById returns a customer without the password hash.
+func (c *customerRepository) GetCustomerById(ctx context.Context, customerID int) (*model.Customer, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var customer model.Customer
+	query := "SELECT id, email, name, address, role FROM customers WHERE id = $1"
+	err := c.db.QueryRowContext(ctx, query, customerID).
+		Scan(&customer.ID, &customer.Email, &customer.Name, &customer.Address, &customer.Role)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, utils.ErrCustomerNotFound
+		}
+		logging.FromContext(ctx).Error("[GetCustomerById] Error getting customer from database", "customer_id", customerID, "error", err)
+		return nil, err
+	}
+
+	return &customer, nil
+}
+
+// GetProfile returns the customer's own view of their account.
+func (c *customerRepository) GetProfile(ctx context.Context, customerID int) (*model.CustomerProfile, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT " + profileColumns + " FROM customers WHERE id = $1"
+	profile, err := scanProfile(c.db.QueryRowContext(ctx, query, customerID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, utils.ErrCustomerNotFound
+		}
+		logging.FromContext(ctx).Error("[GetProfile] Error getting customer from database", "customer_id", customerID, "error", err)
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// UpdateProfile changes the name and address, a nil value keeps the current one.
+func (c *customerRepository) UpdateProfile(ctx context.Context, customerID int, name, address *string) (*model.CustomerProfile, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE customers
+	SET name = COALESCE($1, name), address = COALESCE($2, address)
+	WHERE id = $3
+	RETURNING ` + profileColumns
+	profile, err := scanProfile(c.db.QueryRowContext(ctx, query, name, address, customerID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, utils.ErrCustomerNotFound
+		}
+		logging.FromContext(ctx).Error("[UpdateProfile] Could not update profile", "customer_id", customerID, "error", err)
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// GetPasswordHash returns the stored bcrypt hash to check the current password against.
+func (c *customerRepository) GetPasswordHash(ctx context.Context, customerID int) (string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var hash string
+	err := c.db.QueryRowContext(ctx, "SELECT password FROM customers WHERE id = $1", customerID).Scan(&hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", utils.ErrCustomerNotFound
+		}
+		logging.FromContext(ctx).Error("[GetPasswordHash] Error getting customer from database", "customer_id", customerID, "error", err)
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// UpdatePassword stores a new bcrypt hash.
+func (c *customerRepository) UpdatePassword(ctx context.Context, customerID int, passwordHash string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := c.db.ExecContext(ctx, "UPDATE customers SET password = $1 WHERE id = $2", passwordHash, customerID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[UpdatePassword] Could not update password", "customer_id", customerID, "error", err)
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logging.FromContext(ctx).Error("[UpdatePassword] Could not check update", "customer_id", customerID, "error", err)
+		return err
+	}
+
+	if affected == 0 {
+		return utils.ErrCustomerNotFound
+	}
+
+	return nil
+}
+
+// CreateEmailVerification stores a requested email change and drops the
+// customer's earlier ones, so only the latest token can be confirmed.
+// An email already used by a customer is refused with ErrDuplicateEmail.
+func (c *customerRepository) CreateEmailVerification(ctx context.Context, verification *model.EmailVerification) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("[CreateEmailVerification] Could not start transaction", "customer_id", verification.CustomerID, "error", err)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx).Error("[CreateEmailVerification] Recovered from panic, rolling back transaction", "panic", p)
+			tx.Rollback()
+		}
+	}()
+
+	var taken bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM customers WHERE email = $1)", verification.Email).Scan(&taken)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[CreateEmailVerification] Error checking email", "customer_id", verification.CustomerID, "error", err)
+		return err
+	}
+	if taken {
+		tx.Rollback()
+		return utils.ErrDuplicateEmail
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM email_verifications
+	WHERE customer_id = $1 AND used_at IS NULL`, verification.CustomerID)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[CreateEmailVerification] Error dropping earlier requests", "customer_id", verification.CustomerID, "error", err)
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO email_verifications (customer_id, email, token_hash, expires_at)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`,
+		verification.CustomerID,
+		verification.Email,
+		verification.TokenHash,
+		verification.ExpiresAt,
+	).Scan(&verification.ID, &verification.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[CreateEmailVerification] Error storing request", "customer_id", verification.CustomerID, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("[CreateEmailVerification] Could not commit transaction", "customer_id", verification.CustomerID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// ConfirmEmail replaces the customer's email with the one the token was sent
+// to. A token can only be used once, the address may have been taken since
+// it was requested, which is reported as ErrDuplicateEmail.
+func (c *customerRepository) ConfirmEmail(ctx context.Context, tokenHash string) (*model.EmailVerification, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("[ConfirmEmail] Could not start transaction", "error", err)
+		return nil, err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx).Error("[ConfirmEmail] Recovered from panic, rolling back transaction", "panic", p)
+			tx.Rollback()
+		}
+	}()
+
+	var verification model.EmailVerification
+	err = tx.QueryRowContext(ctx, `
+	SELECT id, customer_id, email, token_hash, expires_at, created_at
+	FROM email_verifications
+	WHERE token_hash = $1 AND used_at IS NULL
+	FOR UPDATE`, tokenHash).Scan(
+		&verification.ID,
+		&verification.CustomerID,
+		&verification.Email,
+		&verification.TokenHash,
+		&verification.ExpiresAt,
+		&verification.CreatedAt,
+	)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, utils.ErrInvalidVerificationToken
+		}
+		logging.FromContext(ctx).Error("[ConfirmEmail] Error retrieving verification", "error", err)
+		return nil, err
+	}
+
+	if !time.Now().Before(verification.ExpiresAt) {
+		tx.Rollback()
+		return nil, utils.ErrVerificationTokenExpired
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE customers SET email = $1 WHERE id = $2", verification.Email, verification.CustomerID)
+	if err != nil {
+		tx.Rollback()
+		if isDuplicateEmail(err) {
+			return nil, utils.ErrDuplicateEmail
+		}
+		logging.FromContext(ctx).Error("[ConfirmEmail] Could not update email", "customer_id", verification.CustomerID, "error", err)
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+	UPDATE email_verifications
+	SET used_at = NOW()
+	WHERE id = $1
+	RETURNING used_at`, verification.ID).Scan(&verification.UsedAt)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[ConfirmEmail] Error using verification", "customer_id", verification.CustomerID, "error", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("[ConfirmEmail] Could not commit transaction", "customer_id", verification.CustomerID, "error", err)
+		return nil, err
+	}
+
+	return &verification, nil
+}
+
+func scanProfile(row *sql.Row) (*model.CustomerProfile, error) {
+	var profile model.CustomerProfile
+	err := row.Scan(&profile.ID, &profile.Email, &profile.Name, &profile.Address, &profile.Role, &profile.PendingEmail)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// isDuplicateEmail reports a unique violation of customers.email.
+func isDuplicateEmail(err error) bool {
+	return strings.Contains(err.Error(), "23505") &&
+		strings.Contains(err.Error(), "customers_email_key")
+}
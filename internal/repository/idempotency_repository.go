@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"bookstore/internal/logging"
+	"bookstore/internal/model"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type IdempotencyRepository interface {
+	ClaimIdempotencyKey(ctx context.Context, key *model.IdempotencyKey, ttl time.Duration) (*model.IdempotencyKey, error)
+	SaveIdempotentResponse(ctx context.Context, key *model.IdempotencyKey) error
+	ReleaseIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+}
+
+type idempotencyRepository struct {
+	db *sql.DB
+}
+
+func NewIdempotencyRepository(db *sql.DB) IdempotencyRepository {
+	return &idempotencyRepository{db: db}
+}
+
+// ClaimIdempotencyKey stores key for ttl unless an unexpired row for the same
+// customer and key exists. It returns nil when the key was claimed, otherwise
+// the stored row so the caller can compare requests or replay the response.
+func (r *idempotencyRepository) ClaimIdempotencyKey(
+	ctx context.Context,
+	key *model.IdempotencyKey,
+	ttl time.Duration,
+) (*model.IdempotencyKey, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	// a key released between the two queries can be claimed on the next try
+	for attempt := 0; attempt < 2; attempt++ {
+		err := r.db.QueryRowContext(ctx, `
+		INSERT INTO idempotency_keys (customer_id, key, request_hash, expires_at)
+		VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))
+		ON CONFLICT (customer_id, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash,
+			status_code = NULL,
+			response_body = NULL,
+			response_headers = NULL,
+			created_at = NOW(),
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= NOW()
+		RETURNING created_at, expires_at`,
+			key.CustomerID,
+			key.Key,
+			key.RequestHash,
+			ttl.Seconds(),
+		).Scan(&key.CreatedAt, &key.ExpiresAt)
+		if err == nil {
+			return nil, nil
+		}
+		if err != sql.ErrNoRows {
+			logging.FromContext(ctx).Error("[ClaimIdempotencyKey] Error claiming key", "customer_id", key.CustomerID, "error", err)
+			return nil, err
+		}
+
+		existing := model.IdempotencyKey{CustomerID: key.CustomerID, Key: key.Key}
+		var statusCode sql.NullInt64
+		var headers []byte
+		err = r.db.QueryRowContext(ctx, `
+		SELECT request_hash, status_code, response_body, response_headers, created_at, expires_at
+		FROM idempotency_keys
+		WHERE customer_id = $1 AND key = $2`, key.CustomerID, key.Key).Scan(
+			&existing.RequestHash,
+			&statusCode,
+			&existing.ResponseBody,
+			&headers,
+			&existing.CreatedAt,
+			&existing.ExpiresAt,
+		)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			logging.FromContext(ctx).Error("[ClaimIdempotencyKey] Error reading key", "customer_id", key.CustomerID, "error", err)
+			return nil, err
+		}
+
+		existing.StatusCode = int(statusCode.Int64)
+		// rows stored before headers were kept have none
+		if headers != nil {
+			if err := json.Unmarshal(headers, &existing.ResponseHeaders); err != nil {
+				logging.FromContext(ctx).Error("[ClaimIdempotencyKey] Error decoding headers", "customer_id", key.CustomerID, "error", err)
+				return nil, err
+			}
+		}
+		return &existing, nil
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// SaveIdempotentResponse stores the response of the request that claimed the key.
+func (r *idempotencyRepository) SaveIdempotentResponse(ctx context.Context, key *model.IdempotencyKey) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	headers, err := json.Marshal(key.ResponseHeaders)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `
+	UPDATE idempotency_keys
+	SET status_code = $3, response_body = $4, response_headers = $5
+	WHERE customer_id = $1 AND key = $2`, key.CustomerID, key.Key, key.StatusCode, key.ResponseBody, headers)
+	if err != nil {
+		logging.FromContext(ctx).Error("[SaveIdempotentResponse] Error storing response", "customer_id", key.CustomerID, "error", err)
+	}
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a key whose request did not complete, so it can be retried.
+func (r *idempotencyRepository) ReleaseIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `
+	DELETE FROM idempotency_keys
+	WHERE customer_id = $1 AND key = $2 AND status_code IS NULL`, key.CustomerID, key.Key)
+	if err != nil {
+		logging.FromContext(ctx).Error("[ReleaseIdempotencyKey] Error releasing key", "customer_id", key.CustomerID, "error", err)
+	}
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys removes every key past its expiry and returns
+// how many were removed. Expired keys are never replayed, only their rows are left.
+func (r *idempotencyRepository) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at < NOW()")
+	if err != nil {
+		logging.FromContext(ctx).Error("[DeleteExpiredIdempotencyKeys] Error deleting expired keys", "error", err)
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		logging.FromContext(ctx).Error("[DeleteExpiredIdempotencyKeys] Could not count deleted keys", "error", err)
+		return 0, err
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"bookstore/internal/model"
+	"bookstore/pkg/utils"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// orderCursor is the keyset position of the last order on a history page.
+// History is always ordered by (checked_out_at, id), most recent first.
+type orderCursor struct {
+	CheckedOutAt time.Time `json:"t"`
+	ID           int64     `json:"id"`
+}
+
+func encodeOrderCursor(last model.OrderResponse) string {
+	cursor := orderCursor{ID: last.ID}
+	if last.CheckedOutAt != nil {
+		cursor.CheckedOutAt = *last.CheckedOutAt
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeOrderCursor(value string) (*orderCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, utils.ErrInvalidCursor
+	}
+
+	var cursor orderCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == 0 {
+		return nil, utils.ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+// condition returns the keyset predicate selecting orders older than the cursor.
+func (c *orderCursor) condition(args *queryArgs) string {
+	return fmt.Sprintf("(o.checked_out_at, o.id) < (%s, %s)", args.add(c.CheckedOutAt), args.add(c.ID))
+}
+
+// orderHistoryFilters turns the history query into predicates on orders o.
+// Carts are never part of the history, orders waiting for payment only when
+// their state is asked for. Dates apply to the checkout time, like the order
+// of the pages, so later updates cannot move an order in or out of the range.
+func orderHistoryFilters(query model.OrderHistoryQuery, args *queryArgs) []string {
+	filters := []string{
+		"o.customer_id = " + args.add(query.CustomerID),
+		"o.order_state <> " + args.add(model.OrderStateCart),
+		"EXISTS (SELECT 1 FROM order_details x WHERE x.order_id = o.id)",
+	}
+
+	if query.From != nil {
+		filters = append(filters, "o.checked_out_at >= "+args.add(*query.From))
+	}
+	if query.To != nil {
+		filters = append(filters, "o.checked_out_at < "+args.add(*query.To))
+	}
+	if len(query.States) > 0 {
+		placeholders := make([]string, len(query.States))
+		for i, state := range query.States {
+			placeholders[i] = args.add(state)
+		}
+		filters = append(filters, "o.order_state IN ("+strings.Join(placeholders, ", ")+")")
+	} else {
+		filters = append(filters, "o.order_state <> "+args.add(model.OrderStatePendingPayment))
+	}
+	if query.MinTotal != nil {
+		filters = append(filters, "o.total >= "+args.add(*query.MinTotal))
+	}
+	if query.BookID != nil {
+		filters = append(filters, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM order_details x WHERE x.order_id = o.id AND x.book_id = %s)",
+			args.add(*query.BookID),
+		))
+	}
+
+	return filters
+}
@@ -1,20 +1,44 @@
 package repository
 
 import (
+	"bookstore/internal/logging"
 	"bookstore/internal/model"
+	"bookstore/pkg/money"
 	"bookstore/pkg/utils"
-	"log"
+	"context"
+	"fmt"
+	"slices"
 
 	"database/sql"
 )
 
+// maxCartAttempts bounds how often CreateOrderIfNotExists retries when the
+// cart it raced with is checked out before it could be read.
+const maxCartAttempts = 3
+
 type OrderRepository interface {
-	AddOrUpdateCart(orderID, bookID, quantity int, subtotal int64) error
-	RemoveFromCart(orderId int, bookId int) error
-	GetCart(orderId int) (*model.OrderResponse, error)
-	GetOrderHistory(customerID, limit, page int) ([]model.OrderResponse, error)
-	CreateOrderIfNotExists(customerID int) (int, error)
-	PayOrder(customerID int) error
+	AddOrUpdateCart(ctx context.Context, detail *model.OrderDetail) error
+	RemoveFromCart(ctx context.Context, orderId int, bookId int) error
+	RepriceCart(ctx context.Context, orderID int) error
+	GetCart(ctx context.Context, orderId int) (*model.OrderResponse, error)
+	GetOrder(ctx context.Context, orderID int) (*model.OrderResponse, error)
+	GetOrderHistory(ctx context.Context, query model.OrderHistoryQuery) (*model.OrderHistoryPage, error)
+	CreateOrderIfNotExists(ctx context.Context, customerID int) (id int, created bool, err error)
+	StartCheckout(ctx context.Context, customerID int) (*model.Order, error)
+	CompleteCheckout(ctx context.Context, orderID int, customerID int) error
+	AbandonCheckout(ctx context.Context, orderID int, customerID int, note string) error
+	CancelCheckout(ctx context.Context, transition *model.OrderStateTransition) error
+	GetOrderState(ctx context.Context, orderID int) (model.OrderState, error)
+	TransitionOrder(ctx context.Context, transition *model.OrderStateTransition) error
+	GetOrderTransitions(ctx context.Context, orderID int) ([]model.OrderStateTransition, error)
+	GetOrderSummary(ctx context.Context, orderID int) (*model.Order, error)
+	StartRefund(
+		ctx context.Context,
+		refund *model.Refund,
+		transition *model.OrderStateTransition,
+	) (fullyRefunded bool, err error)
+	CompleteRefund(ctx context.Context, refund *model.Refund, markRefunded bool) error
+	FailRefund(ctx context.Context, refund *model.Refund) error
 }
 
 type orderRepository struct {
@@ -25,18 +49,24 @@ func NewOrderRepository(db *sql.DB) OrderRepository {
 	return &orderRepository{db: db}
 }
 
-func (r *orderRepository) GetCart(orderId int) (*model.OrderResponse, error) {
-	query := `SELECT o.id, o.total,
+func (r *orderRepository) GetCart(ctx context.Context, orderId int) (*model.OrderResponse, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT o.id, o.order_state, o.updated_at, o.checked_out_at, o.total,
 			  d.id AS detail_id, d.book_id, d.quantity, d.subtotal,
-			  b.title, b.author, b.price
+			  d.title, d.author, d.unit_price, d.currency,
+			  b.price AS catalog_price, b.archived_at,
+			  d.refunded_quantity, o.refunded_total
 			  FROM orders o
 			  JOIN order_details d ON o.id = d.order_id
 			  JOIN books b ON d.book_id = b.id
-			  WHERE o.id = $1 AND o.order_state = $2`
+			  WHERE o.id = $1 AND o.order_state = $2
+			  ORDER BY d.id`
 
-	rows, err := r.db.Query(query, orderId, model.OrderState_One)
+	rows, err := r.db.QueryContext(ctx, query, orderId, model.OrderStateCart)
 	if err != nil {
-		log.Printf("[GetCart] Error retrieving cart: %v", err)
+		logging.FromContext(ctx).Error("[GetCart] Error retrieving cart", "error", err)
 		return nil, err
 	}
 
@@ -44,7 +74,7 @@ func (r *orderRepository) GetCart(orderId int) (*model.OrderResponse, error) {
 
 	cart, err := utils.ConvertToDetailResponse(rows)
 	if err != nil {
-		log.Printf("[GetCart] Error converting rows to detail response: %v", err)
+		logging.FromContext(ctx).Error("[GetCart] Error converting rows to detail response", "error", err)
 		return nil, err
 	}
 
@@ -52,227 +82,937 @@ func (r *orderRepository) GetCart(orderId int) (*model.OrderResponse, error) {
 		return nil, utils.WarnCartEmpty
 	}
 
+	// Archived books stay in the cart but can no longer be bought
+	for i := range cart[0].OrderDetail {
+		for _, book := range cart[0].OrderDetail[i].Book {
+			if book.ArchivedAt != nil {
+				cart[0].OrderDetail[i].Unavailable = true
+			}
+		}
+	}
+
 	// Return the first OrderResponse
 	return &cart[0], nil
 }
 
-func (r *orderRepository) AddOrUpdateCart(orderID, bookID, quantity int, subtotal int64) error {
+// GetOrder returns an order with its lines whatever its state. The lines are
+// the snapshot taken at checkout, so no catalog prices are compared.
+func (r *orderRepository) GetOrder(ctx context.Context, orderID int) (*model.OrderResponse, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT o.id, o.order_state, o.updated_at, o.checked_out_at, o.total,
+			  d.id AS detail_id, d.book_id, d.quantity, d.subtotal,
+			  d.title, d.author, d.unit_price, d.currency,
+			  NULL AS catalog_price, b.archived_at,
+			  d.refunded_quantity, o.refunded_total
+			  FROM orders o
+			  JOIN order_details d ON o.id = d.order_id
+			  JOIN books b ON d.book_id = b.id
+			  WHERE o.id = $1
+			  ORDER BY d.id`
+
+	rows, err := r.db.QueryContext(ctx, query, orderID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[GetOrder] Error retrieving order", "order_id", orderID, "error", err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	orders, err := utils.ConvertToDetailResponse(rows)
+	if err != nil {
+		logging.FromContext(ctx).Error("[GetOrder] Error converting rows to detail response", "order_id", orderID, "error", err)
+		return nil, err
+	}
+
+	if len(orders) == 0 {
+		return nil, utils.ErrOrderNotFound
+	}
+
+	return &orders[0], nil
+}
+
+// AddOrUpdateCart stores a cart line together with the book snapshot it was priced from.
+func (r *orderRepository) AddOrUpdateCart(ctx context.Context, detail *model.OrderDetail) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	orderID := int(detail.OrderID)
+
 	// Begin a transaction
-	tx, err := r.db.Begin()
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf(
-			"[AddOrUpdateCart] Could not start transaction for order ID %d: %v",
-			orderID,
-			err,
-		)
+		logging.FromContext(ctx).Error("[AddOrUpdateCart] Could not start transaction", "order_id", orderID, "error", err)
 		return err
 	}
 
 	defer func() {
 		if p := recover(); p != nil {
-			log.Println("Recovered from panic, rolling back transaction in AddOrUpdateCart")
+			logging.FromContext(ctx).Error("[AddOrUpdateCart] Recovered from panic, rolling back transaction", "panic", p)
 			tx.Rollback()
 		}
 	}()
 
-	_, err = tx.Exec(`
-	INSERT INTO order_details (order_id, book_id, quantity, subtotal)
-	VALUES ($1, $2, $3, $4)
-	ON CONFLICT (order_id, book_id) 
-	DO UPDATE SET 
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO order_details (order_id, book_id, quantity, subtotal, unit_price, title, author, currency)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (order_id, book_id)
+	DO UPDATE SET
 		quantity = $3,
-		subtotal = $4;
-	`, orderID, bookID, quantity, subtotal)
+		subtotal = $4,
+		unit_price = $5,
+		title = $6,
+		author = $7,
+		currency = $8;
+	`,
+		detail.OrderID,
+		detail.BookID,
+		detail.Quantity,
+		detail.Subtotal,
+		detail.UnitPrice,
+		detail.Title,
+		detail.Author,
+		detail.UnitPrice.CurrencyCode(),
+	)
 	if err != nil {
 		tx.Rollback()
-		log.Printf(
-			"[AddOrUpdateCart] Error updating order_details for order ID %d: %v",
-			orderID,
-			err,
-		)
+		logging.FromContext(ctx).Error("[AddOrUpdateCart] Error updating order_details", "order_id", orderID, "error", err)
 		return err
 	}
 
 	// Recalculate the total for the order
-	if err := r.RecalculateTotalPrice(tx, orderID); err != nil {
+	if err := r.RecalculateTotalPrice(ctx, tx, orderID); err != nil {
 		tx.Rollback()
-		log.Printf("[AddOrUpdateCart] Error updating order total for order ID %d: %v", orderID, err)
+		logging.FromContext(ctx).Error("[AddOrUpdateCart] Error updating order total", "order_id", orderID, "error", err)
 		return err
 	}
 
 	// Commit the transaction
 	if err := tx.Commit(); err != nil {
-		log.Printf(
-			"[AddOrUpdateCart] Could not commit transaction for order ID %d: %v",
-			orderID,
-			err,
-		)
+		logging.FromContext(ctx).Error("[AddOrUpdateCart] Could not commit transaction", "order_id", orderID, "error", err)
 		return err
 	}
 
 	return nil
 }
 
-func (r *orderRepository) RemoveFromCart(orderID, bookID int) error {
+func (r *orderRepository) RemoveFromCart(ctx context.Context, orderID, bookID int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	// Begin a transaction to handle potential rollback in case of errors
-	tx, err := r.db.Begin()
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("[RemoveFromCart] Could not start transaction for order ID %d: %v", orderID, err)
+		logging.FromContext(ctx).Error("[RemoveFromCart] Could not start transaction", "order_id", orderID, "error", err)
 		return err
 	}
 
 	defer func() {
 		if p := recover(); p != nil {
-			log.Println("Recovered from panic, rolling back transaction in RemoveFromCart")
+			logging.FromContext(ctx).Error("[RemoveFromCart] Recovered from panic, rolling back transaction", "panic", p)
 			tx.Rollback()
 		}
 	}()
 
 	// Delete the book from order_details based on orderID and bookID
-	_, err = tx.Exec(
+	_, err = tx.ExecContext(
+		ctx,
 		`DELETE FROM order_details WHERE order_id = $1 AND book_id = $2`,
 		orderID,
 		bookID,
 	)
 	if err != nil {
 		tx.Rollback()
-		log.Printf(
-			"[RemoveFromCart] Error deleting from order_details for order ID %d, book ID %d: %v",
-			orderID,
-			bookID,
-			err,
-		)
+		logging.FromContext(ctx).Error("[RemoveFromCart] Error deleting from order_details", "order_id", orderID, "book_id", bookID, "error", err)
 		return err
 	}
 
 	// Recalculate the total for the order
-	if err := r.RecalculateTotalPrice(tx, orderID); err != nil {
+	if err := r.RecalculateTotalPrice(ctx, tx, orderID); err != nil {
 		tx.Rollback()
-		log.Printf("[RemoveFromCart] Error updating order total for order ID %d: %v", orderID, err)
+		logging.FromContext(ctx).Error("[RemoveFromCart] Error updating order total", "order_id", orderID, "error", err)
 		return err
 	}
 
 	// Commit the transaction
 	if err := tx.Commit(); err != nil {
-		log.Printf(
-			"[RemoveFromCart] Could not commit transaction for order ID %d: %v",
-			orderID,
-			err,
-		)
+		logging.FromContext(ctx).Error("[RemoveFromCart] Could not commit transaction", "order_id", orderID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// RepriceCart accepts the current catalog price, title and author for every
+// line of a cart and recalculates its total.
+func (r *orderRepository) RepriceCart(ctx context.Context, orderID int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("[RepriceCart] Could not start transaction", "order_id", orderID, "error", err)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx).Error("[RepriceCart] Recovered from panic, rolling back transaction", "panic", p)
+			tx.Rollback()
+		}
+	}()
+
+	// A cart that went to checkout in the meantime keeps its prices
+	var id int
+	err = tx.QueryRowContext(ctx, `
+	SELECT id FROM orders
+	WHERE id = $1 AND order_state = $2
+	FOR UPDATE`, orderID, model.OrderStateCart).Scan(&id)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return utils.WarnCartEmpty
+		}
+		logging.FromContext(ctx).Error("[RepriceCart] Error locking cart", "order_id", orderID, "error", err)
+		return err
+	}
+
+	if err := r.refreshSnapshots(ctx, tx, orderID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := r.RecalculateTotalPrice(ctx, tx, orderID); err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[RepriceCart] Error updating order total", "order_id", orderID, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("[RepriceCart] Could not commit transaction", "order_id", orderID, "error", err)
 		return err
 	}
 
 	return nil
 }
 
-// GetOrderHistory retrieves all paid orders for a specific customer
+// GetOrderHistory retrieves one page of a customer's checked out orders,
+// most recently checked out first, along with the number of matching orders.
+// Pages are keyed on the checkout time rather than updated_at, so an order
+// changing state between two fetches neither repeats nor goes missing.
 func (r *orderRepository) GetOrderHistory(
-	customerID, limit, page int,
-) ([]model.OrderResponse, error) {
-	query := `SELECT o.id, o.total,
+	ctx context.Context,
+	query model.OrderHistoryQuery,
+) (*model.OrderHistoryPage, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if query.Limit < 1 {
+		return nil, utils.ErrInvalidOrderQuery
+	}
+
+	var cursor *orderCursor
+	if query.Cursor != "" {
+		var err error
+		if cursor, err = decodeOrderCursor(query.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	var args queryArgs
+	filters := orderHistoryFilters(query, &args)
+
+	var total int64
+	countQuery := "SELECT COUNT(*) FROM orders o" + whereClause(filters)
+	if err := r.db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		logging.FromContext(ctx).Error("[GetOrderHistory] Error counting orders", "customer_id", query.CustomerID, "error", err)
+		return nil, err
+	}
+
+	pageFilters := append([]string{}, filters...)
+	if cursor != nil {
+		pageFilters = append(pageFilters, cursor.condition(&args))
+	}
+
+	// one extra order tells whether there is a next page
+	selectQuery := `SELECT o.id, o.order_state, o.updated_at, o.checked_out_at, o.total,
 			  d.id AS detail_id, d.book_id, d.quantity, d.subtotal,
-			  b.title, b.author, b.price
+			  d.title, d.author, d.unit_price, d.currency,
+			  NULL AS catalog_price, b.archived_at,
+			  d.refunded_quantity, o.refunded_total
 			  FROM (
-				SELECT * FROM orders
-				WHERE customer_id = $1 AND order_state = $4
-				ORDER BY updated_at ASC
-				LIMIT $2 OFFSET $3
+				SELECT o.* FROM orders o` + whereClause(pageFilters) + `
+				ORDER BY o.checked_out_at DESC, o.id DESC
+				LIMIT ` + args.add(query.Limit+1) + `
 			  ) o
 			  JOIN order_details d ON o.id = d.order_id
-			  JOIN books b ON d.book_id = b.id`
+			  JOIN books b ON d.book_id = b.id
+			  ORDER BY o.checked_out_at DESC, o.id DESC, d.id`
 
-	rows, err := r.db.Query(query, customerID, limit, page*limit, model.OrderState_Two)
+	rows, err := r.db.QueryContext(ctx, selectQuery, args...)
 	if err != nil {
-		log.Printf(
-			"[GetOrderHistory] Error retrieving paid orders for customer ID %d: %v",
-			customerID,
-			err,
-		)
+		logging.FromContext(ctx).Error("[GetOrderHistory] Error retrieving orders", "customer_id", query.CustomerID, "error", err)
 		return nil, err
 	}
 
 	defer rows.Close()
 
-	return utils.ConvertToDetailResponse(rows)
+	orders, err := utils.ConvertToDetailResponse(rows)
+	if err != nil {
+		logging.FromContext(ctx).Error("[GetOrderHistory] Error converting rows to detail response", "customer_id", query.CustomerID, "error", err)
+		return nil, err
+	}
+
+	page := &model.OrderHistoryPage{Total: total, Limit: query.Limit}
+	if len(orders) > query.Limit {
+		orders = orders[:query.Limit]
+		page.NextCursor = encodeOrderCursor(orders[len(orders)-1])
+	}
+	page.Orders = orders
+
+	return page, nil
 }
 
 // Create Order / Cart if not exist.
-// Order state :
-// - OrderState_One means still in cart state
-// - OrderState_Two means already in paid state
-func (r *orderRepository) CreateOrderIfNotExists(customerID int) (int, error) {
-	var id int
+// Only an order in OrderStateCart is treated as the customer's cart,
+// any other state means the order has already been checked out.
+// created tells whether a new cart was inserted.
+//
+// The orders_one_open_cart_per_customer index allows a single cart per
+// customer, so concurrent calls cannot open two: the losing insert does
+// nothing and the cart of the winner is read instead. If that cart was
+// checked out in between, a new one is tried again.
+func (r *orderRepository) CreateOrderIfNotExists(ctx context.Context, customerID int) (int, bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
-	err := r.db.QueryRow(`
+	selectQuery := `
 		SELECT id FROM orders
 		WHERE customer_id = $1 AND order_state = $2
-	`, customerID, model.OrderState_One).Scan(&id)
+	`
 
-	if err == nil {
-		return id, nil
-	} else if err != sql.ErrNoRows {
-		log.Printf("[CreateOrderIfNotExists] Error checking order to database for customer ID %d: %v", customerID, err)
-		return 0, err
+	// the conflict target must repeat the index predicate literally, 1 is OrderStateCart
+	insertQuery := `
+	INSERT INTO orders (customer_id, updated_at, total, order_state)
+	VALUES ($1, NOW(), 0, $2)
+	ON CONFLICT (customer_id) WHERE order_state = 1 DO NOTHING
+	RETURNING id;`
+
+	for attempt := 0; attempt < maxCartAttempts; attempt++ {
+		var id int
+
+		err := r.db.QueryRowContext(ctx, selectQuery, customerID, model.OrderStateCart).Scan(&id)
+		if err == nil {
+			return id, false, nil
+		} else if err != sql.ErrNoRows {
+			logging.FromContext(ctx).Error("[CreateOrderIfNotExists] Error checking order to database", "customer_id", customerID, "error", err)
+			return 0, false, err
+		}
+
+		err = r.db.QueryRowContext(ctx, insertQuery, customerID, model.OrderStateCart).Scan(&id)
+		if err == nil {
+			return id, true, nil
+		} else if err != sql.ErrNoRows {
+			logging.FromContext(ctx).Error("[CreateOrderIfNotExists] Error creating order", "customer_id", customerID, "error", err)
+			return 0, false, err
+		}
 	}
 
-	query := `
-	INSERT INTO orders (customer_id, updated_at, total)
-	VALUES ($1, NOW(), 0)
-	RETURNING id;`
+	logging.FromContext(ctx).Error("[CreateOrderIfNotExists] Cart kept changing state", "customer_id", customerID)
+	return 0, false, utils.ErrOrderStateChanged
+}
+
+// StartCheckout moves the customer's cart to pending payment. The order and
+// its books are locked so stock is checked and reserved atomically with the
+// state change, if any book is short nothing changes and an OutOfStockError
+// is returned. A cart whose prices drifted from the catalog is refused with
+// a PriceChangedError until the customer reprices it, one holding archived
+// books with an UnavailableItemsError. An empty cart is refused with
+// WarnCartEmpty and one whose total is not positive with ErrOrderNotPayable.
+// Titles and authors are refreshed from the catalog, so the order records
+// exactly what is paid for.
+func (r *orderRepository) StartCheckout(ctx context.Context, customerID int) (*model.Order, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	// Begin a transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("[StartCheckout] Could not start transaction", "customer_id", customerID, "error", err)
+		return nil, err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx).Error("[StartCheckout] Recovered from panic, rolling back transaction", "panic", p)
+			tx.Rollback()
+		}
+	}()
+
+	order := model.Order{CustomerID: int64(customerID), OrderState: model.OrderStatePendingPayment}
+	err = tx.QueryRowContext(ctx, `
+	SELECT id FROM orders
+	WHERE customer_id = $1 AND order_state = $2
+	FOR UPDATE`, customerID, model.OrderStateCart).Scan(&order.ID)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, utils.WarnCartEmpty
+		}
+		logging.FromContext(ctx).Error("[StartCheckout] Error locking cart", "customer_id", customerID, "error", err)
+		return nil, err
+	}
+
+	if err := r.checkLines(ctx, tx, int(order.ID)); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := r.refreshSnapshots(ctx, tx, int(order.ID)); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := r.RecalculateTotalPrice(ctx, tx, int(order.ID)); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := r.reserveStock(ctx, tx, int(order.ID)); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Only the locked cart is moved on
+	err = tx.QueryRowContext(ctx, `
+	UPDATE orders
+	SET order_state = $2, updated_at = NOW(), checked_out_at = NOW()
+	WHERE id = $1
+	RETURNING total, updated_at`, order.ID, model.OrderStatePendingPayment).Scan(&order.Total, &order.UpdatedAt)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[StartCheckout] Error updating order state", "customer_id", customerID, "error", err)
+		return nil, err
+	}
+
+	// e.g. every line priced at zero, nothing can be charged
+	if order.Total.IsZero() || order.Total.IsNegative() {
+		tx.Rollback()
+		return nil, utils.ErrOrderNotPayable
+	}
+
+	err = r.recordTransition(ctx, tx, &model.OrderStateTransition{
+		OrderID:   order.ID,
+		From:      model.OrderStateCart,
+		To:        model.OrderStatePendingPayment,
+		ChangedBy: int64(customerID),
+	})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("[StartCheckout] Could not commit transaction", "customer_id", customerID, "error", err)
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// CompleteCheckout marks an order waiting for payment as paid.
+// ErrOrderStateChanged is returned if the order is no longer pending.
+func (r *orderRepository) CompleteCheckout(ctx context.Context, orderID int, customerID int) error {
+	return r.TransitionOrder(ctx, &model.OrderStateTransition{
+		OrderID:   int64(orderID),
+		From:      model.OrderStatePendingPayment,
+		To:        model.OrderStatePaid,
+		ChangedBy: int64(customerID),
+	})
+}
+
+// AbandonCheckout turns an order waiting for payment back into the
+// customer's cart and puts the reserved copies back in stock. If the customer
+// opened another cart meanwhile, e.g. from a second tab, the lines are merged
+// into it and the emptied order is cancelled, a customer only has one cart.
+// ErrOrderStateChanged is returned if the order is no longer pending.
+func (r *orderRepository) AbandonCheckout(ctx context.Context, orderID int, customerID int, note string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("[AbandonCheckout] Could not start transaction", "order_id", orderID, "error", err)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx).Error("[AbandonCheckout] Recovered from panic, rolling back transaction", "panic", p)
+			tx.Rollback()
+		}
+	}()
+
+	var owner int
+	err = tx.QueryRowContext(ctx, `
+	SELECT customer_id FROM orders
+	WHERE id = $1 AND order_state = $2
+	FOR UPDATE`, orderID, model.OrderStatePendingPayment).Scan(&owner)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return utils.ErrOrderStateChanged
+		}
+		logging.FromContext(ctx).Error("[AbandonCheckout] Error locking order", "order_id", orderID, "error", err)
+		return err
+	}
+
+	if err := r.releaseStock(ctx, tx, orderID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var cartID int
+	err = tx.QueryRowContext(ctx, `
+	SELECT id FROM orders
+	WHERE customer_id = $1 AND order_state = $2
+	FOR UPDATE`, owner, model.OrderStateCart).Scan(&cartID)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[AbandonCheckout] Error looking up open cart", "order_id", orderID, "error", err)
+		return err
+	}
+
+	transition := &model.OrderStateTransition{
+		OrderID:   int64(orderID),
+		From:      model.OrderStatePendingPayment,
+		To:        model.OrderStateCart,
+		ChangedBy: int64(customerID),
+		Note:      note,
+	}
+
+	if err == sql.ErrNoRows {
+		_, err = tx.ExecContext(ctx, `
+		UPDATE orders
+		SET order_state = $2, updated_at = NOW()
+		WHERE id = $1`, orderID, model.OrderStateCart)
+		if err != nil {
+			tx.Rollback()
+			logging.FromContext(ctx).Error("[AbandonCheckout] Error updating state", "order_id", orderID, "error", err)
+			return err
+		}
+	} else {
+		if err := r.mergeIntoCart(ctx, tx, orderID, cartID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		transition.To = model.OrderStateCancelled
+		transition.Note = fmt.Sprintf("%s, merged into cart %d", note, cartID)
+	}
+
+	if err := r.recordTransition(ctx, tx, transition); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("[AbandonCheckout] Could not commit transaction", "order_id", orderID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// CancelCheckout cancels an order still waiting for payment and puts its
+// reserved copies back in stock. ErrOrderStateChanged is returned if the
+// order is no longer pending.
+func (r *orderRepository) CancelCheckout(ctx context.Context, transition *model.OrderStateTransition) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("[CancelCheckout] Could not start transaction", "order_id", transition.OrderID, "error", err)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx).Error("[CancelCheckout] Recovered from panic, rolling back transaction", "panic", p)
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx, `
+	UPDATE orders
+	SET order_state = $3, updated_at = NOW()
+	WHERE id = $1 AND order_state = $2`, transition.OrderID, model.OrderStatePendingPayment, model.OrderStateCancelled)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[CancelCheckout] Error updating state", "order_id", transition.OrderID, "error", err)
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if affected == 0 {
+		tx.Rollback()
+		return utils.ErrOrderStateChanged
+	}
+
+	if err := r.releaseStock(ctx, tx, int(transition.OrderID)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	transition.From = model.OrderStatePendingPayment
+	transition.To = model.OrderStateCancelled
+	if err := r.recordTransition(ctx, tx, transition); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("[CancelCheckout] Could not commit transaction", "order_id", transition.OrderID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// mergeIntoCart moves the lines of orderID into the open cart cartID, adding
+// up quantities of books already in it, and cancels the emptied order. It is
+// kept rather than deleted as its payments still reference it.
+func (r *orderRepository) mergeIntoCart(ctx context.Context, tx *sql.Tx, orderID int, cartID int) error {
+	_, err := tx.ExecContext(ctx, `
+	INSERT INTO order_details (order_id, book_id, quantity, subtotal, unit_price, title, author, currency)
+	SELECT $2, d.book_id, d.quantity, d.subtotal, d.unit_price, d.title, d.author, d.currency
+	FROM order_details d
+	WHERE d.order_id = $1
+	ON CONFLICT (order_id, book_id) DO UPDATE
+	SET quantity = order_details.quantity + EXCLUDED.quantity,
+		subtotal = order_details.unit_price * (order_details.quantity + EXCLUDED.quantity)`, orderID, cartID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[mergeIntoCart] Error merging lines", "order_id", orderID, "cart_id", cartID, "error", err)
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM order_details WHERE order_id = $1", orderID); err != nil {
+		logging.FromContext(ctx).Error("[mergeIntoCart] Error removing merged lines", "order_id", orderID, "error", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE orders
+	SET order_state = $2, total = 0, updated_at = NOW()
+	WHERE id = $1`, orderID, model.OrderStateCancelled)
+	if err != nil {
+		logging.FromContext(ctx).Error("[mergeIntoCart] Error cancelling merged order", "order_id", orderID, "error", err)
+		return err
+	}
+
+	return r.RecalculateTotalPrice(ctx, tx, cartID)
+}
+
+// checkLines makes sure an order can be checked out as it is: it must have
+// lines, none of their books may be archived (UnavailableItemsError) and every
+// line must still carry the catalog price (PriceChangedError). The books are
+// locked in id order, like in reserveStock, so none of this can change before
+// the lines are refreshed.
+func (r *orderRepository) checkLines(ctx context.Context, tx *sql.Tx, orderID int) error {
+	rows, err := tx.QueryContext(ctx, `
+	SELECT b.id, d.title, d.unit_price, b.price, b.archived_at IS NOT NULL
+	FROM order_details d
+	JOIN books b ON b.id = d.book_id
+	WHERE d.order_id = $1
+	ORDER BY b.id
+	FOR UPDATE OF b`, orderID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[checkLines] Error locking books", "order_id", orderID, "error", err)
+		return err
+	}
 
-	if err := r.db.QueryRow(query, customerID).Scan(&id); err != nil {
-		log.Printf(
-			"[CreateOrderIfNotExists] Error creating order for customer ID %d: %v",
-			customerID,
-			err,
-		)
+	lines := 0
+	var unavailable []utils.UnavailableItem
+	var changes []utils.PriceChangedItem
+	for rows.Next() {
+		var item utils.PriceChangedItem
+		var archived bool
+		if err := rows.Scan(&item.BookID, &item.Title, &item.OldPrice, &item.NewPrice, &archived); err != nil {
+			rows.Close()
+			logging.FromContext(ctx).Error("[checkLines] Error reading lines", "order_id", orderID, "error", err)
+			return err
+		}
+		lines++
+		if archived {
+			unavailable = append(unavailable, utils.UnavailableItem{BookID: item.BookID, Title: item.Title})
+		} else if item.OldPrice.Amount != item.NewPrice.Amount {
+			changes = append(changes, item)
+		}
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		logging.FromContext(ctx).Error("[checkLines] Error reading lines", "order_id", orderID, "error", err)
+		return err
+	}
+
+	// repricing cannot make a withdrawn book buyable, so it is reported first
+	switch {
+	case lines == 0:
+		return utils.WarnCartEmpty
+	case len(unavailable) > 0:
+		return &utils.UnavailableItemsError{Items: unavailable}
+	case len(changes) > 0:
+		return &utils.PriceChangedError{Items: changes}
+	}
+	return nil
+}
+
+// refreshSnapshots copies the current title, author and price of every
+// book in an order onto its lines and reprices their subtotals.
+func (r *orderRepository) refreshSnapshots(ctx context.Context, tx *sql.Tx, orderID int) error {
+	_, err := tx.ExecContext(ctx, `
+	UPDATE order_details d
+	SET title = b.title,
+		author = b.author,
+		unit_price = b.price,
+		currency = $2,
+		subtotal = b.price * d.quantity
+	FROM books b
+	WHERE d.order_id = $1 AND b.id = d.book_id`, orderID, money.DefaultCurrency)
+	if err != nil {
+		logging.FromContext(ctx).Error("[refreshSnapshots] Error refreshing lines", "order_id", orderID, "error", err)
+	}
+	return err
+}
+
+// reserveStock takes the ordered copies out of stock and writes a sale
+// movement per line. Books are locked in id order to avoid deadlocks
+// between checkouts sharing the same titles. Archived books count as out
+// of stock.
+func (r *orderRepository) reserveStock(ctx context.Context, tx *sql.Tx, orderID int) error {
+	rows, err := tx.QueryContext(ctx, `
+	SELECT b.id, b.title, b.stock, d.quantity, b.archived_at IS NOT NULL
+	FROM order_details d
+	JOIN books b ON b.id = d.book_id
+	WHERE d.order_id = $1
+	ORDER BY b.id
+	FOR UPDATE OF b`, orderID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[reserveStock] Error locking books", "order_id", orderID, "error", err)
+		return err
+	}
+
+	var shortages []utils.OutOfStockItem
+	for rows.Next() {
+		var item utils.OutOfStockItem
+		var archived bool
+		if err := rows.Scan(&item.BookID, &item.Title, &item.Available, &item.Requested, &archived); err != nil {
+			rows.Close()
+			logging.FromContext(ctx).Error("[reserveStock] Error reading stock", "order_id", orderID, "error", err)
+			return err
+		}
+		if archived {
+			// withdrawn books cannot be sold whatever is left in stock
+			item.Available = 0
+		}
+		if item.Requested > item.Available {
+			shortages = append(shortages, item)
+		}
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		logging.FromContext(ctx).Error("[reserveStock] Error reading stock", "order_id", orderID, "error", err)
+		return err
+	}
+
+	if len(shortages) > 0 {
+		return &utils.OutOfStockError{Items: shortages}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE books b SET stock = b.stock - d.quantity
+	FROM order_details d
+	WHERE d.order_id = $1 AND b.id = d.book_id`, orderID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[reserveStock] Error decrementing stock", "order_id", orderID, "error", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO stock_movements (book_id, quantity_change, reason, order_id, stock_after)
+	SELECT d.book_id, -d.quantity, $2, d.order_id, b.stock
+	FROM order_details d
+	JOIN books b ON b.id = d.book_id
+	WHERE d.order_id = $1`, orderID, model.StockReasonSale)
+	if err != nil {
+		logging.FromContext(ctx).Error("[reserveStock] Error recording stock movements", "order_id", orderID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// releaseStock puts the copies reserved by an order back in stock and
+// writes a release movement per line. Books are locked in id order like in
+// reserveStock.
+func (r *orderRepository) releaseStock(ctx context.Context, tx *sql.Tx, orderID int) error {
+	_, err := tx.ExecContext(ctx, `
+	UPDATE books b SET stock = b.stock + d.quantity
+	FROM (
+		SELECT d.book_id, d.quantity
+		FROM order_details d
+		JOIN books b ON b.id = d.book_id
+		WHERE d.order_id = $1
+		ORDER BY b.id
+		FOR UPDATE OF b
+	) d
+	WHERE b.id = d.book_id`, orderID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[releaseStock] Error restoring stock", "order_id", orderID, "error", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO stock_movements (book_id, quantity_change, reason, order_id, stock_after)
+	SELECT d.book_id, d.quantity, $2, d.order_id, b.stock
+	FROM order_details d
+	JOIN books b ON b.id = d.book_id
+	WHERE d.order_id = $1`, orderID, model.StockReasonRelease)
+	if err != nil {
+		logging.FromContext(ctx).Error("[releaseStock] Error recording stock movements", "order_id", orderID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetOrderState returns the current state of any order.
+func (r *orderRepository) GetOrderState(ctx context.Context, orderID int) (model.OrderState, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var state model.OrderState
+	err := r.db.QueryRowContext(ctx, "SELECT order_state FROM orders WHERE id = $1", orderID).Scan(&state)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, utils.ErrOrderNotFound
+		}
+		logging.FromContext(ctx).Error("[GetOrderState] Error retrieving state", "order_id", orderID, "error", err)
 		return 0, err
 	}
-	return id, nil
+	return state, nil
 }
 
-func (r *orderRepository) PayOrder(customerID int) error {
-	// Begin a transaction
-	tx, err := r.db.Begin()
+// TransitionOrder moves an order from transition.From to transition.To and
+// records it in the history. The update only applies while the order is still
+// in transition.From, otherwise ErrOrderStateChanged is returned.
+func (r *orderRepository) TransitionOrder(ctx context.Context, transition *model.OrderStateTransition) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("[PayOrder] Could not start transaction for customer ID %d: %v", customerID, err)
+		logging.FromContext(ctx).Error("[TransitionOrder] Could not start transaction", "order_id", transition.OrderID, "error", err)
 		return err
 	}
 
 	defer func() {
 		if p := recover(); p != nil {
-			log.Println("Recovered from panic, rolling back transaction in PayOrder")
+			logging.FromContext(ctx).Error("[TransitionOrder] Recovered from panic, rolling back transaction", "panic", p)
 			tx.Rollback()
 		}
 	}()
 
-	// Update the order state to indicate it has been paid for the first order that matches the customerID
-	_, err = tx.Exec(`
-    UPDATE orders
-    SET order_state = $2, updated_at = NOW()
-    WHERE customer_id = $1 AND order_state = $3
-    RETURNING id;`, customerID, model.OrderState_Two, model.OrderState_One) // Only update if the current state is 1 or Cart
+	result, err := tx.ExecContext(ctx, `
+	UPDATE orders
+	SET order_state = $3, updated_at = NOW()
+	WHERE id = $1 AND order_state = $2`, transition.OrderID, transition.From, transition.To)
 	if err != nil {
 		tx.Rollback()
-		log.Printf("[PayOrder] Error updating order state for customer ID %d: %v", customerID, err)
+		logging.FromContext(ctx).Error("[TransitionOrder] Error updating state", "order_id", transition.OrderID, "error", err)
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if affected == 0 {
+		tx.Rollback()
+		return utils.ErrOrderStateChanged
+	}
+
+	if err := r.recordTransition(ctx, tx, transition); err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	// Commit the transaction
 	if err := tx.Commit(); err != nil {
-		log.Printf(
-			"[PayOrder] Could not commit transaction for customer ID %d: %v",
-			customerID,
-			err,
-		)
+		logging.FromContext(ctx).Error("[TransitionOrder] Could not commit transaction", "order_id", transition.OrderID, "error", err)
 		return err
 	}
 
 	return nil
 }
 
-func (r *orderRepository) RecalculateTotalPrice(tx *sql.Tx, orderID int) error {
-	_, err := tx.Exec(`
+// GetOrderTransitions returns the state history of an order, oldest first.
+func (r *orderRepository) GetOrderTransitions(ctx context.Context, orderID int) ([]model.OrderStateTransition, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+	SELECT id, order_id, from_state, to_state, changed_by, note, created_at
+	FROM order_state_transitions
+	WHERE order_id = $1
+	ORDER BY created_at, id`, orderID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[GetOrderTransitions] Error retrieving history", "order_id", orderID, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	transitions := []model.OrderStateTransition{}
+	for rows.Next() {
+		var t model.OrderStateTransition
+		err := rows.Scan(&t.ID, &t.OrderID, &t.From, &t.To, &t.ChangedBy, &t.Note, &t.CreatedAt)
+		if err != nil {
+			logging.FromContext(ctx).Error("[GetOrderTransitions] Error reading history", "order_id", orderID, "error", err)
+			return nil, err
+		}
+		transitions = append(transitions, t)
+	}
+
+	return transitions, rows.Err()
+}
+
+func (r *orderRepository) recordTransition(ctx context.Context, tx *sql.Tx, transition *model.OrderStateTransition) error {
+	err := tx.QueryRowContext(ctx, `
+	INSERT INTO order_state_transitions (order_id, from_state, to_state, changed_by, note)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`,
+		transition.OrderID,
+		transition.From,
+		transition.To,
+		transition.ChangedBy,
+		transition.Note,
+	).Scan(&transition.ID, &transition.CreatedAt)
+	if err != nil {
+		logging.FromContext(ctx).Error("[recordTransition] Error recording transition", "order_id", transition.OrderID, "error", err)
+	}
+	return err
+}
+
+func (r *orderRepository) RecalculateTotalPrice(ctx context.Context, tx *sql.Tx, orderID int) error {
+	_, err := tx.ExecContext(ctx, `
 	WITH subtotal_sum AS (
 		SELECT SUM(d.subtotal) as total_sum
 		FROM order_details d
@@ -281,11 +1021,435 @@ func (r *orderRepository) RecalculateTotalPrice(tx *sql.Tx, orderID int) error {
 		WHERE o.id = $1`, orderID)
 
 	if err != nil {
-		log.Printf(
-			"[RecalculateTotalPrice] Error recalculating total price for order ID %d: %v",
-			orderID,
-			err,
-		)
+		logging.FromContext(ctx).Error("[RecalculateTotalPrice] Error recalculating total price", "order_id", orderID, "error", err)
 	}
 	return err
 }
+
+// GetOrderSummary returns an order without its lines, whatever its state.
+func (r *orderRepository) GetOrderSummary(ctx context.Context, orderID int) (*model.Order, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var order model.Order
+	err := r.db.QueryRowContext(ctx, `
+	SELECT id, customer_id, updated_at, order_state, total
+	FROM orders
+	WHERE id = $1`, orderID).Scan(&order.ID, &order.CustomerID, &order.UpdatedAt, &order.OrderState, &order.Total)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logging.FromContext(ctx).Warn("[GetOrderSummary] Order not found", "order_id", orderID)
+			return nil, utils.ErrOrderNotFound
+		}
+		logging.FromContext(ctx).Error("[GetOrderSummary] Error retrieving order", "order_id", orderID, "error", err)
+		return nil, err
+	}
+	return &order, nil
+}
+
+// StartRefund records a pending refund and already counts its copies and
+// amount as refunded, so concurrent refunds cannot give back more than was
+// paid. The order is locked in transition.From, ErrOrderStateChanged is
+// returned if it moved on, and is moved to transition.To in the same
+// transaction when that differs. Requested lines are matched by book, a
+// refund without lines takes every copy not refunded yet. fullyRefunded tells
+// whether nothing of the order is left to refund.
+func (r *orderRepository) StartRefund(
+	ctx context.Context,
+	refund *model.Refund,
+	transition *model.OrderStateTransition,
+) (fullyRefunded bool, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("[StartRefund] Could not start transaction", "order_id", refund.OrderID, "error", err)
+		return false, err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx).Error("[StartRefund] Recovered from panic, rolling back transaction", "panic", p)
+			tx.Rollback()
+		}
+	}()
+
+	var locked int64
+	err = tx.QueryRowContext(ctx, `
+	SELECT id FROM orders
+	WHERE id = $1 AND order_state = $2
+	FOR UPDATE`, refund.OrderID, transition.From).Scan(&locked)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return false, utils.ErrOrderStateChanged
+		}
+		logging.FromContext(ctx).Error("[StartRefund] Error locking order", "order_id", refund.OrderID, "error", err)
+		return false, err
+	}
+
+	if err := r.resolveRefundLines(ctx, tx, refund); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if transition.To != transition.From {
+		_, err = tx.ExecContext(ctx, `
+		UPDATE orders
+		SET order_state = $2, updated_at = NOW()
+		WHERE id = $1`, refund.OrderID, transition.To)
+		if err != nil {
+			tx.Rollback()
+			logging.FromContext(ctx).Error("[StartRefund] Error updating state", "order_id", refund.OrderID, "error", err)
+			return false, err
+		}
+
+		if err := r.recordTransition(ctx, tx, transition); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+
+	for _, line := range refund.Lines {
+		_, err = tx.ExecContext(ctx, `
+		UPDATE order_details
+		SET refunded_quantity = refunded_quantity + $2
+		WHERE id = $1`, line.OrderDetailID, line.Quantity)
+		if err != nil {
+			tx.Rollback()
+			logging.FromContext(ctx).Error("[StartRefund] Error counting refunded copies", "order_id", refund.OrderID, "error", err)
+			return false, err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `
+	UPDATE orders
+	SET refunded_total = refunded_total + $2
+	WHERE id = $1
+	RETURNING refunded_total >= COALESCE(total, 0)`, refund.OrderID, refund.Amount).Scan(&fullyRefunded)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[StartRefund] Error counting refunded amount", "order_id", refund.OrderID, "error", err)
+		return false, err
+	}
+
+	refund.Status = model.RefundStatusPending
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO refunds (order_id, payment_id, amount, status, reason, restock, created_by)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at, updated_at`,
+		refund.OrderID,
+		refund.PaymentID,
+		refund.Amount,
+		refund.Status,
+		refund.Reason,
+		refund.Restock,
+		refund.CreatedBy,
+	).Scan(&refund.ID, &refund.CreatedAt, &refund.UpdatedAt)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[StartRefund] Error recording refund", "order_id", refund.OrderID, "error", err)
+		return false, err
+	}
+
+	for _, line := range refund.Lines {
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO refund_lines (refund_id, order_detail_id, quantity, amount)
+		VALUES ($1, $2, $3, $4)`, refund.ID, line.OrderDetailID, line.Quantity, line.Amount)
+		if err != nil {
+			tx.Rollback()
+			logging.FromContext(ctx).Error("[StartRefund] Error recording refund line", "refund_id", refund.ID, "error", err)
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("[StartRefund] Could not commit transaction", "order_id", refund.OrderID, "error", err)
+		return false, err
+	}
+
+	return fullyRefunded, nil
+}
+
+// resolveRefundLines checks the requested lines against what is left to
+// refund of every order line, fills in their line ids and amounts at the unit
+// price that was paid, and the refund total. Problems with the request are
+// reported as ErrInvalidRefund.
+func (r *orderRepository) resolveRefundLines(ctx context.Context, tx *sql.Tx, refund *model.Refund) error {
+	type orderLine struct {
+		id        int64
+		bookID    int64
+		title     string
+		left      int64
+		unitPrice money.Money
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+	SELECT id, book_id, title, quantity - refunded_quantity, unit_price, currency
+	FROM order_details
+	WHERE order_id = $1
+	ORDER BY id
+	FOR UPDATE`, refund.OrderID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[resolveRefundLines] Error locking lines", "order_id", refund.OrderID, "error", err)
+		return err
+	}
+
+	var orderLines []orderLine
+	for rows.Next() {
+		var line orderLine
+		var currency string
+		if err := rows.Scan(&line.id, &line.bookID, &line.title, &line.left, &line.unitPrice, &currency); err != nil {
+			rows.Close()
+			logging.FromContext(ctx).Error("[resolveRefundLines] Error reading lines", "order_id", refund.OrderID, "error", err)
+			return err
+		}
+		line.unitPrice.Currency = currency
+		orderLines = append(orderLines, line)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		logging.FromContext(ctx).Error("[resolveRefundLines] Error reading lines", "order_id", refund.OrderID, "error", err)
+		return err
+	}
+
+	requested := refund.Lines
+	if len(requested) == 0 {
+		for _, line := range orderLines {
+			if line.left > 0 {
+				requested = append(requested, model.RefundLine{BookID: line.bookID, Quantity: line.left})
+			}
+		}
+		if len(requested) == 0 {
+			return utils.ErrNothingToRefund
+		}
+	}
+
+	lines := make([]model.RefundLine, 0, len(requested))
+	amounts := make([]money.Money, 0, len(requested))
+	seen := make(map[int64]bool, len(requested))
+	for _, request := range requested {
+		i := slices.IndexFunc(orderLines, func(line orderLine) bool { return line.bookID == request.BookID })
+		switch {
+		case i < 0:
+			return fmt.Errorf("%w: book %d is not part of order %d", utils.ErrInvalidRefund, request.BookID, refund.OrderID)
+		case seen[request.BookID]:
+			return fmt.Errorf("%w: book %d is listed more than once", utils.ErrInvalidRefund, request.BookID)
+		case request.Quantity < 1:
+			return fmt.Errorf("%w: quantity of book %d must be positive", utils.ErrInvalidRefund, request.BookID)
+		case request.Quantity > orderLines[i].left:
+			return fmt.Errorf(
+				"%w: only %d copies of %q are left to refund",
+				utils.ErrInvalidRefund,
+				orderLines[i].left,
+				orderLines[i].title,
+			)
+		}
+		seen[request.BookID] = true
+
+		line := model.RefundLine{
+			OrderDetailID: orderLines[i].id,
+			BookID:        request.BookID,
+			Quantity:      request.Quantity,
+			Amount:        orderLines[i].unitPrice.Mul(request.Quantity),
+		}
+		lines = append(lines, line)
+		amounts = append(amounts, line.Amount)
+	}
+
+	amount, err := money.Sum(amounts[0].Currency, amounts...)
+	if err != nil {
+		return err
+	}
+	// free books alone give nothing back
+	if amount.IsZero() || amount.IsNegative() {
+		return fmt.Errorf("%w: the refunded lines add up to %s", utils.ErrInvalidRefund, amount)
+	}
+
+	refund.Lines = lines
+	refund.Amount = amount
+	return nil
+}
+
+// CompleteRefund marks a refund the provider accepted as succeeded and puts
+// its copies back in stock if it restocks. With markRefunded set the order
+// moves to OrderStateRefunded from whatever state it is in.
+func (r *orderRepository) CompleteRefund(ctx context.Context, refund *model.Refund, markRefunded bool) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("[CompleteRefund] Could not start transaction", "refund_id", refund.ID, "error", err)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx).Error("[CompleteRefund] Recovered from panic, rolling back transaction", "panic", p)
+			tx.Rollback()
+		}
+	}()
+
+	err = tx.QueryRowContext(ctx, `
+	UPDATE refunds
+	SET status = $2, updated_at = NOW()
+	WHERE id = $1
+	RETURNING updated_at`, refund.ID, model.RefundStatusSucceeded).Scan(&refund.UpdatedAt)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[CompleteRefund] Error updating refund", "refund_id", refund.ID, "error", err)
+		return err
+	}
+
+	// the order is locked before the books, like in StartCheckout
+	if markRefunded {
+		var from model.OrderState
+		err = tx.QueryRowContext(ctx, `
+		SELECT order_state FROM orders
+		WHERE id = $1
+		FOR UPDATE`, refund.OrderID).Scan(&from)
+		if err != nil {
+			tx.Rollback()
+			logging.FromContext(ctx).Error("[CompleteRefund] Error locking order", "order_id", refund.OrderID, "error", err)
+			return err
+		}
+
+		if from != model.OrderStateRefunded {
+			_, err = tx.ExecContext(ctx, `
+			UPDATE orders
+			SET order_state = $2, updated_at = NOW()
+			WHERE id = $1`, refund.OrderID, model.OrderStateRefunded)
+			if err != nil {
+				tx.Rollback()
+				logging.FromContext(ctx).Error("[CompleteRefund] Error updating state", "order_id", refund.OrderID, "error", err)
+				return err
+			}
+
+			err = r.recordTransition(ctx, tx, &model.OrderStateTransition{
+				OrderID:   refund.OrderID,
+				From:      from,
+				To:        model.OrderStateRefunded,
+				ChangedBy: refund.CreatedBy,
+				Note:      refund.Reason,
+			})
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	if refund.Restock {
+		if err := r.restockRefund(ctx, tx, refund); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("[CompleteRefund] Could not commit transaction", "refund_id", refund.ID, "error", err)
+		return err
+	}
+
+	refund.Status = model.RefundStatusSucceeded
+	return nil
+}
+
+// FailRefund records why the provider refused a refund and gives its copies
+// and amount back to what is left to refund of the order.
+func (r *orderRepository) FailRefund(ctx context.Context, refund *model.Refund) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("[FailRefund] Could not start transaction", "refund_id", refund.ID, "error", err)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx).Error("[FailRefund] Recovered from panic, rolling back transaction", "panic", p)
+			tx.Rollback()
+		}
+	}()
+
+	err = tx.QueryRowContext(ctx, `
+	UPDATE refunds
+	SET status = $2, failure_reason = $3, updated_at = NOW()
+	WHERE id = $1
+	RETURNING updated_at`, refund.ID, model.RefundStatusFailed, refund.FailureReason).Scan(&refund.UpdatedAt)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[FailRefund] Error updating refund", "refund_id", refund.ID, "error", err)
+		return err
+	}
+
+	// the order row first, StartRefund locks it before the lines
+	_, err = tx.ExecContext(ctx, `
+	UPDATE orders
+	SET refunded_total = refunded_total - $2
+	WHERE id = $1`, refund.OrderID, refund.Amount)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[FailRefund] Error restoring refundable amount", "order_id", refund.OrderID, "error", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE order_details d
+	SET refunded_quantity = d.refunded_quantity - l.quantity
+	FROM refund_lines l
+	WHERE l.refund_id = $1 AND d.id = l.order_detail_id`, refund.ID)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[FailRefund] Error restoring refundable copies", "refund_id", refund.ID, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("[FailRefund] Could not commit transaction", "refund_id", refund.ID, "error", err)
+		return err
+	}
+
+	refund.Status = model.RefundStatusFailed
+	return nil
+}
+
+// restockRefund puts the refunded copies back in stock and writes a refund
+// movement per line. Books are locked in id order like in reserveStock.
+func (r *orderRepository) restockRefund(ctx context.Context, tx *sql.Tx, refund *model.Refund) error {
+	_, err := tx.ExecContext(ctx, `
+	UPDATE books b SET stock = b.stock + l.quantity
+	FROM (
+		SELECT d.book_id, l.quantity
+		FROM refund_lines l
+		JOIN order_details d ON d.id = l.order_detail_id
+		JOIN books b ON b.id = d.book_id
+		WHERE l.refund_id = $1
+		ORDER BY b.id
+		FOR UPDATE OF b
+	) l
+	WHERE b.id = l.book_id`, refund.ID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[restockRefund] Error restoring stock", "refund_id", refund.ID, "error", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO stock_movements (book_id, quantity_change, reason, order_id, stock_after)
+	SELECT d.book_id, l.quantity, $2, d.order_id, b.stock
+	FROM refund_lines l
+	JOIN order_details d ON d.id = l.order_detail_id
+	JOIN books b ON b.id = d.book_id
+	WHERE l.refund_id = $1`, refund.ID, model.StockReasonRefund)
+	if err != nil {
+		logging.FromContext(ctx).Error("[restockRefund] Error recording stock movements", "refund_id", refund.ID, "error", err)
+		return err
+	}
+
+	return nil
+}
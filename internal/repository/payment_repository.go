@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"bookstore/internal/logging"
+	"bookstore/internal/model"
+	"bookstore/pkg/utils"
+	"context"
+	"database/sql"
+)
+
+type PaymentRepository interface {
+	CreatePayment(ctx context.Context, payment *model.Payment) error
+	UpdatePayment(ctx context.Context, payment *model.Payment) error
+	GetCapturedPayment(ctx context.Context, orderID int) (*model.Payment, error)
+}
+
+type paymentRepository struct {
+	db *sql.DB
+}
+
+func NewPaymentRepository(db *sql.DB) PaymentRepository {
+	return &paymentRepository{db: db}
+}
+
+// CreatePayment records a new payment attempt and fills in its id and timestamps.
+func (r *paymentRepository) CreatePayment(ctx context.Context, payment *model.Payment) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	err := r.db.QueryRowContext(ctx, `
+	INSERT INTO payments (order_id, provider, provider_ref, amount, status, failure_reason)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at, updated_at`,
+		payment.OrderID,
+		payment.Provider,
+		payment.ProviderRef,
+		payment.Amount,
+		payment.Status,
+		payment.FailureReason,
+	).Scan(&payment.ID, &payment.CreatedAt, &payment.UpdatedAt)
+	if err != nil {
+		logging.FromContext(ctx).Error("[CreatePayment] Error recording payment", "order_id", payment.OrderID, "error", err)
+	}
+	return err
+}
+
+// UpdatePayment stores the provider reference, status and failure reason of a payment.
+func (r *paymentRepository) UpdatePayment(ctx context.Context, payment *model.Payment) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	err := r.db.QueryRowContext(ctx, `
+	UPDATE payments
+	SET provider_ref = $2, status = $3, failure_reason = $4, updated_at = NOW()
+	WHERE id = $1
+	RETURNING updated_at`,
+		payment.ID,
+		payment.ProviderRef,
+		payment.Status,
+		payment.FailureReason,
+	).Scan(&payment.UpdatedAt)
+	if err != nil {
+		logging.FromContext(ctx).Error("[UpdatePayment] Error updating payment", "payment_id", payment.ID, "error", err)
+	}
+	return err
+}
+
+// GetCapturedPayment returns the payment that took the money of an order,
+// ErrPaymentNotFound if there is none or it was refunded in full.
+func (r *paymentRepository) GetCapturedPayment(ctx context.Context, orderID int) (*model.Payment, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var p model.Payment
+	err := r.db.QueryRowContext(ctx, `
+	SELECT id, order_id, provider, provider_ref, amount, status, failure_reason, created_at, updated_at
+	FROM payments
+	WHERE order_id = $1 AND status = $2
+	ORDER BY id DESC
+	LIMIT 1`, orderID, model.PaymentStatusCaptured).Scan(
+		&p.ID,
+		&p.OrderID,
+		&p.Provider,
+		&p.ProviderRef,
+		&p.Amount,
+		&p.Status,
+		&p.FailureReason,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logging.FromContext(ctx).Warn("[GetCapturedPayment] No captured payment", "order_id", orderID)
+			return nil, utils.ErrPaymentNotFound
+		}
+		logging.FromContext(ctx).Error("[GetCapturedPayment] Error retrieving payment", "order_id", orderID, "error", err)
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultQueryTimeout bounds a single query or transaction unless
+// SetQueryTimeout configures another value.
+const DefaultQueryTimeout = 5 * time.Second
+
+var queryTimeout = DefaultQueryTimeout
+
+// SetQueryTimeout changes the timeout applied to every repository call.
+// It is meant to be called once at startup, zero or less disables it.
+func SetQueryTimeout(timeout time.Duration) {
+	queryTimeout = timeout
+}
+
+// withTimeout derives the context a repository call runs its queries with.
+// The request context still cancels it when the client goes away.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, queryTimeout)
+}
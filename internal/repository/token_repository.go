@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"bookstore/internal/logging"
+	"bookstore/internal/model"
+	"bookstore/pkg/utils"
+	"context"
+	"database/sql"
+	"time"
+)
+
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, usedID int64, next *model.RefreshToken) error
+	RevokeTokenFamily(ctx context.Context, familyID string) error
+	RevokeCustomerTokens(ctx context.Context, customerID int) error
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	DeleteExpiredTokens(ctx context.Context) (int64, error)
+}
+
+type tokenRepository struct {
+	db *sql.DB
+}
+
+func NewTokenRepository(db *sql.DB) TokenRepository {
+	return &tokenRepository{db: db}
+}
+
+// CreateRefreshToken stores the hash of a newly issued refresh token.
+func (r *tokenRepository) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	err := insertRefreshToken(ctx, r.db, token)
+	if err != nil {
+		logging.FromContext(ctx).Error("[CreateRefreshToken] Error storing refresh token", "customer_id", token.CustomerID, "error", err)
+	}
+	return err
+}
+
+// GetRefreshToken looks a refresh token up by its hash, including used and
+// revoked ones so the caller can detect reuse.
+func (r *tokenRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var token model.RefreshToken
+	err := r.db.QueryRowContext(ctx, `
+	SELECT id, customer_id, family_id, token_hash, expires_at, used_at, revoked_at, created_at
+	FROM refresh_tokens
+	WHERE token_hash = $1`, tokenHash).Scan(
+		&token.ID,
+		&token.CustomerID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, utils.ErrInvalidRefreshToken
+		}
+		logging.FromContext(ctx).Error("[GetRefreshToken] Error retrieving refresh token", "error", err)
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// RotateRefreshToken marks a refresh token as used and stores its successor.
+// Only one caller can use a token, whoever loses the race gets
+// ErrRefreshTokenReused and nothing is stored.
+func (r *tokenRepository) RotateRefreshToken(ctx context.Context, usedID int64, next *model.RefreshToken) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("[RotateRefreshToken] Could not start transaction", "token_id", usedID, "error", err)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.FromContext(ctx).Error("[RotateRefreshToken] Recovered from panic, rolling back transaction", "panic", p)
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx, `
+	UPDATE refresh_tokens
+	SET used_at = NOW()
+	WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL`, usedID)
+	if err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[RotateRefreshToken] Error using refresh token", "token_id", usedID, "error", err)
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if affected == 0 {
+		tx.Rollback()
+		return utils.ErrRefreshTokenReused
+	}
+
+	if err := insertRefreshToken(ctx, tx, next); err != nil {
+		tx.Rollback()
+		logging.FromContext(ctx).Error("[RotateRefreshToken] Error storing refresh token", "customer_id", next.CustomerID, "error", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("[RotateRefreshToken] Could not commit transaction", "token_id", usedID, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// RevokeTokenFamily revokes every refresh token descending from one login.
+func (r *tokenRepository) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `
+	UPDATE refresh_tokens
+	SET revoked_at = NOW()
+	WHERE family_id = $1 AND revoked_at IS NULL`, familyID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[RevokeTokenFamily] Error revoking token family", "family_id", familyID, "error", err)
+	}
+	return err
+}
+
+// RevokeCustomerTokens revokes every refresh token of a customer, logging
+// out all their sessions once their access tokens expire.
+func (r *tokenRepository) RevokeCustomerTokens(ctx context.Context, customerID int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `
+	UPDATE refresh_tokens
+	SET revoked_at = NOW()
+	WHERE customer_id = $1 AND revoked_at IS NULL`, customerID)
+	if err != nil {
+		logging.FromContext(ctx).Error("[RevokeCustomerTokens] Error revoking tokens", "customer_id", customerID, "error", err)
+	}
+	return err
+}
+
+// RevokeAccessToken puts an access token on the revocation list until it expires.
+func (r *tokenRepository) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `
+	INSERT INTO revoked_tokens (jti, expires_at)
+	VALUES ($1, $2)
+	ON CONFLICT (jti) DO NOTHING`, jti, expiresAt)
+	if err != nil {
+		logging.FromContext(ctx).Error("[RevokeAccessToken] Error revoking token", "jti", jti, "error", err)
+	}
+	return err
+}
+
+// IsTokenRevoked reports whether an access token is on the revocation list.
+func (r *tokenRepository) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var revoked bool
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)",
+		jti,
+	).Scan(&revoked)
+	if err != nil {
+		logging.FromContext(ctx).Error("[IsTokenRevoked] Error checking token", "jti", jti, "error", err)
+		return false, err
+	}
+	return revoked, nil
+}
+
+// DeleteExpiredTokens removes the refresh tokens and revocation list entries
+// past their expiry and returns how many rows were removed. An expired token
+// is refused whether or not its row is still there.
+func (r *tokenRepository) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var deleted int64
+	for _, table := range []string{"refresh_tokens", "revoked_tokens"} {
+		result, err := r.db.ExecContext(ctx, "DELETE FROM "+table+" WHERE expires_at < NOW()")
+		if err != nil {
+			logging.FromContext(ctx).Error("[DeleteExpiredTokens] Error deleting expired tokens", "table", table, "error", err)
+			return deleted, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			logging.FromContext(ctx).Error("[DeleteExpiredTokens] Could not count deleted tokens", "table", table, "error", err)
+			return deleted, err
+		}
+		deleted += affected
+	}
+
+	return deleted, nil
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func insertRefreshToken(ctx context.Context, db queryRower, token *model.RefreshToken) error {
+	return db.QueryRowContext(ctx, `
+	INSERT INTO refresh_tokens (customer_id, family_id, token_hash, expires_at)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`,
+		token.CustomerID,
+		token.FamilyID,
+		token.TokenHash,
+		token.ExpiresAt,
+	).Scan(&token.ID, &token.CreatedAt)
+}
@@ -2,6 +2,8 @@ package router
 
 import (
 	"bookstore/internal/handler"
+	"bookstore/internal/middleware"
+	"bookstore/internal/model"
 	"bookstore/internal/repository"
 	"bookstore/internal/service"
 
@@ -10,14 +12,28 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func BookRouter(router *gin.Engine, db *sql.DB) {
+func BookRouter(router *gin.Engine, db *sql.DB, authMiddleware gin.HandlerFunc) {
 	repo := repository.NewBookRepository(db)
-	svc := service.NewBookService(repo)
+	svc := service.TraceBookService(service.NewBookService(repo))
 	handler := handler.NewBookHandler(svc)
 
 	// Define the routes
 	router.GET("/book", handler.GetBooks)
 	router.GET("/book/:id", handler.GetBookById)
-	router.POST("/book/create", handler.CreateBook)
-	router.POST("/book/update", handler.UpdateBook)
+
+	// Catalog changes are limited to staff and admins
+	catalogRoutes := router.Group(
+		"/book",
+		authMiddleware,
+		middleware.RequireRole(model.RoleStaff, model.RoleAdmin),
+	)
+	catalogRoutes.POST("/create", handler.CreateBook)
+	catalogRoutes.POST("/update", handler.UpdateBook)
+	catalogRoutes.POST("/:id/stock", handler.AdjustStock)
+	catalogRoutes.DELETE("/:id", handler.ArchiveBook)
+
+	// Restoring archived books and deleting unused ones is limited to admins
+	adminRoutes := router.Group("/admin/books", authMiddleware, middleware.RequireRole(model.RoleAdmin))
+	adminRoutes.POST("/:id/restore", handler.RestoreBook)
+	adminRoutes.DELETE("/:id", handler.DeleteBook)
 }
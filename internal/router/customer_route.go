@@ -2,21 +2,37 @@ package router
 
 import (
 	"bookstore/internal/handler"
+	"bookstore/internal/mail"
+	"bookstore/internal/middleware"
+	"bookstore/internal/model"
 	"bookstore/internal/repository"
 	"bookstore/internal/service"
+	"bookstore/pkg/utils"
 
 	"database/sql"
 
 	"github.com/gin-gonic/gin"
 )
 
-func CustomerRouter(router *gin.Engine, db *sql.DB) {
+func CustomerRouter(router *gin.Engine, db *sql.DB, authMiddleware gin.HandlerFunc, mailer mail.Sender, signer *utils.TokenSigner) {
 	repo := repository.NewCustomerRepository(db)
-	svc := service.NewCustomerService(repo)
+	tokenRepo := repository.NewTokenRepository(db)
+	svc := service.TraceCustomerService(service.NewCustomerService(repo, tokenRepo, mailer, signer))
 	handler := handler.NewCustomerHandler(svc)
 
 	// Define the routes
 	router.POST("/register", handler.Register)
 	router.POST("/login", handler.Login)
+	router.POST("/token/refresh", handler.RefreshToken)
+	router.POST("/logout", authMiddleware, handler.Logout)
+	router.POST("/email/verify", handler.VerifyEmail)
 
+	meRoutes := router.Group("/me", authMiddleware)
+	meRoutes.GET("", handler.GetProfile)
+	meRoutes.PATCH("", handler.UpdateProfile)
+	meRoutes.POST("/password", handler.ChangePassword)
+	meRoutes.POST("/email", handler.ChangeEmail)
+
+	adminRoutes := router.Group("/admin", authMiddleware, middleware.RequireRole(model.RoleAdmin))
+	adminRoutes.POST("/customers/:id/role", handler.UpdateRole)
 }
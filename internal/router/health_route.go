@@ -0,0 +1,21 @@
+package router
+
+import (
+	"bookstore/internal/handler"
+	"bookstore/internal/health"
+	"bookstore/internal/middleware"
+	"bookstore/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func HealthRouter(router *gin.Engine, checker *health.Checker, authMiddleware gin.HandlerFunc) {
+	handler := handler.NewHealthHandler(checker)
+
+	// Probes are unauthenticated so the orchestrator can call them
+	router.GET("/healthz", handler.Liveness)
+	router.GET("/readyz", handler.Readiness)
+
+	// The detailed report names dependencies and their errors
+	router.GET("/debug/health", authMiddleware, middleware.RequireRole(model.RoleAdmin), handler.Report)
+}
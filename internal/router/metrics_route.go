@@ -0,0 +1,12 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsRouter exposes the metrics of gatherer in the Prometheus text format.
+func MetricsRouter(router *gin.Engine, gatherer prometheus.Gatherer) {
+	router.GET("/metrics", gin.WrapH(promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})))
+}
@@ -2,26 +2,70 @@ package router
 
 import (
 	"bookstore/internal/handler"
+	"bookstore/internal/metrics"
+	"bookstore/internal/middleware"
+	"bookstore/internal/model"
+	"bookstore/internal/payment"
 	"bookstore/internal/repository"
 	"bookstore/internal/service"
 
 	"database/sql"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func OrderRouter(router *gin.Engine, db *sql.DB, authMiddleware gin.HandlerFunc) {
+func OrderRouter(
+	router *gin.Engine,
+	db *sql.DB,
+	authMiddleware gin.HandlerFunc,
+	idempotencyMiddleware gin.HandlerFunc,
+	provider payment.PaymentProvider,
+	metrics *metrics.Metrics,
+	cancelWindow time.Duration,
+) {
 	repo := repository.NewOrderRepository(db)
-	svc := service.NewOrderService(repo)
+	bookRepo := repository.NewBookRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	svc := service.TraceOrderService(service.NewOrderService(
+		repo,
+		bookRepo,
+		paymentRepo,
+		provider,
+		metrics,
+		cancelWindow,
+	))
 	handler := handler.NewOrderHandler(svc)
 
-	orderRoutes := router.Group("/orders", authMiddleware)
+	// Retried writes carrying an Idempotency-Key are answered from the first response
+	orderRoutes := router.Group("/orders", authMiddleware, idempotencyMiddleware)
 
 	// Define the routes
 	orderRoutes.POST("/add", handler.AddToCart)
 	orderRoutes.POST("/pay", handler.PayOrder)
 	orderRoutes.POST("/delete", handler.RemoveFromCart)
 	orderRoutes.GET("/cart", handler.GetCart)
-	orderRoutes.POST("/history", handler.GetOrderHistory)
+	orderRoutes.POST("/cart/reprice", handler.RepriceCart)
+	orderRoutes.GET("/history", handler.GetOrderHistory)
+	orderRoutes.POST("/:id/cancel", handler.CancelOrder)
 
+	// Moving orders through fulfilment is limited to staff and admins
+	adminRoutes := router.Group(
+		"/admin/orders",
+		authMiddleware,
+		middleware.RequireRole(model.RoleStaff, model.RoleAdmin),
+		idempotencyMiddleware,
+	)
+	adminRoutes.POST("/:id/state", handler.AdvanceOrder)
+	adminRoutes.GET("/:id/transitions", handler.GetOrderTransitions)
+
+	// Giving money back, or settling orders that may still hold some, is limited to admins
+	refundRoutes := router.Group(
+		"/admin/orders",
+		authMiddleware,
+		middleware.RequireRole(model.RoleAdmin),
+		idempotencyMiddleware,
+	)
+	refundRoutes.POST("/:id/refunds", handler.RefundOrder)
+	refundRoutes.POST("/:id/cancel-pending", handler.CancelPendingOrder)
 }
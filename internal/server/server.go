@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Config holds the listen address and the limits of the HTTP server.
+type Config struct {
+	Addr              string
+	ReadTimeout       time.Duration // Whole request, body included
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration // From the end of the request headers to the end of the response
+	IdleTimeout       time.Duration // Keep-alive connections waiting for the next request
+	MaxHeaderBytes    int
+	ShutdownTimeout   time.Duration // How long in-flight requests may take once shutdown starts
+	DrainDelay        time.Duration // Between the shutdown hooks and closing the listener
+}
+
+type closer struct {
+	name string
+	fn   func() error
+}
+
+// Server is an http.Server that shuts down gracefully: it stops accepting
+// connections, waits for in-flight requests and only then closes what the
+// handlers depend on.
+type Server struct {
+	http            *http.Server
+	shutdownTimeout time.Duration
+	drainDelay      time.Duration
+	onShutdown      []func()
+	closers         []closer
+}
+
+func New(handler http.Handler, config Config) *Server {
+	return &Server{
+		http: &http.Server{
+			Addr:              config.Addr,
+			Handler:           handler,
+			ReadTimeout:       config.ReadTimeout,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+			MaxHeaderBytes:    config.MaxHeaderBytes,
+		},
+		shutdownTimeout: config.ShutdownTimeout,
+		drainDelay:      config.DrainDelay,
+	}
+}
+
+// RegisterOnShutdown registers fn to be called as soon as shutdown starts,
+// before requests are drained, e.g. to fail readiness probes. The server keeps
+// accepting connections for the drain delay afterwards, so load balancers see
+// the failing probe before new connections are refused.
+func (s *Server) RegisterOnShutdown(fn func()) {
+	s.onShutdown = append(s.onShutdown, fn)
+}
+
+// AddCloser registers fn to run once requests are drained. Closers run in
+// the order they were added, so background workers must be added before
+// the database they use.
+func (s *Server) AddCloser(name string, fn func() error) {
+	s.closers = append(s.closers, closer{name: name, fn: fn})
+}
+
+// Run listens on the configured address and serves until ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		s.close()
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves on listener until ctx is done, then shuts down gracefully.
+// Requests still running after the shutdown timeout are cut off and the
+// timeout error is returned, the closers run in every case.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.http.Serve(listener)
+	}()
+
+	slog.Info("[Server] Listening", "addr", listener.Addr().String())
+
+	select {
+	case err := <-serveErr:
+		s.close()
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("[Server] Shutting down, waiting for in-flight requests", "timeout", s.shutdownTimeout)
+	for _, fn := range s.onShutdown {
+		fn()
+	}
+	if s.drainDelay > 0 {
+		slog.Info("[Server] Waiting for load balancers to stop sending traffic", "drain_delay", s.drainDelay)
+		time.Sleep(s.drainDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	err := s.http.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Warn("[Server] Requests did not finish in time", "error", err)
+		s.http.Close()
+	}
+
+	if serveErr := <-serveErr; !errors.Is(serveErr, http.ErrServerClosed) {
+		err = errors.Join(err, serveErr)
+	}
+
+	return errors.Join(err, s.close())
+}
+
+func (s *Server) close() error {
+	var errs []error
+	for _, c := range s.closers {
+		if err := c.fn(); err != nil {
+			slog.Error("[Server] Could not close", "closer", c.name, "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -3,13 +3,25 @@ package service
 import (
 	"bookstore/internal/model"
 	"bookstore/internal/repository"
+	"bookstore/pkg/utils"
+	"context"
+	"fmt"
+)
+
+const (
+	DefaultBookPageSize = 20
+	MaxBookPageSize     = 100
 )
 
 type BookService interface {
-	CreateBook(book *model.Book) error
-	GetBooks() ([]model.Book, error)
-	GetBookById(id int) (*model.Book, error)
-	UpdateBook(book *model.Book) error
+	CreateBook(ctx context.Context, book *model.Book) error
+	GetBooks(ctx context.Context, query model.BookQuery) (*model.BookPage, error)
+	GetBookById(ctx context.Context, id int) (*model.Book, error)
+	UpdateBook(ctx context.Context, book *model.Book) error
+	AdjustStock(ctx context.Context, movement *model.StockMovement) error
+	ArchiveBook(ctx context.Context, id int) (*model.Book, error)
+	RestoreBook(ctx context.Context, id int) (*model.Book, error)
+	DeleteBook(ctx context.Context, id int) error
 }
 
 type bookService struct {
@@ -21,21 +33,114 @@ func NewBookService(repository repository.BookRepository) BookService {
 }
 
 // CreateBook implements Service.
-func (s *bookService) CreateBook(book *model.Book) error {
-	return s.repository.CreateBook(book)
+func (s *bookService) CreateBook(ctx context.Context, book *model.Book) error {
+	if err := validatePrice(book); err != nil {
+		return err
+	}
+	return s.repository.CreateBook(ctx, book)
 }
 
 // GetBookById implements Service.
-func (s *bookService) GetBookById(id int) (*model.Book, error) {
-	return s.repository.GetBookById(id)
+func (s *bookService) GetBookById(ctx context.Context, id int) (*model.Book, error) {
+	return s.repository.GetBookById(ctx, id)
 }
 
 // GetBooks implements Service.
-func (s *bookService) GetBooks() ([]model.Book, error) {
-	return s.repository.GetBooks()
+// Fills in the default sort and page size and rejects queries the
+// repository cannot serve before they reach the database.
+func (s *bookService) GetBooks(ctx context.Context, query model.BookQuery) (*model.BookPage, error) {
+	if query.SortBy == "" {
+		query.SortBy = model.BookSortID
+	}
+	if query.SortDir == "" {
+		query.SortDir = model.SortAsc
+	}
+	if query.Limit == 0 {
+		query.Limit = DefaultBookPageSize
+	}
+
+	switch query.SortBy {
+	case model.BookSortID, model.BookSortTitle, model.BookSortAuthor, model.BookSortPrice:
+	default:
+		return nil, fmt.Errorf("%w: unknown sort field %q", utils.ErrInvalidBookQuery, query.SortBy)
+	}
+
+	if query.SortDir != model.SortAsc && query.SortDir != model.SortDesc {
+		return nil, fmt.Errorf("%w: unknown sort direction %q", utils.ErrInvalidBookQuery, query.SortDir)
+	}
+
+	if query.Limit < 0 || query.Limit > MaxBookPageSize {
+		return nil, fmt.Errorf(
+			"%w: limit must be between 1 and %d",
+			utils.ErrInvalidBookQuery,
+			MaxBookPageSize,
+		)
+	}
+
+	if query.Offset < 0 {
+		return nil, fmt.Errorf("%w: offset cannot be negative", utils.ErrInvalidBookQuery)
+	}
+
+	if query.Offset > 0 && query.Cursor != "" {
+		return nil, fmt.Errorf("%w: use either offset or cursor, not both", utils.ErrInvalidBookQuery)
+	}
+
+	if query.MinPrice != nil && query.MaxPrice != nil &&
+		query.MinPrice.Amount > query.MaxPrice.Amount {
+		return nil, fmt.Errorf("%w: minPrice is greater than maxPrice", utils.ErrInvalidBookQuery)
+	}
+
+	return s.repository.GetBooks(ctx, query)
 }
 
 // UpdateBook implements Service.
-func (s *bookService) UpdateBook(book *model.Book) error {
-	return s.repository.UpdateBook(book)
+func (s *bookService) UpdateBook(ctx context.Context, book *model.Book) error {
+	if err := validatePrice(book); err != nil {
+		return err
+	}
+	return s.repository.UpdateBook(ctx, book)
+}
+
+// validatePrice refuses free and negative prices, a book must be sold for something.
+func validatePrice(book *model.Book) error {
+	if book.Price.IsZero() || book.Price.IsNegative() {
+		return fmt.Errorf("%w: got %s", utils.ErrInvalidBookPrice, book.Price)
+	}
+	return nil
+}
+
+// AdjustStock implements Service.
+// Sales are recorded by checkout only, manual adjustments must use another reason.
+func (s *bookService) AdjustStock(ctx context.Context, movement *model.StockMovement) error {
+	if movement.Change == 0 {
+		return fmt.Errorf("%w: change cannot be zero", utils.ErrInvalidStockChange)
+	}
+
+	switch movement.Reason {
+	case model.StockReasonRestock,
+		model.StockReasonReturn,
+		model.StockReasonDamaged,
+		model.StockReasonLost,
+		model.StockReasonCorrection:
+	default:
+		return fmt.Errorf("%w: reason %q is not allowed", utils.ErrInvalidStockChange, movement.Reason)
+	}
+
+	return s.repository.AdjustStock(ctx, movement)
+}
+
+// ArchiveBook implements Service.
+func (s *bookService) ArchiveBook(ctx context.Context, id int) (*model.Book, error) {
+	return s.repository.ArchiveBook(ctx, id)
+}
+
+// RestoreBook implements Service.
+func (s *bookService) RestoreBook(ctx context.Context, id int) (*model.Book, error) {
+	return s.repository.RestoreBook(ctx, id)
+}
+
+// DeleteBook implements Service.
+// Only books no order ever referred to can be deleted, see ArchiveBook.
+func (s *bookService) DeleteBook(ctx context.Context, id int) error {
+	return s.repository.DeleteBook(ctx, id)
 }
@@ -1,64 +1,317 @@
 package service
 
 import (
+	"bookstore/internal/handler/request"
+	"bookstore/internal/logging"
+	"bookstore/internal/mail"
 	"bookstore/internal/model"
 	"bookstore/internal/repository"
 	"bookstore/pkg/utils"
-	"log"
+	"context"
+	"errors"
 	"strings"
+	"time"
 )
 
 type CustomerService interface {
-	Register(customer *model.Customer) error
-	Login(email, password string) (string, error)
+	Register(ctx context.Context, customer *model.Customer) error
+	Login(ctx context.Context, email, password string) (*model.TokenPair, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*model.TokenPair, error)
+	Logout(ctx context.Context, customerID int, jti string, expiresAt time.Time, refreshToken string) error
+	UpdateRole(ctx context.Context, customerID int, role model.Role) error
+	GetProfile(ctx context.Context, customerID int) (*model.CustomerProfile, error)
+	UpdateProfile(ctx context.Context, customerID int, request request.UpdateProfileRequest) (*model.CustomerProfile, error)
+	ChangePassword(ctx context.Context, customerID int, request request.ChangePasswordRequest) error
+	ChangeEmail(ctx context.Context, customerID int, request request.ChangeEmailRequest) error
+	VerifyEmail(ctx context.Context, token string) (*model.CustomerProfile, error)
 }
 
+// EmailVerificationTTL is how long the token sent to a new email can confirm it.
+const EmailVerificationTTL = 24 * time.Hour
+
 type customerService struct {
-	repository repository.CustomerRepository
+	repository      repository.CustomerRepository
+	tokenRepository repository.TokenRepository
+	mailer          mail.Sender
+	signer          *utils.TokenSigner
 }
 
-func NewCustomerService(repository repository.CustomerRepository) CustomerService {
-	return &customerService{repository: repository}
+func NewCustomerService(
+	repository repository.CustomerRepository,
+	tokenRepository repository.TokenRepository,
+	mailer mail.Sender,
+	signer *utils.TokenSigner,
+) CustomerService {
+	return &customerService{repository: repository, tokenRepository: tokenRepository, mailer: mailer, signer: signer}
 }
 
 // Login implements CustomerService.
-func (s *customerService) Login(email string, password string) (string, error) {
+// A successful login starts a new refresh token family.
+func (s *customerService) Login(ctx context.Context, email string, password string) (*model.TokenPair, error) {
 
 	if email == "" || password == "" {
-		return "", utils.ErrEmptyEmailOrPassword
+		return nil, utils.ErrEmptyEmailOrPassword
 	}
 
-	customer, err := s.repository.Login(strings.ToLower(email), password)
+	customer, err := s.repository.Login(ctx, strings.ToLower(email), password)
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !utils.CheckPassword(password, customer.Password) {
-		return "", utils.ErrWrongPassword
+		return nil, utils.ErrWrongPassword
 	}
 
-	token, err := utils.GenerateToken(
-		customer.ID,
-		customer.Email,
-	)
+	familyID, err := utils.RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
 
+	refreshToken, hash, err := utils.GenerateRefreshToken()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return token, nil
+	err = s.tokenRepository.CreateRefreshToken(ctx, &model.RefreshToken{
+		CustomerID: customer.ID,
+		FamilyID:   familyID,
+		TokenHash:  hash,
+		ExpiresAt:  time.Now().Add(utils.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newTokenPair(customer, refreshToken)
 }
 
-func (s *customerService) Register(customer *model.Customer) error {
+// RefreshToken implements CustomerService.
+// The presented token is exchanged for a new one. Presenting a token that was
+// already exchanged or revoked means it leaked, so its whole family is revoked
+// and both the attacker and the customer have to log in again.
+func (s *customerService) RefreshToken(ctx context.Context, refreshToken string) (*model.TokenPair, error) {
+	if refreshToken == "" {
+		return nil, utils.ErrInvalidRefreshToken
+	}
+
+	stored, err := s.tokenRepository.GetRefreshToken(ctx, utils.HashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.UsedAt != nil || stored.RevokedAt != nil {
+		return nil, s.revokeReusedFamily(ctx, stored)
+	}
+
+	if !time.Now().Before(stored.ExpiresAt) {
+		return nil, utils.ErrRefreshTokenExpired
+	}
+
+	customer, err := s.repository.GetCustomerById(ctx, int(stored.CustomerID))
+	if err != nil {
+		if errors.Is(err, utils.ErrCustomerNotFound) {
+			return nil, utils.ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	nextToken, hash, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.tokenRepository.RotateRefreshToken(ctx, stored.ID, &model.RefreshToken{
+		CustomerID: stored.CustomerID,
+		FamilyID:   stored.FamilyID,
+		TokenHash:  hash,
+		ExpiresAt:  time.Now().Add(utils.RefreshTokenTTL),
+	})
+	if errors.Is(err, utils.ErrRefreshTokenReused) {
+		// another request exchanged the same token first
+		return nil, s.revokeReusedFamily(ctx, stored)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newTokenPair(customer, nextToken)
+}
+
+// Logout implements CustomerService.
+// The access token is revoked until it expires and, when given, the refresh
+// token family is revoked so no new access tokens can be issued from it.
+func (s *customerService) Logout(
+	ctx context.Context,
+	customerID int,
+	jti string,
+	expiresAt time.Time,
+	refreshToken string,
+) error {
+	if jti != "" {
+		if err := s.tokenRepository.RevokeAccessToken(ctx, jti, expiresAt); err != nil {
+			return err
+		}
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	stored, err := s.tokenRepository.GetRefreshToken(ctx, utils.HashToken(refreshToken))
+	if err != nil {
+		return err
+	}
+
+	if stored.CustomerID != int64(customerID) {
+		return utils.ErrInvalidRefreshToken
+	}
+
+	return s.tokenRepository.RevokeTokenFamily(ctx, stored.FamilyID)
+}
+
+func (s *customerService) Register(ctx context.Context, customer *model.Customer) error {
 	hashedPassword, err := utils.HashPassword(customer.Password)
 	if err != nil {
-		log.Printf("[Register] failed to hash for email: %s e: %v", customer.Email, err)
+		logging.FromContext(ctx).Error("[Register] failed to hash password", "email", customer.Email, "error", err)
 		return err
 	}
 
 	customer.Email = strings.ToLower(customer.Email)
 	customer.Password = hashedPassword
+	customer.Role = model.RoleCustomer
+
+	return s.repository.Register(ctx, customer)
+}
+
+func (s *customerService) UpdateRole(ctx context.Context, customerID int, role model.Role) error {
+	switch role {
+	case model.RoleCustomer, model.RoleStaff, model.RoleAdmin:
+	default:
+		return utils.ErrInvalidRole
+	}
+
+	return s.repository.UpdateRole(ctx, customerID, role)
+}
+
+func (s *customerService) GetProfile(ctx context.Context, customerID int) (*model.CustomerProfile, error) {
+	return s.repository.GetProfile(ctx, customerID)
+}
+
+func (s *customerService) UpdateProfile(
+	ctx context.Context,
+	customerID int,
+	request request.UpdateProfileRequest,
+) (*model.CustomerProfile, error) {
+	if request.Name == nil && request.Address == nil {
+		return nil, utils.ErrNothingToUpdate
+	}
+
+	return s.repository.UpdateProfile(ctx, customerID, request.Name, request.Address)
+}
+
+// ChangePassword implements CustomerService.
+// Every refresh token is revoked afterwards, so other sessions have to log in
+// again with the new password once their access token expires.
+func (s *customerService) ChangePassword(ctx context.Context, customerID int, request request.ChangePasswordRequest) error {
+	if err := s.checkPassword(ctx, customerID, request.CurrentPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(request.NewPassword)
+	if err != nil {
+		logging.FromContext(ctx).Error("[ChangePassword] failed to hash password", "customer_id", customerID, "error", err)
+		return err
+	}
+
+	if err := s.repository.UpdatePassword(ctx, customerID, hashedPassword); err != nil {
+		return err
+	}
+
+	return s.tokenRepository.RevokeCustomerTokens(ctx, customerID)
+}
+
+// ChangeEmail implements CustomerService.
+// The current email stays in use until the token mailed to the new one is
+// passed to VerifyEmail.
+func (s *customerService) ChangeEmail(ctx context.Context, customerID int, request request.ChangeEmailRequest) error {
+	if err := s.checkPassword(ctx, customerID, request.Password); err != nil {
+		return err
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	verification := &model.EmailVerification{
+		CustomerID: int64(customerID),
+		Email:      strings.ToLower(request.Email),
+		TokenHash:  utils.HashToken(token),
+		ExpiresAt:  time.Now().Add(EmailVerificationTTL),
+	}
+	if err := s.repository.CreateEmailVerification(ctx, verification); err != nil {
+		return err
+	}
+
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      verification.Email,
+		Subject: "Confirm your new email",
+		Body:    "Confirm this address for your bookstore account with POST /email/verify and the token " + token,
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("[ChangeEmail] Could not send verification", "customer_id", customerID, "mailer", s.mailer.Name(), "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// VerifyEmail implements CustomerService.
+func (s *customerService) VerifyEmail(ctx context.Context, token string) (*model.CustomerProfile, error) {
+	if token == "" {
+		return nil, utils.ErrInvalidVerificationToken
+	}
+
+	verification, err := s.repository.ConfirmEmail(ctx, utils.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repository.GetProfile(ctx, int(verification.CustomerID))
+}
+
+// checkPassword makes sure the customer proved they know the current password.
+func (s *customerService) checkPassword(ctx context.Context, customerID int, password string) error {
+	hash, err := s.repository.GetPasswordHash(ctx, customerID)
+	if err != nil {
+		return err
+	}
+
+	if !utils.CheckPassword(password, hash) {
+		return utils.ErrWrongPassword
+	}
+
+	return nil
+}
+
+func (s *customerService) revokeReusedFamily(ctx context.Context, stored *model.RefreshToken) error {
+	logging.FromContext(ctx).Warn("[RefreshToken] Refresh token reuse, revoking family", "customer_id", stored.CustomerID, "family_id", stored.FamilyID)
+	if err := s.tokenRepository.RevokeTokenFamily(ctx, stored.FamilyID); err != nil {
+		return err
+	}
+	return utils.ErrRefreshTokenReused
+}
+
+func (s *customerService) newTokenPair(customer *model.Customer, refreshToken string) (*model.TokenPair, error) {
+	accessToken, err := s.signer.GenerateToken(customer.ID, customer.Email, customer.Role)
+	if err != nil {
+		return nil, err
+	}
 
-	return s.repository.Register(customer)
+	return &model.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(utils.AccessTokenTTL / time.Second),
+	}, nil
 }
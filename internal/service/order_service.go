@@ -2,59 +2,167 @@ package service
 
 import (
 	"bookstore/internal/handler/request"
+	"bookstore/internal/logging"
+	"bookstore/internal/metrics"
 	"bookstore/internal/model"
+	"bookstore/internal/payment"
 	"bookstore/internal/repository"
 	"bookstore/pkg/utils"
+	"context"
 	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultOrderPageSize = 10
+	MaxOrderPageSize     = 100
 )
 
 type OrderService interface {
-	AddToCart(customerID int, request request.AddToCartRequest) error
-	GetCart(customerID int) (*model.OrderResponse, error)
-	GetOrderHistory(customerID int, request request.HistoryRequest) ([]model.OrderResponse, error)
-	CreateOrderIfNotExists(customerID int) (int, error)
-	RemoveFromCart(customerID int, bookId int) error
-	PayOrder(customerID int) error
+	AddToCart(ctx context.Context, customerID int, request request.AddToCartRequest) error
+	GetCart(ctx context.Context, customerID int) (*model.OrderResponse, error)
+	RepriceCart(ctx context.Context, customerID int) (*model.OrderResponse, error)
+	GetOrderHistory(ctx context.Context, customerID int, query model.OrderHistoryQuery) (*model.OrderHistoryPage, error)
+	CreateOrderIfNotExists(ctx context.Context, customerID int) (int, error)
+	RemoveFromCart(ctx context.Context, customerID int, bookId int) error
+	PayOrder(ctx context.Context, customerID int, card payment.Card) (*model.PaidOrder, error)
+	AdvanceOrder(
+		ctx context.Context,
+		orderID int,
+		to model.OrderState,
+		actorID int,
+		note string,
+	) (*model.OrderStateTransition, error)
+	GetOrderTransitions(ctx context.Context, orderID int) ([]model.OrderStateTransition, error)
+	CancelOrder(ctx context.Context, customerID int, orderID int) (*model.Refund, error)
+	CancelPendingOrder(ctx context.Context, orderID int, actorID int, note string) (*model.OrderStateTransition, error)
+	RefundOrder(ctx context.Context, orderID int, actorID int, request request.RefundOrderRequest) (*model.Refund, error)
+}
+
+// orderTransitions lists, for every state, the states an order may move to.
+// Refunded is terminal. Every state that may become refunded accepts refunds.
+var orderTransitions = map[model.OrderState][]model.OrderState{
+	model.OrderStateCart:           {model.OrderStatePendingPayment, model.OrderStatePaid},
+	model.OrderStatePendingPayment: {model.OrderStatePaid, model.OrderStateCancelled, model.OrderStateCart},
+	model.OrderStatePaid:           {model.OrderStateFulfilling, model.OrderStateCancelled, model.OrderStateRefunded},
+	model.OrderStateFulfilling:     {model.OrderStateShipped, model.OrderStateCancelled, model.OrderStateRefunded},
+	model.OrderStateShipped:        {model.OrderStateDelivered, model.OrderStateRefunded},
+	model.OrderStateDelivered:      {model.OrderStateRefunded},
+	model.OrderStateCancelled:      {model.OrderStateRefunded},
+}
+
+// fulfilmentSteps are the moves staff may make by hand. Paid is only reached
+// by a captured payment, going back to the cart only by an abandoned checkout,
+// so both keep their stock and money in line with the order.
+var fulfilmentSteps = map[model.OrderState]model.OrderState{
+	model.OrderStatePaid:       model.OrderStateFulfilling,
+	model.OrderStateFulfilling: model.OrderStateShipped,
+	model.OrderStateShipped:    model.OrderStateDelivered,
+}
+
+// CanAdvance reports whether staff may move an order from one state to another.
+func CanAdvance(from, to model.OrderState) bool {
+	next, ok := fulfilmentSteps[from]
+	return ok && next == to
+}
+
+// CanTransition reports whether the state machine allows moving from one state to another.
+func CanTransition(from, to model.OrderState) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
 }
 
 type orderService struct {
-	repository repository.OrderRepository
+	repository        repository.OrderRepository
+	bookRepository    repository.BookRepository
+	paymentRepository repository.PaymentRepository
+	provider          payment.PaymentProvider
+	metrics           *metrics.Metrics
+	cancelWindow      time.Duration
 }
 
-func NewOrderService(repository repository.OrderRepository) OrderService {
-	return &orderService{repository: repository}
+func NewOrderService(
+	repository repository.OrderRepository,
+	bookRepository repository.BookRepository,
+	paymentRepository repository.PaymentRepository,
+	provider payment.PaymentProvider,
+	metrics *metrics.Metrics,
+	cancelWindow time.Duration,
+) OrderService {
+	return &orderService{
+		repository:        repository,
+		bookRepository:    bookRepository,
+		paymentRepository: paymentRepository,
+		provider:          provider,
+		metrics:           metrics,
+		cancelWindow:      cancelWindow,
+	}
 }
 
-func (s *orderService) AddToCart(customerID int, request request.AddToCartRequest) error {
+// AddToCart prices the line from the catalog, any price sent by the client is ignored.
+// Archived books cannot be added.
+func (s *orderService) AddToCart(ctx context.Context, customerID int, request request.AddToCartRequest) error {
+
+	book, err := s.bookRepository.GetBookById(ctx, int(request.BookId))
+	if err != nil {
+		if errors.Is(err, utils.ErrBookNotFound) {
+			return utils.ErrBookUnavailable
+		}
+		return err
+	}
 
-	orderId, err := s.CreateOrderIfNotExists(customerID)
+	if book.ArchivedAt != nil {
+		return utils.ErrBookUnavailable
+	}
+
+	orderId, err := s.CreateOrderIfNotExists(ctx, customerID)
 
 	if err != nil {
 		return err
 	}
 
-	subTotal := request.Price * float64(request.Quantity)
+	err = s.repository.AddOrUpdateCart(ctx, &model.OrderDetail{
+		OrderID:   int64(orderId),
+		BookID:    book.ID,
+		Quantity:  request.Quantity,
+		Subtotal:  book.Price.Mul(request.Quantity),
+		UnitPrice: book.Price,
+		Title:     book.Title,
+		Author:    book.Author,
+	})
+	if err != nil {
+		return err
+	}
 
-	return s.repository.AddOrUpdateCart(
-		orderId,
-		int(request.BookId),
-		int(request.Quantity),
-		*utils.ConvertStorePrice(&subTotal),
-	)
+	s.metrics.CartItemAdded()
+	return nil
 }
 
-func (s *orderService) CreateOrderIfNotExists(customerID int) (int, error) {
-	return s.repository.CreateOrderIfNotExists(customerID)
+func (s *orderService) CreateOrderIfNotExists(ctx context.Context, customerID int) (int, error) {
+	orderId, created, err := s.repository.CreateOrderIfNotExists(ctx, customerID)
+	if err != nil {
+		return 0, err
+	}
+
+	if created {
+		s.metrics.CartCreated()
+	}
+	return orderId, nil
 }
 
-func (s *orderService) GetCart(customerID int) (*model.OrderResponse, error) {
-	orderId, err := s.CreateOrderIfNotExists(customerID)
+func (s *orderService) GetCart(ctx context.Context, customerID int) (*model.OrderResponse, error) {
+	orderId, err := s.CreateOrderIfNotExists(ctx, customerID)
 
 	if err != nil {
 		return nil, err
 	}
 
-	cart, err := s.repository.GetCart(orderId)
+	cart, err := s.repository.GetCart(ctx, orderId)
 
 	if err != nil {
 		return nil, err
@@ -66,37 +174,453 @@ func (s *orderService) GetCart(customerID int) (*model.OrderResponse, error) {
 	return cart, nil
 }
 
+// RepriceCart accepts the current catalog prices for the customer's cart and
+// returns the repriced cart.
+func (s *orderService) RepriceCart(ctx context.Context, customerID int) (*model.OrderResponse, error) {
+	orderId, err := s.CreateOrderIfNotExists(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repository.RepriceCart(ctx, orderId); err != nil {
+		return nil, err
+	}
+
+	return s.repository.GetCart(ctx, orderId)
+}
+
+// GetOrderHistory returns one page of the customer's checked out orders.
+// Fills in the default page size and rejects filters that can never match.
 func (s *orderService) GetOrderHistory(
+	ctx context.Context,
 	customerID int,
-	request request.HistoryRequest,
-) ([]model.OrderResponse, error) {
+	query model.OrderHistoryQuery,
+) (*model.OrderHistoryPage, error) {
+	query.CustomerID = int64(customerID)
+
+	if query.Limit == 0 {
+		query.Limit = DefaultOrderPageSize
+	}
+
+	if query.Limit < 0 || query.Limit > MaxOrderPageSize {
+		return nil, fmt.Errorf(
+			"%w: limit must be between 1 and %d",
+			utils.ErrInvalidOrderQuery,
+			MaxOrderPageSize,
+		)
+	}
+
+	if query.From != nil && query.To != nil && !query.From.Before(*query.To) {
+		return nil, fmt.Errorf("%w: from must be before to", utils.ErrInvalidOrderQuery)
+	}
+
+	for _, state := range query.States {
+		if state == model.OrderStateCart {
+			return nil, fmt.Errorf("%w: carts are not part of the history", utils.ErrInvalidOrderQuery)
+		}
+	}
+
+	if query.MinTotal != nil && query.MinTotal.IsNegative() {
+		return nil, fmt.Errorf("%w: minTotal cannot be negative", utils.ErrInvalidOrderQuery)
+	}
+
+	return s.repository.GetOrderHistory(ctx, query)
+}
+
+func (s *orderService) RemoveFromCart(ctx context.Context, customerID int, bookId int) error {
+	orderId, err := s.CreateOrderIfNotExists(ctx, customerID)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.RemoveFromCart(ctx, orderId, bookId)
+}
+
+// PayOrder checks the customer's cart out and charges it. Stock is reserved
+// while the order waits for payment and the order only becomes paid once the
+// provider captured the money. If any step fails the provider side is undone
+// and the order goes back to being the customer's cart. Empty carts, carts
+// holding archived books and orders with nothing to charge are refused before
+// the provider is called.
+func (s *orderService) PayOrder(ctx context.Context, customerID int, card payment.Card) (*model.PaidOrder, error) {
+	order, err := s.repository.StartCheckout(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &model.Payment{
+		OrderID:  order.ID,
+		Provider: s.provider.Name(),
+		Amount:   order.Total,
+		Status:   model.PaymentStatusPending,
+	}
+
+	// StartCheckout refuses these already, never ask the provider for nothing
+	if p.Amount.IsZero() || p.Amount.IsNegative() {
+		err := s.abandonCheckout(context.WithoutCancel(ctx), p, customerID, "order total is not payable")
+		return nil, errors.Join(utils.ErrOrderNotPayable, err)
+	}
+
+	if err := s.paymentRepository.CreatePayment(ctx, p); err != nil {
+		s.metrics.PaymentFailed(metrics.PaymentFailureError)
+		return nil, errors.Join(err, s.abandonCheckout(context.WithoutCancel(ctx), p, customerID, "payment could not be recorded"))
+	}
+
+	p.ProviderRef, err = s.provider.Authorize(ctx, payment.AuthorizeRequest{
+		Reference: fmt.Sprintf("order-%d-payment-%d", p.OrderID, p.ID),
+		Amount:    p.Amount,
+		Card:      card,
+	})
+	if err != nil {
+		return nil, s.failPayment(ctx, p, customerID, err)
+	}
+
+	p.Status = model.PaymentStatusAuthorized
+	if err := s.paymentRepository.UpdatePayment(ctx, p); err != nil {
+		return nil, s.failPayment(ctx, p, customerID, err)
+	}
+
+	if err := s.provider.Capture(ctx, p.ProviderRef, p.Amount); err != nil {
+		return nil, s.failPayment(ctx, p, customerID, err)
+	}
+
+	p.Status = model.PaymentStatusCaptured
+	if err := s.paymentRepository.UpdatePayment(ctx, p); err != nil {
+		return nil, s.failPayment(ctx, p, customerID, err)
+	}
 
-	if request.Limit == 0 {
-		request.Limit = 10
+	if err := s.repository.CompleteCheckout(ctx, int(p.OrderID), customerID); err != nil {
+		return nil, s.failPayment(ctx, p, customerID, err)
 	}
 
-	orders, err := s.repository.GetOrderHistory(customerID, request.Limit, request.Page)
+	s.metrics.OrderPaid(p.Amount)
 
+	// the order is paid at this point, failing to read it back must not say otherwise
+	paid, err := s.repository.GetOrder(ctx, int(p.OrderID))
 	if err != nil {
+		logging.FromContext(ctx).Error("[PayOrder] Could not read paid order", "order_id", p.OrderID, "error", err)
+		paid = &model.OrderResponse{ID: p.OrderID, State: model.OrderStatePaid, Total: p.Amount}
+	}
+
+	return &model.PaidOrder{Order: paid, Payment: p}, nil
+}
+
+// failPayment voids or refunds whatever the provider already holds for p,
+// records why the payment failed and gives the customer their cart back.
+// It keeps going after ctx is done, a timeout must not leave money held.
+// cause is returned for the caller to report, joined with the error of
+// giving the cart back if that failed.
+func (s *orderService) failPayment(ctx context.Context, p *model.Payment, customerID int, cause error) error {
+	ctx = context.WithoutCancel(ctx)
+
+	status := model.PaymentStatusFailed
+	if errors.Is(cause, utils.ErrPaymentDeclined) {
+		status = model.PaymentStatusDeclined
+		s.metrics.PaymentFailed(metrics.PaymentFailureDeclined)
+	} else {
+		s.metrics.PaymentFailed(metrics.PaymentFailureError)
+	}
+
+	switch p.Status {
+	case model.PaymentStatusAuthorized:
+		if err := s.provider.Void(ctx, p.ProviderRef); err != nil {
+			logging.FromContext(ctx).Error("[PayOrder] Could not void payment", "payment_id", p.ID, "error", err)
+		} else {
+			status = model.PaymentStatusVoided
+		}
+	case model.PaymentStatusCaptured:
+		if err := s.provider.Refund(ctx, p.ProviderRef, p.Amount); err != nil {
+			// the money is taken, the order stays pending until staff refund it at
+			// the provider and cancel it through CancelPendingOrder
+			logging.FromContext(ctx).Error("[PayOrder] Could not refund captured payment", "payment_id", p.ID, "order_id", p.OrderID, "error", err)
+			p.FailureReason = failureReason(cause)
+			if err := s.paymentRepository.UpdatePayment(ctx, p); err != nil {
+				logging.FromContext(ctx).Error("[PayOrder] Could not record failure of payment", "payment_id", p.ID, "error", err)
+			}
+			return cause
+		}
+		status = model.PaymentStatusRefunded
+	}
+
+	p.Status = status
+	p.FailureReason = failureReason(cause)
+	if err := s.paymentRepository.UpdatePayment(ctx, p); err != nil {
+		logging.FromContext(ctx).Error("[PayOrder] Could not record failure of payment", "payment_id", p.ID, "error", err)
+	}
+
+	return errors.Join(cause, s.abandonCheckout(ctx, p, customerID, "payment "+string(status)))
+}
+
+// abandonCheckout gives the customer their cart back. The error is returned
+// along with the payment failure, the order would otherwise stay pending with
+// its stock reserved without anyone noticing.
+func (s *orderService) abandonCheckout(ctx context.Context, p *model.Payment, customerID int, note string) error {
+	err := s.repository.AbandonCheckout(ctx, int(p.OrderID), customerID, note)
+	if err != nil {
+		logging.FromContext(ctx).Error("[PayOrder] Could not return order to the cart", "order_id", p.OrderID, "error", err)
+		return fmt.Errorf("could not return order %d to the cart: %w", p.OrderID, err)
+	}
+	return nil
+}
+
+// failureReason fits an error message into payments.failure_reason.
+func failureReason(err error) string {
+	const maxLength = 255
+	reason := err.Error()
+	if len(reason) > maxLength {
+		reason = reason[:maxLength]
+	}
+	return reason
+}
+
+// AdvanceOrder moves an order one fulfilment step forward.
+// If the order changes state concurrently the check is repeated against the
+// fresh state, so the caller always gets the state that actually blocked it.
+func (s *orderService) AdvanceOrder(
+	ctx context.Context,
+	orderID int,
+	to model.OrderState,
+	actorID int,
+	note string,
+) (*model.OrderStateTransition, error) {
+	for {
+		current, err := s.repository.GetOrderState(ctx, orderID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !CanAdvance(current, to) {
+			return nil, &utils.InvalidTransitionError{Current: current, Requested: to}
+		}
+
+		transition := &model.OrderStateTransition{
+			OrderID:   int64(orderID),
+			From:      current,
+			To:        to,
+			ChangedBy: int64(actorID),
+			Note:      note,
+		}
+
+		err = s.repository.TransitionOrder(ctx, transition)
+		if errors.Is(err, utils.ErrOrderStateChanged) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return transition, nil
+	}
+}
+
+func (s *orderService) GetOrderTransitions(ctx context.Context, orderID int) ([]model.OrderStateTransition, error) {
+	if _, err := s.repository.GetOrderState(ctx, orderID); err != nil {
 		return nil, err
 	}
+	return s.repository.GetOrderTransitions(ctx, orderID)
+}
+
+// CancelOrder lets a customer call off their paid order before fulfilment
+// starts and within the cancel window. The whole payment is refunded, every
+// copy goes back in stock and the order ends up refunded. The order is
+// cancelled before the provider is asked, if the refund then fails it stays
+// cancelled with a failed refund for staff to retry.
+func (s *orderService) CancelOrder(ctx context.Context, customerID int, orderID int) (*model.Refund, error) {
+	for {
+		order, err := s.repository.GetOrderSummary(ctx, orderID)
+		if err != nil {
+			return nil, err
+		}
+
+		// other customers' orders do not exist as far as the caller is concerned
+		if order.CustomerID != int64(customerID) {
+			return nil, utils.ErrOrderNotFound
+		}
+		if order.OrderState != model.OrderStatePaid {
+			return nil, &utils.InvalidTransitionError{Current: order.OrderState, Requested: model.OrderStateCancelled}
+		}
+
+		paidAt, err := s.paidAt(ctx, order)
+		if err != nil {
+			return nil, err
+		}
+		if time.Since(paidAt) > s.cancelWindow {
+			return nil, utils.ErrCancelWindowOver
+		}
+
+		p, err := s.paymentRepository.GetCapturedPayment(ctx, orderID)
+		if err != nil {
+			return nil, err
+		}
+
+		refund := &model.Refund{
+			OrderID:   order.ID,
+			PaymentID: p.ID,
+			Reason:    "cancelled by customer",
+			Restock:   true,
+			CreatedBy: int64(customerID),
+		}
+		fullyRefunded, err := s.repository.StartRefund(ctx, refund, &model.OrderStateTransition{
+			OrderID:   order.ID,
+			From:      model.OrderStatePaid,
+			To:        model.OrderStateCancelled,
+			ChangedBy: int64(customerID),
+			Note:      refund.Reason,
+		})
+		if errors.Is(err, utils.ErrOrderStateChanged) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// the whole total came back, so the cancelled order ends up refunded like any other
+		if err := s.settleRefund(ctx, refund, p, fullyRefunded); err != nil {
+			return nil, err
+		}
+		return refund, nil
+	}
+}
 
-	if len(orders) == 0 {
-		return nil, utils.WarnCartEmpty
+// CancelPendingOrder lets staff settle an order stuck waiting for payment,
+// e.g. left behind by a crash during checkout or kept after the provider
+// refused to refund a captured payment. Its stock is released, any money the
+// provider still holds has to be returned at the provider.
+func (s *orderService) CancelPendingOrder(
+	ctx context.Context,
+	orderID int,
+	actorID int,
+	note string,
+) (*model.OrderStateTransition, error) {
+	state, err := s.repository.GetOrderState(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if state != model.OrderStatePendingPayment {
+		return nil, &utils.InvalidTransitionError{Current: state, Requested: model.OrderStateCancelled}
 	}
 
-	return orders, nil
+	transition := &model.OrderStateTransition{
+		OrderID:   int64(orderID),
+		ChangedBy: int64(actorID),
+		Note:      note,
+	}
+	err = s.repository.CancelCheckout(ctx, transition)
+	if errors.Is(err, utils.ErrOrderStateChanged) {
+		// paid or returned to the cart meanwhile, report what blocked it
+		if state, err = s.repository.GetOrderState(ctx, orderID); err != nil {
+			return nil, err
+		}
+		return nil, &utils.InvalidTransitionError{Current: state, Requested: model.OrderStateCancelled}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return transition, nil
 }
 
-func (s *orderService) RemoveFromCart(customerID int, bookId int) error {
-	orderId, err := s.CreateOrderIfNotExists(customerID)
+// paidAt is when the order last became paid. Orders paid before transitions
+// were recorded fall back to their last update.
+func (s *orderService) paidAt(ctx context.Context, order *model.Order) (time.Time, error) {
+	transitions, err := s.repository.GetOrderTransitions(ctx, int(order.ID))
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
+	for i := len(transitions) - 1; i >= 0; i-- {
+		if transitions[i].To == model.OrderStatePaid {
+			return transitions[i].CreatedAt, nil
+		}
+	}
+	return order.UpdatedAt, nil
+}
+
+// RefundOrder gives money back for an order that was paid, for the lines of
+// the request or, without any, for everything not refunded yet. The order
+// becomes refunded once nothing is left to refund.
+func (s *orderService) RefundOrder(
+	ctx context.Context,
+	orderID int,
+	actorID int,
+	request request.RefundOrderRequest,
+) (*model.Refund, error) {
+	for {
+		order, err := s.repository.GetOrderSummary(ctx, orderID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !CanTransition(order.OrderState, model.OrderStateRefunded) {
+			return nil, &utils.InvalidTransitionError{Current: order.OrderState, Requested: model.OrderStateRefunded}
+		}
 
-	return s.repository.RemoveFromCart(orderId, bookId)
+		p, err := s.paymentRepository.GetCapturedPayment(ctx, orderID)
+		if err != nil {
+			return nil, err
+		}
+
+		refund := &model.Refund{
+			OrderID:   order.ID,
+			PaymentID: p.ID,
+			Reason:    request.Reason,
+			Restock:   request.Restock,
+			CreatedBy: int64(actorID),
+		}
+		for _, line := range request.Lines {
+			refund.Lines = append(refund.Lines, model.RefundLine{BookID: line.BookId, Quantity: line.Quantity})
+		}
+
+		// locking the state it was checked in keeps the check valid
+		fullyRefunded, err := s.repository.StartRefund(ctx, refund, &model.OrderStateTransition{
+			OrderID:   order.ID,
+			From:      order.OrderState,
+			To:        order.OrderState,
+			ChangedBy: int64(actorID),
+		})
+		if errors.Is(err, utils.ErrOrderStateChanged) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.settleRefund(ctx, refund, p, fullyRefunded); err != nil {
+			return nil, err
+		}
+		return refund, nil
+	}
 }
 
-func (s *orderService) PayOrder(customerId int) error {
-	return s.repository.PayOrder(customerId)
+// settleRefund asks the provider to give back the money of a started refund
+// and records the outcome. A refused refund frees its copies and amount to be
+// refunded again. Once the order is fully refunded so are its payment and the
+// order itself.
+func (s *orderService) settleRefund(
+	ctx context.Context,
+	refund *model.Refund,
+	p *model.Payment,
+	fullyRefunded bool,
+) error {
+	if err := s.provider.Refund(ctx, p.ProviderRef, refund.Amount); err != nil {
+		refund.FailureReason = failureReason(err)
+		if err := s.repository.FailRefund(context.WithoutCancel(ctx), refund); err != nil {
+			logging.FromContext(ctx).Error("[Refund] Could not record failed refund", "refund_id", refund.ID, "order_id", refund.OrderID, "error", err)
+		}
+		return err
+	}
+
+	// the money is back with the customer, recording it must not be cut short
+	ctx = context.WithoutCancel(ctx)
+
+	if err := s.repository.CompleteRefund(ctx, refund, fullyRefunded); err != nil {
+		logging.FromContext(ctx).Error("[Refund] Could not record completed refund", "refund_id", refund.ID, "order_id", refund.OrderID, "error", err)
+		return err
+	}
+
+	if fullyRefunded {
+		p.Status = model.PaymentStatusRefunded
+		if err := s.paymentRepository.UpdatePayment(ctx, p); err != nil {
+			logging.FromContext(ctx).Error("[Refund] Could not mark payment refunded", "payment_id", p.ID, "error", err)
+		}
+	}
+	return nil
 }
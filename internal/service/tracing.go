@@ -0,0 +1,296 @@
+package service
+
+import (
+	"bookstore/internal/handler/request"
+	"bookstore/internal/model"
+	"bookstore/internal/payment"
+	"bookstore/internal/tracing"
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The Trace* functions wrap a service so each of its methods is recorded as a
+// span named Service.Method. Only identifiers are attached, never emails,
+// passwords, tokens or cards.
+
+func TraceBookService(next BookService) BookService {
+	return &tracedBookService{next: next, tracer: tracing.Tracer()}
+}
+
+func TraceCustomerService(next CustomerService) CustomerService {
+	return &tracedCustomerService{next: next, tracer: tracing.Tracer()}
+}
+
+func TraceOrderService(next OrderService) OrderService {
+	return &tracedOrderService{next: next, tracer: tracing.Tracer()}
+}
+
+func startSpan(ctx context.Context, tracer trace.Tracer, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+func endSpan(span trace.Span, err error) {
+	tracing.RecordError(span, err)
+	span.End()
+}
+
+type tracedBookService struct {
+	next   BookService
+	tracer trace.Tracer
+}
+
+func (s *tracedBookService) CreateBook(ctx context.Context, book *model.Book) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "BookService.CreateBook")
+	defer func() { endSpan(span, err) }()
+	return s.next.CreateBook(ctx, book)
+}
+
+func (s *tracedBookService) GetBooks(ctx context.Context, query model.BookQuery) (_ *model.BookPage, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "BookService.GetBooks")
+	defer func() { endSpan(span, err) }()
+	return s.next.GetBooks(ctx, query)
+}
+
+func (s *tracedBookService) GetBookById(ctx context.Context, id int) (_ *model.Book, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "BookService.GetBookById", attribute.Int("book.id", id))
+	defer func() { endSpan(span, err) }()
+	return s.next.GetBookById(ctx, id)
+}
+
+func (s *tracedBookService) UpdateBook(ctx context.Context, book *model.Book) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "BookService.UpdateBook", attribute.Int64("book.id", book.ID))
+	defer func() { endSpan(span, err) }()
+	return s.next.UpdateBook(ctx, book)
+}
+
+func (s *tracedBookService) AdjustStock(ctx context.Context, movement *model.StockMovement) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "BookService.AdjustStock", attribute.Int64("book.id", movement.BookID))
+	defer func() { endSpan(span, err) }()
+	return s.next.AdjustStock(ctx, movement)
+}
+
+func (s *tracedBookService) ArchiveBook(ctx context.Context, id int) (_ *model.Book, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "BookService.ArchiveBook", attribute.Int("book.id", id))
+	defer func() { endSpan(span, err) }()
+	return s.next.ArchiveBook(ctx, id)
+}
+
+func (s *tracedBookService) RestoreBook(ctx context.Context, id int) (_ *model.Book, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "BookService.RestoreBook", attribute.Int("book.id", id))
+	defer func() { endSpan(span, err) }()
+	return s.next.RestoreBook(ctx, id)
+}
+
+func (s *tracedBookService) DeleteBook(ctx context.Context, id int) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "BookService.DeleteBook", attribute.Int("book.id", id))
+	defer func() { endSpan(span, err) }()
+	return s.next.DeleteBook(ctx, id)
+}
+
+type tracedCustomerService struct {
+	next   CustomerService
+	tracer trace.Tracer
+}
+
+func (s *tracedCustomerService) Register(ctx context.Context, customer *model.Customer) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "CustomerService.Register")
+	defer func() { endSpan(span, err) }()
+	return s.next.Register(ctx, customer)
+}
+
+func (s *tracedCustomerService) Login(ctx context.Context, email, password string) (_ *model.TokenPair, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "CustomerService.Login")
+	defer func() { endSpan(span, err) }()
+	return s.next.Login(ctx, email, password)
+}
+
+func (s *tracedCustomerService) RefreshToken(ctx context.Context, refreshToken string) (_ *model.TokenPair, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "CustomerService.RefreshToken")
+	defer func() { endSpan(span, err) }()
+	return s.next.RefreshToken(ctx, refreshToken)
+}
+
+func (s *tracedCustomerService) Logout(
+	ctx context.Context,
+	customerID int,
+	jti string,
+	expiresAt time.Time,
+	refreshToken string,
+) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "CustomerService.Logout", attribute.Int("customer.id", customerID))
+	defer func() { endSpan(span, err) }()
+	return s.next.Logout(ctx, customerID, jti, expiresAt, refreshToken)
+}
+
+func (s *tracedCustomerService) UpdateRole(ctx context.Context, customerID int, role model.Role) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "CustomerService.UpdateRole",
+		attribute.Int("customer.id", customerID),
+		attribute.String("customer.role", string(role)),
+	)
+	defer func() { endSpan(span, err) }()
+	return s.next.UpdateRole(ctx, customerID, role)
+}
+
+func (s *tracedCustomerService) GetProfile(ctx context.Context, customerID int) (_ *model.CustomerProfile, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "CustomerService.GetProfile", attribute.Int("customer.id", customerID))
+	defer func() { endSpan(span, err) }()
+	return s.next.GetProfile(ctx, customerID)
+}
+
+func (s *tracedCustomerService) UpdateProfile(
+	ctx context.Context,
+	customerID int,
+	request request.UpdateProfileRequest,
+) (_ *model.CustomerProfile, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "CustomerService.UpdateProfile", attribute.Int("customer.id", customerID))
+	defer func() { endSpan(span, err) }()
+	return s.next.UpdateProfile(ctx, customerID, request)
+}
+
+func (s *tracedCustomerService) ChangePassword(ctx context.Context, customerID int, request request.ChangePasswordRequest) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "CustomerService.ChangePassword", attribute.Int("customer.id", customerID))
+	defer func() { endSpan(span, err) }()
+	return s.next.ChangePassword(ctx, customerID, request)
+}
+
+func (s *tracedCustomerService) ChangeEmail(ctx context.Context, customerID int, request request.ChangeEmailRequest) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "CustomerService.ChangeEmail", attribute.Int("customer.id", customerID))
+	defer func() { endSpan(span, err) }()
+	return s.next.ChangeEmail(ctx, customerID, request)
+}
+
+func (s *tracedCustomerService) VerifyEmail(ctx context.Context, token string) (_ *model.CustomerProfile, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "CustomerService.VerifyEmail")
+	defer func() { endSpan(span, err) }()
+	return s.next.VerifyEmail(ctx, token)
+}
+
+type tracedOrderService struct {
+	next   OrderService
+	tracer trace.Tracer
+}
+
+func (s *tracedOrderService) AddToCart(ctx context.Context, customerID int, request request.AddToCartRequest) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "OrderService.AddToCart",
+		attribute.Int("customer.id", customerID),
+		attribute.Int64("book.id", request.BookId),
+	)
+	defer func() { endSpan(span, err) }()
+	return s.next.AddToCart(ctx, customerID, request)
+}
+
+func (s *tracedOrderService) GetCart(ctx context.Context, customerID int) (_ *model.OrderResponse, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "OrderService.GetCart", attribute.Int("customer.id", customerID))
+	defer func() { endSpan(span, err) }()
+	return s.next.GetCart(ctx, customerID)
+}
+
+func (s *tracedOrderService) RepriceCart(ctx context.Context, customerID int) (_ *model.OrderResponse, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "OrderService.RepriceCart", attribute.Int("customer.id", customerID))
+	defer func() { endSpan(span, err) }()
+	return s.next.RepriceCart(ctx, customerID)
+}
+
+func (s *tracedOrderService) GetOrderHistory(
+	ctx context.Context,
+	customerID int,
+	query model.OrderHistoryQuery,
+) (_ *model.OrderHistoryPage, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "OrderService.GetOrderHistory", attribute.Int("customer.id", customerID))
+	defer func() { endSpan(span, err) }()
+	return s.next.GetOrderHistory(ctx, customerID, query)
+}
+
+func (s *tracedOrderService) CreateOrderIfNotExists(ctx context.Context, customerID int) (_ int, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "OrderService.CreateOrderIfNotExists", attribute.Int("customer.id", customerID))
+	defer func() { endSpan(span, err) }()
+	return s.next.CreateOrderIfNotExists(ctx, customerID)
+}
+
+func (s *tracedOrderService) RemoveFromCart(ctx context.Context, customerID int, bookId int) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "OrderService.RemoveFromCart",
+		attribute.Int("customer.id", customerID),
+		attribute.Int("book.id", bookId),
+	)
+	defer func() { endSpan(span, err) }()
+	return s.next.RemoveFromCart(ctx, customerID, bookId)
+}
+
+func (s *tracedOrderService) PayOrder(ctx context.Context, customerID int, card payment.Card) (_ *model.PaidOrder, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "OrderService.PayOrder", attribute.Int("customer.id", customerID))
+	defer func() { endSpan(span, err) }()
+
+	paid, err := s.next.PayOrder(ctx, customerID, card)
+	if paid != nil {
+		span.SetAttributes(attribute.Int64("order.id", paid.Payment.OrderID), attribute.Int64("payment.id", paid.Payment.ID))
+	}
+	return paid, err
+}
+
+func (s *tracedOrderService) AdvanceOrder(
+	ctx context.Context,
+	orderID int,
+	to model.OrderState,
+	actorID int,
+	note string,
+) (_ *model.OrderStateTransition, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "OrderService.AdvanceOrder",
+		attribute.Int("order.id", orderID),
+		attribute.String("order.state", to.String()),
+	)
+	defer func() { endSpan(span, err) }()
+	return s.next.AdvanceOrder(ctx, orderID, to, actorID, note)
+}
+
+func (s *tracedOrderService) GetOrderTransitions(ctx context.Context, orderID int) (_ []model.OrderStateTransition, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "OrderService.GetOrderTransitions", attribute.Int("order.id", orderID))
+	defer func() { endSpan(span, err) }()
+	return s.next.GetOrderTransitions(ctx, orderID)
+}
+
+func (s *tracedOrderService) CancelPendingOrder(
+	ctx context.Context,
+	orderID int,
+	actorID int,
+	note string,
+) (_ *model.OrderStateTransition, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "OrderService.CancelPendingOrder", attribute.Int("order.id", orderID))
+	defer func() { endSpan(span, err) }()
+	return s.next.CancelPendingOrder(ctx, orderID, actorID, note)
+}
+
+func (s *tracedOrderService) CancelOrder(ctx context.Context, customerID int, orderID int) (_ *model.Refund, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "OrderService.CancelOrder",
+		attribute.Int("customer.id", customerID),
+		attribute.Int("order.id", orderID),
+	)
+	defer func() { endSpan(span, err) }()
+
+	refund, err := s.next.CancelOrder(ctx, customerID, orderID)
+	if refund != nil {
+		span.SetAttributes(attribute.Int64("refund.id", refund.ID))
+	}
+	return refund, err
+}
+
+func (s *tracedOrderService) RefundOrder(
+	ctx context.Context,
+	orderID int,
+	actorID int,
+	request request.RefundOrderRequest,
+) (_ *model.Refund, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "OrderService.RefundOrder",
+		attribute.Int("order.id", orderID),
+		attribute.Int("refund.lines", len(request.Lines)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	refund, err := s.next.RefundOrder(ctx, orderID, actorID, request)
+	if refund != nil {
+		span.SetAttributes(attribute.Int64("refund.id", refund.ID))
+	}
+	return refund, err
+}
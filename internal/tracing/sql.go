@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// maxStatementLength keeps the long reporting queries from bloating spans.
+const maxStatementLength = 2048
+
+var (
+	// string literals, numbers and the $n placeholders, which are kept
+	sqlLiteral = regexp.MustCompile(`\$\d+|'(?:[^']|'')*'|\b\d+(?:\.\d+)?\b`)
+	whitespace = regexp.MustCompile(`\s+`)
+)
+
+// OpenDB opens a database whose every query, statement and transaction is
+// recorded as a span carrying its sanitized SQL, never its arguments.
+func OpenDB(driverName, dataSourceName string) (*sql.DB, error) {
+	return otelsql.Open(driverName, dataSourceName, sqlOptions()...)
+}
+
+// WrapDriver instruments a driver the same way as OpenDB, e.g. sqlmock's in tests.
+func WrapDriver(d driver.Driver) driver.Driver {
+	return otelsql.WrapDriver(d, sqlOptions()...)
+}
+
+func sqlOptions() []otelsql.Option {
+	return []otelsql.Option{
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableQuery:         true, // replaced by the sanitized statement
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+		otelsql.WithAttributesGetter(func(_ context.Context, _ otelsql.Method, query string, _ []driver.NamedValue) []attribute.KeyValue {
+			if query == "" {
+				return nil
+			}
+			return []attribute.KeyValue{semconv.DBQueryText(SanitizeSQL(query))}
+		}),
+	}
+}
+
+// SanitizeSQL replaces the literals of query with ? and collapses its
+// whitespace. Placeholders are kept, the arguments bound to them are not
+// part of the statement.
+func SanitizeSQL(query string) string {
+	query = sqlLiteral.ReplaceAllStringFunc(query, func(match string) string {
+		if strings.HasPrefix(match, "$") {
+			return match
+		}
+		return "?"
+	})
+	query = strings.TrimSpace(whitespace.ReplaceAllString(query, " "))
+	if len(query) > maxStatementLength {
+		query = query[:maxStatementLength]
+	}
+	return query
+}
@@ -0,0 +1,126 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName names the instrumentation scope of every span of the application.
+const TracerName = "bookstore"
+
+const (
+	ExporterNone   = "none"   // Spans are not recorded, trace context is still propagated
+	ExporterStdout = "stdout" // Pretty printed JSON on stdout, for development
+	ExporterFile   = "file"   // JSON appended to Config.File, for development
+	ExporterOTLP   = "otlp"   // OTLP over HTTP to a collector
+)
+
+// Exporters lists the accepted Config.Exporter values.
+var Exporters = []string{ExporterNone, ExporterStdout, ExporterFile, ExporterOTLP}
+
+type Config struct {
+	Exporter     string
+	OTLPEndpoint string // e.g. http://otel-collector:4318, empty uses the OTEL_EXPORTER_OTLP_* variables
+	File         string
+	ServiceName  string
+	SampleRatio  float64 // Share of new traces recorded, traces started upstream follow the caller's decision
+}
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator. The returned function flushes the spans still buffered and
+// must be called before the process exits.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporter, closeOutput, err := newExporter(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(config.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		return errors.Join(provider.Shutdown(ctx), closeOutput())
+	}, nil
+}
+
+func newExporter(ctx context.Context, config Config) (sdktrace.SpanExporter, func() error, error) {
+	noClose := func() error { return nil }
+
+	switch config.Exporter {
+	case ExporterNone, "":
+		return nil, noClose, nil
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		return exporter, noClose, err
+	case ExporterFile:
+		if config.File == "" {
+			return nil, nil, errors.New("the file exporter needs a file")
+		}
+		file, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return exporter, file.Close, nil
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		if config.OTLPEndpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(config.OTLPEndpoint))
+		}
+		exporter, err := otlptracehttp.New(ctx, options...)
+		return exporter, noClose, err
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", config.Exporter)
+	}
+}
+
+// Tracer returns the tracer of the application. It follows the global
+// provider, so it can be taken before Setup runs.
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// RecordError marks span as failed with err, nil leaves it untouched.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
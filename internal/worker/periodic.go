@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"bookstore/internal/logging"
+	"context"
+	"time"
+)
+
+// Periodic runs a job in the background, once when started and then every
+// interval, until it is stopped.
+type Periodic struct {
+	name     string
+	interval time.Duration
+	job      func(ctx context.Context) error
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewPeriodic(name string, interval time.Duration, job func(ctx context.Context) error) *Periodic {
+	return &Periodic{name: name, interval: interval, job: job}
+}
+
+// Start runs the job in its own goroutine.
+func (p *Periodic) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	go p.run(ctx)
+}
+
+// Stop cancels a running job and waits for the goroutine to return, so what
+// the job uses can be closed afterwards. A worker never started stops at once.
+func (p *Periodic) Stop() error {
+	if p.cancel == nil {
+		return nil
+	}
+	p.cancel()
+	<-p.done
+	return nil
+}
+
+func (p *Periodic) run(ctx context.Context) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.job(ctx); err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error("[Worker] Job failed", "worker", p.name, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
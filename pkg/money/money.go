@@ -0,0 +1,230 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// USD is the currency every price in the store is kept in for now.
+const USD = "USD"
+
+const DefaultCurrency = USD
+
+var (
+	ErrInvalidAmount    = errors.New("invalid money amount")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// minorUnits lists currencies whose minor unit exponent is not 2 (ISO 4217).
+var minorUnits = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// Money is an exact amount stored in minor units (cents for USD)
+// together with its ISO 4217 currency code.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// New returns an amount expressed in minor units of the given currency.
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Parse reads a decimal string such as "9.99" and rounds half away from zero
+// when it has more digits than the currency allows.
+func Parse(value, currency string) (Money, error) {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	s := strings.TrimSpace(value)
+	negative := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" || !isDigits(whole) || !isDigits(fraction) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+
+	exp := exponent(currency)
+	roundUp := len(fraction) > exp && fraction[exp] >= '5'
+	if len(fraction) > exp {
+		fraction = fraction[:exp]
+	}
+	fraction += strings.Repeat("0", exp-len(fraction))
+
+	var amount int64
+	if digits := whole + fraction; digits != "" {
+		var err error
+		if amount, err = strconv.ParseInt(digits, 10, 64); err != nil {
+			return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+		}
+	}
+	if roundUp {
+		amount++
+	}
+	if negative {
+		amount = -amount
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// FromFloat converts a float using its shortest decimal representation, so
+// 9.99 becomes 999 cents rather than the truncated 998. NaN and infinities
+// are refused with ErrInvalidAmount.
+func FromFloat(value float64, currency string) (Money, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return Money{}, fmt.Errorf("%w: %v", ErrInvalidAmount, value)
+	}
+	return Parse(strconv.FormatFloat(value, 'f', -1, 64), currency)
+}
+
+func (m Money) CurrencyCode() string {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+	return m.Currency
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if m.CurrencyCode() != other.CurrencyCode() {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.CurrencyCode(), other.CurrencyCode())
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.CurrencyCode()}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+// Mul multiplies the amount by a quantity, e.g. unit price times copies.
+func (m Money) Mul(quantity int64) Money {
+	return Money{Amount: m.Amount * quantity, Currency: m.CurrencyCode()}
+}
+
+// Sum adds up values of the same currency, starting from zero in currency.
+func Sum(currency string, values ...Money) (Money, error) {
+	total := Money{Currency: currency}
+	for _, value := range values {
+		var err error
+		if total, err = total.Add(value); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// String formats the amount as a plain decimal, e.g. "9.99".
+func (m Money) String() string {
+	exp := exponent(m.CurrencyCode())
+	digits := strconv.FormatInt(m.Amount, 10)
+
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// MarshalJSON writes the amount as a decimal string so clients never see a float.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts a decimal string or a bare JSON number; either is
+// parsed from its text so no binary float rounding is involved.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var number json.Number
+		if err := json.Unmarshal(data, &number); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAmount, data)
+		}
+		text = number.String()
+	}
+
+	parsed, err := Parse(text, m.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads a bigint column holding minor units. NULL is read as zero.
+func (m *Money) Scan(src interface{}) error {
+	currency := m.CurrencyCode()
+
+	switch v := src.(type) {
+	case nil:
+		*m = Money{Currency: currency}
+	case int64:
+		*m = Money{Amount: v, Currency: currency}
+	case []byte:
+		return m.scanText(string(v), currency)
+	case string:
+		return m.scanText(v, currency)
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidAmount, src)
+	}
+	return nil
+}
+
+func (m *Money) scanText(text, currency string) error {
+	amount, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidAmount, text)
+	}
+	*m = Money{Amount: amount, Currency: currency}
+	return nil
+}
+
+// Value stores the amount as minor units.
+func (m Money) Value() (driver.Value, error) {
+	return m.Amount, nil
+}
+
+func exponent(currency string) int {
+	if exp, ok := minorUnits[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
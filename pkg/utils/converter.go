@@ -2,47 +2,40 @@ package utils
 
 import (
 	"bookstore/internal/model"
+	"bookstore/pkg/money"
 	"database/sql"
-	"log"
+	"fmt"
+	"time"
 )
 
-func ConvertStorePrice(value *float64) *int64 {
-
-	if value == nil {
-		return nil
-	}
-
-	result := int64(*value * 100)
-
-	return &result
-}
-
-func ConvertToDisplayPrice(value *int64) *float64 {
-	if value == nil {
-		return nil
-	}
-
-	result := float64(*value) / 100
-
-	return &result
-}
-
+// ConvertToDetailResponse groups order lines into orders. Orders are returned
+// in the order their first row appears, so the ORDER BY of the query is kept.
+// Books are read from the line snapshot, not from the live catalog. Rows with a
+// catalog_price different from the snapshot are marked with a PriceChange.
+// The last two columns are the refunded quantity of the line and the refunded
+// total of the order. Errors are returned wrapped for the caller to log.
 func ConvertToDetailResponse(rows *sql.Rows) ([]model.OrderResponse, error) {
 	defer rows.Close()
 
-	var orders []model.OrderResponse
-	orderMap := make(map[int]*model.OrderResponse)
-
-	// Return immediately if no rows are present
+	orders := []model.OrderResponse{}
+	orderIndex := make(map[int]int)
 
 	for rows.Next() {
-		var orderID, detailID, quantity int
+		var orderID, detailID, quantity, refundedQuantity int
 		var bookID int64
-		var total, subtotal, price int64
-		var title, author string
+		var state model.OrderState
+		var updatedAt time.Time
+		var checkedOutAt *time.Time
+		var total, subtotal, price, refundedTotal money.Money
+		var catalogPrice *money.Money
+		var title, author, currency string
+		var archivedAt *time.Time
 
 		err := rows.Scan(
 			&orderID,
+			&state,
+			&updatedAt,
+			&checkedOutAt,
 			&total,
 			&detailID,
 			&bookID,
@@ -51,27 +44,45 @@ func ConvertToDetailResponse(rows *sql.Rows) ([]model.OrderResponse, error) {
 			&title,
 			&author,
 			&price,
+			&currency,
+			&catalogPrice,
+			&archivedAt,
+			&refundedQuantity,
+			&refundedTotal,
 		)
 		if err != nil {
-			log.Printf("[ConvertToDetailResponse] could not scan order row: %v", err)
-			return nil, err
+			return nil, fmt.Errorf("could not scan order row: %w", err)
 		}
 
-		// If the order is not in the map, create a new OrderResponse entry
-		if _, ok := orderMap[orderID]; !ok {
-			orderMap[orderID] = &model.OrderResponse{
-				ID:          (int64(orderID)),
-				OrderDetail: []model.OrderDetailResponse{},
-				Total:       *ConvertToDisplayPrice(&total),
-			}
+		// amounts are stored as minor units, the line carries their currency
+		total.Currency = currency
+		subtotal.Currency = currency
+		price.Currency = currency
+		refundedTotal.Currency = currency
+
+		// If the order has not been seen yet, create a new OrderResponse entry
+		index, ok := orderIndex[orderID]
+		if !ok {
+			index = len(orders)
+			orderIndex[orderID] = index
+			orders = append(orders, model.OrderResponse{
+				ID:           (int64(orderID)),
+				State:        state,
+				UpdatedAt:    updatedAt,
+				CheckedOutAt: checkedOutAt,
+				OrderDetail:  []model.OrderDetailResponse{},
+				Total:        total,
+				Refund:       orderRefund(total, refundedTotal),
+			})
 		}
 
 		// Create a new Book entry
 		book := model.Book{
-			ID:     bookID,
-			Title:  title,
-			Author: author,
-			Price:  *ConvertToDisplayPrice(&price),
+			ID:         bookID,
+			Title:      title,
+			Author:     author,
+			Price:      price,
+			ArchivedAt: archivedAt,
 		}
 
 		// Create a new OrderDetailResponse entry
@@ -79,20 +90,34 @@ func ConvertToDetailResponse(rows *sql.Rows) ([]model.OrderResponse, error) {
 			ID:       (int64(detailID)),
 			Book:     []model.Book{book},
 			Quantity: (int64(quantity)),
-			Subtotal: *ConvertToDisplayPrice(&subtotal),
+			Subtotal: subtotal,
+			Refunded: int64(refundedQuantity),
 		}
 
-		orderMap[orderID].OrderDetail = append(orderMap[orderID].OrderDetail, orderDetail)
-	}
+		if catalogPrice != nil && catalogPrice.Amount != price.Amount {
+			catalogPrice.Currency = currency
+			orderDetail.PriceChange = &model.PriceChange{OldPrice: price, NewPrice: *catalogPrice}
+			orders[index].PricesChanged = true
+		}
 
-	for _, order := range orderMap {
-		orders = append(orders, *order)
+		orders[index].OrderDetail = append(orders[index].OrderDetail, orderDetail)
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Printf("[ConvertToDetailResponse] could not retrieve orders: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("could not retrieve orders: %w", err)
 	}
 
 	return orders, nil
 }
+
+// orderRefund sums up what was refunded of an order, nil if nothing was.
+func orderRefund(total, refunded money.Money) *model.OrderRefund {
+	if refunded.IsZero() {
+		return nil
+	}
+	status := model.OrderRefundPartial
+	if refunded.Amount >= total.Amount {
+		status = model.OrderRefundFull
+	}
+	return &model.OrderRefund{Status: status, Amount: refunded}
+}
@@ -1,14 +1,154 @@
 package utils
 
-import "errors"
+import (
+	"bookstore/internal/model"
+	"bookstore/pkg/money"
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var (
-	ErrBookNotFound = errors.New("book not found")
+	ErrBookNotFound     = errors.New("book not found")
+	ErrBookUnavailable  = errors.New("book unavailable")
+	ErrBookReferenced   = errors.New("book is referenced by orders")
+	ErrInvalidBookQuery = errors.New("invalid book query")
+	ErrInvalidBookPrice = errors.New("book price must be greater than zero")
+	ErrInvalidCursor    = errors.New("invalid cursor")
+
+	ErrOutOfStock         = errors.New("out of stock")
+	ErrInsufficientStock  = errors.New("insufficient stock")
+	ErrInvalidStockChange = errors.New("invalid stock change")
 
 	ErrDuplicateEmail       = errors.New("duplicate email")
 	ErrWrongPassword        = errors.New("wrong password")
 	ErrEmptyEmailOrPassword = errors.New("empty")
 	ErrEmailNotFound        = errors.New("email not found")
+	ErrCustomerNotFound     = errors.New("customer not found")
+	ErrInvalidRole          = errors.New("invalid role")
+	ErrAdminExists          = errors.New("an admin already exists")
+	ErrNothingToUpdate      = errors.New("nothing to update")
+
+	ErrInvalidVerificationToken = errors.New("invalid email verification token")
+	ErrVerificationTokenExpired = errors.New("email verification token expired")
+	ErrUnknownMailSender        = errors.New("unknown mail sender")
+
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+	ErrSecretKeyNotSet     = errors.New("token secret key not set")
+
+	ErrInvalidConfig = errors.New("invalid configuration")
+	ErrWeakSecretKey = errors.New("secret key is too weak")
+
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrInvalidOrderQuery = errors.New("invalid order history query")
+	ErrInvalidTransition = errors.New("invalid order state transition")
+	ErrOrderStateChanged = errors.New("order state changed concurrently")
+	ErrCartPriceChanged  = errors.New("cart prices changed")
+	ErrOrderNotPayable   = errors.New("order total is not payable")
+	ErrCancelWindowOver  = errors.New("order can no longer be cancelled")
+	ErrInvalidRefund     = errors.New("invalid refund")
+	ErrNothingToRefund   = errors.New("nothing left to refund")
+
+	ErrPaymentDeclined        = errors.New("payment declined")
+	ErrPaymentTimeout         = errors.New("payment provider timed out")
+	ErrPaymentFailed          = errors.New("payment failed")
+	ErrUnknownPaymentProvider = errors.New("unknown payment provider")
+	ErrPaymentNotFound        = errors.New("no captured payment")
+
+	ErrUnknownMigration          = errors.New("database has a migration this build does not know")
+	ErrMigrationChecksumMismatch = errors.New("applied migration was modified")
+	ErrNoMigrationsApplied       = errors.New("no migrations applied")
+	ErrSchemaVersionMismatch     = errors.New("database schema is not at the expected version")
 
 	WarnCartEmpty = errors.New("cart empty")
 )
+
+type OutOfStockItem struct {
+	BookID    int64  `json:"bookId"`
+	Title     string `json:"title"`
+	Requested int64  `json:"requested"`
+	Available int64  `json:"available"`
+}
+
+// OutOfStockError lists every book in an order that does not have enough copies.
+// It matches ErrOutOfStock with errors.Is.
+type OutOfStockError struct {
+	Items []OutOfStockItem
+}
+
+func (e *OutOfStockError) Error() string {
+	titles := make([]string, len(e.Items))
+	for i, item := range e.Items {
+		titles[i] = fmt.Sprintf("%q (requested %d, available %d)", item.Title, item.Requested, item.Available)
+	}
+	return "out of stock: " + strings.Join(titles, ", ")
+}
+
+func (e *OutOfStockError) Is(target error) bool {
+	return target == ErrOutOfStock
+}
+
+type PriceChangedItem struct {
+	BookID   int64       `json:"bookId"`
+	Title    string      `json:"title"`
+	OldPrice money.Money `json:"oldPrice"`
+	NewPrice money.Money `json:"newPrice"`
+}
+
+// PriceChangedError lists every cart line whose book price changed in the
+// catalog since it was added. It matches ErrCartPriceChanged with errors.Is.
+type PriceChangedError struct {
+	Items []PriceChangedItem
+}
+
+func (e *PriceChangedError) Error() string {
+	titles := make([]string, len(e.Items))
+	for i, item := range e.Items {
+		titles[i] = fmt.Sprintf("%q (was %s, now %s)", item.Title, item.OldPrice, item.NewPrice)
+	}
+	return "cart prices changed: " + strings.Join(titles, ", ")
+}
+
+func (e *PriceChangedError) Is(target error) bool {
+	return target == ErrCartPriceChanged
+}
+
+type UnavailableItem struct {
+	BookID int64  `json:"bookId"`
+	Title  string `json:"title"`
+}
+
+// UnavailableItemsError lists every cart line whose book was withdrawn from
+// sale. It matches ErrBookUnavailable with errors.Is.
+type UnavailableItemsError struct {
+	Items []UnavailableItem
+}
+
+func (e *UnavailableItemsError) Error() string {
+	titles := make([]string, len(e.Items))
+	for i, item := range e.Items {
+		titles[i] = fmt.Sprintf("%q", item.Title)
+	}
+	return "books unavailable: " + strings.Join(titles, ", ")
+}
+
+func (e *UnavailableItemsError) Is(target error) bool {
+	return target == ErrBookUnavailable
+}
+
+// InvalidTransitionError reports an order state change the state machine does
+// not allow. It matches ErrInvalidTransition with errors.Is.
+type InvalidTransitionError struct {
+	Current   model.OrderState `json:"current"`
+	Requested model.OrderState `json:"requested"`
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("cannot move order from %s to %s", e.Current, e.Requested)
+}
+
+func (e *InvalidTransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
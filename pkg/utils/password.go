@@ -1,18 +1,14 @@
 package utils
 
 import (
-	"log"
-
 	"golang.org/x/crypto/bcrypt"
 )
 
+// CheckPassword reports whether providedPassword matches the stored hash. A
+// mismatch is the caller's to report, the login answers ErrWrongPassword.
 func CheckPassword(providedPassword, storedPassword string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(providedPassword))
-	if err != nil {
-		log.Printf("Password mismatch: %v", err) // Log the error for debugging
-		return false
-	}
-	return true
+	return err == nil
 }
 
 func HashPassword(password string) (string, error) {
@@ -1,27 +1,100 @@
 package utils
 
 import (
-	"os"
+	"bookstore/internal/model"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
+const (
+	AccessTokenTTL  = 15 * time.Minute    // Lifetime of the JWT sent with every request
+	RefreshTokenTTL = 30 * 24 * time.Hour // Lifetime of a refresh token, each rotation starts a new one
+)
+
+// TokenSigner signs and verifies access tokens with one HMAC key.
+type TokenSigner struct {
+	secretKey []byte
+}
+
+// NewTokenSigner returns a signer for secretKey. The key is validated by the
+// config package, an empty key makes every token operation fail.
+func NewTokenSigner(secretKey string) *TokenSigner {
+	return &TokenSigner{secretKey: []byte(secretKey)}
+}
+
+// SigningKey is the jwt.Keyfunc verifying access tokens, only HMAC signed
+// tokens are accepted.
+func (s *TokenSigner) SigningKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	if len(s.secretKey) == 0 {
+		return nil, ErrSecretKeyNotSet
+	}
+	return s.secretKey, nil
+}
+
 type Claims struct {
-	ID    int64  `json:"id"`
-	Email string `json:"email"`
+	ID    int64      `json:"id"`
+	Email string     `json:"email"`
+	Role  model.Role `json:"role"`
 	jwt.StandardClaims
 }
 
-func GenerateToken(userID int64, email string) (string, error) {
+// GenerateToken issues a short-lived access token. Every token gets a random
+// jti so it can be revoked on logout.
+func (s *TokenSigner) GenerateToken(userID int64, email string, role model.Role) (string, error) {
+	if len(s.secretKey) == 0 {
+		return "", ErrSecretKeyNotSet
+	}
+
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
 	claims := Claims{
 		ID:    userID,
 		Email: email,
+		Role:  role,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 72).Unix(), // Token expiration time
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(), // Token expiration time
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("SECRET_KEY")))
+	return token.SignedString(s.secretKey)
+}
+
+// GenerateRefreshToken returns a new opaque refresh token and the hash to store.
+func GenerateRefreshToken() (token string, hash string, err error) {
+	token, err = RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashToken(token), nil
+}
+
+// HashToken is the SHA-256 of a refresh token, the token itself is never stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RandomToken returns size random bytes encoded as unpadded base64url.
+func RandomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
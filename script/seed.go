@@ -1,12 +1,13 @@
 package main
 
 import (
+	"bookstore/internal/config"
 	"bookstore/internal/migration"
-	"fmt"
+	"bookstore/pkg/utils"
+	"errors"
+	"flag"
 	"log"
-	"os"
 
-	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -14,28 +15,27 @@ import (
 func main() {
 	logHeader := "SeedingPhase"
 
-	err := godotenv.Load()
+	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Error loading .env file")
-	}
-
-	host := os.Getenv("DB_HOST")
-	user := os.Getenv("POSTGRES_USER")
-	password := os.Getenv("POSTGRES_PASSWORD")
-	dbname := os.Getenv("POSTGRES_DB")
-	port := os.Getenv("DB_PORT")
-
-	// Create the connection string
-	conn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-		host,
-		user,
-		password,
-		dbname,
-		port,
+		log.Fatalf("[%v] Could not load configuration: %v", logHeader, err)
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		log.Fatalf("[%v] Invalid configuration: %v", logHeader, err)
+	}
+
+	adminEmail := flag.String(
+		"admin",
+		cfg.AdminEmail,
+		"email of a registered customer to promote to the first admin",
 	)
+	openingStock := flag.Int(
+		"opening-stock",
+		0,
+		"copies to give every book that was never stocked, 0 leaves stock untouched",
+	)
+	flag.Parse()
 
-	db, err := gorm.Open(postgres.Open(conn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(cfg.Database.DSN()), &gorm.Config{})
 
 	if err != nil {
 		log.Fatalf("[%v] Seeding the initial db failed: %v", logHeader, err)
@@ -51,11 +51,29 @@ func main() {
 	}
 
 	// Seed the database with books
-	migration.SeedBooks(sqlDB)
-
-	if err := migration.AddUniqueConstraintIfNotExists(sqlDB); err != nil {
-		log.Fatalf("[%v] Could not migrate books: %v", logHeader, err)
+	if err := migration.SeedBooks(sqlDB); err != nil {
+		log.Fatalf("[%v] Could not seed books: %v", logHeader, err)
 	}
 
 	log.Printf("[%v] Database seeded with initial books.", logHeader)
+
+	if *openingStock > 0 {
+		stocked, err := migration.SetOpeningStock(sqlDB, *openingStock)
+		if err != nil {
+			log.Fatalf("[%v] Could not set the opening stock: %v", logHeader, err)
+		}
+		log.Printf("[%v] Gave %d book(s) an opening stock of %d.", logHeader, stocked, *openingStock)
+	}
+
+	if *adminEmail != "" {
+		err := migration.PromoteFirstAdmin(sqlDB, *adminEmail)
+		if errors.Is(err, utils.ErrAdminExists) {
+			// seed runs on every start, so an existing admin is expected
+			log.Printf("[%v] An admin already exists, %s was not promoted.", logHeader, *adminEmail)
+		} else if err != nil {
+			log.Fatalf("[%v] Could not promote %s to admin: %v", logHeader, *adminEmail, err)
+		} else {
+			log.Printf("[%v] Promoted %s to admin.", logHeader, *adminEmail)
+		}
+	}
 }
@@ -0,0 +1,206 @@
+package config_test
+
+import (
+	"bookstore/internal/config"
+	"bookstore/pkg/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const strongKey = "0123456789abcdef0123456789abcdef"
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func validConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Database.Host = "db"
+	cfg.Database.User = "user"
+	cfg.Database.Name = "bookstore"
+	cfg.Auth.SecretKey = strongKey
+	return &cfg
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := config.LoadFrom(filepath.Join(t.TempDir(), "missing.env"), env(nil))
+
+	assert.NoError(t, err)
+	assert.Equal(t, config.Default(), *cfg)
+	assert.Equal(t, 24*time.Hour, cfg.Idempotency.KeyTTL)
+	assert.Equal(t, time.Hour, cfg.Idempotency.CleanupInterval)
+	assert.Equal(t, time.Hour, cfg.Auth.TokenCleanupInterval)
+	assert.Equal(t, 24*time.Hour, cfg.Orders.CancelWindow)
+	assert.Equal(t, "log", cfg.Mail.Sender)
+	assert.Equal(t, ":8080", cfg.HTTP.Addr)
+	assert.Equal(t, 5*time.Second, cfg.HTTP.DrainDelay)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+database:
+  host: file-host
+  user: file-user
+  name: file-db
+  queryTimeout: 2s
+http:
+  writeTimeout: 45s
+payment:
+  provider: file-provider
+`)
+	dotEnv := writeFile(t, ".env", "CONFIG_FILE="+file+"\nPOSTGRES_USER=dotenv-user\nPOSTGRES_DB=dotenv-db\n")
+
+	cfg, err := config.LoadFrom(dotEnv, env(map[string]string{"POSTGRES_DB": "env-db"}))
+
+	assert.NoError(t, err)
+	// the file overrides the defaults
+	assert.Equal(t, "file-host", cfg.Database.Host)
+	assert.Equal(t, 2*time.Second, cfg.Database.QueryTimeout)
+	assert.Equal(t, 45*time.Second, cfg.HTTP.WriteTimeout)
+	assert.Equal(t, 15*time.Second, cfg.HTTP.ReadTimeout)
+	// .env overrides the file, the environment overrides both
+	assert.Equal(t, "dotenv-user", cfg.Database.User)
+	assert.Equal(t, "env-db", cfg.Database.Name)
+	assert.Equal(t, "file-provider", cfg.Payment.Provider)
+}
+
+func TestLoad_JSONFile(t *testing.T) {
+	file := writeFile(t, "config.json", `{"auth": {"secretKey": "`+strongKey+`"}, "idempotency": {"keyTTL": "1h"}}`)
+
+	cfg, err := config.LoadFrom("", env(map[string]string{"CONFIG_FILE": file}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, strongKey, cfg.Auth.SecretKey)
+	assert.Equal(t, time.Hour, cfg.Idempotency.KeyTTL)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	t.Run("unknown key in file", func(t *testing.T) {
+		file := writeFile(t, "config.yaml", "database:\n  hots: db\n")
+
+		_, err := config.LoadFrom("", env(map[string]string{"CONFIG_FILE": file}))
+
+		assert.ErrorIs(t, err, utils.ErrInvalidConfig)
+	})
+
+	t.Run("missing config file", func(t *testing.T) {
+		_, err := config.LoadFrom("", env(map[string]string{"CONFIG_FILE": "/does/not/exist.yaml"}))
+
+		assert.Error(t, err)
+	})
+
+	t.Run("malformed values are all reported", func(t *testing.T) {
+		_, err := config.LoadFrom("", env(map[string]string{
+			"DB_QUERY_TIMEOUT":      "five seconds",
+			"ORDER_CANCEL_WINDOW":   "a day",
+			"HTTP_MAX_HEADER_BYTES": "1MB",
+		}))
+
+		assert.ErrorIs(t, err, utils.ErrInvalidConfig)
+		assert.ErrorContains(t, err, "DB_QUERY_TIMEOUT")
+		assert.ErrorContains(t, err, "HTTP_MAX_HEADER_BYTES")
+		assert.ErrorContains(t, err, "ORDER_CANCEL_WINDOW")
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		assert.NoError(t, validConfig().Validate())
+	})
+
+	for name, key := range map[string]string{
+		"too short":        "changeme",
+		"single character": "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"blank":            "   ",
+	} {
+		t.Run("weak secret key: "+name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.Auth.SecretKey = key
+
+			assert.ErrorIs(t, cfg.Validate(), utils.ErrWeakSecretKey)
+		})
+	}
+
+	t.Run("missing secret key", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Auth.SecretKey = ""
+
+		assert.ErrorIs(t, cfg.Validate(), utils.ErrInvalidConfig)
+	})
+
+	t.Run("missing database settings", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Database.Host = ""
+		cfg.Database.Port = "db"
+
+		err := cfg.Validate()
+		assert.ErrorIs(t, err, utils.ErrInvalidConfig)
+		assert.ErrorContains(t, err, "DB_HOST")
+		assert.ErrorContains(t, err, "database.port")
+	})
+
+	t.Run("database URL replaces the other settings", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Database.Host = ""
+		cfg.Database.URL = "postgres://user:password@db:5432/bookstore?sslmode=disable"
+
+		assert.NoError(t, cfg.Validate())
+		assert.Equal(t, cfg.Database.URL, cfg.Database.DSN())
+	})
+
+	t.Run("non positive timeouts", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.HTTP.ShutdownTimeout = 0
+		cfg.Idempotency.KeyTTL = -time.Second
+		cfg.Orders.CancelWindow = 0
+		cfg.Auth.TokenCleanupInterval = 0
+
+		err := cfg.Validate()
+		assert.ErrorContains(t, err, "auth.tokenCleanupInterval")
+		assert.ErrorContains(t, err, "http.shutdownTimeout")
+		assert.ErrorContains(t, err, "idempotency.keyTTL")
+		assert.ErrorContains(t, err, "orders.cancelWindow")
+	})
+
+	t.Run("unknown log level", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Log.Level = "verbose"
+
+		err := cfg.Validate()
+		assert.ErrorIs(t, err, utils.ErrInvalidConfig)
+		assert.ErrorContains(t, err, "LOG_LEVEL")
+	})
+
+	t.Run("invalid tracing settings", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Tracing.SampleRatio = 2
+
+		err := cfg.Validate()
+		assert.ErrorIs(t, err, utils.ErrInvalidConfig)
+		assert.ErrorContains(t, err, "tracing.sampleRatio")
+	})
+}
+
+func TestDatabaseConfig_DSN(t *testing.T) {
+	cfg := validConfig()
+	cfg.Database.Password = "secret"
+
+	assert.Equal(
+		t,
+		"host=db user=user password=secret dbname=bookstore port=5432 sslmode=disable",
+		cfg.Database.DSN(),
+	)
+}
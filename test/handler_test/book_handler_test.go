@@ -2,15 +2,19 @@ package handler_test
 
 import (
 	"bookstore/internal/handler"
+	"bookstore/internal/middleware"
 	"bookstore/internal/model"
+	"bookstore/pkg/money"
 	"bookstore/pkg/utils"
 	"bookstore/test/mocks"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/mock/gomock"
@@ -30,12 +34,17 @@ func TestBookHandler_GetBooks(t *testing.T) {
 	router.GET("/books", h.GetBooks)
 
 	t.Run("success", func(t *testing.T) {
-		mockBooks := []model.Book{
-			{ID: 1, Title: "Book 1", Author: "Author 1"},
-			{ID: 2, Title: "Book 2", Author: "Author 2"},
+		mockPage := model.BookPage{
+			Books: []model.Book{
+				{ID: 1, Title: "Book 1", Author: "Author 1", Price: money.New(999, money.USD)},
+				{ID: 2, Title: "Book 2", Author: "Author 2", Price: money.New(1299, money.USD)},
+			},
+			Total:      3,
+			Limit:      2,
+			NextCursor: "next",
 		}
 
-		mockBookService.EXPECT().GetBooks().Return(mockBooks, nil)
+		mockBookService.EXPECT().GetBooks(gomock.Any(), model.BookQuery{}).Return(&mockPage, nil)
 
 		req, _ := http.NewRequest(http.MethodGet, "/books", nil)
 		w := httptest.NewRecorder()
@@ -43,14 +52,85 @@ func TestBookHandler_GetBooks(t *testing.T) {
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
-		var books []model.Book
-		err := json.Unmarshal(w.Body.Bytes(), &books)
+		var page model.BookPage
+		err := json.Unmarshal(w.Body.Bytes(), &page)
 		assert.NoError(t, err)
-		assert.Equal(t, mockBooks, books)
+		assert.Equal(t, mockPage, page)
+	})
+
+	t.Run("query parameters", func(t *testing.T) {
+		minPrice := money.New(500, money.USD)
+		maxPrice := money.New(1050, money.USD)
+
+		mockBookService.EXPECT().GetBooks(gomock.Any(), model.BookQuery{
+			Title:    "peace",
+			Author:   "tolstoy",
+			MinPrice: &minPrice,
+			MaxPrice: &maxPrice,
+			SortBy:   model.BookSortPrice,
+			SortDir:  model.SortDesc,
+			Limit:    5,
+			Offset:   10,
+		}).Return(&model.BookPage{Books: []model.Book{}}, nil)
+
+		req, _ := http.NewRequest(
+			http.MethodGet,
+			"/books?title=peace&author=tolstoy&minPrice=5&maxPrice=10.50&sort=price&order=desc&limit=5&offset=10",
+			nil,
+		)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("invalid sort", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/books?sort=isbn", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid price", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/books?minPrice=cheap", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid query from service", func(t *testing.T) {
+		mockBookService.EXPECT().
+			GetBooks(gomock.Any(), model.BookQuery{Limit: 1000}).
+			Return(nil, utils.ErrInvalidBookQuery)
+
+		req, _ := http.NewRequest(http.MethodGet, "/books?limit=1000", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		mockBookService.EXPECT().
+			GetBooks(gomock.Any(), model.BookQuery{Cursor: "bogus"}).
+			Return(nil, utils.ErrInvalidCursor)
+
+		req, _ := http.NewRequest(http.MethodGet, "/books?cursor=bogus", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 
 	t.Run("error", func(t *testing.T) {
-		mockBookService.EXPECT().GetBooks().Return(nil, errors.New("failed to retrieve books"))
+		mockBookService.EXPECT().GetBooks(gomock.Any(), gomock.Any()).Return(nil, errors.New("failed to retrieve books"))
 
 		req, _ := http.NewRequest(http.MethodGet, "/books", nil)
 		w := httptest.NewRecorder()
@@ -74,8 +154,8 @@ func TestBookHandler_GetBookById(t *testing.T) {
 	router.GET("/books/:id", h.GetBookById)
 
 	t.Run("success", func(t *testing.T) {
-		mockBook := model.Book{ID: 1, Title: "Book 1", Author: "Author 1", Price: 1.19}
-		mockBookService.EXPECT().GetBookById(1).Return(&mockBook, nil)
+		mockBook := model.Book{ID: 1, Title: "Book 1", Author: "Author 1", Price: money.New(119, money.USD)}
+		mockBookService.EXPECT().GetBookById(gomock.Any(), 1).Return(&mockBook, nil)
 
 		req, _ := http.NewRequest(http.MethodGet, "/books/1", nil)
 		w := httptest.NewRecorder()
@@ -100,7 +180,7 @@ func TestBookHandler_GetBookById(t *testing.T) {
 
 	t.Run("book not found", func(t *testing.T) {
 		mockBookService.EXPECT().
-			GetBookById(1).
+			GetBookById(gomock.Any(), 1).
 			Return((*model.Book)(nil), utils.ErrBookNotFound)
 
 		req, _ := http.NewRequest(http.MethodGet, "/books/1", nil)
@@ -125,8 +205,8 @@ func TestBookHandler_CreateBook(t *testing.T) {
 	router.POST("/books", h.CreateBook)
 
 	t.Run("success", func(t *testing.T) {
-		newBook := model.Book{ID: int64(1), Title: "New Book", Author: "New Author", Price: 1.23}
-		mockBookService.EXPECT().CreateBook(&newBook).Return(nil)
+		newBook := model.Book{ID: int64(1), Title: "New Book", Author: "New Author", Price: money.New(123, money.USD)}
+		mockBookService.EXPECT().CreateBook(gomock.Any(), &newBook).Return(nil)
 
 		jsonBook, _ := json.Marshal(newBook)
 		req, _ := http.NewRequest(http.MethodPost, "/books", bytes.NewBuffer(jsonBook))
@@ -148,10 +228,26 @@ func TestBookHandler_CreateBook(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 
+	t.Run("price must be positive", func(t *testing.T) {
+		for _, price := range []money.Money{money.New(0, money.USD), money.New(-100, money.USD)} {
+			book := model.Book{Title: "New Book", Author: "New Author", Price: price}
+			mockBookService.EXPECT().CreateBook(gomock.Any(), &book).Return(utils.ErrInvalidBookPrice)
+
+			jsonBook, _ := json.Marshal(book)
+			req, _ := http.NewRequest(http.MethodPost, "/books", bytes.NewBuffer(jsonBook))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, price.String())
+		}
+	})
+
 	t.Run("service error", func(t *testing.T) {
-		newBook := model.Book{Title: "New Book", Author: "New Author"}
+		newBook := model.Book{Title: "New Book", Author: "New Author", Price: money.New(500, money.USD)}
 		mockBookService.EXPECT().
-			CreateBook(&newBook).
+			CreateBook(gomock.Any(), &newBook).
 			Return(utils.ErrBookNotFound)
 
 		jsonBook, _ := json.Marshal(newBook)
@@ -178,8 +274,13 @@ func TestBookHandler_UpdateBook(t *testing.T) {
 	router.PUT("/books", h.UpdateBook)
 
 	t.Run("success", func(t *testing.T) {
-		updatedBook := model.Book{ID: 1, Title: "Updated Book", Author: "Updated Author"}
-		mockBookService.EXPECT().UpdateBook(&updatedBook).Return(nil)
+		updatedBook := model.Book{
+			ID:     1,
+			Title:  "Updated Book",
+			Author: "Updated Author",
+			Price:  money.New(1099, money.USD),
+		}
+		mockBookService.EXPECT().UpdateBook(gomock.Any(), &updatedBook).Return(nil)
 
 		jsonBook, _ := json.Marshal(updatedBook)
 		req, _ := http.NewRequest(http.MethodPut, "/books", bytes.NewBuffer(jsonBook))
@@ -201,9 +302,44 @@ func TestBookHandler_UpdateBook(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 
+	t.Run("price must be positive", func(t *testing.T) {
+		for _, price := range []money.Money{money.New(0, money.USD), money.New(-100, money.USD)} {
+			book := model.Book{ID: 1, Title: "Updated Book", Author: "Updated Author", Price: price}
+			mockBookService.EXPECT().UpdateBook(gomock.Any(), &book).Return(utils.ErrInvalidBookPrice)
+
+			jsonBook, _ := json.Marshal(book)
+			req, _ := http.NewRequest(http.MethodPut, "/books", bytes.NewBuffer(jsonBook))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, price.String())
+		}
+	})
+
+	t.Run("service error", func(t *testing.T) {
+		updatedBook := model.Book{ID: 1, Title: "Updated Book", Author: "Updated Author", Price: money.New(1099, money.USD)}
+		mockBookService.EXPECT().UpdateBook(gomock.Any(), &updatedBook).Return(errors.New("db down"))
+
+		jsonBook, _ := json.Marshal(updatedBook)
+		req, _ := http.NewRequest(http.MethodPut, "/books", bytes.NewBuffer(jsonBook))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
 	t.Run("book not found", func(t *testing.T) {
-		updatedBook := model.Book{ID: 1, Title: "Updated Book", Author: "Updated Author"}
-		mockBookService.EXPECT().UpdateBook(&updatedBook).Return(utils.ErrBookNotFound)
+		updatedBook := model.Book{
+			ID:     1,
+			Title:  "Updated Book",
+			Author: "Updated Author",
+			Price:  money.New(1099, money.USD),
+		}
+		mockBookService.EXPECT().UpdateBook(gomock.Any(), &updatedBook).Return(utils.ErrBookNotFound)
 
 		jsonBook, _ := json.Marshal(updatedBook)
 		req, _ := http.NewRequest(http.MethodPut, "/books", bytes.NewBuffer(jsonBook))
@@ -215,3 +351,207 @@ func TestBookHandler_UpdateBook(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, w.Code)
 	})
 }
+
+func TestBookHandler_AdjustStock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBookService := mocks.NewMockBookService(ctrl)
+	h := handler.NewBookHandler(mockBookService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	router.POST("/books/:id/stock", h.AdjustStock)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("success", func(t *testing.T) {
+		mockBookService.EXPECT().
+			AdjustStock(gomock.Any(), &model.StockMovement{BookID: 1, Change: 10, Reason: model.StockReasonRestock}).
+			DoAndReturn(func(_ context.Context, movement *model.StockMovement) error {
+				movement.ID = 4
+				movement.Stock = 15
+				return nil
+			})
+
+		w := post("/books/1/stock", `{"change": 10, "reason": "restock"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var movement model.StockMovement
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &movement))
+		assert.Equal(t, int64(15), movement.Stock)
+	})
+
+	t.Run("unknown reason", func(t *testing.T) {
+		w := post("/books/1/stock", `{"change": -1, "reason": "sale"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("zero change", func(t *testing.T) {
+		w := post("/books/1/stock", `{"change": 0, "reason": "correction"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("book not found", func(t *testing.T) {
+		mockBookService.EXPECT().AdjustStock(gomock.Any(), gomock.Any()).Return(utils.ErrBookNotFound)
+
+		w := post("/books/9/stock", `{"change": 1, "reason": "restock"}`)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("insufficient stock", func(t *testing.T) {
+		mockBookService.EXPECT().AdjustStock(gomock.Any(), gomock.Any()).Return(utils.ErrInsufficientStock)
+
+		w := post("/books/1/stock", `{"change": -100, "reason": "lost"}`)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+}
+
+func TestBookHandler_ArchiveRestoreDelete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBookService := mocks.NewMockBookService(ctrl)
+	h := handler.NewBookHandler(mockBookService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	router.DELETE("/books/:id", h.ArchiveBook)
+	router.POST("/admin/books/:id/restore", h.RestoreBook)
+	router.DELETE("/admin/books/:id", h.DeleteBook)
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	archivedAt := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	t.Run("archive", func(t *testing.T) {
+		mockBookService.EXPECT().
+			ArchiveBook(gomock.Any(), 1).
+			Return(&model.Book{ID: 1, Title: "1984", ArchivedAt: &archivedAt}, nil)
+
+		w := send(http.MethodDelete, "/books/1")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var book model.Book
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &book))
+		assert.Equal(t, archivedAt, *book.ArchivedAt)
+	})
+
+	t.Run("archive unknown book", func(t *testing.T) {
+		mockBookService.EXPECT().ArchiveBook(gomock.Any(), 9).Return(nil, utils.ErrBookNotFound)
+
+		assert.Equal(t, http.StatusNotFound, send(http.MethodDelete, "/books/9").Code)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		mockBookService.EXPECT().RestoreBook(gomock.Any(), 1).Return(&model.Book{ID: 1, Title: "1984"}, nil)
+
+		w := send(http.MethodPost, "/admin/books/1/restore")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "archivedAt")
+	})
+
+	t.Run("delete unused book", func(t *testing.T) {
+		mockBookService.EXPECT().DeleteBook(gomock.Any(), 1).Return(nil)
+
+		assert.Equal(t, http.StatusNoContent, send(http.MethodDelete, "/admin/books/1").Code)
+	})
+
+	t.Run("delete book in orders", func(t *testing.T) {
+		mockBookService.EXPECT().DeleteBook(gomock.Any(), 1).Return(utils.ErrBookReferenced)
+
+		assert.Equal(t, http.StatusConflict, send(http.MethodDelete, "/admin/books/1").Code)
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, send(http.MethodDelete, "/admin/books/abc").Code)
+	})
+}
+
+func TestBookHandler_CatalogRequiresStaff(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBookService := mocks.NewMockBookService(ctrl)
+	h := handler.NewBookHandler(mockBookService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	catalog := router.Group(
+		"/books",
+		middleware.AuthMiddleware(testSigner, noRevocations(ctrl)),
+		middleware.RequireRole(model.RoleStaff, model.RoleAdmin),
+	)
+	catalog.POST("/create", h.CreateBook)
+	catalog.POST("/update", h.UpdateBook)
+
+	newBook := model.Book{Title: "New Book", Author: "New Author", Price: money.New(500, money.USD)}
+	jsonBook, _ := json.Marshal(newBook)
+
+	send := func(path, role string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(jsonBook))
+		req.Header.Set("Content-Type", "application/json")
+		if role != "" {
+			token, _ := testSigner.GenerateToken(1, "staff@example.com", model.Role(role))
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("no token is unauthorized", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send("/books/create", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, send("/books/update", "").Code)
+	})
+
+	t.Run("customer is forbidden", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send("/books/create", string(model.RoleCustomer)).Code)
+		assert.Equal(t, http.StatusForbidden, send("/books/update", string(model.RoleCustomer)).Code)
+	})
+
+	t.Run("staff can create", func(t *testing.T) {
+		mockBookService.EXPECT().CreateBook(gomock.Any(), &newBook).Return(nil)
+
+		assert.Equal(t, http.StatusCreated, send("/books/create", string(model.RoleStaff)).Code)
+	})
+
+	t.Run("admin can update", func(t *testing.T) {
+		mockBookService.EXPECT().UpdateBook(gomock.Any(), &newBook).Return(nil)
+
+		assert.Equal(t, http.StatusOK, send("/books/update", string(model.RoleAdmin)).Code)
+	})
+}
+
+func TestRequireRole_WithoutAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/admin", middleware.RequireRole(model.RoleAdmin), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/admin", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -3,6 +3,7 @@ package handler_test
 import (
 	"bookstore/internal/handler"
 	"bookstore/internal/handler/request"
+	"bookstore/internal/middleware"
 	"bookstore/internal/model"
 	"bookstore/pkg/utils"
 	"bookstore/test/mocks"
@@ -11,7 +12,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -31,8 +34,8 @@ func TestCustomerHandler_Login(t *testing.T) {
 		token := "testToken"
 
 		mockCustomerService.EXPECT().
-			Login("test@example.com", "password").
-			Return(token, nil)
+			Login(gomock.Any(), "test@example.com", "password").
+			Return(&model.TokenPair{AccessToken: token, RefreshToken: "refresh", ExpiresIn: 900}, nil)
 
 		loginRequest := request.LoginRequest{Email: "test@example.com", Password: "password"}
 		jsonReq, _ := json.Marshal(loginRequest)
@@ -50,13 +53,14 @@ func TestCustomerHandler_Login(t *testing.T) {
 
 		assert.Equal(t, "Login successful", actualResponse["message"])
 		assert.Equal(t, token, actualResponse["token"])
+		assert.Equal(t, "refresh", actualResponse["refreshToken"])
 
 	})
 
 	t.Run("invalid email or password", func(t *testing.T) {
 		mockCustomerService.EXPECT().
-			Login("wrong@example.com", "wrongpassword").
-			Return("", utils.ErrWrongPassword)
+			Login(gomock.Any(), "wrong@example.com", "wrongpassword").
+			Return(nil, utils.ErrWrongPassword)
 
 		// Prepare request with incorrect credentials
 		loginRequest := request.LoginRequest{Email: "wrong@example.com", Password: "wrongpassword"}
@@ -89,7 +93,7 @@ func TestCustomerHandler_Register(t *testing.T) {
 			Password: "hashedpassword",
 		}
 
-		mockCustomerService.EXPECT().Register(gomock.Any()).Return(nil)
+		mockCustomerService.EXPECT().Register(gomock.Any(), gomock.Any()).Return(nil)
 
 		jsonReq, _ := json.Marshal(newCustomer)
 		req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(jsonReq))
@@ -120,3 +124,291 @@ func TestCustomerHandler_Register(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 }
+
+func TestCustomerHandler_UpdateRole(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCustomerService := mocks.NewMockCustomerService(ctrl)
+	router := gin.Default()
+
+	customerHandler := handler.NewCustomerHandler(mockCustomerService)
+	router.POST(
+		"/admin/customers/:id/role",
+		middleware.AuthMiddleware(testSigner, noRevocations(ctrl)),
+		middleware.RequireRole(model.RoleAdmin),
+		customerHandler.UpdateRole,
+	)
+
+	send := func(role model.Role, body string) *httptest.ResponseRecorder {
+		token, _ := testSigner.GenerateToken(1, "admin@example.com", role)
+		req, _ := http.NewRequest(http.MethodPost, "/admin/customers/2/role", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("admin promotes staff", func(t *testing.T) {
+		mockCustomerService.EXPECT().UpdateRole(gomock.Any(), 2, model.RoleStaff).Return(nil)
+
+		w := send(model.RoleAdmin, `{"role": "staff"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("staff is forbidden", func(t *testing.T) {
+		w := send(model.RoleStaff, `{"role": "admin"}`)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("unknown role", func(t *testing.T) {
+		w := send(model.RoleAdmin, `{"role": "root"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("customer not found", func(t *testing.T) {
+		mockCustomerService.EXPECT().UpdateRole(gomock.Any(), 2, model.RoleStaff).Return(utils.ErrCustomerNotFound)
+
+		w := send(model.RoleAdmin, `{"role": "staff"}`)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+// noRevocations is a revocation list that never revokes anything.
+func noRevocations(ctrl *gomock.Controller) middleware.RevocationList {
+	revocations := mocks.NewMockTokenRepository(ctrl)
+	revocations.EXPECT().IsTokenRevoked(gomock.Any(), gomock.Any()).Return(false, nil).AnyTimes()
+	return revocations
+}
+
+func TestCustomerHandler_RefreshToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCustomerService := mocks.NewMockCustomerService(ctrl)
+	router := gin.Default()
+
+	customerHandler := handler.NewCustomerHandler(mockCustomerService)
+	router.POST("/token/refresh", customerHandler.RefreshToken)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/token/refresh", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("success", func(t *testing.T) {
+		mockCustomerService.EXPECT().
+			RefreshToken(gomock.Any(), "old").
+			Return(&model.TokenPair{AccessToken: "access", RefreshToken: "new", ExpiresIn: 900}, nil)
+
+		w := send(`{"refreshToken": "old"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"token": "access", "refreshToken": "new", "expiresIn": 900}`, w.Body.String())
+	})
+
+	t.Run("reused token", func(t *testing.T) {
+		mockCustomerService.EXPECT().
+			RefreshToken(gomock.Any(), "old").
+			Return(nil, utils.ErrRefreshTokenReused)
+
+		w := send(`{"refreshToken": "old"}`)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		mockCustomerService.EXPECT().
+			RefreshToken(gomock.Any(), "old").
+			Return(nil, utils.ErrRefreshTokenExpired)
+
+		w := send(`{"refreshToken": "old"}`)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		w := send(`{}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestCustomerHandler_Logout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCustomerService := mocks.NewMockCustomerService(ctrl)
+	revocations := mocks.NewMockTokenRepository(ctrl)
+	router := gin.Default()
+
+	customerHandler := handler.NewCustomerHandler(mockCustomerService)
+	router.POST("/logout", middleware.AuthMiddleware(testSigner, revocations), customerHandler.Logout)
+
+	token, _ := testSigner.GenerateToken(1, "test@example.com", model.RoleCustomer)
+	claims := &utils.Claims{}
+	_, _ = jwt.ParseWithClaims(token, claims, testSigner.SigningKey)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/logout", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("revokes the current token", func(t *testing.T) {
+		revocations.EXPECT().IsTokenRevoked(gomock.Any(), claims.Id).Return(false, nil)
+		mockCustomerService.EXPECT().
+			Logout(gomock.Any(), 1, claims.Id, time.Unix(claims.ExpiresAt, 0), "refresh").
+			Return(nil)
+
+		w := send(`{"refreshToken": "refresh"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("revoked token is rejected", func(t *testing.T) {
+		revocations.EXPECT().IsTokenRevoked(gomock.Any(), claims.Id).Return(true, nil)
+
+		w := send(``)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestCustomerHandler_Profile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCustomerService := mocks.NewMockCustomerService(ctrl)
+	router := gin.Default()
+
+	customerHandler := handler.NewCustomerHandler(mockCustomerService)
+	router.POST("/email/verify", customerHandler.VerifyEmail)
+	me := router.Group("/me", middleware.AuthMiddleware(testSigner, noRevocations(ctrl)))
+	me.GET("", customerHandler.GetProfile)
+	me.PATCH("", customerHandler.UpdateProfile)
+	me.POST("/password", customerHandler.ChangePassword)
+	me.POST("/email", customerHandler.ChangeEmail)
+
+	token, _ := testSigner.GenerateToken(1, "test@example.com", model.RoleCustomer)
+	profile := &model.CustomerProfile{ID: 1, Email: "test@example.com", Name: "Jane", Address: "123 Street", Role: model.RoleCustomer}
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("profile has no password", func(t *testing.T) {
+		mockCustomerService.EXPECT().GetProfile(gomock.Any(), 1).Return(profile, nil)
+
+		w := send(http.MethodGet, "/me", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "password")
+		var got model.CustomerProfile
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, *profile, got)
+	})
+
+	t.Run("update the address", func(t *testing.T) {
+		mockCustomerService.EXPECT().
+			UpdateProfile(gomock.Any(), 1, gomock.Any()).
+			DoAndReturn(func(_ interface{}, _ int, request request.UpdateProfileRequest) (*model.CustomerProfile, error) {
+				assert.Nil(t, request.Name)
+				assert.Equal(t, "Elm Street 1", *request.Address)
+				return profile, nil
+			})
+
+		w := send(http.MethodPatch, "/me", `{"address": "Elm Street 1"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("empty name is rejected", func(t *testing.T) {
+		w := send(http.MethodPatch, "/me", `{"name": ""}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("nothing to update", func(t *testing.T) {
+		mockCustomerService.EXPECT().UpdateProfile(gomock.Any(), 1, gomock.Any()).Return(nil, utils.ErrNothingToUpdate)
+
+		w := send(http.MethodPatch, "/me", `{}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("change password", func(t *testing.T) {
+		mockCustomerService.EXPECT().
+			ChangePassword(gomock.Any(), 1, request.ChangePasswordRequest{CurrentPassword: "old-password", NewPassword: "new-password"}).
+			Return(nil)
+
+		w := send(http.MethodPost, "/me/password", `{"currentPassword": "old-password", "newPassword": "new-password"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("wrong current password", func(t *testing.T) {
+		mockCustomerService.EXPECT().ChangePassword(gomock.Any(), 1, gomock.Any()).Return(utils.ErrWrongPassword)
+
+		w := send(http.MethodPost, "/me/password", `{"currentPassword": "guess", "newPassword": "new-password"}`)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("short new password", func(t *testing.T) {
+		w := send(http.MethodPost, "/me/password", `{"currentPassword": "old-password", "newPassword": "short"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("change email waits for verification", func(t *testing.T) {
+		mockCustomerService.EXPECT().
+			ChangeEmail(gomock.Any(), 1, request.ChangeEmailRequest{Email: "New@example.com", Password: "password"}).
+			Return(nil)
+
+		w := send(http.MethodPost, "/me/email", `{"email": "New@example.com", "password": "password"}`)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Contains(t, w.Body.String(), `"pendingEmail":"new@example.com"`)
+	})
+
+	t.Run("email already registered", func(t *testing.T) {
+		mockCustomerService.EXPECT().ChangeEmail(gomock.Any(), 1, gomock.Any()).Return(utils.ErrDuplicateEmail)
+
+		w := send(http.MethodPost, "/me/email", `{"email": "taken@example.com", "password": "password"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("verify email", func(t *testing.T) {
+		mockCustomerService.EXPECT().VerifyEmail(gomock.Any(), "token").Return(profile, nil)
+
+		w := send(http.MethodPost, "/email/verify", `{"token": "token"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("expired verification", func(t *testing.T) {
+		mockCustomerService.EXPECT().VerifyEmail(gomock.Any(), "old").Return(nil, utils.ErrVerificationTokenExpired)
+
+		w := send(http.MethodPost, "/email/verify", `{"token": "old"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
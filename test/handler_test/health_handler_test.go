@@ -0,0 +1,109 @@
+package handler_test
+
+import (
+	"bookstore/internal/handler"
+	"bookstore/internal/health"
+	"bookstore/internal/middleware"
+	"bookstore/internal/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var dbErr error
+	checker := health.NewChecker(time.Second)
+	checker.Add("postgres", func(ctx context.Context) error { return dbErr })
+
+	router := gin.Default()
+	healthHandler := handler.NewHealthHandler(checker)
+	router.GET("/healthz", healthHandler.Liveness)
+	router.GET("/readyz", healthHandler.Readiness)
+	router.GET(
+		"/debug/health",
+		middleware.AuthMiddleware(testSigner, noRevocations(ctrl)),
+		middleware.RequireRole(model.RoleAdmin),
+		healthHandler.Report,
+	)
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	status := func(w *httptest.ResponseRecorder) string {
+		var body struct {
+			Status string `json:"status"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body.Status
+	}
+
+	adminToken, _ := testSigner.GenerateToken(1, "admin@example.com", model.RoleAdmin)
+	customerToken, _ := testSigner.GenerateToken(2, "test@example.com", model.RoleCustomer)
+
+	t.Run("ready when every check is up", func(t *testing.T) {
+		dbErr = nil
+
+		w := get("/readyz", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, health.StatusUp, status(w))
+	})
+
+	t.Run("not ready when the database is down", func(t *testing.T) {
+		dbErr = errors.New("connection refused")
+
+		w := get("/readyz", "")
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, health.StatusDown, status(w))
+		assert.NotContains(t, w.Body.String(), "connection refused")
+
+		// the process itself is still alive
+		assert.Equal(t, http.StatusOK, get("/healthz", "").Code)
+	})
+
+	t.Run("detailed report for admins", func(t *testing.T) {
+		dbErr = errors.New("connection refused")
+
+		w := get("/debug/health", adminToken)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		var report health.Report
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.Equal(t, health.StatusDown, report.Status)
+		assert.Equal(t, "connection refused", report.Checks[0].Error)
+	})
+
+	t.Run("detailed report needs the admin role", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, get("/debug/health", "").Code)
+		assert.Equal(t, http.StatusForbidden, get("/debug/health", customerToken).Code)
+	})
+
+	t.Run("not ready while shutting down", func(t *testing.T) {
+		dbErr = nil
+		checker.Drain()
+
+		w := get("/readyz", "")
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, health.StatusShuttingDown, status(w))
+		assert.Equal(t, http.StatusOK, get("/healthz", "").Code)
+	})
+}
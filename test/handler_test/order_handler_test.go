@@ -5,13 +5,19 @@ import (
 	"bookstore/internal/handler/request"
 	"bookstore/internal/middleware"
 	"bookstore/internal/model"
+	"bookstore/internal/payment"
+	"bookstore/internal/service"
+	"bookstore/pkg/money"
 	"bookstore/pkg/utils"
 	"bookstore/test/mocks"
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/mock/gomock"
@@ -25,27 +31,160 @@ func TestOrderHandler_PayOrder(t *testing.T) {
 	mockOrderService := mocks.NewMockOrderService(ctrl)
 	router := gin.Default()
 
-	router.Use(middleware.AuthMiddleware())
+	router.Use(middleware.AuthMiddleware(testSigner, noRevocations(ctrl)))
 	orderHandler := handler.NewOrderHandler(mockOrderService)
 	router.POST("/pay", orderHandler.PayOrder)
 
-	t.Run("success", func(t *testing.T) {
-		customerID := int64(1)
-		mockOrderService.EXPECT().PayOrder(int(customerID)).Return(nil)
+	customerID := int64(1)
+	card := payment.Card{Number: payment.FakeCardSuccess}
+	token, _ := testSigner.GenerateToken(customerID, "test@example.com", model.RoleCustomer)
 
-		token, _ := utils.GenerateToken(customerID, "test@example.com")
-		req, _ := http.NewRequest(http.MethodPost, "/pay", nil)
+	send := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/pay", bytes.NewBufferString(body))
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
-
 		router.ServeHTTP(w, req)
+		return w
+	}
+	payBody := `{"cardNumber": "` + payment.FakeCardSuccess + `"}`
+
+	t.Run("success", func(t *testing.T) {
+		mockOrderService.EXPECT().
+			PayOrder(gomock.Any(), int(customerID), card).
+			Return(&model.PaidOrder{
+				Order: &model.OrderResponse{
+					ID:    7,
+					State: model.OrderStatePaid,
+					Total: money.New(2500, money.USD),
+					OrderDetail: []model.OrderDetailResponse{
+						{ID: 1, Book: []model.Book{{ID: 2, Title: "Moby Dick"}}, Quantity: 1, Subtotal: money.New(2500, money.USD)},
+					},
+				},
+				Payment: &model.Payment{
+					ID:          3,
+					OrderID:     7,
+					Provider:    payment.FakeProviderName,
+					ProviderRef: "fake_000001",
+					Amount:      money.New(2500, money.USD),
+					Status:      model.PaymentStatusCaptured,
+				},
+			}, nil)
+
+		w := send(payBody)
 
 		assert.Equal(t, http.StatusOK, w.Code)
 
-		var actualResponse gin.H
+		var actualResponse struct {
+			Message string              `json:"message"`
+			Order   model.OrderResponse `json:"order"`
+			Payment model.Payment       `json:"payment"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &actualResponse)
+		assert.NoError(t, err)
+		assert.Equal(t, "Order paid successfully", actualResponse.Message)
+		assert.Equal(t, model.PaymentStatusCaptured, actualResponse.Payment.Status)
+		assert.Equal(t, int64(7), actualResponse.Payment.OrderID)
+		assert.Equal(t, int64(7), actualResponse.Order.ID)
+		assert.Equal(t, model.OrderStatePaid, actualResponse.Order.State)
+		assert.Equal(t, money.New(2500, money.USD), actualResponse.Order.Total)
+		assert.Len(t, actualResponse.Order.OrderDetail, 1)
+	})
+
+	t.Run("missing card", func(t *testing.T) {
+		w := send(`{}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("out of stock", func(t *testing.T) {
+		items := []utils.OutOfStockItem{
+			{BookID: 2, Title: "Moby Dick", Requested: 3, Available: 1},
+		}
+		mockOrderService.EXPECT().
+			PayOrder(gomock.Any(), 1, card).
+			Return(nil, &utils.OutOfStockError{Items: items})
+
+		w := send(payBody)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		var actualResponse struct {
+			Message string                 `json:"message"`
+			Details []utils.OutOfStockItem `json:"details"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &actualResponse)
+		assert.NoError(t, err)
+		assert.Equal(t, items, actualResponse.Details)
+	})
+
+	t.Run("prices changed", func(t *testing.T) {
+		items := []utils.PriceChangedItem{
+			{BookID: 1, Title: "1984", OldPrice: money.New(1000, money.USD), NewPrice: money.New(1200, money.USD)},
+		}
+		mockOrderService.EXPECT().
+			PayOrder(gomock.Any(), 1, card).
+			Return(nil, &utils.PriceChangedError{Items: items})
+
+		w := send(payBody)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		var actualResponse struct {
+			Details []utils.PriceChangedItem `json:"details"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &actualResponse)
+		assert.NoError(t, err)
+		assert.Equal(t, items, actualResponse.Details)
+	})
+
+	t.Run("unavailable books", func(t *testing.T) {
+		items := []utils.UnavailableItem{{BookID: 4, Title: "Withdrawn"}}
+		mockOrderService.EXPECT().
+			PayOrder(gomock.Any(), 1, card).
+			Return(nil, &utils.UnavailableItemsError{Items: items})
+
+		w := send(payBody)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		var actualResponse struct {
+			Details []utils.UnavailableItem `json:"details"`
+		}
 		err := json.Unmarshal(w.Body.Bytes(), &actualResponse)
 		assert.NoError(t, err)
-		assert.Equal(t, "Order paid successfully", actualResponse["message"])
+		assert.Equal(t, items, actualResponse.Details)
+	})
+
+	t.Run("cart errors", func(t *testing.T) {
+		for err, status := range map[error]int{
+			utils.WarnCartEmpty:      http.StatusBadRequest,
+			utils.ErrOrderNotPayable: http.StatusUnprocessableEntity,
+		} {
+			mockOrderService.EXPECT().
+				PayOrder(gomock.Any(), 1, card).
+				Return(nil, err)
+
+			w := send(payBody)
+
+			assert.Equal(t, status, w.Code, err.Error())
+		}
+	})
+
+	t.Run("payment errors", func(t *testing.T) {
+		for err, status := range map[error]int{
+			fmt.Errorf("%w: insufficient funds", utils.ErrPaymentDeclined): http.StatusPaymentRequired,
+			utils.ErrPaymentTimeout: http.StatusGatewayTimeout,
+			utils.ErrPaymentFailed:  http.StatusBadGateway,
+		} {
+			mockOrderService.EXPECT().
+				PayOrder(gomock.Any(), 1, card).
+				Return(nil, err)
+
+			w := send(payBody)
+
+			assert.Equal(t, status, w.Code, err.Error())
+		}
 	})
 
 	t.Run("unauthorized", func(t *testing.T) {
@@ -65,7 +204,7 @@ func TestOrderHandler_GetCart(t *testing.T) {
 	mockOrderService := mocks.NewMockOrderService(ctrl)
 	router := gin.Default()
 
-	router.Use(middleware.AuthMiddleware())
+	router.Use(middleware.AuthMiddleware(testSigner, noRevocations(ctrl)))
 	orderHandler := handler.NewOrderHandler(mockOrderService)
 	router.GET("/cart", orderHandler.GetCart)
 
@@ -74,8 +213,8 @@ func TestOrderHandler_GetCart(t *testing.T) {
 		orderID := int64(1)
 
 		books := []model.Book{
-			{ID: 1, Title: "Book 1", Author: "Author 1", Price: 10.0},
-			{ID: 2, Title: "Book 2", Author: "Author 2", Price: 15.0},
+			{ID: 1, Title: "Book 1", Author: "Author 1", Price: money.New(1000, money.USD)},
+			{ID: 2, Title: "Book 2", Author: "Author 2", Price: money.New(1500, money.USD)},
 		}
 
 		orderDetails := []model.OrderDetailResponse{
@@ -83,21 +222,22 @@ func TestOrderHandler_GetCart(t *testing.T) {
 				ID:       1,
 				Book:     books,
 				Quantity: 2,
-				Subtotal: 25.0,
+				Subtotal: money.New(2500, money.USD),
 			},
 		}
 
 		expectedResponse := model.OrderResponse{
 			ID:          orderID,
+			State:       model.OrderStateCart,
 			OrderDetail: orderDetails,
-			Total:       25.0,
+			Total:       money.New(2500, money.USD),
 		}
 
 		mockOrderService.EXPECT().
-			GetCart(int(customerID)).
+			GetCart(gomock.Any(), int(customerID)).
 			Return(&expectedResponse, nil)
 
-		token, err := utils.GenerateToken(customerID, "test@example.com")
+		token, err := testSigner.GenerateToken(customerID, "test@example.com", model.RoleCustomer)
 		assert.NoError(t, err)
 
 		req, _ := http.NewRequest(http.MethodGet, "/cart", nil)
@@ -125,71 +265,179 @@ func TestOrderHandler_GetCart(t *testing.T) {
 	})
 }
 
-func TestOrderHandler_GetOrderHistory(t *testing.T) {
+func TestOrderHandler_RepriceCart(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mockOrderService := mocks.NewMockOrderService(ctrl)
 	router := gin.Default()
 
-	router.Use(middleware.AuthMiddleware())
+	router.Use(middleware.AuthMiddleware(testSigner, noRevocations(ctrl)))
 	orderHandler := handler.NewOrderHandler(mockOrderService)
-	router.POST("/history", orderHandler.GetOrderHistory)
+	router.POST("/cart/reprice", orderHandler.RepriceCart)
 
-	page, limit := 0, 10
+	customerID := int64(1)
+	token, _ := testSigner.GenerateToken(customerID, "test@example.com", model.RoleCustomer)
+
+	send := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/cart/reprice", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
 
 	t.Run("success", func(t *testing.T) {
-		customerID := int64(1)
+		repriced := model.OrderResponse{
+			ID:    7,
+			State: model.OrderStateCart,
+			OrderDetail: []model.OrderDetailResponse{
+				{ID: 1, Book: []model.Book{}, Quantity: 2, Subtotal: money.New(2400, money.USD)},
+			},
+			Total: money.New(2400, money.USD),
+		}
+		mockOrderService.EXPECT().
+			RepriceCart(gomock.Any(), int(customerID)).
+			Return(&repriced, nil)
+
+		w := send()
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var actualResponse model.OrderResponse
+		err := json.Unmarshal(w.Body.Bytes(), &actualResponse)
+		assert.NoError(t, err)
+		assert.Equal(t, repriced, actualResponse)
+	})
+
+	t.Run("empty cart", func(t *testing.T) {
+		mockOrderService.EXPECT().
+			RepriceCart(gomock.Any(), int(customerID)).
+			Return(nil, utils.WarnCartEmpty)
 
+		w := send()
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("unexpected error", func(t *testing.T) {
+		mockOrderService.EXPECT().
+			RepriceCart(gomock.Any(), int(customerID)).
+			Return(nil, errors.New("db down"))
+
+		w := send()
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestOrderHandler_GetOrderHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockOrderService := mocks.NewMockOrderService(ctrl)
+	router := gin.Default()
+
+	router.Use(middleware.AuthMiddleware(testSigner, noRevocations(ctrl)))
+	orderHandler := handler.NewOrderHandler(mockOrderService)
+	router.GET("/history", orderHandler.GetOrderHistory)
+
+	customerID := int64(1)
+	token, _ := testSigner.GenerateToken(customerID, "test@example.com", model.RoleCustomer)
+
+	send := func(url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("success", func(t *testing.T) {
 		books := []model.Book{
-			{ID: 1, Title: "Book 1", Author: "Author 1", Price: 10.0},
-			{ID: 2, Title: "Book 2", Author: "Author 2", Price: 15.0},
+			{ID: 1, Title: "Book 1", Author: "Author 1", Price: money.New(1000, money.USD)},
+			{ID: 2, Title: "Book 2", Author: "Author 2", Price: money.New(1500, money.USD)},
 		}
 
-		request := request.HistoryRequest{Page: page, Limit: limit}
-		jsonReq, _ := json.Marshal(request)
-
 		orderDetails := []model.OrderDetailResponse{
 			{
 				ID:       1,
 				Book:     books,
 				Quantity: 2,
-				Subtotal: 25.0,
+				Subtotal: money.New(2500, money.USD),
 			},
 		}
 
-		expectedResponse := []model.OrderResponse{
-			{
-				ID:          1,
-				OrderDetail: orderDetails,
-				Total:       25.0,
+		expectedResponse := &model.OrderHistoryPage{
+			Orders: []model.OrderResponse{
+				{
+					ID:          1,
+					State:       model.OrderStatePaid,
+					UpdatedAt:   time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC),
+					OrderDetail: orderDetails,
+					Total:       money.New(2500, money.USD),
+				},
 			},
+			Total:      3,
+			Limit:      1,
+			NextCursor: "next",
 		}
 
 		mockOrderService.EXPECT().
-			GetOrderHistory(int(customerID), request).
+			GetOrderHistory(gomock.Any(), int(customerID), model.OrderHistoryQuery{Limit: 1}).
 			Return(expectedResponse, nil)
 
-		token, _ := utils.GenerateToken(customerID, "test@example.com")
-		req, _ := http.NewRequest(http.MethodPost, "/history", bytes.NewBuffer(jsonReq))
-		req.Header.Set("Authorization", "Bearer "+token)
-		w := httptest.NewRecorder()
-
-		router.ServeHTTP(w, req)
+		w := send("/history?limit=1")
 
 		assert.Equal(t, http.StatusOK, w.Code)
 
-		var actualResponse []model.OrderResponse
+		var actualResponse model.OrderHistoryPage
 		err := json.Unmarshal(w.Body.Bytes(), &actualResponse)
 		assert.NoError(t, err)
 
-		assert.Equal(t, expectedResponse, actualResponse)
+		assert.Equal(t, *expectedResponse, actualResponse)
+	})
+
+	t.Run("filters are parsed", func(t *testing.T) {
+		from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		to := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC) // the whole of May 31st is included
+		minTotal := money.New(1050, money.USD)
+		bookID := int64(3)
+
+		mockOrderService.EXPECT().
+			GetOrderHistory(gomock.Any(), int(customerID), model.OrderHistoryQuery{
+				From:     &from,
+				To:       &to,
+				States:   []model.OrderState{model.OrderStatePaid, model.OrderStateShipped},
+				MinTotal: &minTotal,
+				BookID:   &bookID,
+				Cursor:   "abc",
+			}).
+			Return(&model.OrderHistoryPage{Orders: []model.OrderResponse{}}, nil)
+
+		w := send("/history?from=2024-05-01&to=2024-05-31&state=paid,shipped&minTotal=10.50&bookId=3&cursor=abc")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("unknown state", func(t *testing.T) {
+		w := send("/history?state=lost")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		mockOrderService.EXPECT().
+			GetOrderHistory(gomock.Any(), int(customerID), gomock.Any()).
+			Return(nil, utils.ErrInvalidCursor)
+
+		w := send("/history?cursor=garbage")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 
 	t.Run("unauthorized", func(t *testing.T) {
-		request := request.HistoryRequest{Page: page, Limit: limit}
-		jsonReq, _ := json.Marshal(request)
-		req, _ := http.NewRequest(http.MethodPost, "/history", bytes.NewBuffer(jsonReq))
+		req, _ := http.NewRequest(http.MethodGet, "/history", nil)
 		w := httptest.NewRecorder()
 
 		router.ServeHTTP(w, req)
@@ -205,7 +453,7 @@ func TestOrderHandler_RemoveFromCart(t *testing.T) {
 	mockOrderService := mocks.NewMockOrderService(ctrl)
 	router := gin.Default()
 
-	router.Use(middleware.AuthMiddleware())
+	router.Use(middleware.AuthMiddleware(testSigner, noRevocations(ctrl)))
 	orderHandler := handler.NewOrderHandler(mockOrderService)
 	router.POST("/remove", orderHandler.RemoveFromCart)
 
@@ -213,9 +461,9 @@ func TestOrderHandler_RemoveFromCart(t *testing.T) {
 		customerID := int64(1)
 		request := request.RemoveItemFromCartRequest{BookId: 1}
 
-		mockOrderService.EXPECT().RemoveFromCart(int(customerID), int(request.BookId)).Return(nil)
+		mockOrderService.EXPECT().RemoveFromCart(gomock.Any(), int(customerID), int(request.BookId)).Return(nil)
 
-		token, err := utils.GenerateToken(customerID, "test@example.com")
+		token, err := testSigner.GenerateToken(customerID, "test@example.com", model.RoleCustomer)
 		assert.NoError(t, err)
 
 		jsonReq, _ := json.Marshal(request)
@@ -251,24 +499,21 @@ func TestOrderHandler_AddToCart(t *testing.T) {
 	mockOrderService := mocks.NewMockOrderService(ctrl)
 	router := gin.Default()
 
-	router.Use(middleware.AuthMiddleware())
+	router.Use(middleware.AuthMiddleware(testSigner, noRevocations(ctrl)))
 	orderHandler := handler.NewOrderHandler(mockOrderService)
 	router.POST("/add", orderHandler.AddToCart)
 
 	t.Run("success", func(t *testing.T) {
 		customerID := 1
 		orderID := 1
-		request := request.AddToCartRequest{BookId: 1, Quantity: 2, Price: 10}
+		request := request.AddToCartRequest{BookId: 1, Quantity: 2}
 
 		// since its converted to cent
 		mockOrderService.EXPECT().
-			AddToCart(orderID, request).
+			AddToCart(gomock.Any(), orderID, request).
 			Return(nil)
 
-		token, _ := utils.GenerateToken(
-			int64(customerID),
-			"test@example.com",
-		)
+		token, _ := testSigner.GenerateToken(int64(customerID), "test@example.com", model.RoleCustomer)
 		jsonReq, _ := json.Marshal(request)
 		req, _ := http.NewRequest(http.MethodPost, "/add", bytes.NewBuffer(jsonReq))
 		req.Header.Set("Content-Type", "application/json")
@@ -283,6 +528,48 @@ func TestOrderHandler_AddToCart(t *testing.T) {
 		err := json.Unmarshal(w.Body.Bytes(), &actualResponse)
 		assert.NoError(t, err)
 		assert.Equal(t, "Cart updated", actualResponse["message"])
+		assert.Empty(t, w.Header().Get("Deprecation"))
+	})
+
+	t.Run("deprecated price is accepted with warning", func(t *testing.T) {
+		price := 0.01
+		request := request.AddToCartRequest{BookId: 1, Quantity: 2, Price: &price}
+
+		mockOrderService.EXPECT().
+			AddToCart(gomock.Any(), 1, request).
+			Return(nil)
+
+		token, _ := testSigner.GenerateToken(1, "test@example.com", model.RoleCustomer)
+		jsonReq, _ := json.Marshal(request)
+		req, _ := http.NewRequest(http.MethodPost, "/add", bytes.NewBuffer(jsonReq))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "true", w.Header().Get("Deprecation"))
+		assert.Contains(t, w.Header().Get("Warning"), "price is deprecated")
+	})
+
+	t.Run("book unavailable", func(t *testing.T) {
+		request := request.AddToCartRequest{BookId: 99, Quantity: 1}
+
+		mockOrderService.EXPECT().
+			AddToCart(gomock.Any(), 1, request).
+			Return(utils.ErrBookUnavailable)
+
+		token, _ := testSigner.GenerateToken(1, "test@example.com", model.RoleCustomer)
+		jsonReq, _ := json.Marshal(request)
+		req, _ := http.NewRequest(http.MethodPost, "/add", bytes.NewBuffer(jsonReq))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
 	})
 
 	t.Run("unauthorized", func(t *testing.T) {
@@ -295,7 +582,7 @@ func TestOrderHandler_AddToCart(t *testing.T) {
 	})
 
 	t.Run("bad request", func(t *testing.T) {
-		token, _ := utils.GenerateToken(1, "test@example.com")
+		token, _ := testSigner.GenerateToken(1, "test@example.com", model.RoleCustomer)
 		req, _ := http.NewRequest(
 			http.MethodPost,
 			"/add",
@@ -310,3 +597,314 @@ func TestOrderHandler_AddToCart(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 }
+
+func TestOrderHandler_AdvanceOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockOrderService := mocks.NewMockOrderService(ctrl)
+	router := gin.Default()
+
+	router.Use(middleware.AuthMiddleware(testSigner, noRevocations(ctrl)))
+	orderHandler := handler.NewOrderHandler(mockOrderService)
+	router.POST("/admin/orders/:id/state", orderHandler.AdvanceOrder)
+
+	token, _ := testSigner.GenerateToken(2, "staff@example.com", model.RoleStaff)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/admin/orders/7/state", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("success", func(t *testing.T) {
+		mockOrderService.EXPECT().
+			AdvanceOrder(gomock.Any(), 7, model.OrderStateShipped, 2, "tracking 123").
+			Return(&model.OrderStateTransition{
+				OrderID: 7,
+				From:    model.OrderStateFulfilling,
+				To:      model.OrderStateShipped,
+			}, nil)
+
+		w := send(`{"state":"shipped","note":"tracking 123"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"from":"fulfilling"`)
+		assert.Contains(t, w.Body.String(), `"to":"shipped"`)
+	})
+
+	t.Run("illegal transition", func(t *testing.T) {
+		mockOrderService.EXPECT().
+			AdvanceOrder(gomock.Any(), 7, model.OrderStatePaid, 2, "").
+			Return(nil, &utils.InvalidTransitionError{
+				Current:   model.OrderStateDelivered,
+				Requested: model.OrderStatePaid,
+			})
+
+		w := send(`{"state":"paid"}`)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		var actualResponse struct {
+			Details struct {
+				Current   string `json:"current"`
+				Requested string `json:"requested"`
+			} `json:"details"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &actualResponse))
+		assert.Equal(t, "delivered", actualResponse.Details.Current)
+		assert.Equal(t, "paid", actualResponse.Details.Requested)
+	})
+
+	t.Run("unknown state", func(t *testing.T) {
+		w := send(`{"state":"lost_in_space"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("order not found", func(t *testing.T) {
+		mockOrderService.EXPECT().
+			AdvanceOrder(gomock.Any(), 7, model.OrderStateShipped, 2, "").
+			Return(nil, utils.ErrOrderNotFound)
+
+		w := send(`{"state":"shipped"}`)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+// TestOrderHandler_AdvanceOrder_RefusesRefunds runs the real service, so a raw
+// state change can never stand in for a refund or a cancellation.
+func TestOrderHandler_AdvanceOrder_RefusesRefunds(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	orderService := service.NewOrderService(mockRepo, nil, nil, nil, nil, cancelWindow)
+	router := gin.Default()
+
+	router.Use(middleware.AuthMiddleware(testSigner, noRevocations(ctrl)))
+	router.POST("/admin/orders/:id/state", handler.NewOrderHandler(orderService).AdvanceOrder)
+
+	token, _ := testSigner.GenerateToken(2, "admin@example.com", model.RoleAdmin)
+
+	for _, state := range []model.OrderState{model.OrderStateRefunded, model.OrderStateCancelled} {
+		t.Run(state.String(), func(t *testing.T) {
+			// no TransitionOrder is expected, the order is never touched
+			mockRepo.EXPECT().GetOrderState(gomock.Any(), 7).Return(model.OrderStatePaid, nil)
+
+			req, _ := http.NewRequest(http.MethodPost, "/admin/orders/7/state", bytes.NewBufferString(`{"state":"`+state.String()+`"}`))
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusConflict, w.Code)
+			assert.Contains(t, w.Body.String(), `"requested":"`+state.String()+`"`)
+		})
+	}
+}
+
+func TestOrderHandler_CancelPendingOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockOrderService := mocks.NewMockOrderService(ctrl)
+	router := gin.Default()
+
+	router.Use(middleware.AuthMiddleware(testSigner, noRevocations(ctrl)))
+	orderHandler := handler.NewOrderHandler(mockOrderService)
+	router.POST("/admin/orders/:id/cancel-pending", orderHandler.CancelPendingOrder)
+
+	token, _ := testSigner.GenerateToken(2, "admin@example.com", model.RoleAdmin)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/admin/orders/7/cancel-pending", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("success", func(t *testing.T) {
+		mockOrderService.EXPECT().
+			CancelPendingOrder(gomock.Any(), 7, 2, "refunded at the provider").
+			Return(&model.OrderStateTransition{
+				OrderID: 7,
+				From:    model.OrderStatePendingPayment,
+				To:      model.OrderStateCancelled,
+			}, nil)
+
+		w := send(`{"note":"refunded at the provider"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"from":"pending_payment"`)
+		assert.Contains(t, w.Body.String(), `"to":"cancelled"`)
+	})
+
+	t.Run("note is required", func(t *testing.T) {
+		w := send(`{}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("order not pending", func(t *testing.T) {
+		mockOrderService.EXPECT().
+			CancelPendingOrder(gomock.Any(), 7, 2, "stuck").
+			Return(nil, &utils.InvalidTransitionError{
+				Current:   model.OrderStatePaid,
+				Requested: model.OrderStateCancelled,
+			})
+
+		w := send(`{"note":"stuck"}`)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+}
+
+func TestOrderHandler_CancelOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockOrderService := mocks.NewMockOrderService(ctrl)
+	router := gin.Default()
+
+	router.Use(middleware.AuthMiddleware(testSigner, noRevocations(ctrl)))
+	orderHandler := handler.NewOrderHandler(mockOrderService)
+	router.POST("/orders/:id/cancel", orderHandler.CancelOrder)
+
+	token, _ := testSigner.GenerateToken(1, "test@example.com", model.RoleCustomer)
+
+	send := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("success", func(t *testing.T) {
+		mockOrderService.EXPECT().
+			CancelOrder(gomock.Any(), 1, 7).
+			Return(&model.Refund{
+				ID:      5,
+				OrderID: 7,
+				Amount:  money.New(2500, money.USD),
+				Status:  model.RefundStatusSucceeded,
+				Restock: true,
+			}, nil)
+
+		w := send("/orders/7/cancel")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var actualResponse struct {
+			Message string       `json:"message"`
+			Refund  model.Refund `json:"refund"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &actualResponse))
+		assert.Equal(t, "Order cancelled", actualResponse.Message)
+		assert.Equal(t, model.RefundStatusSucceeded, actualResponse.Refund.Status)
+		assert.Equal(t, money.New(2500, money.USD), actualResponse.Refund.Amount)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for err, status := range map[error]int{
+			utils.ErrCancelWindowOver: http.StatusConflict,
+			utils.ErrOrderNotFound:    http.StatusNotFound,
+			&utils.InvalidTransitionError{
+				Current:   model.OrderStateShipped,
+				Requested: model.OrderStateCancelled,
+			}: http.StatusConflict,
+			utils.ErrPaymentTimeout: http.StatusGatewayTimeout,
+			utils.ErrPaymentFailed:  http.StatusBadGateway,
+		} {
+			mockOrderService.EXPECT().CancelOrder(gomock.Any(), 1, 7).Return(nil, err)
+
+			w := send("/orders/7/cancel")
+
+			assert.Equal(t, status, w.Code, err.Error())
+		}
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		w := send("/orders/abc/cancel")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestOrderHandler_RefundOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockOrderService := mocks.NewMockOrderService(ctrl)
+	router := gin.Default()
+
+	router.Use(middleware.AuthMiddleware(testSigner, noRevocations(ctrl)))
+	orderHandler := handler.NewOrderHandler(mockOrderService)
+	router.POST("/admin/orders/:id/refunds", orderHandler.RefundOrder)
+
+	token, _ := testSigner.GenerateToken(2, "admin@example.com", model.RoleAdmin)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/admin/orders/7/refunds", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("partial refund", func(t *testing.T) {
+		mockOrderService.EXPECT().
+			RefundOrder(gomock.Any(), 7, 2, request.RefundOrderRequest{
+				Lines:   []request.RefundLineRequest{{BookId: 1, Quantity: 1}},
+				Restock: true,
+				Reason:  "damaged",
+			}).
+			Return(&model.Refund{
+				ID:     6,
+				Amount: money.New(1000, money.USD),
+				Status: model.RefundStatusSucceeded,
+				Lines:  []model.RefundLine{{OrderDetailID: 1, BookID: 1, Quantity: 1, Amount: money.New(1000, money.USD)}},
+			}, nil)
+
+		w := send(`{"lines":[{"bookId":1,"quantity":1}],"restock":true,"reason":"damaged"}`)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var refund model.Refund
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &refund))
+		assert.Equal(t, int64(6), refund.ID)
+		assert.Len(t, refund.Lines, 1)
+	})
+
+	t.Run("invalid lines", func(t *testing.T) {
+		w := send(`{"lines":[{"bookId":1,"quantity":0}]}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for err, status := range map[error]int{
+			fmt.Errorf("%w: only 1 copies of %q are left to refund", utils.ErrInvalidRefund, "1984"): http.StatusBadRequest,
+			utils.ErrNothingToRefund: http.StatusConflict,
+			utils.ErrPaymentNotFound: http.StatusConflict,
+			utils.ErrOrderNotFound:   http.StatusNotFound,
+			utils.ErrPaymentDeclined: http.StatusBadGateway,
+		} {
+			mockOrderService.EXPECT().
+				RefundOrder(gomock.Any(), 7, 2, request.RefundOrderRequest{}).
+				Return(nil, err)
+
+			w := send(`{}`)
+
+			assert.Equal(t, status, w.Code, err.Error())
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package health_test
+
+import (
+	"bookstore/internal/health"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker_Run(t *testing.T) {
+	t.Run("all checks up", func(t *testing.T) {
+		checker := health.NewChecker(time.Second)
+		checker.Add("first", func(ctx context.Context) error { return nil })
+		checker.Add("second", func(ctx context.Context) error { return nil })
+
+		report := checker.Run(context.Background())
+
+		assert.Equal(t, health.StatusUp, report.Status)
+		assert.Len(t, report.Checks, 2)
+		assert.Equal(t, "first", report.Checks[0].Name)
+		assert.Equal(t, health.StatusUp, report.Checks[1].Status)
+	})
+
+	t.Run("one failing check marks the report down", func(t *testing.T) {
+		checker := health.NewChecker(time.Second)
+		checker.Add("postgres", func(ctx context.Context) error { return errors.New("connection refused") })
+		checker.Add("migrations", func(ctx context.Context) error { return nil })
+
+		report := checker.Run(context.Background())
+
+		assert.Equal(t, health.StatusDown, report.Status)
+		assert.Equal(t, health.StatusDown, report.Checks[0].Status)
+		assert.Equal(t, "connection refused", report.Checks[0].Error)
+		assert.Equal(t, health.StatusUp, report.Checks[1].Status)
+	})
+
+	t.Run("slow checks time out", func(t *testing.T) {
+		checker := health.NewChecker(10 * time.Millisecond)
+		checker.Add("slow", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		report := checker.Run(context.Background())
+
+		assert.Equal(t, health.StatusDown, report.Status)
+		assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks[0].Error)
+	})
+
+	t.Run("draining reports shutting down", func(t *testing.T) {
+		checker := health.NewChecker(time.Second)
+		checker.Add("postgres", func(ctx context.Context) error { return nil })
+		assert.False(t, checker.Draining())
+
+		checker.Drain()
+		report := checker.Run(context.Background())
+
+		assert.True(t, checker.Draining())
+		assert.Equal(t, health.StatusShuttingDown, report.Status)
+	})
+}
+
+func TestPingDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	check := health.PingDatabase(db)
+
+	mock.ExpectPing()
+	assert.NoError(t, check(context.Background()))
+
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	assert.EqualError(t, check(context.Background()), "connection refused")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,191 @@
+package middleware_test
+
+import (
+	"bookstore/internal/middleware"
+	"bookstore/internal/model"
+	"bookstore/test/mocks"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mocks.NewMockIdempotencyRepository(ctrl)
+	ttl := time.Hour
+
+	calls := 0
+	status := http.StatusOK
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("customerID", 1)
+		c.Next()
+	})
+	router.Use(middleware.Idempotency(store, ttl))
+	handle := func(c *gin.Context) {
+		calls++
+		body, _ := io.ReadAll(c.Request.Body)
+		c.JSON(status, gin.H{"call": calls, "body": string(body)})
+	}
+	router.POST("/pay", handle)
+	router.GET("/cart", handle)
+	router.POST("/cart/items", func(c *gin.Context) {
+		calls++
+		c.Header("Deprecation", "true")
+		c.Header("Warning", `299 - "price is deprecated"`)
+		c.Header("X-Request-ID", "first")
+		c.JSON(http.StatusCreated, gin.H{"call": calls})
+	})
+
+	send := func(method, key, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/pay", bytes.NewBufferString(body))
+		if method == http.MethodGet {
+			req, _ = http.NewRequest(method, "/cart", http.NoBody)
+		}
+		if key != "" {
+			req.Header.Set(middleware.IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	var stored *model.IdempotencyKey
+	expectClaimed := func() {
+		store.EXPECT().
+			ClaimIdempotencyKey(gomock.Any(), gomock.Any(), ttl).
+			DoAndReturn(func(_ context.Context, key *model.IdempotencyKey, _ time.Duration) (*model.IdempotencyKey, error) {
+				assert.Equal(t, int64(1), key.CustomerID)
+				assert.Equal(t, "key-1", key.Key)
+				return nil, nil
+			})
+	}
+
+	t.Run("first request runs and its response is stored", func(t *testing.T) {
+		calls = 0
+		expectClaimed()
+		store.EXPECT().
+			SaveIdempotentResponse(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, key *model.IdempotencyKey) error {
+				stored = key
+				return nil
+			})
+
+		w := send(http.MethodPost, "key-1", `{"cardNumber":"4242424242424242"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusOK, stored.StatusCode)
+		assert.JSONEq(t, w.Body.String(), string(stored.ResponseBody))
+		assert.Contains(t, w.Body.String(), "4242424242424242", "the handler still sees the body")
+	})
+
+	t.Run("repeat is answered from the stored response", func(t *testing.T) {
+		store.EXPECT().ClaimIdempotencyKey(gomock.Any(), gomock.Any(), ttl).Return(stored, nil)
+
+		w := send(http.MethodPost, "key-1", `{"cardNumber":"4242424242424242"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, "true", w.Header().Get(middleware.IdempotentReplayedHeader))
+		assert.Equal(t, string(stored.ResponseBody), w.Body.String())
+	})
+
+	t.Run("key reused for a different body", func(t *testing.T) {
+		store.EXPECT().ClaimIdempotencyKey(gomock.Any(), gomock.Any(), ttl).Return(stored, nil)
+
+		w := send(http.MethodPost, "key-1", `{"cardNumber":"4000000000000002"}`)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("repeat while the first request is running", func(t *testing.T) {
+		inProgress := *stored
+		inProgress.StatusCode = 0
+		inProgress.ResponseBody = nil
+		store.EXPECT().ClaimIdempotencyKey(gomock.Any(), gomock.Any(), ttl).Return(&inProgress, nil)
+
+		w := send(http.MethodPost, "key-1", `{"cardNumber":"4242424242424242"}`)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("server errors release the key", func(t *testing.T) {
+		status = http.StatusBadGateway
+		defer func() { status = http.StatusOK }()
+		expectClaimed()
+		store.EXPECT().ReleaseIdempotencyKey(gomock.Any(), gomock.Any()).Return(nil)
+
+		w := send(http.MethodPost, "key-1", `{}`)
+
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+	})
+
+	t.Run("store unavailable", func(t *testing.T) {
+		store.EXPECT().
+			ClaimIdempotencyKey(gomock.Any(), gomock.Any(), ttl).
+			Return(nil, errors.New("connection refused"))
+
+		w := send(http.MethodPost, "key-1", `{}`)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("headers are stored and replayed", func(t *testing.T) {
+		addItem := func() *httptest.ResponseRecorder {
+			req, _ := http.NewRequest(http.MethodPost, "/cart/items", bytes.NewBufferString(`{}`))
+			req.Header.Set(middleware.IdempotencyKeyHeader, "key-1")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		calls = 0
+		expectClaimed()
+		store.EXPECT().
+			SaveIdempotentResponse(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, key *model.IdempotencyKey) error {
+				stored = key
+				return nil
+			})
+		addItem()
+
+		assert.Equal(t, http.Header{
+			"Content-Type": {"application/json; charset=utf-8"},
+			"Deprecation":  {"true"},
+			"Warning":      {`299 - "price is deprecated"`},
+		}, stored.ResponseHeaders, "headers of one exchange are not kept")
+
+		store.EXPECT().ClaimIdempotencyKey(gomock.Any(), gomock.Any(), ttl).Return(stored, nil)
+
+		w := addItem()
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, "true", w.Header().Get("Deprecation"))
+		assert.Equal(t, `299 - "price is deprecated"`, w.Header().Get("Warning"))
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Empty(t, w.Header().Get("X-Request-ID"))
+	})
+
+	t.Run("requests without a key and reads pass through", func(t *testing.T) {
+		calls = 0
+
+		assert.Equal(t, http.StatusOK, send(http.MethodPost, "", `{}`).Code)
+		assert.Equal(t, http.StatusOK, send(http.MethodGet, "key-1", "").Code)
+		assert.Equal(t, 2, calls)
+	})
+}
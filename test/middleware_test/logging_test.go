@@ -0,0 +1,137 @@
+package middleware_test
+
+import (
+	"bookstore/internal/logging"
+	"bookstore/internal/middleware"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLoggedRouter(output *bytes.Buffer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(
+		middleware.RequestID(logging.New(output, slog.LevelDebug)),
+		middleware.AccessLog(),
+		middleware.Recovery(),
+	)
+	return router
+}
+
+// logLines decodes every JSON line written to output.
+func logLines(t *testing.T, output *bytes.Buffer) []map[string]any {
+	var lines []map[string]any
+	for _, raw := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		if raw == "" {
+			continue
+		}
+		var line map[string]any
+		require.NoError(t, json.Unmarshal([]byte(raw), &line), raw)
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestRequestID(t *testing.T) {
+	var output bytes.Buffer
+	router := newLoggedRouter(&output)
+	router.GET("/books/:id", func(c *gin.Context) {
+		logging.FromContext(c.Request.Context()).Info("[GetBookById] handled")
+		c.Status(http.StatusNoContent)
+	})
+
+	send := func(requestID string) *httptest.ResponseRecorder {
+		output.Reset()
+		req, _ := http.NewRequest(http.MethodGet, "/books/1", http.NoBody)
+		if requestID != "" {
+			req.Header.Set(middleware.RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("incoming ID is kept and logged", func(t *testing.T) {
+		w := send("abc-123")
+
+		assert.Equal(t, "abc-123", w.Header().Get(middleware.RequestIDHeader))
+		lines := logLines(t, &output)
+		require.Len(t, lines, 2)
+		for _, line := range lines {
+			assert.Equal(t, "abc-123", line["request_id"])
+		}
+	})
+
+	t.Run("missing ID is generated", func(t *testing.T) {
+		w := send("")
+
+		id := w.Header().Get(middleware.RequestIDHeader)
+		assert.NotEmpty(t, id)
+		assert.Equal(t, id, logLines(t, &output)[0]["request_id"])
+	})
+
+	t.Run("unsafe ID is replaced", func(t *testing.T) {
+		for _, requestID := range []string{"a b", `x"}`, strings.Repeat("a", 129)} {
+			w := send(requestID)
+
+			id := w.Header().Get(middleware.RequestIDHeader)
+			assert.NotEmpty(t, id)
+			assert.NotEqual(t, requestID, id)
+		}
+	})
+}
+
+func TestAccessLog(t *testing.T) {
+	var output bytes.Buffer
+	router := newLoggedRouter(&output)
+	router.GET("/orders/:id", func(c *gin.Context) {
+		c.Set("customerID", 7)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+	})
+	router.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	send := func(path string) (*httptest.ResponseRecorder, map[string]any) {
+		output.Reset()
+		req, _ := http.NewRequest(http.MethodGet, path, http.NoBody)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		lines := logLines(t, &output)
+		require.NotEmpty(t, lines)
+		return w, lines[len(lines)-1]
+	}
+
+	t.Run("request is logged with its route template", func(t *testing.T) {
+		_, line := send("/orders/42")
+
+		assert.Equal(t, "request", line["msg"])
+		assert.Equal(t, "WARN", line["level"])
+		assert.Equal(t, "GET", line["method"])
+		assert.Equal(t, "/orders/:id", line["route"])
+		assert.Equal(t, "/orders/42", line["path"])
+		assert.EqualValues(t, http.StatusNotFound, line["status"])
+		assert.EqualValues(t, 7, line["customer_id"])
+		assert.Contains(t, line, "latency_ms")
+		assert.Contains(t, line, "request_id")
+	})
+
+	t.Run("panics are logged as server errors", func(t *testing.T) {
+		w, line := send("/panic")
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, "ERROR", line["level"])
+		assert.EqualValues(t, http.StatusInternalServerError, line["status"])
+		assert.NotContains(t, line, "customer_id")
+		assert.Contains(t, output.String(), "[Recovery] handler panicked")
+	})
+}
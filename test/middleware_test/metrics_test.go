@@ -0,0 +1,77 @@
+package middleware_test
+
+import (
+	"bookstore/internal/metrics"
+	"bookstore/internal/middleware"
+	"bookstore/internal/router"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	registry := prometheus.NewRegistry()
+	m := metrics.New(registry)
+	require.NoError(t, m.RegisterDB("postgres", db))
+
+	engine := gin.New()
+	engine.Use(middleware.Metrics(m))
+	router.MetricsRouter(engine, registry)
+	engine.GET("/books/:id", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	send := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, path, http.NoBody)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	send("/books/1")
+	send("/books/2")
+	send("/no/such/route")
+
+	w := send("/metrics")
+	require.Equal(t, http.StatusOK, w.Code)
+	body, _ := io.ReadAll(w.Body)
+	exposition := string(body)
+
+	t.Run("requests are counted by route template", func(t *testing.T) {
+		assert.Contains(t, exposition, `bookstore_http_requests_total{method="GET",route="/books/:id",status="200"} 2`)
+		assert.Contains(t, exposition, `bookstore_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+		assert.NotContains(t, exposition, `route="/books/1"`)
+	})
+
+	t.Run("latency histogram", func(t *testing.T) {
+		assert.Contains(t, exposition, `bookstore_http_request_duration_seconds_count{method="GET",route="/books/:id"} 2`)
+	})
+
+	t.Run("database pool statistics", func(t *testing.T) {
+		assert.Contains(t, exposition, `go_sql_open_connections{db_name="postgres"}`)
+	})
+}
+
+// A nil *metrics.Metrics is what services get when metrics are not wired.
+func TestMetrics_Nil(t *testing.T) {
+	var m *metrics.Metrics
+
+	assert.NotPanics(t, func() {
+		m.ObserveRequest(http.MethodGet, "/", http.StatusOK, 0)
+		m.CartCreated()
+		m.PaymentFailed(metrics.PaymentFailureError)
+	})
+}
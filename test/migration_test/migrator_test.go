@@ -0,0 +1,266 @@
+package migration_test
+
+import (
+	"bookstore/internal/migration"
+	"bookstore/pkg/utils"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	lockQuery    = regexp.QuoteMeta(`SELECT pg_advisory_lock($1)`)
+	unlockQuery  = regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)
+	tableQuery   = regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS schema_migrations`)
+	appliedQuery = regexp.QuoteMeta(`SELECT version, checksum, applied_at FROM schema_migrations`)
+	insertQuery  = regexp.QuoteMeta(`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`)
+	deleteQuery  = regexp.QuoteMeta(`DELETE FROM schema_migrations WHERE version = $1`)
+)
+
+var testMigrations = fstest.MapFS{
+	"0001_create_books.up.sql":   {Data: []byte("CREATE TABLE books (id SERIAL)")},
+	"0001_create_books.down.sql": {Data: []byte("DROP TABLE books")},
+	"0002_add_stock.up.sql":      {Data: []byte("ALTER TABLE books ADD COLUMN stock INT")},
+	"0002_add_stock.down.sql":    {Data: []byte("ALTER TABLE books DROP COLUMN stock")},
+	"README.md":                  {Data: []byte("not a migration")},
+}
+
+func checksum(t *testing.T, version int64) string {
+	migrations, err := migration.LoadMigrations(testMigrations)
+	assert.NoError(t, err)
+	for _, m := range migrations {
+		if m.Version == version {
+			return m.Checksum()
+		}
+	}
+	t.Fatalf("no migration %d", version)
+	return ""
+}
+
+func expectLocked(mock sqlmock.Sqlmock, applied *sqlmock.Rows) {
+	mock.ExpectExec(lockQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(tableQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(appliedQuery).WillReturnRows(applied)
+}
+
+func appliedRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"version", "checksum", "applied_at"})
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := migration.LoadMigrations(testMigrations)
+
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, int64(1), migrations[0].Version)
+	assert.Equal(t, "create_books", migrations[0].Name)
+	assert.Equal(t, "DROP TABLE books", migrations[0].Down)
+	assert.Equal(t, "add_stock", migrations[1].Name)
+
+	t.Run("missing down file", func(t *testing.T) {
+		_, err := migration.LoadMigrations(fstest.MapFS{
+			"0001_create_books.up.sql": {Data: []byte("CREATE TABLE books (id SERIAL)")},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("embedded migrations are complete", func(t *testing.T) {
+		_, err := migration.NewMigrator(nil)
+		assert.NoError(t, err)
+	})
+}
+
+func TestMigrator_Up(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := migration.NewMigratorFromFS(db, testMigrations)
+	assert.NoError(t, err)
+
+	t.Run("applies pending migrations in order", func(t *testing.T) {
+		expectLocked(mock, appliedRows().AddRow(1, checksum(t, 1), time.Now()))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE books ADD COLUMN stock INT")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(insertQuery).
+			WithArgs(int64(2), "add_stock", checksum(t, 2)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec(unlockQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		applied, err := migrator.Up()
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failed migration is rolled back", func(t *testing.T) {
+		expectLocked(mock, appliedRows().AddRow(1, checksum(t, 1), time.Now()))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE books ADD COLUMN stock INT")).
+			WillReturnError(assert.AnError)
+		mock.ExpectRollback()
+		mock.ExpectExec(unlockQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		applied, err := migrator.Up()
+
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Equal(t, 0, applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("refuses modified migrations", func(t *testing.T) {
+		expectLocked(mock, appliedRows().AddRow(1, "edited", time.Now()))
+		mock.ExpectExec(unlockQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err := migrator.Up()
+
+		assert.ErrorIs(t, err, utils.ErrMigrationChecksumMismatch)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("refuses unknown migrations", func(t *testing.T) {
+		expectLocked(mock, appliedRows().
+			AddRow(1, checksum(t, 1), time.Now()).
+			AddRow(3, "from a newer build", time.Now()))
+		mock.ExpectExec(unlockQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err := migrator.Up()
+
+		assert.ErrorIs(t, err, utils.ErrUnknownMigration)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestMigrator_Down(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := migration.NewMigratorFromFS(db, testMigrations)
+	assert.NoError(t, err)
+
+	expectLocked(mock, appliedRows().
+		AddRow(1, checksum(t, 1), time.Now()).
+		AddRow(2, checksum(t, 2), time.Now()))
+	for _, step := range []struct {
+		version int64
+		sql     string
+	}{{2, "ALTER TABLE books DROP COLUMN stock"}, {1, "DROP TABLE books"}} {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(step.sql)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(deleteQuery).WithArgs(step.version).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec(unlockQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	reverted, err := migrator.Down(5)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, reverted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Redo(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := migration.NewMigratorFromFS(db, testMigrations)
+	assert.NoError(t, err)
+
+	t.Run("reverts and reapplies the latest migration", func(t *testing.T) {
+		expectLocked(mock, appliedRows().
+			AddRow(1, checksum(t, 1), time.Now()).
+			AddRow(2, checksum(t, 2), time.Now()))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE books DROP COLUMN stock")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(deleteQuery).WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE books ADD COLUMN stock INT")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(insertQuery).
+			WithArgs(int64(2), "add_stock", checksum(t, 2)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec(unlockQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		assert.NoError(t, migrator.Redo())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("nothing applied", func(t *testing.T) {
+		expectLocked(mock, appliedRows())
+		mock.ExpectExec(unlockQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		assert.ErrorIs(t, migrator.Redo(), utils.ErrNoMigrationsApplied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestMigrator_Status(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := migration.NewMigratorFromFS(db, testMigrations)
+	assert.NoError(t, err)
+
+	appliedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	expectLocked(mock, appliedRows().AddRow(1, "edited", appliedAt))
+	mock.ExpectExec(unlockQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	statuses, err := migrator.Status()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []migration.MigrationStatus{
+		{Version: 1, Name: "create_books", Applied: true, AppliedAt: &appliedAt, Modified: true},
+		{Version: 2, Name: "add_stock"},
+	}, statuses)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_CheckVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := migration.NewMigratorFromFS(db, testMigrations)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), migrator.Latest())
+
+	versionQuery := regexp.QuoteMeta(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)
+
+	t.Run("up to date", func(t *testing.T) {
+		mock.ExpectQuery(versionQuery).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+
+		assert.NoError(t, migrator.CheckVersion(context.Background()))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("pending migrations", func(t *testing.T) {
+		mock.ExpectQuery(versionQuery).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+
+		err := migrator.CheckVersion(context.Background())
+		assert.ErrorIs(t, err, utils.ErrSchemaVersionMismatch)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("table missing", func(t *testing.T) {
+		mock.ExpectQuery(versionQuery).WillReturnError(errors.New(`relation "schema_migrations" does not exist`))
+
+		err := migrator.CheckVersion(context.Background())
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,64 @@
+package migration_test
+
+import (
+	"bookstore/internal/migration"
+	"bookstore/internal/model"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var openingStockQuery = regexp.QuoteMeta(`
+	WITH stocked AS (
+		UPDATE books b SET stock = $1
+		WHERE b.stock = 0 AND b.archived_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM stock_movements m WHERE m.book_id = b.id)
+		RETURNING b.id, b.stock
+	)
+	INSERT INTO stock_movements (book_id, quantity_change, reason, note, stock_after)
+	SELECT id, stock, $2, 'opening stock', stock FROM stocked`)
+
+func TestSetOpeningStock(t *testing.T) {
+	t.Run("stocks books that were never stocked", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectExec(openingStockQuery).
+			WithArgs(20, model.StockReasonRestock).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+
+		stocked, err := migration.SetOpeningStock(db, 20)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), stocked)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("quantity must be positive", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		_, err = migration.SetOpeningStock(db, 0)
+
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectExec(openingStockQuery).WillReturnError(errors.New("db down"))
+
+		_, err = migration.SetOpeningStock(db, 20)
+
+		assert.ErrorContains(t, err, "db down")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
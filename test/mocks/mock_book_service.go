@@ -6,6 +6,7 @@ package mocks
 
 import (
 	model "bookstore/internal/model"
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -34,60 +35,118 @@ func (m *MockBookService) EXPECT() *MockBookServiceMockRecorder {
 	return m.recorder
 }
 
+// AdjustStock mocks base method.
+func (m *MockBookService) AdjustStock(ctx context.Context, movement *model.StockMovement) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AdjustStock", ctx, movement)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AdjustStock indicates an expected call of AdjustStock.
+func (mr *MockBookServiceMockRecorder) AdjustStock(ctx, movement interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AdjustStock", reflect.TypeOf((*MockBookService)(nil).AdjustStock), ctx, movement)
+}
+
+// ArchiveBook mocks base method.
+func (m *MockBookService) ArchiveBook(ctx context.Context, id int) (*model.Book, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ArchiveBook", ctx, id)
+	ret0, _ := ret[0].(*model.Book)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ArchiveBook indicates an expected call of ArchiveBook.
+func (mr *MockBookServiceMockRecorder) ArchiveBook(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ArchiveBook", reflect.TypeOf((*MockBookService)(nil).ArchiveBook), ctx, id)
+}
+
 // CreateBook mocks base method.
-func (m *MockBookService) CreateBook(book *model.Book) error {
+func (m *MockBookService) CreateBook(ctx context.Context, book *model.Book) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateBook", book)
+	ret := m.ctrl.Call(m, "CreateBook", ctx, book)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // CreateBook indicates an expected call of CreateBook.
-func (mr *MockBookServiceMockRecorder) CreateBook(book interface{}) *gomock.Call {
+func (mr *MockBookServiceMockRecorder) CreateBook(ctx, book interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBook", reflect.TypeOf((*MockBookService)(nil).CreateBook), book)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBook", reflect.TypeOf((*MockBookService)(nil).CreateBook), ctx, book)
+}
+
+// DeleteBook mocks base method.
+func (m *MockBookService) DeleteBook(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteBook", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteBook indicates an expected call of DeleteBook.
+func (mr *MockBookServiceMockRecorder) DeleteBook(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBook", reflect.TypeOf((*MockBookService)(nil).DeleteBook), ctx, id)
 }
 
 // GetBookById mocks base method.
-func (m *MockBookService) GetBookById(id int) (*model.Book, error) {
+func (m *MockBookService) GetBookById(ctx context.Context, id int) (*model.Book, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetBookById", id)
+	ret := m.ctrl.Call(m, "GetBookById", ctx, id)
 	ret0, _ := ret[0].(*model.Book)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetBookById indicates an expected call of GetBookById.
-func (mr *MockBookServiceMockRecorder) GetBookById(id interface{}) *gomock.Call {
+func (mr *MockBookServiceMockRecorder) GetBookById(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBookById", reflect.TypeOf((*MockBookService)(nil).GetBookById), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBookById", reflect.TypeOf((*MockBookService)(nil).GetBookById), ctx, id)
 }
 
 // GetBooks mocks base method.
-func (m *MockBookService) GetBooks() ([]model.Book, error) {
+func (m *MockBookService) GetBooks(ctx context.Context, query model.BookQuery) (*model.BookPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetBooks")
-	ret0, _ := ret[0].([]model.Book)
+	ret := m.ctrl.Call(m, "GetBooks", ctx, query)
+	ret0, _ := ret[0].(*model.BookPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetBooks indicates an expected call of GetBooks.
-func (mr *MockBookServiceMockRecorder) GetBooks() *gomock.Call {
+func (mr *MockBookServiceMockRecorder) GetBooks(ctx, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBooks", reflect.TypeOf((*MockBookService)(nil).GetBooks), ctx, query)
+}
+
+// RestoreBook mocks base method.
+func (m *MockBookService) RestoreBook(ctx context.Context, id int) (*model.Book, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreBook", ctx, id)
+	ret0, _ := ret[0].(*model.Book)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreBook indicates an expected call of RestoreBook.
+func (mr *MockBookServiceMockRecorder) RestoreBook(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBooks", reflect.TypeOf((*MockBookService)(nil).GetBooks))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreBook", reflect.TypeOf((*MockBookService)(nil).RestoreBook), ctx, id)
 }
 
 // UpdateBook mocks base method.
-func (m *MockBookService) UpdateBook(book *model.Book) error {
+func (m *MockBookService) UpdateBook(ctx context.Context, book *model.Book) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateBook", book)
+	ret := m.ctrl.Call(m, "UpdateBook", ctx, book)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateBook indicates an expected call of UpdateBook.
-func (mr *MockBookServiceMockRecorder) UpdateBook(book interface{}) *gomock.Call {
+func (mr *MockBookServiceMockRecorder) UpdateBook(ctx, book interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBook", reflect.TypeOf((*MockBookService)(nil).UpdateBook), book)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBook", reflect.TypeOf((*MockBookService)(nil).UpdateBook), ctx, book)
 }
@@ -6,6 +6,7 @@ package mocks
 
 import (
 	model "bookstore/internal/model"
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -34,31 +35,148 @@ func (m *MockCustomerRepository) EXPECT() *MockCustomerRepositoryMockRecorder {
 	return m.recorder
 }
 
+// ConfirmEmail mocks base method.
+func (m *MockCustomerRepository) ConfirmEmail(ctx context.Context, tokenHash string) (*model.EmailVerification, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConfirmEmail", ctx, tokenHash)
+	ret0, _ := ret[0].(*model.EmailVerification)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConfirmEmail indicates an expected call of ConfirmEmail.
+func (mr *MockCustomerRepositoryMockRecorder) ConfirmEmail(ctx, tokenHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConfirmEmail", reflect.TypeOf((*MockCustomerRepository)(nil).ConfirmEmail), ctx, tokenHash)
+}
+
+// CreateEmailVerification mocks base method.
+func (m *MockCustomerRepository) CreateEmailVerification(ctx context.Context, verification *model.EmailVerification) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateEmailVerification", ctx, verification)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateEmailVerification indicates an expected call of CreateEmailVerification.
+func (mr *MockCustomerRepositoryMockRecorder) CreateEmailVerification(ctx, verification interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateEmailVerification", reflect.TypeOf((*MockCustomerRepository)(nil).CreateEmailVerification), ctx, verification)
+}
+
+// GetCustomerById mocks base method.
+func (m *MockCustomerRepository) GetCustomerById(ctx context.Context, customerID int) (*model.Customer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCustomerById", ctx, customerID)
+	ret0, _ := ret[0].(*model.Customer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCustomerById indicates an expected call of GetCustomerById.
+func (mr *MockCustomerRepositoryMockRecorder) GetCustomerById(ctx, customerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCustomerById", reflect.TypeOf((*MockCustomerRepository)(nil).GetCustomerById), ctx, customerID)
+}
+
+// GetPasswordHash mocks base method.
+func (m *MockCustomerRepository) GetPasswordHash(ctx context.Context, customerID int) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPasswordHash", ctx, customerID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPasswordHash indicates an expected call of GetPasswordHash.
+func (mr *MockCustomerRepositoryMockRecorder) GetPasswordHash(ctx, customerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPasswordHash", reflect.TypeOf((*MockCustomerRepository)(nil).GetPasswordHash), ctx, customerID)
+}
+
+// GetProfile mocks base method.
+func (m *MockCustomerRepository) GetProfile(ctx context.Context, customerID int) (*model.CustomerProfile, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetProfile", ctx, customerID)
+	ret0, _ := ret[0].(*model.CustomerProfile)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetProfile indicates an expected call of GetProfile.
+func (mr *MockCustomerRepositoryMockRecorder) GetProfile(ctx, customerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProfile", reflect.TypeOf((*MockCustomerRepository)(nil).GetProfile), ctx, customerID)
+}
+
 // Login mocks base method.
-func (m *MockCustomerRepository) Login(email, password string) (*model.Customer, error) {
+func (m *MockCustomerRepository) Login(ctx context.Context, email, password string) (*model.Customer, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Login", email, password)
+	ret := m.ctrl.Call(m, "Login", ctx, email, password)
 	ret0, _ := ret[0].(*model.Customer)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Login indicates an expected call of Login.
-func (mr *MockCustomerRepositoryMockRecorder) Login(email, password interface{}) *gomock.Call {
+func (mr *MockCustomerRepositoryMockRecorder) Login(ctx, email, password interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockCustomerRepository)(nil).Login), email, password)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockCustomerRepository)(nil).Login), ctx, email, password)
 }
 
 // Register mocks base method.
-func (m *MockCustomerRepository) Register(customer *model.Customer) error {
+func (m *MockCustomerRepository) Register(ctx context.Context, customer *model.Customer) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Register", customer)
+	ret := m.ctrl.Call(m, "Register", ctx, customer)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Register indicates an expected call of Register.
-func (mr *MockCustomerRepositoryMockRecorder) Register(customer interface{}) *gomock.Call {
+func (mr *MockCustomerRepositoryMockRecorder) Register(ctx, customer interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockCustomerRepository)(nil).Register), ctx, customer)
+}
+
+// UpdatePassword mocks base method.
+func (m *MockCustomerRepository) UpdatePassword(ctx context.Context, customerID int, passwordHash string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePassword", ctx, customerID, passwordHash)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePassword indicates an expected call of UpdatePassword.
+func (mr *MockCustomerRepositoryMockRecorder) UpdatePassword(ctx, customerID, passwordHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePassword", reflect.TypeOf((*MockCustomerRepository)(nil).UpdatePassword), ctx, customerID, passwordHash)
+}
+
+// UpdateProfile mocks base method.
+func (m *MockCustomerRepository) UpdateProfile(ctx context.Context, customerID int, name, address *string) (*model.CustomerProfile, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateProfile", ctx, customerID, name, address)
+	ret0, _ := ret[0].(*model.CustomerProfile)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateProfile indicates an expected call of UpdateProfile.
+func (mr *MockCustomerRepositoryMockRecorder) UpdateProfile(ctx, customerID, name, address interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateProfile", reflect.TypeOf((*MockCustomerRepository)(nil).UpdateProfile), ctx, customerID, name, address)
+}
+
+// UpdateRole mocks base method.
+func (m *MockCustomerRepository) UpdateRole(ctx context.Context, customerID int, role model.Role) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateRole", ctx, customerID, role)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateRole indicates an expected call of UpdateRole.
+func (mr *MockCustomerRepositoryMockRecorder) UpdateRole(ctx, customerID, role interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockCustomerRepository)(nil).Register), customer)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRole", reflect.TypeOf((*MockCustomerRepository)(nil).UpdateRole), ctx, customerID, role)
 }
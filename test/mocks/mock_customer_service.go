@@ -5,8 +5,11 @@
 package mocks
 
 import (
+	request "bookstore/internal/handler/request"
 	model "bookstore/internal/model"
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -34,31 +37,147 @@ func (m *MockCustomerService) EXPECT() *MockCustomerServiceMockRecorder {
 	return m.recorder
 }
 
+// ChangeEmail mocks base method.
+func (m *MockCustomerService) ChangeEmail(ctx context.Context, customerID int, request request.ChangeEmailRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChangeEmail", ctx, customerID, request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ChangeEmail indicates an expected call of ChangeEmail.
+func (mr *MockCustomerServiceMockRecorder) ChangeEmail(ctx, customerID, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangeEmail", reflect.TypeOf((*MockCustomerService)(nil).ChangeEmail), ctx, customerID, request)
+}
+
+// ChangePassword mocks base method.
+func (m *MockCustomerService) ChangePassword(ctx context.Context, customerID int, request request.ChangePasswordRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChangePassword", ctx, customerID, request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ChangePassword indicates an expected call of ChangePassword.
+func (mr *MockCustomerServiceMockRecorder) ChangePassword(ctx, customerID, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangePassword", reflect.TypeOf((*MockCustomerService)(nil).ChangePassword), ctx, customerID, request)
+}
+
+// GetProfile mocks base method.
+func (m *MockCustomerService) GetProfile(ctx context.Context, customerID int) (*model.CustomerProfile, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetProfile", ctx, customerID)
+	ret0, _ := ret[0].(*model.CustomerProfile)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetProfile indicates an expected call of GetProfile.
+func (mr *MockCustomerServiceMockRecorder) GetProfile(ctx, customerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProfile", reflect.TypeOf((*MockCustomerService)(nil).GetProfile), ctx, customerID)
+}
+
 // Login mocks base method.
-func (m *MockCustomerService) Login(email, password string) (string, error) {
+func (m *MockCustomerService) Login(ctx context.Context, email, password string) (*model.TokenPair, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Login", email, password)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Login", ctx, email, password)
+	ret0, _ := ret[0].(*model.TokenPair)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Login indicates an expected call of Login.
-func (mr *MockCustomerServiceMockRecorder) Login(email, password interface{}) *gomock.Call {
+func (mr *MockCustomerServiceMockRecorder) Login(ctx, email, password interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockCustomerService)(nil).Login), ctx, email, password)
+}
+
+// Logout mocks base method.
+func (m *MockCustomerService) Logout(ctx context.Context, customerID int, jti string, expiresAt time.Time, refreshToken string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Logout", ctx, customerID, jti, expiresAt, refreshToken)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Logout indicates an expected call of Logout.
+func (mr *MockCustomerServiceMockRecorder) Logout(ctx, customerID, jti, expiresAt, refreshToken interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockCustomerService)(nil).Login), email, password)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Logout", reflect.TypeOf((*MockCustomerService)(nil).Logout), ctx, customerID, jti, expiresAt, refreshToken)
+}
+
+// RefreshToken mocks base method.
+func (m *MockCustomerService) RefreshToken(ctx context.Context, refreshToken string) (*model.TokenPair, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshToken", ctx, refreshToken)
+	ret0, _ := ret[0].(*model.TokenPair)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefreshToken indicates an expected call of RefreshToken.
+func (mr *MockCustomerServiceMockRecorder) RefreshToken(ctx, refreshToken interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshToken", reflect.TypeOf((*MockCustomerService)(nil).RefreshToken), ctx, refreshToken)
 }
 
 // Register mocks base method.
-func (m *MockCustomerService) Register(customer *model.Customer) error {
+func (m *MockCustomerService) Register(ctx context.Context, customer *model.Customer) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Register", customer)
+	ret := m.ctrl.Call(m, "Register", ctx, customer)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Register indicates an expected call of Register.
-func (mr *MockCustomerServiceMockRecorder) Register(customer interface{}) *gomock.Call {
+func (mr *MockCustomerServiceMockRecorder) Register(ctx, customer interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockCustomerService)(nil).Register), ctx, customer)
+}
+
+// UpdateProfile mocks base method.
+func (m *MockCustomerService) UpdateProfile(ctx context.Context, customerID int, request request.UpdateProfileRequest) (*model.CustomerProfile, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateProfile", ctx, customerID, request)
+	ret0, _ := ret[0].(*model.CustomerProfile)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateProfile indicates an expected call of UpdateProfile.
+func (mr *MockCustomerServiceMockRecorder) UpdateProfile(ctx, customerID, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateProfile", reflect.TypeOf((*MockCustomerService)(nil).UpdateProfile), ctx, customerID, request)
+}
+
+// UpdateRole mocks base method.
+func (m *MockCustomerService) UpdateRole(ctx context.Context, customerID int, role model.Role) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateRole", ctx, customerID, role)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateRole indicates an expected call of UpdateRole.
+func (mr *MockCustomerServiceMockRecorder) UpdateRole(ctx, customerID, role interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRole", reflect.TypeOf((*MockCustomerService)(nil).UpdateRole), ctx, customerID, role)
+}
+
+// VerifyEmail mocks base method.
+func (m *MockCustomerService) VerifyEmail(ctx context.Context, token string) (*model.CustomerProfile, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyEmail", ctx, token)
+	ret0, _ := ret[0].(*model.CustomerProfile)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifyEmail indicates an expected call of VerifyEmail.
+func (mr *MockCustomerServiceMockRecorder) VerifyEmail(ctx, token interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockCustomerService)(nil).Register), customer)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyEmail", reflect.TypeOf((*MockCustomerService)(nil).VerifyEmail), ctx, token)
 }
@@ -0,0 +1,95 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/repository/idempotency_repository.go
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	model "bookstore/internal/model"
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockIdempotencyRepository is a mock of IdempotencyRepository interface.
+type MockIdempotencyRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockIdempotencyRepositoryMockRecorder
+}
+
+// MockIdempotencyRepositoryMockRecorder is the mock recorder for MockIdempotencyRepository.
+type MockIdempotencyRepositoryMockRecorder struct {
+	mock *MockIdempotencyRepository
+}
+
+// NewMockIdempotencyRepository creates a new mock instance.
+func NewMockIdempotencyRepository(ctrl *gomock.Controller) *MockIdempotencyRepository {
+	mock := &MockIdempotencyRepository{ctrl: ctrl}
+	mock.recorder = &MockIdempotencyRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIdempotencyRepository) EXPECT() *MockIdempotencyRepositoryMockRecorder {
+	return m.recorder
+}
+
+// ClaimIdempotencyKey mocks base method.
+func (m *MockIdempotencyRepository) ClaimIdempotencyKey(ctx context.Context, key *model.IdempotencyKey, ttl time.Duration) (*model.IdempotencyKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimIdempotencyKey", ctx, key, ttl)
+	ret0, _ := ret[0].(*model.IdempotencyKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimIdempotencyKey indicates an expected call of ClaimIdempotencyKey.
+func (mr *MockIdempotencyRepositoryMockRecorder) ClaimIdempotencyKey(ctx, key, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimIdempotencyKey", reflect.TypeOf((*MockIdempotencyRepository)(nil).ClaimIdempotencyKey), ctx, key, ttl)
+}
+
+// DeleteExpiredIdempotencyKeys mocks base method.
+func (m *MockIdempotencyRepository) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredIdempotencyKeys", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredIdempotencyKeys indicates an expected call of DeleteExpiredIdempotencyKeys.
+func (mr *MockIdempotencyRepositoryMockRecorder) DeleteExpiredIdempotencyKeys(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredIdempotencyKeys", reflect.TypeOf((*MockIdempotencyRepository)(nil).DeleteExpiredIdempotencyKeys), ctx)
+}
+
+// ReleaseIdempotencyKey mocks base method.
+func (m *MockIdempotencyRepository) ReleaseIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseIdempotencyKey", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseIdempotencyKey indicates an expected call of ReleaseIdempotencyKey.
+func (mr *MockIdempotencyRepositoryMockRecorder) ReleaseIdempotencyKey(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseIdempotencyKey", reflect.TypeOf((*MockIdempotencyRepository)(nil).ReleaseIdempotencyKey), ctx, key)
+}
+
+// SaveIdempotentResponse mocks base method.
+func (m *MockIdempotencyRepository) SaveIdempotentResponse(ctx context.Context, key *model.IdempotencyKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveIdempotentResponse", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveIdempotentResponse indicates an expected call of SaveIdempotentResponse.
+func (mr *MockIdempotencyRepositoryMockRecorder) SaveIdempotentResponse(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveIdempotentResponse", reflect.TypeOf((*MockIdempotencyRepository)(nil).SaveIdempotentResponse), ctx, key)
+}
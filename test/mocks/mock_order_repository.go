@@ -6,6 +6,7 @@ package mocks
 
 import (
 	model "bookstore/internal/model"
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -34,89 +35,264 @@ func (m *MockOrderRepository) EXPECT() *MockOrderRepositoryMockRecorder {
 	return m.recorder
 }
 
+// AbandonCheckout mocks base method.
+func (m *MockOrderRepository) AbandonCheckout(ctx context.Context, orderID, customerID int, note string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AbandonCheckout", ctx, orderID, customerID, note)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AbandonCheckout indicates an expected call of AbandonCheckout.
+func (mr *MockOrderRepositoryMockRecorder) AbandonCheckout(ctx, orderID, customerID, note interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AbandonCheckout", reflect.TypeOf((*MockOrderRepository)(nil).AbandonCheckout), ctx, orderID, customerID, note)
+}
+
 // AddOrUpdateCart mocks base method.
-func (m *MockOrderRepository) AddOrUpdateCart(orderID, bookID, quantity int, subtotal int64) error {
+func (m *MockOrderRepository) AddOrUpdateCart(ctx context.Context, detail *model.OrderDetail) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddOrUpdateCart", orderID, bookID, quantity, subtotal)
+	ret := m.ctrl.Call(m, "AddOrUpdateCart", ctx, detail)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // AddOrUpdateCart indicates an expected call of AddOrUpdateCart.
-func (mr *MockOrderRepositoryMockRecorder) AddOrUpdateCart(orderID, bookID, quantity, subtotal interface{}) *gomock.Call {
+func (mr *MockOrderRepositoryMockRecorder) AddOrUpdateCart(ctx, detail interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOrUpdateCart", reflect.TypeOf((*MockOrderRepository)(nil).AddOrUpdateCart), ctx, detail)
+}
+
+// CancelCheckout mocks base method.
+func (m *MockOrderRepository) CancelCheckout(ctx context.Context, transition *model.OrderStateTransition) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelCheckout", ctx, transition)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CancelCheckout indicates an expected call of CancelCheckout.
+func (mr *MockOrderRepositoryMockRecorder) CancelCheckout(ctx, transition interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelCheckout", reflect.TypeOf((*MockOrderRepository)(nil).CancelCheckout), ctx, transition)
+}
+
+// CompleteCheckout mocks base method.
+func (m *MockOrderRepository) CompleteCheckout(ctx context.Context, orderID, customerID int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompleteCheckout", ctx, orderID, customerID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompleteCheckout indicates an expected call of CompleteCheckout.
+func (mr *MockOrderRepositoryMockRecorder) CompleteCheckout(ctx, orderID, customerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteCheckout", reflect.TypeOf((*MockOrderRepository)(nil).CompleteCheckout), ctx, orderID, customerID)
+}
+
+// CompleteRefund mocks base method.
+func (m *MockOrderRepository) CompleteRefund(ctx context.Context, refund *model.Refund, markRefunded bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompleteRefund", ctx, refund, markRefunded)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompleteRefund indicates an expected call of CompleteRefund.
+func (mr *MockOrderRepositoryMockRecorder) CompleteRefund(ctx, refund, markRefunded interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOrUpdateCart", reflect.TypeOf((*MockOrderRepository)(nil).AddOrUpdateCart), orderID, bookID, quantity, subtotal)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteRefund", reflect.TypeOf((*MockOrderRepository)(nil).CompleteRefund), ctx, refund, markRefunded)
 }
 
 // CreateOrderIfNotExists mocks base method.
-func (m *MockOrderRepository) CreateOrderIfNotExists(customerID int) (int, error) {
+func (m *MockOrderRepository) CreateOrderIfNotExists(ctx context.Context, customerID int) (int, bool, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateOrderIfNotExists", customerID)
+	ret := m.ctrl.Call(m, "CreateOrderIfNotExists", ctx, customerID)
 	ret0, _ := ret[0].(int)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
 // CreateOrderIfNotExists indicates an expected call of CreateOrderIfNotExists.
-func (mr *MockOrderRepositoryMockRecorder) CreateOrderIfNotExists(customerID interface{}) *gomock.Call {
+func (mr *MockOrderRepositoryMockRecorder) CreateOrderIfNotExists(ctx, customerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrderIfNotExists", reflect.TypeOf((*MockOrderRepository)(nil).CreateOrderIfNotExists), ctx, customerID)
+}
+
+// FailRefund mocks base method.
+func (m *MockOrderRepository) FailRefund(ctx context.Context, refund *model.Refund) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FailRefund", ctx, refund)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FailRefund indicates an expected call of FailRefund.
+func (mr *MockOrderRepositoryMockRecorder) FailRefund(ctx, refund interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrderIfNotExists", reflect.TypeOf((*MockOrderRepository)(nil).CreateOrderIfNotExists), customerID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FailRefund", reflect.TypeOf((*MockOrderRepository)(nil).FailRefund), ctx, refund)
 }
 
 // GetCart mocks base method.
-func (m *MockOrderRepository) GetCart(orderId int) (*model.OrderResponse, error) {
+func (m *MockOrderRepository) GetCart(ctx context.Context, orderId int) (*model.OrderResponse, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCart", orderId)
+	ret := m.ctrl.Call(m, "GetCart", ctx, orderId)
 	ret0, _ := ret[0].(*model.OrderResponse)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetCart indicates an expected call of GetCart.
-func (mr *MockOrderRepositoryMockRecorder) GetCart(orderId interface{}) *gomock.Call {
+func (mr *MockOrderRepositoryMockRecorder) GetCart(ctx, orderId interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCart", reflect.TypeOf((*MockOrderRepository)(nil).GetCart), orderId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCart", reflect.TypeOf((*MockOrderRepository)(nil).GetCart), ctx, orderId)
+}
+
+// GetOrder mocks base method.
+func (m *MockOrderRepository) GetOrder(ctx context.Context, orderID int) (*model.OrderResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrder", ctx, orderID)
+	ret0, _ := ret[0].(*model.OrderResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrder indicates an expected call of GetOrder.
+func (mr *MockOrderRepositoryMockRecorder) GetOrder(ctx, orderID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrder", reflect.TypeOf((*MockOrderRepository)(nil).GetOrder), ctx, orderID)
 }
 
 // GetOrderHistory mocks base method.
-func (m *MockOrderRepository) GetOrderHistory(customerID, limit, page int) ([]model.OrderResponse, error) {
+func (m *MockOrderRepository) GetOrderHistory(ctx context.Context, query model.OrderHistoryQuery) (*model.OrderHistoryPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOrderHistory", customerID, limit, page)
-	ret0, _ := ret[0].([]model.OrderResponse)
+	ret := m.ctrl.Call(m, "GetOrderHistory", ctx, query)
+	ret0, _ := ret[0].(*model.OrderHistoryPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetOrderHistory indicates an expected call of GetOrderHistory.
-func (mr *MockOrderRepositoryMockRecorder) GetOrderHistory(customerID, limit, page interface{}) *gomock.Call {
+func (mr *MockOrderRepositoryMockRecorder) GetOrderHistory(ctx, query interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderHistory", reflect.TypeOf((*MockOrderRepository)(nil).GetOrderHistory), customerID, limit, page)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderHistory", reflect.TypeOf((*MockOrderRepository)(nil).GetOrderHistory), ctx, query)
 }
 
-// PayOrder mocks base method.
-func (m *MockOrderRepository) PayOrder(customerID int) error {
+// GetOrderState mocks base method.
+func (m *MockOrderRepository) GetOrderState(ctx context.Context, orderID int) (model.OrderState, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PayOrder", customerID)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "GetOrderState", ctx, orderID)
+	ret0, _ := ret[0].(model.OrderState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderState indicates an expected call of GetOrderState.
+func (mr *MockOrderRepositoryMockRecorder) GetOrderState(ctx, orderID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderState", reflect.TypeOf((*MockOrderRepository)(nil).GetOrderState), ctx, orderID)
+}
+
+// GetOrderSummary mocks base method.
+func (m *MockOrderRepository) GetOrderSummary(ctx context.Context, orderID int) (*model.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrderSummary", ctx, orderID)
+	ret0, _ := ret[0].(*model.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderSummary indicates an expected call of GetOrderSummary.
+func (mr *MockOrderRepositoryMockRecorder) GetOrderSummary(ctx, orderID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderSummary", reflect.TypeOf((*MockOrderRepository)(nil).GetOrderSummary), ctx, orderID)
 }
 
-// PayOrder indicates an expected call of PayOrder.
-func (mr *MockOrderRepositoryMockRecorder) PayOrder(customerID interface{}) *gomock.Call {
+// GetOrderTransitions mocks base method.
+func (m *MockOrderRepository) GetOrderTransitions(ctx context.Context, orderID int) ([]model.OrderStateTransition, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrderTransitions", ctx, orderID)
+	ret0, _ := ret[0].([]model.OrderStateTransition)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderTransitions indicates an expected call of GetOrderTransitions.
+func (mr *MockOrderRepositoryMockRecorder) GetOrderTransitions(ctx, orderID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PayOrder", reflect.TypeOf((*MockOrderRepository)(nil).PayOrder), customerID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderTransitions", reflect.TypeOf((*MockOrderRepository)(nil).GetOrderTransitions), ctx, orderID)
 }
 
 // RemoveFromCart mocks base method.
-func (m *MockOrderRepository) RemoveFromCart(orderId, bookId int) error {
+func (m *MockOrderRepository) RemoveFromCart(ctx context.Context, orderId, bookId int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "RemoveFromCart", orderId, bookId)
+	ret := m.ctrl.Call(m, "RemoveFromCart", ctx, orderId, bookId)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // RemoveFromCart indicates an expected call of RemoveFromCart.
-func (mr *MockOrderRepositoryMockRecorder) RemoveFromCart(orderId, bookId interface{}) *gomock.Call {
+func (mr *MockOrderRepositoryMockRecorder) RemoveFromCart(ctx, orderId, bookId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveFromCart", reflect.TypeOf((*MockOrderRepository)(nil).RemoveFromCart), ctx, orderId, bookId)
+}
+
+// RepriceCart mocks base method.
+func (m *MockOrderRepository) RepriceCart(ctx context.Context, orderID int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RepriceCart", ctx, orderID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RepriceCart indicates an expected call of RepriceCart.
+func (mr *MockOrderRepositoryMockRecorder) RepriceCart(ctx, orderID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RepriceCart", reflect.TypeOf((*MockOrderRepository)(nil).RepriceCart), ctx, orderID)
+}
+
+// StartCheckout mocks base method.
+func (m *MockOrderRepository) StartCheckout(ctx context.Context, customerID int) (*model.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StartCheckout", ctx, customerID)
+	ret0, _ := ret[0].(*model.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StartCheckout indicates an expected call of StartCheckout.
+func (mr *MockOrderRepositoryMockRecorder) StartCheckout(ctx, customerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartCheckout", reflect.TypeOf((*MockOrderRepository)(nil).StartCheckout), ctx, customerID)
+}
+
+// StartRefund mocks base method.
+func (m *MockOrderRepository) StartRefund(ctx context.Context, refund *model.Refund, transition *model.OrderStateTransition) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StartRefund", ctx, refund, transition)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StartRefund indicates an expected call of StartRefund.
+func (mr *MockOrderRepositoryMockRecorder) StartRefund(ctx, refund, transition interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartRefund", reflect.TypeOf((*MockOrderRepository)(nil).StartRefund), ctx, refund, transition)
+}
+
+// TransitionOrder mocks base method.
+func (m *MockOrderRepository) TransitionOrder(ctx context.Context, transition *model.OrderStateTransition) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransitionOrder", ctx, transition)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TransitionOrder indicates an expected call of TransitionOrder.
+func (mr *MockOrderRepositoryMockRecorder) TransitionOrder(ctx, transition interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveFromCart", reflect.TypeOf((*MockOrderRepository)(nil).RemoveFromCart), orderId, bookId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransitionOrder", reflect.TypeOf((*MockOrderRepository)(nil).TransitionOrder), ctx, transition)
 }
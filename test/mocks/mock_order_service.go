@@ -7,6 +7,8 @@ package mocks
 import (
 	request "bookstore/internal/handler/request"
 	model "bookstore/internal/model"
+	payment "bookstore/internal/payment"
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -36,88 +38,179 @@ func (m *MockOrderService) EXPECT() *MockOrderServiceMockRecorder {
 }
 
 // AddToCart mocks base method.
-func (m *MockOrderService) AddToCart(customerID int, request request.AddToCartRequest) error {
+func (m *MockOrderService) AddToCart(ctx context.Context, customerID int, request request.AddToCartRequest) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddToCart", customerID, request)
+	ret := m.ctrl.Call(m, "AddToCart", ctx, customerID, request)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // AddToCart indicates an expected call of AddToCart.
-func (mr *MockOrderServiceMockRecorder) AddToCart(customerID, request interface{}) *gomock.Call {
+func (mr *MockOrderServiceMockRecorder) AddToCart(ctx, customerID, request interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddToCart", reflect.TypeOf((*MockOrderService)(nil).AddToCart), customerID, request)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddToCart", reflect.TypeOf((*MockOrderService)(nil).AddToCart), ctx, customerID, request)
+}
+
+// AdvanceOrder mocks base method.
+func (m *MockOrderService) AdvanceOrder(ctx context.Context, orderID int, to model.OrderState, actorID int, note string) (*model.OrderStateTransition, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AdvanceOrder", ctx, orderID, to, actorID, note)
+	ret0, _ := ret[0].(*model.OrderStateTransition)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AdvanceOrder indicates an expected call of AdvanceOrder.
+func (mr *MockOrderServiceMockRecorder) AdvanceOrder(ctx, orderID, to, actorID, note interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AdvanceOrder", reflect.TypeOf((*MockOrderService)(nil).AdvanceOrder), ctx, orderID, to, actorID, note)
+}
+
+// CancelOrder mocks base method.
+func (m *MockOrderService) CancelOrder(ctx context.Context, customerID, orderID int) (*model.Refund, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelOrder", ctx, customerID, orderID)
+	ret0, _ := ret[0].(*model.Refund)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelOrder indicates an expected call of CancelOrder.
+func (mr *MockOrderServiceMockRecorder) CancelOrder(ctx, customerID, orderID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelOrder", reflect.TypeOf((*MockOrderService)(nil).CancelOrder), ctx, customerID, orderID)
+}
+
+// CancelPendingOrder mocks base method.
+func (m *MockOrderService) CancelPendingOrder(ctx context.Context, orderID, actorID int, note string) (*model.OrderStateTransition, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelPendingOrder", ctx, orderID, actorID, note)
+	ret0, _ := ret[0].(*model.OrderStateTransition)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelPendingOrder indicates an expected call of CancelPendingOrder.
+func (mr *MockOrderServiceMockRecorder) CancelPendingOrder(ctx, orderID, actorID, note interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelPendingOrder", reflect.TypeOf((*MockOrderService)(nil).CancelPendingOrder), ctx, orderID, actorID, note)
 }
 
 // CreateOrderIfNotExists mocks base method.
-func (m *MockOrderService) CreateOrderIfNotExists(customerID int) (int, error) {
+func (m *MockOrderService) CreateOrderIfNotExists(ctx context.Context, customerID int) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateOrderIfNotExists", customerID)
+	ret := m.ctrl.Call(m, "CreateOrderIfNotExists", ctx, customerID)
 	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateOrderIfNotExists indicates an expected call of CreateOrderIfNotExists.
-func (mr *MockOrderServiceMockRecorder) CreateOrderIfNotExists(customerID interface{}) *gomock.Call {
+func (mr *MockOrderServiceMockRecorder) CreateOrderIfNotExists(ctx, customerID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrderIfNotExists", reflect.TypeOf((*MockOrderService)(nil).CreateOrderIfNotExists), customerID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrderIfNotExists", reflect.TypeOf((*MockOrderService)(nil).CreateOrderIfNotExists), ctx, customerID)
 }
 
 // GetCart mocks base method.
-func (m *MockOrderService) GetCart(customerID int) (*model.OrderResponse, error) {
+func (m *MockOrderService) GetCart(ctx context.Context, customerID int) (*model.OrderResponse, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCart", customerID)
+	ret := m.ctrl.Call(m, "GetCart", ctx, customerID)
 	ret0, _ := ret[0].(*model.OrderResponse)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetCart indicates an expected call of GetCart.
-func (mr *MockOrderServiceMockRecorder) GetCart(customerID interface{}) *gomock.Call {
+func (mr *MockOrderServiceMockRecorder) GetCart(ctx, customerID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCart", reflect.TypeOf((*MockOrderService)(nil).GetCart), customerID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCart", reflect.TypeOf((*MockOrderService)(nil).GetCart), ctx, customerID)
 }
 
 // GetOrderHistory mocks base method.
-func (m *MockOrderService) GetOrderHistory(customerID int, request request.HistoryRequest) ([]model.OrderResponse, error) {
+func (m *MockOrderService) GetOrderHistory(ctx context.Context, customerID int, query model.OrderHistoryQuery) (*model.OrderHistoryPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOrderHistory", customerID, request)
-	ret0, _ := ret[0].([]model.OrderResponse)
+	ret := m.ctrl.Call(m, "GetOrderHistory", ctx, customerID, query)
+	ret0, _ := ret[0].(*model.OrderHistoryPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetOrderHistory indicates an expected call of GetOrderHistory.
-func (mr *MockOrderServiceMockRecorder) GetOrderHistory(customerID, request interface{}) *gomock.Call {
+func (mr *MockOrderServiceMockRecorder) GetOrderHistory(ctx, customerID, query interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderHistory", reflect.TypeOf((*MockOrderService)(nil).GetOrderHistory), customerID, request)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderHistory", reflect.TypeOf((*MockOrderService)(nil).GetOrderHistory), ctx, customerID, query)
+}
+
+// GetOrderTransitions mocks base method.
+func (m *MockOrderService) GetOrderTransitions(ctx context.Context, orderID int) ([]model.OrderStateTransition, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrderTransitions", ctx, orderID)
+	ret0, _ := ret[0].([]model.OrderStateTransition)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderTransitions indicates an expected call of GetOrderTransitions.
+func (mr *MockOrderServiceMockRecorder) GetOrderTransitions(ctx, orderID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderTransitions", reflect.TypeOf((*MockOrderService)(nil).GetOrderTransitions), ctx, orderID)
 }
 
 // PayOrder mocks base method.
-func (m *MockOrderService) PayOrder(customerID int) error {
+func (m *MockOrderService) PayOrder(ctx context.Context, customerID int, card payment.Card) (*model.PaidOrder, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PayOrder", customerID)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "PayOrder", ctx, customerID, card)
+	ret0, _ := ret[0].(*model.PaidOrder)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // PayOrder indicates an expected call of PayOrder.
-func (mr *MockOrderServiceMockRecorder) PayOrder(customerID interface{}) *gomock.Call {
+func (mr *MockOrderServiceMockRecorder) PayOrder(ctx, customerID, card interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PayOrder", reflect.TypeOf((*MockOrderService)(nil).PayOrder), ctx, customerID, card)
+}
+
+// RefundOrder mocks base method.
+func (m *MockOrderService) RefundOrder(ctx context.Context, orderID, actorID int, request request.RefundOrderRequest) (*model.Refund, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefundOrder", ctx, orderID, actorID, request)
+	ret0, _ := ret[0].(*model.Refund)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefundOrder indicates an expected call of RefundOrder.
+func (mr *MockOrderServiceMockRecorder) RefundOrder(ctx, orderID, actorID, request interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PayOrder", reflect.TypeOf((*MockOrderService)(nil).PayOrder), customerID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefundOrder", reflect.TypeOf((*MockOrderService)(nil).RefundOrder), ctx, orderID, actorID, request)
 }
 
 // RemoveFromCart mocks base method.
-func (m *MockOrderService) RemoveFromCart(customerID, bookId int) error {
+func (m *MockOrderService) RemoveFromCart(ctx context.Context, customerID, bookId int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "RemoveFromCart", customerID, bookId)
+	ret := m.ctrl.Call(m, "RemoveFromCart", ctx, customerID, bookId)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // RemoveFromCart indicates an expected call of RemoveFromCart.
-func (mr *MockOrderServiceMockRecorder) RemoveFromCart(customerID, bookId interface{}) *gomock.Call {
+func (mr *MockOrderServiceMockRecorder) RemoveFromCart(ctx, customerID, bookId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveFromCart", reflect.TypeOf((*MockOrderService)(nil).RemoveFromCart), ctx, customerID, bookId)
+}
+
+// RepriceCart mocks base method.
+func (m *MockOrderService) RepriceCart(ctx context.Context, customerID int) (*model.OrderResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RepriceCart", ctx, customerID)
+	ret0, _ := ret[0].(*model.OrderResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RepriceCart indicates an expected call of RepriceCart.
+func (mr *MockOrderServiceMockRecorder) RepriceCart(ctx, customerID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveFromCart", reflect.TypeOf((*MockOrderService)(nil).RemoveFromCart), customerID, bookId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RepriceCart", reflect.TypeOf((*MockOrderService)(nil).RepriceCart), ctx, customerID)
 }
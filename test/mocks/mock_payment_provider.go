@@ -0,0 +1,108 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/payment/provider.go
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	payment "bookstore/internal/payment"
+	money "bookstore/pkg/money"
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockPaymentProvider is a mock of PaymentProvider interface.
+type MockPaymentProvider struct {
+	ctrl     *gomock.Controller
+	recorder *MockPaymentProviderMockRecorder
+}
+
+// MockPaymentProviderMockRecorder is the mock recorder for MockPaymentProvider.
+type MockPaymentProviderMockRecorder struct {
+	mock *MockPaymentProvider
+}
+
+// NewMockPaymentProvider creates a new mock instance.
+func NewMockPaymentProvider(ctrl *gomock.Controller) *MockPaymentProvider {
+	mock := &MockPaymentProvider{ctrl: ctrl}
+	mock.recorder = &MockPaymentProviderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPaymentProvider) EXPECT() *MockPaymentProviderMockRecorder {
+	return m.recorder
+}
+
+// Authorize mocks base method.
+func (m *MockPaymentProvider) Authorize(ctx context.Context, request payment.AuthorizeRequest) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Authorize", ctx, request)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Authorize indicates an expected call of Authorize.
+func (mr *MockPaymentProviderMockRecorder) Authorize(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Authorize", reflect.TypeOf((*MockPaymentProvider)(nil).Authorize), ctx, request)
+}
+
+// Capture mocks base method.
+func (m *MockPaymentProvider) Capture(ctx context.Context, reference string, amount money.Money) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Capture", ctx, reference, amount)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Capture indicates an expected call of Capture.
+func (mr *MockPaymentProviderMockRecorder) Capture(ctx, reference, amount interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Capture", reflect.TypeOf((*MockPaymentProvider)(nil).Capture), ctx, reference, amount)
+}
+
+// Name mocks base method.
+func (m *MockPaymentProvider) Name() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Name")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// Name indicates an expected call of Name.
+func (mr *MockPaymentProviderMockRecorder) Name() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Name", reflect.TypeOf((*MockPaymentProvider)(nil).Name))
+}
+
+// Refund mocks base method.
+func (m *MockPaymentProvider) Refund(ctx context.Context, reference string, amount money.Money) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Refund", ctx, reference, amount)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Refund indicates an expected call of Refund.
+func (mr *MockPaymentProviderMockRecorder) Refund(ctx, reference, amount interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Refund", reflect.TypeOf((*MockPaymentProvider)(nil).Refund), ctx, reference, amount)
+}
+
+// Void mocks base method.
+func (m *MockPaymentProvider) Void(ctx context.Context, reference string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Void", ctx, reference)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Void indicates an expected call of Void.
+func (mr *MockPaymentProviderMockRecorder) Void(ctx, reference interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Void", reflect.TypeOf((*MockPaymentProvider)(nil).Void), ctx, reference)
+}
@@ -0,0 +1,79 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/repository/payment_repository.go
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	model "bookstore/internal/model"
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockPaymentRepository is a mock of PaymentRepository interface.
+type MockPaymentRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockPaymentRepositoryMockRecorder
+}
+
+// MockPaymentRepositoryMockRecorder is the mock recorder for MockPaymentRepository.
+type MockPaymentRepositoryMockRecorder struct {
+	mock *MockPaymentRepository
+}
+
+// NewMockPaymentRepository creates a new mock instance.
+func NewMockPaymentRepository(ctrl *gomock.Controller) *MockPaymentRepository {
+	mock := &MockPaymentRepository{ctrl: ctrl}
+	mock.recorder = &MockPaymentRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPaymentRepository) EXPECT() *MockPaymentRepositoryMockRecorder {
+	return m.recorder
+}
+
+// CreatePayment mocks base method.
+func (m *MockPaymentRepository) CreatePayment(ctx context.Context, payment *model.Payment) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreatePayment", ctx, payment)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreatePayment indicates an expected call of CreatePayment.
+func (mr *MockPaymentRepositoryMockRecorder) CreatePayment(ctx, payment interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePayment", reflect.TypeOf((*MockPaymentRepository)(nil).CreatePayment), ctx, payment)
+}
+
+// GetCapturedPayment mocks base method.
+func (m *MockPaymentRepository) GetCapturedPayment(ctx context.Context, orderID int) (*model.Payment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCapturedPayment", ctx, orderID)
+	ret0, _ := ret[0].(*model.Payment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCapturedPayment indicates an expected call of GetCapturedPayment.
+func (mr *MockPaymentRepositoryMockRecorder) GetCapturedPayment(ctx, orderID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCapturedPayment", reflect.TypeOf((*MockPaymentRepository)(nil).GetCapturedPayment), ctx, orderID)
+}
+
+// UpdatePayment mocks base method.
+func (m *MockPaymentRepository) UpdatePayment(ctx context.Context, payment *model.Payment) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePayment", ctx, payment)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePayment indicates an expected call of UpdatePayment.
+func (mr *MockPaymentRepositoryMockRecorder) UpdatePayment(ctx, payment interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePayment", reflect.TypeOf((*MockPaymentRepository)(nil).UpdatePayment), ctx, payment)
+}
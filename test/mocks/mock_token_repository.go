@@ -0,0 +1,195 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/repository/token_repository.go
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	model "bookstore/internal/model"
+	context "context"
+	sql "database/sql"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockTokenRepository is a mock of TokenRepository interface.
+type MockTokenRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockTokenRepositoryMockRecorder
+}
+
+// MockTokenRepositoryMockRecorder is the mock recorder for MockTokenRepository.
+type MockTokenRepositoryMockRecorder struct {
+	mock *MockTokenRepository
+}
+
+// NewMockTokenRepository creates a new mock instance.
+func NewMockTokenRepository(ctrl *gomock.Controller) *MockTokenRepository {
+	mock := &MockTokenRepository{ctrl: ctrl}
+	mock.recorder = &MockTokenRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTokenRepository) EXPECT() *MockTokenRepositoryMockRecorder {
+	return m.recorder
+}
+
+// CreateRefreshToken mocks base method.
+func (m *MockTokenRepository) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRefreshToken", ctx, token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateRefreshToken indicates an expected call of CreateRefreshToken.
+func (mr *MockTokenRepositoryMockRecorder) CreateRefreshToken(ctx, token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRefreshToken", reflect.TypeOf((*MockTokenRepository)(nil).CreateRefreshToken), ctx, token)
+}
+
+// DeleteExpiredTokens mocks base method.
+func (m *MockTokenRepository) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredTokens", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredTokens indicates an expected call of DeleteExpiredTokens.
+func (mr *MockTokenRepositoryMockRecorder) DeleteExpiredTokens(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredTokens", reflect.TypeOf((*MockTokenRepository)(nil).DeleteExpiredTokens), ctx)
+}
+
+// GetRefreshToken mocks base method.
+func (m *MockTokenRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRefreshToken", ctx, tokenHash)
+	ret0, _ := ret[0].(*model.RefreshToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRefreshToken indicates an expected call of GetRefreshToken.
+func (mr *MockTokenRepositoryMockRecorder) GetRefreshToken(ctx, tokenHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRefreshToken", reflect.TypeOf((*MockTokenRepository)(nil).GetRefreshToken), ctx, tokenHash)
+}
+
+// IsTokenRevoked mocks base method.
+func (m *MockTokenRepository) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsTokenRevoked", ctx, jti)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsTokenRevoked indicates an expected call of IsTokenRevoked.
+func (mr *MockTokenRepositoryMockRecorder) IsTokenRevoked(ctx, jti interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTokenRevoked", reflect.TypeOf((*MockTokenRepository)(nil).IsTokenRevoked), ctx, jti)
+}
+
+// RevokeAccessToken mocks base method.
+func (m *MockTokenRepository) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAccessToken", ctx, jti, expiresAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeAccessToken indicates an expected call of RevokeAccessToken.
+func (mr *MockTokenRepositoryMockRecorder) RevokeAccessToken(ctx, jti, expiresAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAccessToken", reflect.TypeOf((*MockTokenRepository)(nil).RevokeAccessToken), ctx, jti, expiresAt)
+}
+
+// RevokeCustomerTokens mocks base method.
+func (m *MockTokenRepository) RevokeCustomerTokens(ctx context.Context, customerID int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeCustomerTokens", ctx, customerID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeCustomerTokens indicates an expected call of RevokeCustomerTokens.
+func (mr *MockTokenRepositoryMockRecorder) RevokeCustomerTokens(ctx, customerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeCustomerTokens", reflect.TypeOf((*MockTokenRepository)(nil).RevokeCustomerTokens), ctx, customerID)
+}
+
+// RevokeTokenFamily mocks base method.
+func (m *MockTokenRepository) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeTokenFamily", ctx, familyID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeTokenFamily indicates an expected call of RevokeTokenFamily.
+func (mr *MockTokenRepositoryMockRecorder) RevokeTokenFamily(ctx, familyID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeTokenFamily", reflect.TypeOf((*MockTokenRepository)(nil).RevokeTokenFamily), ctx, familyID)
+}
+
+// RotateRefreshToken mocks base method.
+func (m *MockTokenRepository) RotateRefreshToken(ctx context.Context, usedID int64, next *model.RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateRefreshToken", ctx, usedID, next)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RotateRefreshToken indicates an expected call of RotateRefreshToken.
+func (mr *MockTokenRepositoryMockRecorder) RotateRefreshToken(ctx, usedID, next interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateRefreshToken", reflect.TypeOf((*MockTokenRepository)(nil).RotateRefreshToken), ctx, usedID, next)
+}
+
+// MockqueryRower is a mock of queryRower interface.
+type MockqueryRower struct {
+	ctrl     *gomock.Controller
+	recorder *MockqueryRowerMockRecorder
+}
+
+// MockqueryRowerMockRecorder is the mock recorder for MockqueryRower.
+type MockqueryRowerMockRecorder struct {
+	mock *MockqueryRower
+}
+
+// NewMockqueryRower creates a new mock instance.
+func NewMockqueryRower(ctrl *gomock.Controller) *MockqueryRower {
+	mock := &MockqueryRower{ctrl: ctrl}
+	mock.recorder = &MockqueryRowerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockqueryRower) EXPECT() *MockqueryRowerMockRecorder {
+	return m.recorder
+}
+
+// QueryRowContext mocks base method.
+func (m *MockqueryRower) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, query}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "QueryRowContext", varargs...)
+	ret0, _ := ret[0].(*sql.Row)
+	return ret0
+}
+
+// QueryRowContext indicates an expected call of QueryRowContext.
+func (mr *MockqueryRowerMockRecorder) QueryRowContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRowContext", reflect.TypeOf((*MockqueryRower)(nil).QueryRowContext), varargs...)
+}
@@ -0,0 +1,237 @@
+package money_test
+
+import (
+	"bookstore/pkg/money"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// boundedAmount keeps generated amounts far enough from int64 limits
+// that sums of many values cannot overflow.
+type boundedAmount int64
+
+func (boundedAmount) Generate(r *rand.Rand, _ int) reflect.Value {
+	return reflect.ValueOf(boundedAmount(r.Int63n(2_000_000_000_000) - 1_000_000_000_000))
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		currency string
+		expected int64
+	}{
+		{"9.99", money.USD, 999},
+		{"10.5", money.USD, 1050},
+		{"7", money.USD, 700},
+		{".5", money.USD, 50},
+		{"1.005", money.USD, 101},
+		{"1.004", money.USD, 100},
+		{"-2.345", money.USD, -235},
+		{"+3.10", money.USD, 310},
+		{"1500.4", "JPY", 1500},
+		{"1500.5", "JPY", 1501},
+		{"1.2345", "KWD", 1235},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			m, err := money.Parse(tt.input, tt.currency)
+			assert.NoError(t, err)
+			assert.Equal(t, money.New(tt.expected, tt.currency), m)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, input := range []string{"", ".", "abc", "1.2.3", "1,00", "--1", "9.9x"} {
+			_, err := money.Parse(input, money.USD)
+			assert.ErrorIs(t, err, money.ErrInvalidAmount, input)
+		}
+	})
+}
+
+func TestFromFloat(t *testing.T) {
+	// every one of these truncated to one cent less with int64(value * 100)
+	tests := map[float64]int64{
+		9.99:  999,
+		0.29:  29,
+		1.15:  115,
+		4.35:  435,
+		19.99: 1999,
+	}
+
+	for value, expected := range tests {
+		m, err := money.FromFloat(value, money.USD)
+		assert.NoError(t, err)
+		assert.Equal(t, money.New(expected, money.USD), m)
+	}
+
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		_, err := money.FromFloat(value, money.USD)
+		assert.ErrorIs(t, err, money.ErrInvalidAmount)
+	}
+}
+
+func TestString(t *testing.T) {
+	assert.Equal(t, "9.99", money.New(999, money.USD).String())
+	assert.Equal(t, "0.05", money.New(5, money.USD).String())
+	assert.Equal(t, "-0.50", money.New(-50, money.USD).String())
+	assert.Equal(t, "0.00", money.Money{}.String())
+	assert.Equal(t, "1500", money.New(1500, "JPY").String())
+	assert.Equal(t, "1.234", money.New(1234, "KWD").String())
+}
+
+func TestJSON(t *testing.T) {
+	t.Run("marshals as decimal string", func(t *testing.T) {
+		data, err := json.Marshal(struct {
+			Price money.Money `json:"price"`
+		}{money.New(999, money.USD)})
+
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"price":"9.99"}`, string(data))
+	})
+
+	t.Run("accepts string and number", func(t *testing.T) {
+		var fromString, fromNumber money.Money
+		assert.NoError(t, json.Unmarshal([]byte(`"9.99"`), &fromString))
+		assert.NoError(t, json.Unmarshal([]byte(`9.99`), &fromNumber))
+
+		assert.Equal(t, money.New(999, money.USD), fromString)
+		assert.Equal(t, fromString, fromNumber)
+	})
+
+	t.Run("rejects garbage", func(t *testing.T) {
+		var m money.Money
+		assert.Error(t, json.Unmarshal([]byte(`true`), &m))
+		assert.Error(t, json.Unmarshal([]byte(`"ten"`), &m))
+	})
+}
+
+func TestScanAndValue(t *testing.T) {
+	var m money.Money
+
+	assert.NoError(t, m.Scan(int64(1299)))
+	assert.Equal(t, money.New(1299, money.USD), m)
+
+	assert.NoError(t, m.Scan([]byte("42")))
+	assert.Equal(t, money.New(42, money.USD), m)
+
+	// an order with no lines has a NULL total
+	assert.NoError(t, m.Scan(nil))
+	assert.Equal(t, money.New(0, money.USD), m)
+
+	assert.Error(t, m.Scan(1.5))
+
+	value, err := money.New(999, money.USD).Value()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(999), value)
+}
+
+func TestArithmetic(t *testing.T) {
+	price := money.New(999, money.USD)
+
+	assert.Equal(t, money.New(2997, money.USD), price.Mul(3))
+
+	sum, err := price.Add(money.New(1, money.USD))
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(1000, money.USD), sum)
+
+	diff, err := price.Sub(money.New(1000, money.USD))
+	assert.NoError(t, err)
+	assert.True(t, diff.IsNegative())
+
+	_, err = price.Add(money.New(100, "JPY"))
+	assert.ErrorIs(t, err, money.ErrCurrencyMismatch)
+
+	_, err = money.Sum(money.USD, price, money.New(100, "JPY"))
+	assert.ErrorIs(t, err, money.ErrCurrencyMismatch)
+}
+
+func TestPropertyStringRoundTrip(t *testing.T) {
+	property := func(amount boundedAmount) bool {
+		m := money.New(int64(amount), money.USD)
+		parsed, err := money.Parse(m.String(), money.USD)
+		return err == nil && parsed == m
+	}
+
+	assert.NoError(t, quick.Check(property, nil))
+}
+
+func TestPropertyJSONRoundTrip(t *testing.T) {
+	property := func(amount boundedAmount) bool {
+		m := money.New(int64(amount), money.USD)
+		data, err := json.Marshal(m)
+		if err != nil {
+			return false
+		}
+
+		var decoded money.Money
+		return json.Unmarshal(data, &decoded) == nil && decoded == m
+	}
+
+	assert.NoError(t, quick.Check(property, nil))
+}
+
+func TestPropertySQLRoundTrip(t *testing.T) {
+	property := func(amount boundedAmount) bool {
+		m := money.New(int64(amount), money.USD)
+		value, err := m.Value()
+		if err != nil {
+			return false
+		}
+
+		var scanned money.Money
+		return scanned.Scan(value) == nil && scanned == m
+	}
+
+	assert.NoError(t, quick.Check(property, nil))
+}
+
+func TestPropertySumMatchesMinorUnits(t *testing.T) {
+	property := func(amounts []boundedAmount) bool {
+		values := make([]money.Money, len(amounts))
+		var expected int64
+		for i, amount := range amounts {
+			values[i] = money.New(int64(amount), money.USD)
+			expected += int64(amount)
+		}
+
+		total, err := money.Sum(money.USD, values...)
+		return err == nil && total == money.New(expected, money.USD)
+	}
+
+	assert.NoError(t, quick.Check(property, nil))
+}
+
+func TestPropertySumOfParsedPricesDoesNotDrift(t *testing.T) {
+	// summing decimal prices as float64 drifts after a handful of additions,
+	// summing parsed Money must stay exact however many lines are added
+	property := func(cents []uint16) bool {
+		total := money.New(0, money.USD)
+		var expected int64
+		for _, c := range cents {
+			price, err := money.Parse(money.New(int64(c), money.USD).String(), money.USD)
+			if err != nil {
+				return false
+			}
+			if total, err = total.Add(price); err != nil {
+				return false
+			}
+			expected += int64(c)
+		}
+		return total.Amount == expected
+	}
+
+	assert.NoError(t, quick.Check(property, nil))
+
+	dime, err := money.FromFloat(0.1, money.USD)
+	assert.NoError(t, err)
+	total, err := money.Sum(money.USD, dime, dime, dime, dime, dime, dime, dime, dime, dime, dime)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.00", total.String())
+}
@@ -0,0 +1,113 @@
+package payment_test
+
+import (
+	"bookstore/internal/payment"
+	"bookstore/pkg/money"
+	"bookstore/pkg/utils"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeProvider_Authorize(t *testing.T) {
+	provider := payment.NewFakeProvider()
+	amount := money.New(2500, money.USD)
+
+	authorize := func(card string) (string, error) {
+		return provider.Authorize(context.Background(), payment.AuthorizeRequest{
+			Reference: "order-1-payment-1",
+			Amount:    amount,
+			Card:      payment.Card{Number: card},
+		})
+	}
+
+	t.Run("success card", func(t *testing.T) {
+		reference, err := authorize("4242 4242 4242 4242")
+
+		assert.NoError(t, err)
+		assert.NotEmpty(t, reference)
+	})
+
+	t.Run("deterministic failures", func(t *testing.T) {
+		for card, expected := range map[string]error{
+			payment.FakeCardDeclined: utils.ErrPaymentDeclined,
+			payment.FakeCardTimeout:  utils.ErrPaymentTimeout,
+			"5555555555554444":       utils.ErrPaymentDeclined,
+		} {
+			reference, err := authorize(card)
+
+			assert.ErrorIs(t, err, expected, card)
+			assert.Empty(t, reference)
+		}
+	})
+
+	t.Run("zero amount", func(t *testing.T) {
+		_, err := provider.Authorize(context.Background(), payment.AuthorizeRequest{
+			Amount: money.New(0, money.USD),
+			Card:   payment.Card{Number: payment.FakeCardSuccess},
+		})
+
+		assert.ErrorIs(t, err, utils.ErrPaymentFailed)
+	})
+}
+
+func TestFakeProvider_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	amount := money.New(2500, money.USD)
+
+	authorize := func(provider *payment.FakeProvider) string {
+		reference, err := provider.Authorize(ctx, payment.AuthorizeRequest{
+			Amount: amount,
+			Card:   payment.Card{Number: payment.FakeCardSuccess},
+		})
+		assert.NoError(t, err)
+		return reference
+	}
+
+	t.Run("capture then refund in parts", func(t *testing.T) {
+		provider := payment.NewFakeProvider()
+		reference := authorize(provider)
+
+		assert.NoError(t, provider.Capture(ctx, reference, amount))
+		assert.ErrorIs(t, provider.Capture(ctx, reference, amount), utils.ErrPaymentFailed)
+		assert.ErrorIs(t, provider.Void(ctx, reference), utils.ErrPaymentFailed)
+
+		assert.NoError(t, provider.Refund(ctx, reference, money.New(1000, money.USD)))
+		assert.NoError(t, provider.Refund(ctx, reference, money.New(1500, money.USD)))
+		assert.ErrorIs(t, provider.Refund(ctx, reference, money.New(1, money.USD)), utils.ErrPaymentFailed)
+	})
+
+	t.Run("capture must match the authorized amount", func(t *testing.T) {
+		provider := payment.NewFakeProvider()
+		reference := authorize(provider)
+
+		err := provider.Capture(ctx, reference, money.New(2600, money.USD))
+
+		assert.ErrorIs(t, err, utils.ErrPaymentFailed)
+	})
+
+	t.Run("voided authorization cannot be captured or refunded", func(t *testing.T) {
+		provider := payment.NewFakeProvider()
+		reference := authorize(provider)
+
+		assert.NoError(t, provider.Void(ctx, reference))
+		assert.ErrorIs(t, provider.Capture(ctx, reference, amount), utils.ErrPaymentFailed)
+		assert.ErrorIs(t, provider.Refund(ctx, reference, amount), utils.ErrPaymentFailed)
+	})
+
+	t.Run("unknown reference", func(t *testing.T) {
+		provider := payment.NewFakeProvider()
+
+		assert.ErrorIs(t, provider.Capture(ctx, "fake_999999", amount), utils.ErrPaymentFailed)
+	})
+}
+
+func TestNewProvider(t *testing.T) {
+	provider, err := payment.NewProvider("")
+	assert.NoError(t, err)
+	assert.Equal(t, payment.FakeProviderName, provider.Name())
+
+	_, err = payment.NewProvider("stripe")
+	assert.ErrorIs(t, err, utils.ErrUnknownPaymentProvider)
+}
@@ -3,10 +3,14 @@ package repository_test
 import (
 	"bookstore/internal/model"
 	"bookstore/internal/repository"
+	"bookstore/pkg/money"
 	"bookstore/pkg/utils"
+	"context"
 	"database/sql"
 	"errors"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -20,23 +24,23 @@ func TestCreateBook(t *testing.T) {
 	bookRepo := repository.NewBookRepository(db)
 
 	t.Run("success", func(t *testing.T) {
-		book := &model.Book{Title: "Test Book", Author: "Author", Price: 10.5}
-		mock.ExpectQuery("INSERT INTO books").
-			WithArgs(book.Title, book.Author, utils.ConvertStorePrice(&book.Price)).
-			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		book := &model.Book{Title: "Test Book", Author: "Author", Price: money.New(1050, money.USD)}
+		mock.ExpectExec("INSERT INTO books").
+			WithArgs(book.Title, book.Author, book.Price).
+			WillReturnResult(sqlmock.NewResult(1, 1))
 
-		err := bookRepo.CreateBook(book)
+		err := bookRepo.CreateBook(context.Background(), book)
 		assert.NoError(t, err)
 
 	})
 
 	t.Run("error on insert", func(t *testing.T) {
-		book := &model.Book{Title: "Test Book", Author: "Author", Price: 10.5}
-		mock.ExpectQuery("INSERT INTO books").
-			WithArgs(book.Title, book.Author, utils.ConvertStorePrice(&book.Price)).
+		book := &model.Book{Title: "Test Book", Author: "Author", Price: money.New(1050, money.USD)}
+		mock.ExpectExec("INSERT INTO books").
+			WithArgs(book.Title, book.Author, book.Price).
 			WillReturnError(errors.New("insert error"))
 
-		err := bookRepo.CreateBook(book)
+		err := bookRepo.CreateBook(context.Background(), book)
 		assert.Error(t, err)
 	})
 }
@@ -48,25 +52,206 @@ func TestGetBooks(t *testing.T) {
 
 	bookRepo := repository.NewBookRepository(db)
 
-	t.Run("success", func(t *testing.T) {
-		rows := sqlmock.NewRows([]string{"id", "title", "author", "price"}).
-			AddRow(1, "Book 1", "Author 1", 1000).
-			AddRow(2, "Book 2", "Author 2", 2000)
-		mock.ExpectQuery("SELECT id, title, author, price FROM books").
-			WillReturnRows(rows)
+	columns := []string{"id", "title", "author", "price", "stock"}
+
+	t.Run("first page with next cursor", func(t *testing.T) {
+		query := model.BookQuery{SortBy: model.BookSortID, SortDir: model.SortAsc, Limit: 2}
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM books WHERE archived_at IS NULL")).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+		mock.ExpectQuery(regexp.QuoteMeta(
+			"SELECT id, title, author, price, stock FROM books WHERE archived_at IS NULL ORDER BY id ASC LIMIT $1",
+		)).
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, "Book 1", "Author 1", 1000, 10).
+				AddRow(2, "Book 2", "Author 2", 2000, 10).
+				AddRow(3, "Book 3", "Author 3", 3000, 10))
+
+		page, err := bookRepo.GetBooks(context.Background(), query)
+		assert.NoError(t, err)
+		assert.Len(t, page.Books, 2)
+		assert.Equal(t, int64(5), page.Total)
+		assert.Equal(t, 2, page.Limit)
+		assert.NotEmpty(t, page.NextCursor)
+		assert.Equal(t, money.New(2000, money.USD), page.Books[1].Price)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("last page has no cursor", func(t *testing.T) {
+		query := model.BookQuery{SortBy: model.BookSortID, SortDir: model.SortAsc, Limit: 2}
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM books WHERE archived_at IS NULL")).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(regexp.QuoteMeta(
+			"SELECT id, title, author, price, stock FROM books WHERE archived_at IS NULL ORDER BY id ASC LIMIT $1",
+		)).
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "Book 1", "Author 1", 1000, 10))
+
+		page, err := bookRepo.GetBooks(context.Background(), query)
+		assert.NoError(t, err)
+		assert.Len(t, page.Books, 1)
+		assert.Empty(t, page.NextCursor)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("filters, sort and offset", func(t *testing.T) {
+		minPrice := money.New(500, money.USD)
+		maxPrice := money.New(1500, money.USD)
+		query := model.BookQuery{
+			Title:    "war",
+			Author:   "Tolstoy",
+			MinPrice: &minPrice,
+			MaxPrice: &maxPrice,
+			SortBy:   model.BookSortPrice,
+			SortDir:  model.SortDesc,
+			Limit:    10,
+			Offset:   20,
+		}
+
+		where := " WHERE archived_at IS NULL AND title ILIKE $1 AND author ILIKE $2 AND price >= $3 AND price <= $4"
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM books"+where)).
+			WithArgs("%war%", "%Tolstoy%", int64(500), int64(1500)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(21))
+		mock.ExpectQuery(regexp.QuoteMeta(
+			"SELECT id, title, author, price, stock FROM books"+where+
+				" ORDER BY price DESC, id DESC LIMIT $5 OFFSET $6",
+		)).
+			WithArgs("%war%", "%Tolstoy%", int64(500), int64(1500), 11, 20).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(5, "War and Peace", "Leo Tolstoy", 1299, 10))
+
+		page, err := bookRepo.GetBooks(context.Background(), query)
+		assert.NoError(t, err)
+		assert.Len(t, page.Books, 1)
+		assert.Equal(t, 20, page.Offset)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("search escapes like wildcards", func(t *testing.T) {
+		query := model.BookQuery{
+			Title:   `100%_\`,
+			SortBy:  model.BookSortID,
+			SortDir: model.SortAsc,
+			Limit:   1,
+		}
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM books WHERE archived_at IS NULL AND title ILIKE $1")).
+			WithArgs(`%100\%\_\\%`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(regexp.QuoteMeta(
+			"SELECT id, title, author, price, stock FROM books WHERE archived_at IS NULL AND title ILIKE $1 ORDER BY id ASC LIMIT $2",
+		)).
+			WithArgs(`%100\%\_\\%`, 2).
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		page, err := bookRepo.GetBooks(context.Background(), query)
+		assert.NoError(t, err)
+		assert.Empty(t, page.Books)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("cursor continues after last row", func(t *testing.T) {
+		query := model.BookQuery{SortBy: model.BookSortPrice, SortDir: model.SortAsc, Limit: 1}
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM books WHERE archived_at IS NULL")).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectQuery(regexp.QuoteMeta(
+			"SELECT id, title, author, price, stock FROM books WHERE archived_at IS NULL ORDER BY price ASC, id ASC LIMIT $1",
+		)).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(2, "To Kill a Mockingbird", "Harper Lee", 799, 10).
+				AddRow(1, "1984", "George Orwell", 999, 10))
+
+		first, err := bookRepo.GetBooks(context.Background(), query)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, first.NextCursor)
+
+		query.Cursor = first.NextCursor
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM books WHERE archived_at IS NULL")).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectQuery(regexp.QuoteMeta(
+			"SELECT id, title, author, price, stock FROM books WHERE archived_at IS NULL AND (price, id) > ($1, $2) ORDER BY price ASC, id ASC LIMIT $3",
+		)).
+			WithArgs(int64(799), int64(2), 2).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "1984", "George Orwell", 999, 10))
+
+		second, err := bookRepo.GetBooks(context.Background(), query)
+		assert.NoError(t, err)
+		assert.Len(t, second.Books, 1)
+		assert.Empty(t, second.NextCursor)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("cursor from another sort order is rejected", func(t *testing.T) {
+		query := model.BookQuery{SortBy: model.BookSortTitle, SortDir: model.SortAsc, Limit: 1}
 
-		books, err := bookRepo.GetBooks()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM books WHERE archived_at IS NULL")).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(regexp.QuoteMeta(
+			"SELECT id, title, author, price, stock FROM books WHERE archived_at IS NULL ORDER BY title ASC, id ASC LIMIT $1",
+		)).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, "1984", "George Orwell", 999, 10).
+				AddRow(3, "Moby Dick", "Herman Melville", 1199, 10))
+
+		page, err := bookRepo.GetBooks(context.Background(), query)
 		assert.NoError(t, err)
-		assert.Len(t, books, 2)
+
+		query.SortDir = model.SortDesc
+		query.Cursor = page.NextCursor
+
+		_, err = bookRepo.GetBooks(context.Background(), query)
+		assert.ErrorIs(t, err, utils.ErrInvalidCursor)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("malformed cursor", func(t *testing.T) {
+		query := model.BookQuery{
+			SortBy:  model.BookSortID,
+			SortDir: model.SortAsc,
+			Limit:   1,
+			Cursor:  "not a cursor",
+		}
+
+		_, err := bookRepo.GetBooks(context.Background(), query)
+		assert.ErrorIs(t, err, utils.ErrInvalidCursor)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown sort column never reaches sql", func(t *testing.T) {
+		query := model.BookQuery{SortBy: "price; DROP TABLE books", SortDir: model.SortAsc, Limit: 1}
+
+		_, err := bookRepo.GetBooks(context.Background(), query)
+		assert.ErrorIs(t, err, utils.ErrInvalidBookQuery)
+		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
 	t.Run("error on query", func(t *testing.T) {
-		mock.ExpectQuery("SELECT id, title, author, price FROM books").
+		query := model.BookQuery{SortBy: model.BookSortID, SortDir: model.SortAsc, Limit: 10}
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM books WHERE archived_at IS NULL")).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery("SELECT id, title, author, price, stock FROM books").
 			WillReturnError(errors.New("query error"))
 
-		books, err := bookRepo.GetBooks()
+		page, err := bookRepo.GetBooks(context.Background(), query)
+		assert.Error(t, err)
+		assert.Nil(t, page)
+	})
+
+	t.Run("error on count", func(t *testing.T) {
+		query := model.BookQuery{SortBy: model.BookSortID, SortDir: model.SortAsc, Limit: 10}
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM books WHERE archived_at IS NULL")).
+			WillReturnError(errors.New("count error"))
+
+		page, err := bookRepo.GetBooks(context.Background(), query)
 		assert.Error(t, err)
-		assert.Nil(t, books)
+		assert.Nil(t, page)
 	})
 }
 
@@ -78,37 +263,65 @@ func TestGetBookById(t *testing.T) {
 	bookRepo := repository.NewBookRepository(db)
 
 	t.Run("success", func(t *testing.T) {
-		rows := sqlmock.NewRows([]string{"id", "title", "author", "price"}).
-			AddRow(1, "Test Book", "Author", 1000)
-		mock.ExpectQuery("SELECT id, title, author, price FROM books WHERE id =").
+		rows := sqlmock.NewRows([]string{"id", "title", "author", "price", "stock", "archived_at"}).
+			AddRow(1, "Test Book", "Author", 1000, 10, nil)
+		mock.ExpectQuery("SELECT id, title, author, price, stock, archived_at FROM books WHERE id =").
 			WithArgs(1).
 			WillReturnRows(rows)
 
-		book, err := bookRepo.GetBookById(1)
+		book, err := bookRepo.GetBookById(context.Background(), 1)
 		assert.NoError(t, err)
 		assert.Equal(t, "Test Book", book.Title)
 	})
 
 	t.Run("book not found", func(t *testing.T) {
-		mock.ExpectQuery("SELECT id, title, author, price FROM books WHERE id =").
+		mock.ExpectQuery("SELECT id, title, author, price, stock, archived_at FROM books WHERE id =").
 			WithArgs(1).
 			WillReturnError(sql.ErrNoRows)
 
-		book, err := bookRepo.GetBookById(1)
+		book, err := bookRepo.GetBookById(context.Background(), 1)
 		assert.Error(t, err)
 		assert.Equal(t, "book not found", err.Error())
 		assert.Equal(t, int64(0), book.ID)
 	})
 
 	t.Run("error on query", func(t *testing.T) {
-		mock.ExpectQuery("SELECT id, title, author, price FROM books WHERE id =").
+		mock.ExpectQuery("SELECT id, title, author, price, stock, archived_at FROM books WHERE id =").
 			WithArgs(1).
 			WillReturnError(errors.New("query error"))
 
-		book, err := bookRepo.GetBookById(1)
+		book, err := bookRepo.GetBookById(context.Background(), 1)
 		assert.Error(t, err)
 		assert.Equal(t, int64(0), book.ID)
 	})
+
+	t.Run("cancelled by the caller", func(t *testing.T) {
+		mock.ExpectQuery("SELECT id, title, author, price, stock, archived_at FROM books WHERE id =").
+			WithArgs(1).
+			WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "price", "stock"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := bookRepo.GetBookById(ctx, 1)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("slow query times out", func(t *testing.T) {
+		repository.SetQueryTimeout(10 * time.Millisecond)
+		defer repository.SetQueryTimeout(repository.DefaultQueryTimeout)
+
+		mock.ExpectQuery("SELECT id, title, author, price, stock, archived_at FROM books WHERE id =").
+			WithArgs(1).
+			WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "price", "stock"}))
+
+		start := time.Now()
+		_, err := bookRepo.GetBookById(context.Background(), 1)
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
 }
 
 func TestUpdateBook(t *testing.T) {
@@ -119,33 +332,213 @@ func TestUpdateBook(t *testing.T) {
 	bookRepo := repository.NewBookRepository(db)
 
 	t.Run("success", func(t *testing.T) {
-		book := &model.Book{ID: 1, Title: "Updated Book", Author: "Updated Author", Price: 20.0}
+		book := &model.Book{ID: 1, Title: "Updated Book", Author: "Updated Author", Price: money.New(2000, money.USD)}
 		mock.ExpectQuery("UPDATE books SET").
-			WithArgs(book.Title, book.Author, utils.ConvertStorePrice(&book.Price), book.ID).
+			WithArgs(book.Title, book.Author, book.Price, book.ID).
 			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 
-		err := bookRepo.UpdateBook(book)
+		err := bookRepo.UpdateBook(context.Background(), book)
 		assert.NoError(t, err)
 	})
 
 	t.Run("book not found", func(t *testing.T) {
-		book := &model.Book{ID: 1, Title: "Updated Book", Author: "Updated Author", Price: 20.0}
+		book := &model.Book{ID: 1, Title: "Updated Book", Author: "Updated Author", Price: money.New(2000, money.USD)}
 		mock.ExpectQuery("UPDATE books SET").
-			WithArgs(book.Title, book.Author, utils.ConvertStorePrice(&book.Price), book.ID).
+			WithArgs(book.Title, book.Author, book.Price, book.ID).
 			WillReturnError(sql.ErrNoRows)
 
-		err := bookRepo.UpdateBook(book)
+		err := bookRepo.UpdateBook(context.Background(), book)
 		assert.Error(t, err)
 		assert.Equal(t, "book not found", err.Error())
 	})
 
 	t.Run("error on update", func(t *testing.T) {
-		book := &model.Book{ID: 1, Title: "Updated Book", Author: "Updated Author", Price: 20.0}
+		book := &model.Book{ID: 1, Title: "Updated Book", Author: "Updated Author", Price: money.New(2000, money.USD)}
 		mock.ExpectQuery("UPDATE books SET").
-			WithArgs(book.Title, book.Author, utils.ConvertStorePrice(&book.Price), book.ID).
+			WithArgs(book.Title, book.Author, book.Price, book.ID).
 			WillReturnError(errors.New("update error"))
 
-		err := bookRepo.UpdateBook(book)
+		err := bookRepo.UpdateBook(context.Background(), book)
 		assert.Error(t, err)
 	})
 }
+
+func TestAdjustStock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	bookRepo := repository.NewBookRepository(db)
+
+	lockQuery := regexp.QuoteMeta("SELECT stock FROM books WHERE id = $1 FOR UPDATE")
+	updateQuery := regexp.QuoteMeta("UPDATE books SET stock = $1 WHERE id = $2")
+	ledgerQuery := regexp.QuoteMeta("INSERT INTO stock_movements")
+
+	t.Run("success", func(t *testing.T) {
+		movement := &model.StockMovement{
+			BookID: 1,
+			Change: -2,
+			Reason: model.StockReasonDamaged,
+			Note:   "water damage",
+		}
+		createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(10))
+		mock.ExpectExec(updateQuery).
+			WithArgs(int64(8), int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(ledgerQuery).
+			WithArgs(int64(1), int64(-2), model.StockReasonDamaged, nil, "water damage", int64(8)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, createdAt))
+		mock.ExpectCommit()
+
+		err := bookRepo.AdjustStock(context.Background(), movement)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), movement.ID)
+		assert.Equal(t, int64(8), movement.Stock)
+		assert.Equal(t, createdAt, movement.CreatedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("book not found", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(int64(1)).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		err := bookRepo.AdjustStock(context.Background(), &model.StockMovement{BookID: 1, Change: 1})
+		assert.ErrorIs(t, err, utils.ErrBookNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("stock cannot go negative", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(1))
+		mock.ExpectRollback()
+
+		err := bookRepo.AdjustStock(context.Background(), &model.StockMovement{BookID: 1, Change: -2})
+		assert.ErrorIs(t, err, utils.ErrInsufficientStock)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("error recording movement", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"stock"}).AddRow(1))
+		mock.ExpectExec(updateQuery).
+			WithArgs(int64(6), int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(ledgerQuery).
+			WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		err := bookRepo.AdjustStock(context.Background(), &model.StockMovement{BookID: 1, Change: 5})
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestArchiveAndRestoreBook(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	bookRepo := repository.NewBookRepository(db)
+
+	columns := []string{"id", "title", "author", "price", "stock", "archived_at"}
+	archivedAt := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	t.Run("archive keeps the first archive time", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET archived_at = COALESCE(archived_at, NOW()) WHERE id = $1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "1984", "George Orwell", 999, 3, archivedAt))
+
+		book, err := bookRepo.ArchiveBook(context.Background(), 1)
+
+		assert.NoError(t, err)
+		assert.Equal(t, archivedAt, *book.ArchivedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("archive unknown book", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET archived_at")).
+			WithArgs(9).
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := bookRepo.ArchiveBook(context.Background(), 9)
+
+		assert.ErrorIs(t, err, utils.ErrBookNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET archived_at = NULL WHERE id = $1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "1984", "George Orwell", 999, 3, nil))
+
+		book, err := bookRepo.RestoreBook(context.Background(), 1)
+
+		assert.NoError(t, err)
+		assert.Nil(t, book.ArchivedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeleteBook(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	bookRepo := repository.NewBookRepository(db)
+
+	lockQuery := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM order_details WHERE book_id = b.id)
+	FROM books b
+	WHERE b.id = $1
+	FOR UPDATE`)
+
+	t.Run("unused book is deleted with its ledger", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM stock_movements WHERE book_id = $1")).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM books WHERE id = $1")).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := bookRepo.DeleteBook(context.Background(), 1)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("book referenced by an order", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		err := bookRepo.DeleteBook(context.Background(), 1)
+
+		assert.ErrorIs(t, err, utils.ErrBookReferenced)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("book not found", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(9).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		err := bookRepo.DeleteBook(context.Background(), 9)
+
+		assert.ErrorIs(t, err, utils.ErrBookNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -4,8 +4,12 @@ import (
 	"bookstore/internal/model"
 	"bookstore/internal/repository"
 	"bookstore/pkg/utils"
+	"context"
 	"database/sql"
+	"errors"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -30,7 +34,7 @@ func TestCustomerRepository_Register(t *testing.T) {
 			WithArgs(customer.Email, customer.Password, customer.Name, customer.Address).
 			WillReturnResult(sqlmock.NewResult(1, 1))
 
-		err := customerRepo.Register(customer)
+		err := customerRepo.Register(context.Background(), customer)
 
 		assert.NoError(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
@@ -42,7 +46,7 @@ func TestCustomerRepository_Register(t *testing.T) {
 			WithArgs(customer.Email, customer.Password, customer.Name, customer.Address).
 			WillReturnError(utils.ErrDuplicateEmail)
 
-		err := customerRepo.Register(customer)
+		err := customerRepo.Register(context.Background(), customer)
 
 		assert.Error(t, err)
 		assert.EqualError(t, err, utils.ErrDuplicateEmail.Error())
@@ -56,7 +60,7 @@ func TestCustomerRepository_Login(t *testing.T) {
 	defer db.Close()
 
 	customerRepo := repository.NewCustomerRepository(db)
-	query := "SELECT id, email, password FROM customers WHERE email = ?"
+	query := "SELECT id, email, password, role FROM customers WHERE email = ?"
 
 	t.Run("successful login", func(t *testing.T) {
 		email := "test@example.com"
@@ -67,19 +71,21 @@ func TestCustomerRepository_Login(t *testing.T) {
 			ID:       1,
 			Email:    email,
 			Password: hashedPassword,
+			Role:     model.RoleStaff,
 		}
 
 		mock.ExpectQuery(query).
 			WithArgs(email).
-			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password"}).
-				AddRow(customer.ID, customer.Email, customer.Password),
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password", "role"}).
+				AddRow(customer.ID, customer.Email, customer.Password, customer.Role),
 			)
 
-		result, err := customerRepo.Login(email, password)
+		result, err := customerRepo.Login(context.Background(), email, password)
 
 		assert.NoError(t, err)
 		assert.NotNil(t, result)
 		assert.Equal(t, customer.ID, result.ID)
+		assert.Equal(t, model.RoleStaff, result.Role)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
@@ -91,7 +97,7 @@ func TestCustomerRepository_Login(t *testing.T) {
 			WithArgs(email).
 			WillReturnError(sql.ErrNoRows)
 
-		result, err := customerRepo.Login(email, password)
+		result, err := customerRepo.Login(context.Background(), email, password)
 
 		assert.Error(t, err)
 		assert.Nil(t, result)
@@ -117,10 +123,195 @@ func TestCustomerRepository_Login(t *testing.T) {
 				AddRow(customer.ID, customer.Email, customer.Password, customer.Name, customer.Address),
 			)
 
-		result, err := customerRepo.Login(email, password)
+		result, err := customerRepo.Login(context.Background(), email, password)
 
 		assert.Error(t, err)
 		assert.Nil(t, result)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
+
+func TestCustomerRepository_UpdateRole(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	customerRepo := repository.NewCustomerRepository(db)
+	query := regexp.QuoteMeta("UPDATE customers SET role = $1 WHERE id = $2")
+
+	t.Run("success", func(t *testing.T) {
+		mock.ExpectExec(query).
+			WithArgs(model.RoleStaff, 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := customerRepo.UpdateRole(context.Background(), 2, model.RoleStaff)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("customer not found", func(t *testing.T) {
+		mock.ExpectExec(query).
+			WithArgs(model.RoleStaff, 99).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		err := customerRepo.UpdateRole(context.Background(), 99, model.RoleStaff)
+
+		assert.ErrorIs(t, err, utils.ErrCustomerNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCustomerRepository_UpdateProfile(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	customerRepo := repository.NewCustomerRepository(db)
+	columns := []string{"id", "email", "name", "address", "role", "pending_email"}
+	name := "Jane Doe"
+
+	t.Run("success", func(t *testing.T) {
+		mock.ExpectQuery("UPDATE customers SET name = COALESCE\\(\\$1, name\\), address = COALESCE\\(\\$2, address\\)").
+			WithArgs(&name, nil, 1).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, "jane@example.com", name, "123 Street", model.RoleCustomer, "new@example.com"))
+
+		profile, err := customerRepo.UpdateProfile(context.Background(), 1, &name, nil)
+
+		assert.NoError(t, err)
+		assert.Equal(t, &model.CustomerProfile{
+			ID:           1,
+			Email:        "jane@example.com",
+			Name:         name,
+			Address:      "123 Street",
+			Role:         model.RoleCustomer,
+			PendingEmail: "new@example.com",
+		}, profile)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("customer not found", func(t *testing.T) {
+		mock.ExpectQuery("UPDATE customers").
+			WithArgs(&name, nil, 99).
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := customerRepo.UpdateProfile(context.Background(), 99, &name, nil)
+
+		assert.ErrorIs(t, err, utils.ErrCustomerNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCustomerRepository_CreateEmailVerification(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	customerRepo := repository.NewCustomerRepository(db)
+	exists := regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM customers WHERE email = $1)")
+	expiresAt := time.Now().Add(time.Hour)
+	verification := func() *model.EmailVerification {
+		return &model.EmailVerification{CustomerID: 1, Email: "new@example.com", TokenHash: "hash", ExpiresAt: expiresAt}
+	}
+
+	t.Run("replaces earlier requests", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(exists).WithArgs("new@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectExec("DELETE FROM email_verifications").WithArgs(int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("INSERT INTO email_verifications").
+			WithArgs(int64(1), "new@example.com", "hash", expiresAt).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(4, time.Now()))
+		mock.ExpectCommit()
+
+		stored := verification()
+		err := customerRepo.CreateEmailVerification(context.Background(), stored)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), stored.ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("email taken", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(exists).WithArgs("new@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		err := customerRepo.CreateEmailVerification(context.Background(), verification())
+
+		assert.ErrorIs(t, err, utils.ErrDuplicateEmail)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCustomerRepository_ConfirmEmail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	customerRepo := repository.NewCustomerRepository(db)
+	columns := []string{"id", "customer_id", "email", "token_hash", "expires_at", "created_at"}
+	selectQuery := "SELECT id, customer_id, email, token_hash, expires_at, created_at FROM email_verifications"
+	updateEmail := regexp.QuoteMeta("UPDATE customers SET email = $1 WHERE id = $2")
+
+	t.Run("replaces the email", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(selectQuery).WithArgs("hash").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(4, 1, "new@example.com", "hash", time.Now().Add(time.Hour), time.Now()))
+		mock.ExpectExec(updateEmail).WithArgs("new@example.com", int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("UPDATE email_verifications SET used_at = NOW\\(\\)").WithArgs(int64(4)).
+			WillReturnRows(sqlmock.NewRows([]string{"used_at"}).AddRow(time.Now()))
+		mock.ExpectCommit()
+
+		verification, err := customerRepo.ConfirmEmail(context.Background(), "hash")
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), verification.CustomerID)
+		assert.NotNil(t, verification.UsedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown or used token", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(selectQuery).WithArgs("used").WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		_, err := customerRepo.ConfirmEmail(context.Background(), "used")
+
+		assert.ErrorIs(t, err, utils.ErrInvalidVerificationToken)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(selectQuery).WithArgs("hash").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(4, 1, "new@example.com", "hash", time.Now().Add(-time.Minute), time.Now()))
+		mock.ExpectRollback()
+
+		_, err := customerRepo.ConfirmEmail(context.Background(), "hash")
+
+		assert.ErrorIs(t, err, utils.ErrVerificationTokenExpired)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("email taken since the request", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(selectQuery).WithArgs("hash").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(4, 1, "new@example.com", "hash", time.Now().Add(time.Hour), time.Now()))
+		mock.ExpectExec(updateEmail).WithArgs("new@example.com", int64(1)).
+			WillReturnError(errors.New(`ERROR: duplicate key value violates unique constraint "customers_email_key" (SQLSTATE 23505)`))
+		mock.ExpectRollback()
+
+		_, err := customerRepo.ConfirmEmail(context.Background(), "hash")
+
+		assert.ErrorIs(t, err, utils.ErrDuplicateEmail)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
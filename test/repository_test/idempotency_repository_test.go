@@ -0,0 +1,150 @@
+package repository_test
+
+import (
+	"bookstore/internal/model"
+	"bookstore/internal/repository"
+	"context"
+	"database/sql"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyRepository_ClaimIdempotencyKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	idempotencyRepo := repository.NewIdempotencyRepository(db)
+
+	claimQuery := regexp.QuoteMeta(`INSERT INTO idempotency_keys (customer_id, key, request_hash, expires_at)`)
+	selectQuery := regexp.QuoteMeta(`SELECT request_hash, status_code, response_body, response_headers, created_at, expires_at
+		FROM idempotency_keys
+		WHERE customer_id = $1 AND key = $2`)
+
+	newKey := func() *model.IdempotencyKey {
+		return &model.IdempotencyKey{CustomerID: 1, Key: "key-1", RequestHash: "hash"}
+	}
+
+	t.Run("new key is claimed", func(t *testing.T) {
+		now := time.Now()
+		key := newKey()
+		mock.ExpectQuery(claimQuery).
+			WithArgs(int64(1), "key-1", "hash", float64(3600)).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at", "expires_at"}).AddRow(now, now.Add(time.Hour)))
+
+		existing, err := idempotencyRepo.ClaimIdempotencyKey(context.Background(), key, time.Hour)
+
+		assert.NoError(t, err)
+		assert.Nil(t, existing)
+		assert.Equal(t, now.Add(time.Hour), key.ExpiresAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("existing key is returned", func(t *testing.T) {
+		now := time.Now()
+		mock.ExpectQuery(claimQuery).WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery(selectQuery).
+			WithArgs(int64(1), "key-1").
+			WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "response_body", "response_headers", "created_at", "expires_at"}).
+				AddRow("hash", 200, []byte(`{"message":"ok"}`), []byte(`{"Deprecation":["true"]}`), now, now.Add(time.Hour)))
+
+		existing, err := idempotencyRepo.ClaimIdempotencyKey(context.Background(), newKey(), time.Hour)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 200, existing.StatusCode)
+		assert.Equal(t, []byte(`{"message":"ok"}`), existing.ResponseBody)
+		assert.Equal(t, "true", existing.ResponseHeaders.Get("Deprecation"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("key still in progress", func(t *testing.T) {
+		now := time.Now()
+		mock.ExpectQuery(claimQuery).WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery(selectQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "response_body", "response_headers", "created_at", "expires_at"}).
+				AddRow("hash", nil, nil, nil, now, now.Add(time.Hour)))
+
+		existing, err := idempotencyRepo.ClaimIdempotencyKey(context.Background(), newKey(), time.Hour)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 0, existing.StatusCode)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("key released in between is claimed on retry", func(t *testing.T) {
+		now := time.Now()
+		mock.ExpectQuery(claimQuery).WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery(selectQuery).WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery(claimQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at", "expires_at"}).AddRow(now, now.Add(time.Hour)))
+
+		existing, err := idempotencyRepo.ClaimIdempotencyKey(context.Background(), newKey(), time.Hour)
+
+		assert.NoError(t, err)
+		assert.Nil(t, existing)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestIdempotencyRepository_SaveAndRelease(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	idempotencyRepo := repository.NewIdempotencyRepository(db)
+
+	key := &model.IdempotencyKey{
+		CustomerID:      1,
+		Key:             "key-1",
+		StatusCode:      200,
+		ResponseBody:    []byte(`{}`),
+		ResponseHeaders: http.Header{"Location": {"/orders/7"}},
+	}
+
+	t.Run("save", func(t *testing.T) {
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE idempotency_keys
+	SET status_code = $3, response_body = $4, response_headers = $5
+	WHERE customer_id = $1 AND key = $2`)).
+			WithArgs(int64(1), "key-1", 200, []byte(`{}`), []byte(`{"Location":["/orders/7"]}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := idempotencyRepo.SaveIdempotentResponse(context.Background(), key)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("release only deletes unfinished keys", func(t *testing.T) {
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM idempotency_keys
+	WHERE customer_id = $1 AND key = $2 AND status_code IS NULL`)).
+			WithArgs(int64(1), "key-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := idempotencyRepo.ReleaseIdempotencyKey(context.Background(), key)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestIdempotencyRepository_DeleteExpiredIdempotencyKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	idempotencyRepo := repository.NewIdempotencyRepository(db)
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE expires_at < NOW()")).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	deleted, err := idempotencyRepo.DeleteExpiredIdempotencyKeys(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
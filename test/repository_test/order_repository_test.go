@@ -3,14 +3,26 @@ package repository_test
 import (
 	"bookstore/internal/model"
 	"bookstore/internal/repository"
+	"bookstore/pkg/money"
+	"bookstore/pkg/utils"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
 )
 
+var orderColumns = []string{
+	"id", "order_state", "updated_at", "checked_out_at", "total",
+	"detail_id", "book_id", "quantity", "subtotal",
+	"title", "author", "unit_price", "currency", "catalog_price", "archived_at",
+	"refunded_quantity", "refunded_total",
+}
+
 var recalculationQuery = regexp.QuoteMeta(
 	`WITH subtotal_sum AS (
         SELECT SUM(d.subtotal) as total_sum
@@ -29,35 +41,41 @@ func TestOrderRepository_GetCart(t *testing.T) {
 
 	orderID := 1
 
-	query := `SELECT o.id, o.total,
+	query := `SELECT o.id, o.order_state, o.updated_at, o.checked_out_at, o.total,
     d.id AS detail_id, d.book_id, d.quantity, d.subtotal,
-    b.title, b.author, b.price
+    d.title, d.author, d.unit_price, d.currency,
+    b.price AS catalog_price, b.archived_at,
+    d.refunded_quantity, o.refunded_total
     FROM orders o
     JOIN order_details d ON o.id = d.order_id
     JOIN books b ON d.book_id = b.id
-    WHERE o.id = \$1 AND o.order_state = \$2`
+    WHERE o.id = \$1 AND o.order_state = \$2
+    ORDER BY d.id`
 
 	t.Run("successful retrieval of cart", func(t *testing.T) {
+		updatedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
 		mock.ExpectQuery(query).
-			WithArgs(orderID, model.OrderState_One).
-			WillReturnRows(sqlmock.NewRows([]string{"id", "total", "detail_id", "book_id", "quantity", "subtotal", "title", "author", "price"}).
-				AddRow(1, 400, 1, 1, 2, 400, "Book Title", "Author Name", 200))
+			WithArgs(orderID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows(orderColumns).
+				AddRow(1, model.OrderStateCart, updatedAt, nil, 400, 1, 1, 2, 400, "Book Title", "Author Name", 200, "USD", 200, nil, 0, 0))
 
-		result, err := orderRepo.GetCart(orderID)
+		result, err := orderRepo.GetCart(context.Background(), orderID)
 
 		expected := &model.OrderResponse{
-			ID: int64(orderID),
+			ID:        int64(orderID),
+			State:     model.OrderStateCart,
+			UpdatedAt: updatedAt,
 			OrderDetail: []model.OrderDetailResponse{
 				{
 					ID: 1,
 					Book: []model.Book{
-						{ID: 1, Title: "Book Title", Author: "Author Name", Price: 2.00},
+						{ID: 1, Title: "Book Title", Author: "Author Name", Price: money.New(200, money.USD)},
 					},
 					Quantity: 2,
-					Subtotal: 4.00,
+					Subtotal: money.New(400, money.USD),
 				},
 			},
-			Total: 4.00,
+			Total: money.New(400, money.USD),
 		}
 
 		assert.NoError(t, err)
@@ -65,12 +83,49 @@ func TestOrderRepository_GetCart(t *testing.T) {
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
+	t.Run("archived books are flagged unavailable", func(t *testing.T) {
+		archivedAt := time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery(query).
+			WithArgs(orderID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows(orderColumns).
+				AddRow(1, model.OrderStateCart, time.Now(), nil, 600, 1, 1, 2, 400, "Book Title", "Author Name", 200, "USD", 200, nil, 0, 0).
+				AddRow(1, model.OrderStateCart, time.Now(), nil, 600, 2, 2, 1, 200, "Old Title", "Author Name", 200, "USD", 200, archivedAt, 0, 0))
+
+		result, err := orderRepo.GetCart(context.Background(), orderID)
+
+		assert.NoError(t, err)
+		assert.False(t, result.OrderDetail[0].Unavailable)
+		assert.True(t, result.OrderDetail[1].Unavailable)
+		assert.Equal(t, archivedAt, *result.OrderDetail[1].Book[0].ArchivedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("price drift is reported per line", func(t *testing.T) {
+		mock.ExpectQuery(query).
+			WithArgs(orderID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows(orderColumns).
+				AddRow(1, model.OrderStateCart, time.Now(), nil, 600, 1, 1, 2, 400, "Book Title", "Author Name", 200, "USD", 250, nil, 0, 0).
+				AddRow(1, model.OrderStateCart, time.Now(), nil, 600, 2, 2, 1, 200, "Other Title", "Author Name", 200, "USD", 200, nil, 0, 0))
+
+		result, err := orderRepo.GetCart(context.Background(), orderID)
+
+		assert.NoError(t, err)
+		assert.True(t, result.PricesChanged)
+		assert.Equal(t, &model.PriceChange{
+			OldPrice: money.New(200, money.USD),
+			NewPrice: money.New(250, money.USD),
+		}, result.OrderDetail[0].PriceChange)
+		assert.Nil(t, result.OrderDetail[1].PriceChange)
+		assert.Equal(t, money.New(400, money.USD), result.OrderDetail[0].Subtotal)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
 	t.Run("error retrieving cart", func(t *testing.T) {
 		mock.ExpectQuery(query).
-			WithArgs(orderID, model.OrderState_One).
+			WithArgs(orderID, model.OrderStateCart).
 			WillReturnError(sql.ErrNoRows)
 
-		result, err := orderRepo.GetCart(orderID)
+		result, err := orderRepo.GetCart(context.Background(), orderID)
 
 		assert.Error(t, err)
 		assert.Nil(t, result)
@@ -78,90 +133,240 @@ func TestOrderRepository_GetCart(t *testing.T) {
 	})
 }
 
-func TestOrderRepository_GetOrderHistory(t *testing.T) {
+func TestOrderRepository_GetOrder(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
 
 	orderRepo := repository.NewOrderRepository(db)
 
-	customerID := 1
-	limit := 10
-	page := 0
-
-	query := regexp.QuoteMeta(`SELECT o.id, o.total,
-	d.id AS detail_id, d.book_id, d.quantity, d.subtotal,
-	b.title, b.author, b.price
-	FROM (
-	  SELECT * FROM orders
-	  WHERE customer_id = $1 AND order_state = $4
-	  ORDER BY updated_at ASC
-	  LIMIT $2 OFFSET $3
-	) o
-	JOIN order_details d ON o.id = d.order_id
-	JOIN books b ON d.book_id = b.id`)
-
-	t.Run("successful retrieval of order history", func(t *testing.T) {
+	orderID := 7
+
+	query := `SELECT o.id, o.order_state, o.updated_at, o.checked_out_at, o.total,
+    d.id AS detail_id, d.book_id, d.quantity, d.subtotal,
+    d.title, d.author, d.unit_price, d.currency,
+    NULL AS catalog_price, b.archived_at,
+    d.refunded_quantity, o.refunded_total
+    FROM orders o
+    JOIN order_details d ON o.id = d.order_id
+    JOIN books b ON d.book_id = b.id
+    WHERE o.id = \$1
+    ORDER BY d.id`
+
+	t.Run("order in any state", func(t *testing.T) {
 		mock.ExpectQuery(query).
-			WithArgs(customerID, limit, page*limit, model.OrderState_Two).
-			WillReturnRows(sqlmock.NewRows([]string{"id", "total", "detail_id", "book_id", "quantity", "subtotal", "title", "author", "price"}).
-				AddRow(1, 3197, 1, 1, 2, 2398, "1984", "George Orwell", 999).
-				AddRow(1, 3197, 2, 2, 1, 799, "To Kill a Mockingbird", "Harper Lee", 799))
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(orderColumns).
+				AddRow(orderID, model.OrderStatePaid, time.Now(), time.Now(), 400, 1, 1, 2, 400, "Book Title", "Author Name", 200, "USD", nil, nil, 1, 200))
+
+		result, err := orderRepo.GetOrder(context.Background(), orderID)
 
-		orders, err := orderRepo.GetOrderHistory(customerID, limit, page)
 		assert.NoError(t, err)
-		assert.Len(t, orders, 1)
+		assert.Equal(t, int64(orderID), result.ID)
+		assert.Equal(t, model.OrderStatePaid, result.State)
+		assert.Equal(t, money.New(400, money.USD), result.Total)
+		assert.Len(t, result.OrderDetail, 1)
+		assert.Nil(t, result.OrderDetail[0].PriceChange)
+		assert.Equal(t, int64(1), result.OrderDetail[0].Refunded)
+		assert.Equal(t, &model.OrderRefund{
+			Status: model.OrderRefundPartial,
+			Amount: money.New(200, money.USD),
+		}, result.Refund)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
 
-		expectedOrder := model.OrderResponse{
-			ID:    1,
-			Total: 31.97,
-			OrderDetail: []model.OrderDetailResponse{
-				{
-					ID:       1,
-					Quantity: 2,
-					Subtotal: 23.98,
-					Book: []model.Book{
-						{
-							ID:     1,
-							Title:  "1984",
-							Author: "George Orwell",
-							Price:  9.99,
-						},
-					},
-				},
-				{
-					ID:       2,
-					Quantity: 1,
-					Subtotal: 7.99,
-					Book: []model.Book{
-						{
-							ID:     2,
-							Title:  "To Kill a Mockingbird",
-							Author: "Harper Lee",
-							Price:  7.99,
-						},
-					},
-				},
-			},
+	t.Run("order not found", func(t *testing.T) {
+		mock.ExpectQuery(query).
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(orderColumns))
+
+		_, err := orderRepo.GetOrder(context.Background(), orderID)
+
+		assert.ErrorIs(t, err, utils.ErrOrderNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOrderRepository_GetOrderHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	orderRepo := repository.NewOrderRepository(db)
+
+	customerID := int64(1)
+	newer := time.Date(2024, 5, 2, 9, 0, 0, 0, time.UTC)
+	older := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	// updates happen after checkout and do not decide the order of the history
+	updated := time.Date(2024, 5, 3, 9, 0, 0, 0, time.UTC)
+
+	countQuery := regexp.QuoteMeta(`SELECT COUNT(*) FROM orders o WHERE o.customer_id = $1 AND o.order_state <> $2 AND EXISTS (SELECT 1 FROM order_details x WHERE x.order_id = o.id) AND o.order_state <> $3`)
+	pageQuery := regexp.QuoteMeta(`ORDER BY o.checked_out_at DESC, o.id DESC, d.id`)
+	nextPageQuery := regexp.QuoteMeta(`(o.checked_out_at, o.id) < ($4, $5)`)
+
+	t.Run("most recently checked out first with a next cursor", func(t *testing.T) {
+		query := model.OrderHistoryQuery{CustomerID: customerID, Limit: 2}
+
+		mock.ExpectQuery(countQuery).
+			WithArgs(customerID, model.OrderStateCart, model.OrderStatePendingPayment).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectQuery(pageQuery).
+			WithArgs(customerID, model.OrderStateCart, model.OrderStatePendingPayment, 3).
+			WillReturnRows(sqlmock.NewRows(orderColumns).
+				AddRow(9, model.OrderStateShipped, updated, newer, 3197, 5, 1, 2, 2398, "1984", "George Orwell", 999, "USD", nil, nil, 0, 0).
+				AddRow(9, model.OrderStateShipped, updated, newer, 3197, 6, 2, 1, 799, "To Kill a Mockingbird", "Harper Lee", 799, "USD", nil, nil, 0, 0).
+				AddRow(4, model.OrderStatePaid, older, older, 999, 2, 1, 1, 999, "1984", "George Orwell", 999, "USD", nil, nil, 0, 0).
+				AddRow(2, model.OrderStatePaid, older, older, 799, 1, 2, 1, 799, "To Kill a Mockingbird", "Harper Lee", 799, "USD", nil, nil, 0, 0))
+
+		page, err := orderRepo.GetOrderHistory(context.Background(), query)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), page.Total)
+		assert.Len(t, page.Orders, 2)
+		assert.Equal(t, int64(9), page.Orders[0].ID)
+		assert.Equal(t, model.OrderStateShipped, page.Orders[0].State)
+		assert.Equal(t, newer, *page.Orders[0].CheckedOutAt)
+		assert.Len(t, page.Orders[0].OrderDetail, 2)
+		assert.Equal(t, int64(4), page.Orders[1].ID)
+		assert.NotEmpty(t, page.NextCursor)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		t.Run("following page starts after the cursor", func(t *testing.T) {
+			query.Cursor = page.NextCursor
+
+			mock.ExpectQuery(countQuery).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+			mock.ExpectQuery(nextPageQuery).
+				WithArgs(customerID, model.OrderStateCart, model.OrderStatePendingPayment, older, int64(4), 3).
+				WillReturnRows(sqlmock.NewRows(orderColumns).
+					AddRow(2, model.OrderStatePaid, older, older, 799, 1, 2, 1, 799, "To Kill a Mockingbird", "Harper Lee", 799, "USD", nil, nil, 0, 0))
+
+			next, err := orderRepo.GetOrderHistory(context.Background(), query)
+
+			assert.NoError(t, err)
+			assert.Len(t, next.Orders, 1)
+			assert.Equal(t, int64(2), next.Orders[0].ID)
+			assert.Empty(t, next.NextCursor)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	})
+
+	t.Run("order changing state between two pages", func(t *testing.T) {
+		query := model.OrderHistoryQuery{CustomerID: customerID, Limit: 1}
+
+		mock.ExpectQuery(countQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(pageQuery).
+			WithArgs(customerID, model.OrderStateCart, model.OrderStatePendingPayment, 2).
+			WillReturnRows(sqlmock.NewRows(orderColumns).
+				AddRow(9, model.OrderStatePaid, newer, newer, 999, 5, 1, 1, 999, "1984", "George Orwell", 999, "USD", nil, nil, 0, 0).
+				AddRow(4, model.OrderStatePaid, older, older, 999, 2, 1, 1, 999, "1984", "George Orwell", 999, "USD", nil, nil, 0, 0))
+
+		first, err := orderRepo.GetOrderHistory(context.Background(), query)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(9), first.Orders[0].ID)
+
+		// order 4 ships before the next page is fetched, its updated_at now
+		// comes after order 9 was checked out
+		transitionQuery := regexp.QuoteMeta(`UPDATE orders SET order_state = $3, updated_at = NOW() WHERE id = $1 AND order_state = $2`)
+		mock.ExpectBegin()
+		mock.ExpectExec(transitionQuery).
+			WithArgs(int64(4), model.OrderStatePaid, model.OrderStateFulfilling).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO order_state_transitions`)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, updated))
+		mock.ExpectCommit()
+
+		err = orderRepo.TransitionOrder(context.Background(), &model.OrderStateTransition{
+			OrderID: 4, From: model.OrderStatePaid, To: model.OrderStateFulfilling, ChangedBy: 2,
+		})
+		assert.NoError(t, err)
+
+		// the cursor still holds order 9's checkout time, order 4 is on the
+		// second page instead of being skipped for its newer update
+		query.Cursor = first.NextCursor
+		mock.ExpectQuery(countQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(nextPageQuery).
+			WithArgs(customerID, model.OrderStateCart, model.OrderStatePendingPayment, newer, int64(9), 2).
+			WillReturnRows(sqlmock.NewRows(orderColumns).
+				AddRow(4, model.OrderStateFulfilling, updated, older, 999, 2, 1, 1, 999, "1984", "George Orwell", 999, "USD", nil, nil, 0, 0))
+
+		second, err := orderRepo.GetOrderHistory(context.Background(), query)
+
+		assert.NoError(t, err)
+		assert.Len(t, second.Orders, 1)
+		assert.Equal(t, int64(4), second.Orders[0].ID)
+		assert.Equal(t, model.OrderStateFulfilling, second.Orders[0].State)
+		assert.Empty(t, second.NextCursor)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		minTotal := money.New(1000, money.USD)
+		bookID := int64(2)
+		query := model.OrderHistoryQuery{
+			CustomerID: customerID,
+			From:       &older,
+			To:         &newer,
+			States:     []model.OrderState{model.OrderStatePaid, model.OrderStateShipped},
+			MinTotal:   &minTotal,
+			BookID:     &bookID,
+			Limit:      10,
 		}
 
-		assert.Equal(t, expectedOrder, orders[0])
+		mock.ExpectQuery(regexp.QuoteMeta(`o.checked_out_at >= $3 AND o.checked_out_at < $4 `+
+			`AND o.order_state IN ($5, $6) AND o.total >= $7 `+
+			`AND EXISTS (SELECT 1 FROM order_details x WHERE x.order_id = o.id AND x.book_id = $8)`)).
+			WithArgs(customerID, model.OrderStateCart, older, newer,
+				model.OrderStatePaid, model.OrderStateShipped, minTotal, bookID).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(pageQuery).
+			WillReturnRows(sqlmock.NewRows(orderColumns))
+
+		page, err := orderRepo.GetOrderHistory(context.Background(), query)
+
+		assert.NoError(t, err)
+		assert.Empty(t, page.Orders)
+		assert.NotNil(t, page.Orders)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
-	t.Run("error when retrieving order history", func(t *testing.T) {
-		mock.ExpectQuery(query).
-			WithArgs(customerID, limit, page*limit, model.OrderState_Two).
-			WillReturnError(sql.ErrNoRows)
+	t.Run("pending orders only when asked for", func(t *testing.T) {
+		query := model.OrderHistoryQuery{
+			CustomerID: customerID,
+			States:     []model.OrderState{model.OrderStatePendingPayment},
+			Limit:      10,
+		}
 
-		orders, err := orderRepo.GetOrderHistory(customerID, limit, page)
-		assert.Error(t, err)
-		assert.Nil(t, orders)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM orders o WHERE o.customer_id = $1 AND o.order_state <> $2 `+
+			`AND EXISTS (SELECT 1 FROM order_details x WHERE x.order_id = o.id) AND o.order_state IN ($3)`)).
+			WithArgs(customerID, model.OrderStateCart, model.OrderStatePendingPayment).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(pageQuery).
+			WithArgs(customerID, model.OrderStateCart, model.OrderStatePendingPayment, 11).
+			WillReturnRows(sqlmock.NewRows(orderColumns).
+				AddRow(5, model.OrderStatePendingPayment, newer, newer, 999, 3, 1, 1, 999, "1984", "George Orwell", 999, "USD", nil, nil, 0, 0))
+
+		page, err := orderRepo.GetOrderHistory(context.Background(), query)
+
+		assert.NoError(t, err)
+		assert.Len(t, page.Orders, 1)
+		assert.Equal(t, model.OrderStatePendingPayment, page.Orders[0].State)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		query := model.OrderHistoryQuery{CustomerID: customerID, Limit: 10, Cursor: "not a cursor"}
+
+		_, err := orderRepo.GetOrderHistory(context.Background(), query)
+
+		assert.ErrorIs(t, err, utils.ErrInvalidCursor)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
 
-func TestOrderRepository_PayOrder(t *testing.T) {
+func TestOrderRepository_StartCheckout(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
@@ -169,42 +374,274 @@ func TestOrderRepository_PayOrder(t *testing.T) {
 	orderRepo := repository.NewOrderRepository(db)
 
 	customerID := 1
+	orderID := 7
 
-	query := regexp.QuoteMeta(
-		`UPDATE orders SET order_state = $2, updated_at = NOW() WHERE customer_id = $1 AND order_state = $3 RETURNING id;`,
+	lockOrderQuery := regexp.QuoteMeta(
+		`SELECT id FROM orders WHERE customer_id = $1 AND order_state = $2 FOR UPDATE`,
 	)
+	linesQuery := regexp.QuoteMeta(`SELECT b.id, d.title, d.unit_price, b.price, b.archived_at IS NOT NULL
+	FROM order_details d
+	JOIN books b ON b.id = d.book_id
+	WHERE d.order_id = $1
+	ORDER BY b.id
+	FOR UPDATE OF b`)
+	refreshQuery := regexp.QuoteMeta(`UPDATE order_details d
+	SET title = b.title,
+		author = b.author,
+		unit_price = b.price,
+		currency = $2,
+		subtotal = b.price * d.quantity
+	FROM books b
+	WHERE d.order_id = $1 AND b.id = d.book_id`)
+	lockBooksQuery := regexp.QuoteMeta(`SELECT b.id, b.title, b.stock, d.quantity, b.archived_at IS NOT NULL
+	FROM order_details d
+	JOIN books b ON b.id = d.book_id
+	WHERE d.order_id = $1
+	ORDER BY b.id
+	FOR UPDATE OF b`)
+	decrementQuery := regexp.QuoteMeta(`UPDATE books b SET stock = b.stock - d.quantity`)
+	ledgerQuery := regexp.QuoteMeta(`INSERT INTO stock_movements`)
+	pendingQuery := regexp.QuoteMeta(
+		`UPDATE orders SET order_state = $2, updated_at = NOW(), checked_out_at = NOW() WHERE id = $1 RETURNING total, updated_at`,
+	)
+	historyQuery := regexp.QuoteMeta(`INSERT INTO order_state_transitions`)
 
-	t.Run("successful payment of order", func(t *testing.T) {
-		mock.ExpectBegin()
-		mock.ExpectExec(query).
-			WithArgs(customerID, model.OrderState_Two, model.OrderState_One).
-			WillReturnResult(sqlmock.NewResult(1, 1))
+	stockColumns := []string{"id", "title", "stock", "quantity", "archived"}
 
+	lineColumns := []string{"id", "title", "unit_price", "price", "archived"}
+
+	expectCartLocked := func() {
+		mock.ExpectQuery(lockOrderQuery).
+			WithArgs(customerID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orderID))
+		mock.ExpectQuery(linesQuery).
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(lineColumns).
+				AddRow(1, "1984", 1000, 1000, false).
+				AddRow(2, "Moby Dick", 500, 500, false))
+	}
+
+	expectCartRefreshed := func() {
+		expectCartLocked()
+		mock.ExpectExec(refreshQuery).
+			WithArgs(orderID, money.DefaultCurrency).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(recalculationQuery).
+			WithArgs(orderID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	expectStockReserved := func() {
+		expectCartRefreshed()
+		mock.ExpectQuery(lockBooksQuery).
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(stockColumns).
+				AddRow(1, "1984", 5, 2, false).
+				AddRow(2, "Moby Dick", 1, 1, false))
+		mock.ExpectExec(decrementQuery).
+			WithArgs(orderID).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(ledgerQuery).
+			WithArgs(orderID, model.StockReasonSale).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+	}
+
+	t.Run("cart moves to pending payment with stock reserved", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectStockReserved()
+		mock.ExpectQuery(pendingQuery).
+			WithArgs(orderID, model.OrderStatePendingPayment).
+			WillReturnRows(sqlmock.NewRows([]string{"total", "updated_at"}).AddRow(2500, time.Now()))
+		mock.ExpectQuery(historyQuery).
+			WithArgs(orderID, model.OrderStateCart, model.OrderStatePendingPayment, customerID, "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
 		mock.ExpectCommit()
 
-		err := orderRepo.PayOrder(customerID)
+		order, err := orderRepo.StartCheckout(context.Background(), customerID)
 		assert.NoError(t, err)
+		assert.Equal(t, int64(orderID), order.ID)
+		assert.Equal(t, model.OrderStatePendingPayment, order.OrderState)
+		assert.Equal(t, money.New(2500, money.USD), order.Total)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("out of stock rolls back and lists books", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectCartRefreshed()
+		mock.ExpectQuery(lockBooksQuery).
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(stockColumns).
+				AddRow(1, "1984", 5, 2, false).
+				AddRow(2, "Moby Dick", 1, 3, false).
+				AddRow(3, "War and Peace", 0, 1, false))
+		mock.ExpectRollback()
+
+		_, err := orderRepo.StartCheckout(context.Background(), customerID)
+		assert.ErrorIs(t, err, utils.ErrOutOfStock)
+
+		var outOfStock *utils.OutOfStockError
+		assert.ErrorAs(t, err, &outOfStock)
+		assert.Equal(t, []utils.OutOfStockItem{
+			{BookID: 2, Title: "Moby Dick", Requested: 3, Available: 1},
+			{BookID: 3, Title: "War and Peace", Requested: 1, Available: 0},
+		}, outOfStock.Items)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("archived books cannot be checked out", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectCartRefreshed()
+		mock.ExpectQuery(lockBooksQuery).
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(stockColumns).
+				AddRow(1, "1984", 5, 2, false).
+				AddRow(4, "Withdrawn", 10, 1, true))
+		mock.ExpectRollback()
+
+		_, err := orderRepo.StartCheckout(context.Background(), customerID)
+
+		var outOfStock *utils.OutOfStockError
+		assert.ErrorAs(t, err, &outOfStock)
+		assert.Equal(t, []utils.OutOfStockItem{
+			{BookID: 4, Title: "Withdrawn", Requested: 1, Available: 0},
+		}, outOfStock.Items)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("archived books are reported before changed prices", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockOrderQuery).
+			WithArgs(customerID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orderID))
+		mock.ExpectQuery(linesQuery).
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(lineColumns).
+				AddRow(1, "1984", 1000, 1200, false).
+				AddRow(4, "Withdrawn", 700, 700, true))
+		mock.ExpectRollback()
+
+		_, err := orderRepo.StartCheckout(context.Background(), customerID)
+		assert.ErrorIs(t, err, utils.ErrBookUnavailable)
+
+		var unavailable *utils.UnavailableItemsError
+		assert.ErrorAs(t, err, &unavailable)
+		assert.Equal(t, []utils.UnavailableItem{{BookID: 4, Title: "Withdrawn"}}, unavailable.Items)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("cart without lines", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockOrderQuery).
+			WithArgs(customerID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orderID))
+		mock.ExpectQuery(linesQuery).
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(lineColumns))
+		mock.ExpectRollback()
+
+		_, err := orderRepo.StartCheckout(context.Background(), customerID)
+		assert.ErrorIs(t, err, utils.WarnCartEmpty)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	for name, total := range map[string]any{"zero": 0, "null": nil} {
+		t.Run(name+" total is not payable", func(t *testing.T) {
+			mock.ExpectBegin()
+			expectStockReserved()
+			mock.ExpectQuery(pendingQuery).
+				WithArgs(orderID, model.OrderStatePendingPayment).
+				WillReturnRows(sqlmock.NewRows([]string{"total", "updated_at"}).AddRow(total, time.Now()))
+			mock.ExpectRollback()
+
+			_, err := orderRepo.StartCheckout(context.Background(), customerID)
+			assert.ErrorIs(t, err, utils.ErrOrderNotPayable)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("changed prices must be acknowledged first", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockOrderQuery).
+			WithArgs(customerID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orderID))
+		mock.ExpectQuery(linesQuery).
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(lineColumns).
+				AddRow(1, "1984", 1000, 1200, false).
+				AddRow(2, "Moby Dick", 500, 500, false))
+		mock.ExpectRollback()
+
+		_, err := orderRepo.StartCheckout(context.Background(), customerID)
+		assert.ErrorIs(t, err, utils.ErrCartPriceChanged)
+
+		var priceChanged *utils.PriceChangedError
+		assert.ErrorAs(t, err, &priceChanged)
+		assert.Equal(t, []utils.PriceChangedItem{
+			{BookID: 1, Title: "1984", OldPrice: money.New(1000, money.USD), NewPrice: money.New(1200, money.USD)},
+		}, priceChanged.Items)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("error when refreshing line snapshots", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectCartLocked()
+		mock.ExpectExec(refreshQuery).
+			WithArgs(orderID, money.DefaultCurrency).
+			WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		_, err := orderRepo.StartCheckout(context.Background(), customerID)
+		assert.EqualError(t, err, "sql: connection is already closed")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("no open cart", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockOrderQuery).
+			WithArgs(customerID, model.OrderStateCart).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		_, err := orderRepo.StartCheckout(context.Background(), customerID)
+		assert.ErrorIs(t, err, utils.WarnCartEmpty)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
 	t.Run("error when starting transaction", func(t *testing.T) {
 		mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
 
-		err := orderRepo.PayOrder(customerID)
+		_, err := orderRepo.StartCheckout(context.Background(), customerID)
 		assert.Error(t, err)
 		assert.EqualError(t, err, "sql: connection is already closed")
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
+	t.Run("error when decrementing stock", func(t *testing.T) {
+		mock.ExpectBegin()
+		expectCartRefreshed()
+		mock.ExpectQuery(lockBooksQuery).
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(stockColumns).AddRow(1, "1984", 5, 2, false))
+		mock.ExpectExec(decrementQuery).
+			WithArgs(orderID).
+			WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		_, err := orderRepo.StartCheckout(context.Background(), customerID)
+		assert.EqualError(t, err, "sql: connection is already closed")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
 	t.Run("error when executing update", func(t *testing.T) {
 		mock.ExpectBegin()
-		mock.ExpectExec(query).
-			WithArgs(customerID, model.OrderState_Two, model.OrderState_One).
+		expectStockReserved()
+		mock.ExpectQuery(pendingQuery).
+			WithArgs(orderID, model.OrderStatePendingPayment).
 			WillReturnError(sql.ErrNoRows)
 
 		mock.ExpectRollback()
 
-		err := orderRepo.PayOrder(customerID)
+		_, err := orderRepo.StartCheckout(context.Background(), customerID)
 		assert.Error(t, err)
 		assert.EqualError(t, err, "sql: no rows in result set")
 		assert.NoError(t, mock.ExpectationsWereMet())
@@ -212,19 +649,207 @@ func TestOrderRepository_PayOrder(t *testing.T) {
 
 	t.Run("error when committing transaction", func(t *testing.T) {
 		mock.ExpectBegin()
-		mock.ExpectExec(query).
-			WithArgs(customerID, model.OrderState_Two, model.OrderState_One).
-			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectStockReserved()
+		mock.ExpectQuery(pendingQuery).
+			WithArgs(orderID, model.OrderStatePendingPayment).
+			WillReturnRows(sqlmock.NewRows([]string{"total", "updated_at"}).AddRow(2500, time.Now()))
+		mock.ExpectQuery(historyQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
 
 		mock.ExpectCommit().WillReturnError(sql.ErrConnDone)
 
-		err := orderRepo.PayOrder(customerID)
+		_, err := orderRepo.StartCheckout(context.Background(), customerID)
 		assert.Error(t, err)
 		assert.EqualError(t, err, "sql: connection is already closed")
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
 
+func TestOrderRepository_CompleteCheckout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	orderRepo := repository.NewOrderRepository(db)
+
+	customerID := 1
+	orderID := 7
+
+	updateQuery := regexp.QuoteMeta(
+		`UPDATE orders SET order_state = $3, updated_at = NOW() WHERE id = $1 AND order_state = $2`,
+	)
+
+	t.Run("pending order becomes paid", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(updateQuery).
+			WithArgs(orderID, model.OrderStatePendingPayment, model.OrderStatePaid).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO order_state_transitions`)).
+			WithArgs(orderID, model.OrderStatePendingPayment, model.OrderStatePaid, customerID, "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+		mock.ExpectCommit()
+
+		err := orderRepo.CompleteCheckout(context.Background(), orderID, customerID)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("order no longer pending", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(updateQuery).
+			WithArgs(orderID, model.OrderStatePendingPayment, model.OrderStatePaid).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := orderRepo.CompleteCheckout(context.Background(), orderID, customerID)
+		assert.ErrorIs(t, err, utils.ErrOrderStateChanged)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOrderRepository_AbandonCheckout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	orderRepo := repository.NewOrderRepository(db)
+
+	customerID := 1
+	orderID := 7
+	cartID := 9
+
+	lockQuery := regexp.QuoteMeta(`SELECT customer_id FROM orders WHERE id = $1 AND order_state = $2 FOR UPDATE`)
+	cartQuery := regexp.QuoteMeta(`SELECT id FROM orders WHERE customer_id = $1 AND order_state = $2 FOR UPDATE`)
+	updateQuery := regexp.QuoteMeta(`UPDATE orders SET order_state = $2, updated_at = NOW() WHERE id = $1`)
+
+	expectLockedAndReleased := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(orderID, model.OrderStatePendingPayment).
+			WillReturnRows(sqlmock.NewRows([]string{"customer_id"}).AddRow(customerID))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE books b SET stock = b.stock + d.quantity`)).
+			WithArgs(orderID).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO stock_movements`)).
+			WithArgs(orderID, model.StockReasonRelease).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+	}
+
+	t.Run("order returns to the cart and stock is released", func(t *testing.T) {
+		expectLockedAndReleased()
+		mock.ExpectQuery(cartQuery).
+			WithArgs(customerID, model.OrderStateCart).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec(updateQuery).
+			WithArgs(orderID, model.OrderStateCart).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO order_state_transitions`)).
+			WithArgs(orderID, model.OrderStatePendingPayment, model.OrderStateCart, customerID, "payment declined").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+		mock.ExpectCommit()
+
+		err := orderRepo.AbandonCheckout(context.Background(), orderID, customerID, "payment declined")
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("lines are merged into a cart opened meanwhile", func(t *testing.T) {
+		expectLockedAndReleased()
+		mock.ExpectQuery(cartQuery).
+			WithArgs(customerID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(cartID))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO order_details (order_id, book_id, quantity, subtotal, unit_price, title, author, currency)
+			SELECT $2, d.book_id, d.quantity, d.subtotal, d.unit_price, d.title, d.author, d.currency
+			FROM order_details d
+			WHERE d.order_id = $1
+			ON CONFLICT (order_id, book_id) DO UPDATE
+			SET quantity = order_details.quantity + EXCLUDED.quantity,
+			subtotal = order_details.unit_price * (order_details.quantity + EXCLUDED.quantity)`)).
+			WithArgs(orderID, cartID).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM order_details WHERE order_id = $1`)).
+			WithArgs(orderID).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE orders SET order_state = $2, total = 0, updated_at = NOW() WHERE id = $1`)).
+			WithArgs(orderID, model.OrderStateCancelled).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(recalculationQuery).
+			WithArgs(cartID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO order_state_transitions`)).
+			WithArgs(orderID, model.OrderStatePendingPayment, model.OrderStateCancelled, customerID, "payment declined, merged into cart 9").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, time.Now()))
+		mock.ExpectCommit()
+
+		err := orderRepo.AbandonCheckout(context.Background(), orderID, customerID, "payment declined")
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("order no longer pending keeps its stock", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(orderID, model.OrderStatePendingPayment).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		err := orderRepo.AbandonCheckout(context.Background(), orderID, customerID, "payment declined")
+		assert.ErrorIs(t, err, utils.ErrOrderStateChanged)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOrderRepository_CancelCheckout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	orderRepo := repository.NewOrderRepository(db)
+
+	orderID, adminID := 7, 2
+
+	updateQuery := regexp.QuoteMeta(
+		`UPDATE orders SET order_state = $3, updated_at = NOW() WHERE id = $1 AND order_state = $2`,
+	)
+
+	t.Run("order is cancelled and stock is released", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(updateQuery).
+			WithArgs(int64(orderID), model.OrderStatePendingPayment, model.OrderStateCancelled).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE books b SET stock = b.stock + d.quantity`)).
+			WithArgs(orderID).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO stock_movements`)).
+			WithArgs(orderID, model.StockReasonRelease).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO order_state_transitions`)).
+			WithArgs(int64(orderID), model.OrderStatePendingPayment, model.OrderStateCancelled, int64(adminID), "stuck").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+		mock.ExpectCommit()
+
+		transition := &model.OrderStateTransition{OrderID: int64(orderID), ChangedBy: int64(adminID), Note: "stuck"}
+		err := orderRepo.CancelCheckout(context.Background(), transition)
+
+		assert.NoError(t, err)
+		assert.Equal(t, model.OrderStateCancelled, transition.To)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("order no longer pending keeps its stock", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(updateQuery).
+			WithArgs(int64(orderID), model.OrderStatePendingPayment, model.OrderStateCancelled).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := orderRepo.CancelCheckout(context.Background(), &model.OrderStateTransition{OrderID: int64(orderID)})
+
+		assert.ErrorIs(t, err, utils.ErrOrderStateChanged)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
 func TestOrderRepository_CreateOrderIfNotExists(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
@@ -238,57 +863,112 @@ func TestOrderRepository_CreateOrderIfNotExists(t *testing.T) {
 		`SELECT id FROM orders WHERE customer_id = $1 AND order_state = $2`,
 	)
 
-	insertQuery := `INSERT INTO orders \(customer_id, updated_at, total\) VALUES \(\$1, NOW\(\), 0\) RETURNING id`
+	insertQuery := regexp.QuoteMeta(`INSERT INTO orders (customer_id, updated_at, total, order_state)
+	VALUES ($1, NOW(), 0, $2)
+	ON CONFLICT (customer_id) WHERE order_state = 1 DO NOTHING
+	RETURNING id`)
+
+	expectNoCart := func() {
+		mock.ExpectQuery(checkQuery).
+			WithArgs(customerID, model.OrderStateCart).
+			WillReturnError(sql.ErrNoRows)
+	}
 
 	t.Run("existing order found", func(t *testing.T) {
 		mock.ExpectQuery(checkQuery).
-			WithArgs(customerID, model.OrderState_One).
+			WithArgs(customerID, model.OrderStateCart).
 			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 
-		id, err := orderRepo.CreateOrderIfNotExists(customerID)
+		id, created, err := orderRepo.CreateOrderIfNotExists(context.Background(), customerID)
 		assert.NoError(t, err)
 		assert.Equal(t, 1, id)
+		assert.False(t, created)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
 	t.Run("no existing order found, create new order", func(t *testing.T) {
-		mock.ExpectQuery(checkQuery).
-			WithArgs(customerID, model.OrderState_One).
-			WillReturnError(sql.ErrNoRows)
-
+		expectNoCart()
 		mock.ExpectQuery(insertQuery).
-			WithArgs(customerID).
+			WithArgs(customerID, model.OrderStateCart).
 			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
 
-		id, err := orderRepo.CreateOrderIfNotExists(customerID)
+		id, created, err := orderRepo.CreateOrderIfNotExists(context.Background(), customerID)
 		assert.NoError(t, err)
 		assert.Equal(t, 2, id)
+		assert.True(t, created)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("concurrent call created the cart first", func(t *testing.T) {
+		expectNoCart()
+		mock.ExpectQuery(insertQuery).
+			WithArgs(customerID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(checkQuery).
+			WithArgs(customerID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+
+		id, created, err := orderRepo.CreateOrderIfNotExists(context.Background(), customerID)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, id)
+		assert.False(t, created)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("cart created concurrently was checked out before it was read", func(t *testing.T) {
+		expectNoCart()
+		mock.ExpectQuery(insertQuery).
+			WithArgs(customerID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		// the winner's cart already left the cart state, so the retry opens a new one
+		expectNoCart()
+		mock.ExpectQuery(insertQuery).
+			WithArgs(customerID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+
+		id, created, err := orderRepo.CreateOrderIfNotExists(context.Background(), customerID)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, id)
+		assert.True(t, created)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("cart keeps being checked out by concurrent calls", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			expectNoCart()
+			mock.ExpectQuery(insertQuery).
+				WithArgs(customerID, model.OrderStateCart).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}
+
+		id, _, err := orderRepo.CreateOrderIfNotExists(context.Background(), customerID)
+		assert.ErrorIs(t, err, utils.ErrOrderStateChanged)
+		assert.Equal(t, 0, id)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
 	t.Run("error while checking existing orders", func(t *testing.T) {
 		mock.ExpectQuery(checkQuery).
-			WithArgs(customerID, model.OrderState_One).
+			WithArgs(customerID, model.OrderStateCart).
 			WillReturnError(sql.ErrConnDone)
 
-		id, err := orderRepo.CreateOrderIfNotExists(customerID)
+		id, created, err := orderRepo.CreateOrderIfNotExists(context.Background(), customerID)
 		assert.Error(t, err)
 		assert.Equal(t, 0, id)
+		assert.False(t, created)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
 	t.Run("error while creating new order", func(t *testing.T) {
-		mock.ExpectQuery(checkQuery).
-			WithArgs(customerID, model.OrderState_One).
-			WillReturnError(sql.ErrNoRows)
-
+		expectNoCart()
 		mock.ExpectQuery(insertQuery).
-			WithArgs(customerID).
+			WithArgs(customerID, model.OrderStateCart).
 			WillReturnError(sql.ErrConnDone)
 
-		id, err := orderRepo.CreateOrderIfNotExists(customerID)
+		id, created, err := orderRepo.CreateOrderIfNotExists(context.Background(), customerID)
 		assert.Error(t, err)
 		assert.Equal(t, 0, id)
+		assert.False(t, created)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
@@ -319,7 +999,7 @@ func TestOrderRepository_RemoveFromCart(t *testing.T) {
 
 		mock.ExpectCommit()
 
-		err := orderRepo.RemoveFromCart(orderID, bookID)
+		err := orderRepo.RemoveFromCart(context.Background(), orderID, bookID)
 		assert.NoError(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -327,7 +1007,7 @@ func TestOrderRepository_RemoveFromCart(t *testing.T) {
 	t.Run("error starting transaction", func(t *testing.T) {
 		mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
 
-		err := orderRepo.RemoveFromCart(orderID, bookID)
+		err := orderRepo.RemoveFromCart(context.Background(), orderID, bookID)
 		assert.Error(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -340,7 +1020,7 @@ func TestOrderRepository_RemoveFromCart(t *testing.T) {
 
 		mock.ExpectRollback()
 
-		err := orderRepo.RemoveFromCart(orderID, bookID)
+		err := orderRepo.RemoveFromCart(context.Background(), orderID, bookID)
 		assert.Error(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -357,7 +1037,7 @@ func TestOrderRepository_RemoveFromCart(t *testing.T) {
 
 		mock.ExpectRollback()
 
-		err := orderRepo.RemoveFromCart(orderID, bookID)
+		err := orderRepo.RemoveFromCart(context.Background(), orderID, bookID)
 		assert.Error(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -374,7 +1054,7 @@ func TestOrderRepository_RemoveFromCart(t *testing.T) {
 
 		mock.ExpectCommit().WillReturnError(sql.ErrConnDone)
 
-		err := orderRepo.RemoveFromCart(orderID, bookID)
+		err := orderRepo.RemoveFromCart(context.Background(), orderID, bookID)
 		assert.Error(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -388,19 +1068,30 @@ func TestOrderRepository_AddOrUpdateCart(t *testing.T) {
 	orderRepo := repository.NewOrderRepository(db)
 
 	orderID := 1
-	bookID := 2
-	quantity := 3
-	subtotal := int64(600)
+	detail := &model.OrderDetail{
+		OrderID:   int64(orderID),
+		BookID:    2,
+		Quantity:  3,
+		Subtotal:  money.New(600, money.USD),
+		UnitPrice: money.New(200, money.USD),
+		Title:     "1984",
+		Author:    "George Orwell",
+	}
+	args := []driver.Value{
+		detail.OrderID, detail.BookID, detail.Quantity, detail.Subtotal,
+		detail.UnitPrice, detail.Title, detail.Author, "USD",
+	}
 
 	insertQuery := regexp.QuoteMeta(
-		`INSERT INTO order_details (order_id, book_id, quantity, subtotal) VALUES 
-    ($1, $2, $3, $4) ON CONFLICT (order_id, book_id) DO UPDATE SET quantity = $3, subtotal = $4;`,
+		`INSERT INTO order_details (order_id, book_id, quantity, subtotal, unit_price, title, author, currency)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (order_id, book_id)`,
 	)
 
 	t.Run("successful add or update cart", func(t *testing.T) {
 		mock.ExpectBegin()
 		mock.ExpectExec(insertQuery).
-			WithArgs(orderID, bookID, quantity, subtotal).
+			WithArgs(args...).
 			WillReturnResult(sqlmock.NewResult(0, 1))
 
 		mock.ExpectExec(recalculationQuery).
@@ -409,7 +1100,7 @@ func TestOrderRepository_AddOrUpdateCart(t *testing.T) {
 
 		mock.ExpectCommit()
 
-		err := orderRepo.AddOrUpdateCart(orderID, bookID, quantity, subtotal)
+		err := orderRepo.AddOrUpdateCart(context.Background(), detail)
 		assert.NoError(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -417,7 +1108,7 @@ func TestOrderRepository_AddOrUpdateCart(t *testing.T) {
 	t.Run("error starting transaction", func(t *testing.T) {
 		mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
 
-		err := orderRepo.AddOrUpdateCart(orderID, bookID, quantity, subtotal)
+		err := orderRepo.AddOrUpdateCart(context.Background(), detail)
 		assert.Error(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -425,12 +1116,12 @@ func TestOrderRepository_AddOrUpdateCart(t *testing.T) {
 	t.Run("error during insert/update", func(t *testing.T) {
 		mock.ExpectBegin()
 		mock.ExpectExec(insertQuery).
-			WithArgs(orderID, bookID, quantity, subtotal).
+			WithArgs(args...).
 			WillReturnError(sql.ErrConnDone)
 
 		mock.ExpectRollback()
 
-		err := orderRepo.AddOrUpdateCart(orderID, bookID, quantity, subtotal)
+		err := orderRepo.AddOrUpdateCart(context.Background(), detail)
 		assert.Error(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -438,7 +1129,7 @@ func TestOrderRepository_AddOrUpdateCart(t *testing.T) {
 	t.Run("error recalculating total", func(t *testing.T) {
 		mock.ExpectBegin()
 		mock.ExpectExec(insertQuery).
-			WithArgs(orderID, bookID, quantity, subtotal).
+			WithArgs(args...).
 			WillReturnResult(sqlmock.NewResult(0, 1))
 
 		mock.ExpectExec(recalculationQuery).
@@ -447,7 +1138,7 @@ func TestOrderRepository_AddOrUpdateCart(t *testing.T) {
 
 		mock.ExpectRollback()
 
-		err := orderRepo.AddOrUpdateCart(orderID, bookID, quantity, subtotal)
+		err := orderRepo.AddOrUpdateCart(context.Background(), detail)
 		assert.Error(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -455,7 +1146,7 @@ func TestOrderRepository_AddOrUpdateCart(t *testing.T) {
 	t.Run("error committing transaction", func(t *testing.T) {
 		mock.ExpectBegin()
 		mock.ExpectExec(insertQuery).
-			WithArgs(orderID, bookID, quantity, subtotal).
+			WithArgs(args...).
 			WillReturnResult(sqlmock.NewResult(0, 1))
 
 		mock.ExpectExec(recalculationQuery).
@@ -464,8 +1155,480 @@ func TestOrderRepository_AddOrUpdateCart(t *testing.T) {
 
 		mock.ExpectCommit().WillReturnError(sql.ErrConnDone)
 
-		err := orderRepo.AddOrUpdateCart(orderID, bookID, quantity, subtotal)
+		err := orderRepo.AddOrUpdateCart(context.Background(), detail)
 		assert.Error(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
+
+func TestOrderRepository_RepriceCart(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	orderRepo := repository.NewOrderRepository(db)
+
+	orderID := 1
+
+	lockQuery := regexp.QuoteMeta(`SELECT id FROM orders WHERE id = $1 AND order_state = $2 FOR UPDATE`)
+	refreshQuery := regexp.QuoteMeta(`UPDATE order_details d SET title = b.title`)
+
+	t.Run("lines take the catalog price", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(orderID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orderID))
+		mock.ExpectExec(refreshQuery).
+			WithArgs(orderID, money.DefaultCurrency).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(recalculationQuery).
+			WithArgs(orderID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := orderRepo.RepriceCart(context.Background(), orderID)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("order is no longer a cart", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(orderID, model.OrderStateCart).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		err := orderRepo.RepriceCart(context.Background(), orderID)
+		assert.ErrorIs(t, err, utils.WarnCartEmpty)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("error refreshing lines", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(orderID, model.OrderStateCart).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orderID))
+		mock.ExpectExec(refreshQuery).
+			WithArgs(orderID, money.DefaultCurrency).
+			WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		err := orderRepo.RepriceCart(context.Background(), orderID)
+		assert.EqualError(t, err, "sql: connection is already closed")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOrderRepository_TransitionOrder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	orderRepo := repository.NewOrderRepository(db)
+
+	updateQuery := regexp.QuoteMeta(`UPDATE orders
+	SET order_state = $3, updated_at = NOW()
+	WHERE id = $1 AND order_state = $2`)
+	historyQuery := regexp.QuoteMeta(`INSERT INTO order_state_transitions`)
+
+	newTransition := func() *model.OrderStateTransition {
+		return &model.OrderStateTransition{
+			OrderID:   7,
+			From:      model.OrderStatePaid,
+			To:        model.OrderStateFulfilling,
+			ChangedBy: 2,
+			Note:      "picking",
+		}
+	}
+
+	t.Run("records the transition", func(t *testing.T) {
+		createdAt := time.Now()
+		mock.ExpectBegin()
+		mock.ExpectExec(updateQuery).
+			WithArgs(int64(7), model.OrderStatePaid, model.OrderStateFulfilling).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(historyQuery).
+			WithArgs(int64(7), model.OrderStatePaid, model.OrderStateFulfilling, int64(2), "picking").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(11, createdAt))
+		mock.ExpectCommit()
+
+		transition := newTransition()
+		err := orderRepo.TransitionOrder(context.Background(), transition)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(11), transition.ID)
+		assert.Equal(t, createdAt, transition.CreatedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("state changed concurrently", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(updateQuery).
+			WithArgs(int64(7), model.OrderStatePaid, model.OrderStateFulfilling).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := orderRepo.TransitionOrder(context.Background(), newTransition())
+
+		assert.ErrorIs(t, err, utils.ErrOrderStateChanged)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOrderRepository_GetOrderState(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	orderRepo := repository.NewOrderRepository(db)
+	query := regexp.QuoteMeta(`SELECT order_state FROM orders WHERE id = $1`)
+
+	t.Run("found", func(t *testing.T) {
+		mock.ExpectQuery(query).
+			WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"order_state"}).AddRow(int64(model.OrderStateShipped)))
+
+		state, err := orderRepo.GetOrderState(context.Background(), 7)
+
+		assert.NoError(t, err)
+		assert.Equal(t, model.OrderStateShipped, state)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		mock.ExpectQuery(query).WithArgs(8).WillReturnError(sql.ErrNoRows)
+
+		_, err := orderRepo.GetOrderState(context.Background(), 8)
+
+		assert.ErrorIs(t, err, utils.ErrOrderNotFound)
+	})
+}
+
+func TestOrderRepository_GetOrderSummary(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	orderRepo := repository.NewOrderRepository(db)
+	query := regexp.QuoteMeta(`SELECT id, customer_id, updated_at, order_state, total
+	FROM orders
+	WHERE id = $1`)
+
+	t.Run("found", func(t *testing.T) {
+		updatedAt := time.Now()
+		mock.ExpectQuery(query).
+			WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "updated_at", "order_state", "total"}).
+				AddRow(7, 1, updatedAt, model.OrderStatePaid, 2500))
+
+		order, err := orderRepo.GetOrderSummary(context.Background(), 7)
+
+		assert.NoError(t, err)
+		assert.Equal(t, &model.Order{
+			ID:         7,
+			CustomerID: 1,
+			UpdatedAt:  updatedAt,
+			OrderState: model.OrderStatePaid,
+			Total:      money.New(2500, money.USD),
+		}, order)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		mock.ExpectQuery(query).WithArgs(8).WillReturnError(sql.ErrNoRows)
+
+		_, err := orderRepo.GetOrderSummary(context.Background(), 8)
+
+		assert.ErrorIs(t, err, utils.ErrOrderNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOrderRepository_StartRefund(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	orderRepo := repository.NewOrderRepository(db)
+
+	orderID := int64(7)
+	customerID := int64(1)
+
+	lockQuery := regexp.QuoteMeta(`SELECT id FROM orders
+	WHERE id = $1 AND order_state = $2
+	FOR UPDATE`)
+	linesQuery := regexp.QuoteMeta(`SELECT id, book_id, title, quantity - refunded_quantity, unit_price, currency
+	FROM order_details
+	WHERE order_id = $1
+	ORDER BY id
+	FOR UPDATE`)
+	stateQuery := regexp.QuoteMeta(`UPDATE orders
+		SET order_state = $2, updated_at = NOW()
+		WHERE id = $1`)
+	historyQuery := regexp.QuoteMeta(`INSERT INTO order_state_transitions`)
+	countCopiesQuery := regexp.QuoteMeta(`UPDATE order_details
+		SET refunded_quantity = refunded_quantity + $2
+		WHERE id = $1`)
+	countAmountQuery := regexp.QuoteMeta(`UPDATE orders
+	SET refunded_total = refunded_total + $2
+	WHERE id = $1
+	RETURNING refunded_total >= COALESCE(total, 0)`)
+	refundQuery := regexp.QuoteMeta(`INSERT INTO refunds (order_id, payment_id, amount, status, reason, restock, created_by)`)
+	refundLineQuery := regexp.QuoteMeta(`INSERT INTO refund_lines (refund_id, order_detail_id, quantity, amount)`)
+
+	lineColumns := []string{"id", "book_id", "title", "left", "unit_price", "currency"}
+
+	expectLocked := func(state model.OrderState) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(orderID, state).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orderID))
+		mock.ExpectQuery(linesQuery).
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(lineColumns).
+				AddRow(1, 1, "1984", 2, 1000, "USD").
+				AddRow(2, 2, "Moby Dick", 0, 500, "USD").
+				AddRow(3, 3, "Free Sample", 1, 0, "USD"))
+	}
+
+	t.Run("cancellation refunds every copy left", func(t *testing.T) {
+		createdAt := time.Now()
+		expectLocked(model.OrderStatePaid)
+		mock.ExpectExec(stateQuery).
+			WithArgs(orderID, model.OrderStateCancelled).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(historyQuery).
+			WithArgs(orderID, model.OrderStatePaid, model.OrderStateCancelled, customerID, "cancelled by customer").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, createdAt))
+		mock.ExpectExec(countCopiesQuery).WithArgs(int64(1), int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(countCopiesQuery).WithArgs(int64(3), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(countAmountQuery).
+			WithArgs(orderID, money.New(2000, money.USD)).
+			WillReturnRows(sqlmock.NewRows([]string{"fully_refunded"}).AddRow(true))
+		mock.ExpectQuery(refundQuery).
+			WithArgs(orderID, int64(3), money.New(2000, money.USD), model.RefundStatusPending, "cancelled by customer", true, customerID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(5, createdAt, createdAt))
+		mock.ExpectExec(refundLineQuery).
+			WithArgs(int64(5), int64(1), int64(2), money.New(2000, money.USD)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(refundLineQuery).
+			WithArgs(int64(5), int64(3), int64(1), money.New(0, money.USD)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		refund := &model.Refund{OrderID: orderID, PaymentID: 3, Reason: "cancelled by customer", Restock: true, CreatedBy: customerID}
+		fullyRefunded, err := orderRepo.StartRefund(context.Background(), refund, &model.OrderStateTransition{
+			OrderID:   orderID,
+			From:      model.OrderStatePaid,
+			To:        model.OrderStateCancelled,
+			ChangedBy: customerID,
+			Note:      "cancelled by customer",
+		})
+
+		assert.NoError(t, err)
+		assert.True(t, fullyRefunded)
+		assert.Equal(t, int64(5), refund.ID)
+		assert.Equal(t, model.RefundStatusPending, refund.Status)
+		assert.Equal(t, money.New(2000, money.USD), refund.Amount)
+		assert.Equal(t, []model.RefundLine{
+			{OrderDetailID: 1, BookID: 1, Quantity: 2, Amount: money.New(2000, money.USD)},
+			{OrderDetailID: 3, BookID: 3, Quantity: 1, Amount: money.New(0, money.USD)},
+		}, refund.Lines)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("partial refund keeps the state", func(t *testing.T) {
+		createdAt := time.Now()
+		expectLocked(model.OrderStateDelivered)
+		mock.ExpectExec(countCopiesQuery).WithArgs(int64(1), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(countAmountQuery).
+			WithArgs(orderID, money.New(1000, money.USD)).
+			WillReturnRows(sqlmock.NewRows([]string{"fully_refunded"}).AddRow(false))
+		mock.ExpectQuery(refundQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(6, createdAt, createdAt))
+		mock.ExpectExec(refundLineQuery).
+			WithArgs(int64(6), int64(1), int64(1), money.New(1000, money.USD)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		refund := &model.Refund{OrderID: orderID, PaymentID: 3, CreatedBy: 2, Lines: []model.RefundLine{{BookID: 1, Quantity: 1}}}
+		fullyRefunded, err := orderRepo.StartRefund(context.Background(), refund, &model.OrderStateTransition{
+			OrderID: orderID,
+			From:    model.OrderStateDelivered,
+			To:      model.OrderStateDelivered,
+		})
+
+		assert.NoError(t, err)
+		assert.False(t, fullyRefunded)
+		assert.Equal(t, money.New(1000, money.USD), refund.Amount)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	for name, lines := range map[string][]model.RefundLine{
+		"more copies than are left": {{BookID: 1, Quantity: 3}},
+		"book not in the order":     {{BookID: 9, Quantity: 1}},
+		"book listed twice":         {{BookID: 1, Quantity: 1}, {BookID: 1, Quantity: 1}},
+		"refunded copies":           {{BookID: 2, Quantity: 1}},
+		"only free books":           {{BookID: 3, Quantity: 1}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expectLocked(model.OrderStatePaid)
+			mock.ExpectRollback()
+
+			refund := &model.Refund{OrderID: orderID, Lines: lines}
+			_, err := orderRepo.StartRefund(context.Background(), refund, &model.OrderStateTransition{
+				OrderID: orderID,
+				From:    model.OrderStatePaid,
+				To:      model.OrderStatePaid,
+			})
+
+			assert.ErrorIs(t, err, utils.ErrInvalidRefund)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("nothing left to refund", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(orderID, model.OrderStatePaid).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orderID))
+		mock.ExpectQuery(linesQuery).
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(lineColumns).AddRow(2, 2, "Moby Dick", 0, 500, "USD"))
+		mock.ExpectRollback()
+
+		_, err := orderRepo.StartRefund(context.Background(), &model.Refund{OrderID: orderID}, &model.OrderStateTransition{
+			OrderID: orderID,
+			From:    model.OrderStatePaid,
+			To:      model.OrderStatePaid,
+		})
+
+		assert.ErrorIs(t, err, utils.ErrNothingToRefund)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("state changed concurrently", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(orderID, model.OrderStatePaid).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		_, err := orderRepo.StartRefund(context.Background(), &model.Refund{OrderID: orderID}, &model.OrderStateTransition{
+			OrderID: orderID,
+			From:    model.OrderStatePaid,
+			To:      model.OrderStateCancelled,
+		})
+
+		assert.ErrorIs(t, err, utils.ErrOrderStateChanged)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOrderRepository_CompleteRefund(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	orderRepo := repository.NewOrderRepository(db)
+
+	succeedQuery := regexp.QuoteMeta(`UPDATE refunds
+	SET status = $2, updated_at = NOW()
+	WHERE id = $1
+	RETURNING updated_at`)
+	lockQuery := regexp.QuoteMeta(`SELECT order_state FROM orders
+		WHERE id = $1
+		FOR UPDATE`)
+	stateQuery := regexp.QuoteMeta(`UPDATE orders
+			SET order_state = $2, updated_at = NOW()
+			WHERE id = $1`)
+	historyQuery := regexp.QuoteMeta(`INSERT INTO order_state_transitions`)
+	restockQuery := regexp.QuoteMeta(`UPDATE books b SET stock = b.stock + l.quantity`)
+	ledgerQuery := regexp.QuoteMeta(`INSERT INTO stock_movements`)
+
+	t.Run("restocks and marks the order refunded", func(t *testing.T) {
+		now := time.Now()
+		mock.ExpectBegin()
+		mock.ExpectQuery(succeedQuery).
+			WithArgs(int64(5), model.RefundStatusSucceeded).
+			WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(now))
+		mock.ExpectQuery(lockQuery).
+			WithArgs(int64(7)).
+			WillReturnRows(sqlmock.NewRows([]string{"order_state"}).AddRow(model.OrderStateDelivered))
+		mock.ExpectExec(stateQuery).
+			WithArgs(int64(7), model.OrderStateRefunded).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(historyQuery).
+			WithArgs(int64(7), model.OrderStateDelivered, model.OrderStateRefunded, int64(2), "damaged in transit").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(12, now))
+		mock.ExpectExec(restockQuery).WithArgs(int64(5)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(ledgerQuery).
+			WithArgs(int64(5), model.StockReasonRefund).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		refund := &model.Refund{ID: 5, OrderID: 7, Restock: true, CreatedBy: 2, Reason: "damaged in transit"}
+		err := orderRepo.CompleteRefund(context.Background(), refund, true)
+
+		assert.NoError(t, err)
+		assert.Equal(t, model.RefundStatusSucceeded, refund.Status)
+		assert.Equal(t, now, refund.UpdatedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("partial refund leaves order and stock alone", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(succeedQuery).
+			WithArgs(int64(6), model.RefundStatusSucceeded).
+			WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+		mock.ExpectCommit()
+
+		err := orderRepo.CompleteRefund(context.Background(), &model.Refund{ID: 6, OrderID: 7}, false)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("error when restocking", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(succeedQuery).
+			WithArgs(int64(5), model.RefundStatusSucceeded).
+			WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+		mock.ExpectExec(restockQuery).WithArgs(int64(5)).WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		err := orderRepo.CompleteRefund(context.Background(), &model.Refund{ID: 5, OrderID: 7, Restock: true}, false)
+
+		assert.ErrorIs(t, err, sql.ErrConnDone)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOrderRepository_FailRefund(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	orderRepo := repository.NewOrderRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE refunds
+	SET status = $2, failure_reason = $3, updated_at = NOW()`)).
+		WithArgs(int64(5), model.RefundStatusFailed, "payment failed").
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE orders
+	SET refunded_total = refunded_total - $2`)).
+		WithArgs(int64(7), money.New(1000, money.USD)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE order_details d
+	SET refunded_quantity = d.refunded_quantity - l.quantity`)).
+		WithArgs(int64(5)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	refund := &model.Refund{ID: 5, OrderID: 7, Amount: money.New(1000, money.USD), FailureReason: "payment failed"}
+	err = orderRepo.FailRefund(context.Background(), refund)
+
+	assert.NoError(t, err)
+	assert.Equal(t, model.RefundStatusFailed, refund.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
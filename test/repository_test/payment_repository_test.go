@@ -0,0 +1,128 @@
+package repository_test
+
+import (
+	"bookstore/internal/model"
+	"bookstore/internal/repository"
+	"bookstore/pkg/money"
+	"bookstore/pkg/utils"
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentRepository_CreatePayment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	paymentRepo := repository.NewPaymentRepository(db)
+
+	insertQuery := regexp.QuoteMeta(`INSERT INTO payments (order_id, provider, provider_ref, amount, status, failure_reason)`)
+
+	t.Run("records the attempt", func(t *testing.T) {
+		now := time.Now()
+		payment := &model.Payment{
+			OrderID:  7,
+			Provider: "fake",
+			Amount:   money.New(2500, money.USD),
+			Status:   model.PaymentStatusPending,
+		}
+		mock.ExpectQuery(insertQuery).
+			WithArgs(int64(7), "fake", "", payment.Amount, model.PaymentStatusPending, "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(3, now, now))
+
+		err := paymentRepo.CreatePayment(context.Background(), payment)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), payment.ID)
+		assert.Equal(t, now, payment.CreatedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		mock.ExpectQuery(insertQuery).WillReturnError(sql.ErrConnDone)
+
+		err := paymentRepo.CreatePayment(context.Background(), &model.Payment{OrderID: 7})
+
+		assert.ErrorIs(t, err, sql.ErrConnDone)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPaymentRepository_UpdatePayment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	paymentRepo := repository.NewPaymentRepository(db)
+
+	updateQuery := regexp.QuoteMeta(`UPDATE payments
+	SET provider_ref = $2, status = $3, failure_reason = $4, updated_at = NOW()
+	WHERE id = $1`)
+
+	t.Run("stores the outcome", func(t *testing.T) {
+		now := time.Now()
+		payment := &model.Payment{
+			ID:            3,
+			ProviderRef:   "fake_000001",
+			Status:        model.PaymentStatusDeclined,
+			FailureReason: "payment declined: insufficient funds",
+		}
+		mock.ExpectQuery(updateQuery).
+			WithArgs(int64(3), "fake_000001", model.PaymentStatusDeclined, "payment declined: insufficient funds").
+			WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(now))
+
+		err := paymentRepo.UpdatePayment(context.Background(), payment)
+
+		assert.NoError(t, err)
+		assert.Equal(t, now, payment.UpdatedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPaymentRepository_GetCapturedPayment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	paymentRepo := repository.NewPaymentRepository(db)
+
+	selectQuery := regexp.QuoteMeta(`SELECT id, order_id, provider, provider_ref, amount, status, failure_reason, created_at, updated_at
+	FROM payments
+	WHERE order_id = $1 AND status = $2`)
+	columns := []string{
+		"id", "order_id", "provider", "provider_ref", "amount", "status", "failure_reason", "created_at", "updated_at",
+	}
+
+	t.Run("captured payment", func(t *testing.T) {
+		now := time.Now()
+		mock.ExpectQuery(selectQuery).
+			WithArgs(7, model.PaymentStatusCaptured).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(3, 7, "fake", "fake_000001", 2500, model.PaymentStatusCaptured, "", now, now))
+
+		payment, err := paymentRepo.GetCapturedPayment(context.Background(), 7)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), payment.ID)
+		assert.Equal(t, "fake_000001", payment.ProviderRef)
+		assert.Equal(t, money.New(2500, money.USD), payment.Amount)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("nothing captured", func(t *testing.T) {
+		mock.ExpectQuery(selectQuery).
+			WithArgs(7, model.PaymentStatusCaptured).
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		_, err := paymentRepo.GetCapturedPayment(context.Background(), 7)
+
+		assert.ErrorIs(t, err, utils.ErrPaymentNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,129 @@
+package repository_test
+
+import (
+	"bookstore/internal/model"
+	"bookstore/internal/repository"
+	"bookstore/pkg/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenRepository_RotateRefreshToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	tokenRepo := repository.NewTokenRepository(db)
+
+	useQuery := regexp.QuoteMeta(`UPDATE refresh_tokens
+	SET used_at = NOW()
+	WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL`)
+	insertQuery := regexp.QuoteMeta(`INSERT INTO refresh_tokens (customer_id, family_id, token_hash, expires_at)`)
+
+	newToken := func() *model.RefreshToken {
+		return &model.RefreshToken{
+			CustomerID: 1,
+			FamilyID:   "family-1",
+			TokenHash:  utils.HashToken("next"),
+			ExpiresAt:  time.Now().Add(utils.RefreshTokenTTL),
+		}
+	}
+
+	t.Run("marks the old token used and stores the new one", func(t *testing.T) {
+		next := newToken()
+		mock.ExpectBegin()
+		mock.ExpectExec(useQuery).WithArgs(int64(10)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(insertQuery).
+			WithArgs(next.CustomerID, next.FamilyID, next.TokenHash, next.ExpiresAt).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(11, time.Now()))
+		mock.ExpectCommit()
+
+		err := tokenRepo.RotateRefreshToken(context.Background(), 10, next)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(11), next.ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("already used token is reported as reuse", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(useQuery).WithArgs(int64(10)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := tokenRepo.RotateRefreshToken(context.Background(), 10, newToken())
+
+		assert.ErrorIs(t, err, utils.ErrRefreshTokenReused)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestTokenRepository_GetRefreshToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	tokenRepo := repository.NewTokenRepository(db)
+	query := regexp.QuoteMeta(`FROM refresh_tokens
+	WHERE token_hash = $1`)
+
+	mock.ExpectQuery(query).WithArgs("unknown").WillReturnError(sql.ErrNoRows)
+
+	_, err = tokenRepo.GetRefreshToken(context.Background(), "unknown")
+
+	assert.ErrorIs(t, err, utils.ErrInvalidRefreshToken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTokenRepository_IsTokenRevoked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	tokenRepo := repository.NewTokenRepository(db)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`)).
+		WithArgs("jti-1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	revoked, err := tokenRepo.IsTokenRevoked(context.Background(), "jti-1")
+
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestTokenRepository_DeleteExpiredTokens(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	tokenRepo := repository.NewTokenRepository(db)
+	refreshQuery := regexp.QuoteMeta("DELETE FROM refresh_tokens WHERE expires_at < NOW()")
+	revokedQuery := regexp.QuoteMeta("DELETE FROM revoked_tokens WHERE expires_at < NOW()")
+
+	t.Run("both tables are cleaned", func(t *testing.T) {
+		mock.ExpectExec(refreshQuery).WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec(revokedQuery).WillReturnResult(sqlmock.NewResult(0, 2))
+
+		deleted, err := tokenRepo.DeleteExpiredTokens(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), deleted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("error stops the cleanup", func(t *testing.T) {
+		mock.ExpectExec(refreshQuery).WillReturnError(errors.New("connection reset"))
+
+		_, err := tokenRepo.DeleteExpiredTokens(context.Background())
+
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,181 @@
+package server_test
+
+import (
+	"bookstore/internal/server"
+	"bookstore/internal/worker"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// slowHandler answers once release is closed and reports when a request arrived.
+func slowHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		io.WriteString(w, "done")
+	})
+}
+
+func start(t *testing.T, srv *server.Server) (string, context.CancelFunc, <-chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(ctx, listener)
+	}()
+	return "http://" + listener.Addr().String(), cancel, done
+}
+
+func TestServer_GracefulShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	srv := server.New(slowHandler(started, release), server.Config{ShutdownTimeout: 5 * time.Second})
+
+	var events []string
+	srv.RegisterOnShutdown(func() { events = append(events, "drain") })
+	srv.AddCloser("worker", func() error {
+		events = append(events, "worker")
+		return nil
+	})
+	srv.AddCloser("database", func() error {
+		events = append(events, "database")
+		return nil
+	})
+
+	url, cancel, done := start(t, srv)
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		response <- result{body: string(body), err: err}
+	}()
+
+	<-started
+	cancel()
+
+	// the in-flight request keeps the server from stopping
+	select {
+	case err := <-done:
+		t.Fatalf("server stopped with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	got := <-response
+	assert.NoError(t, got.err)
+	assert.Equal(t, "done", got.body)
+	assert.NoError(t, <-done)
+	assert.Equal(t, []string{"drain", "worker", "database"}, events)
+
+	// new connections are refused once stopped
+	_, err := http.Get(url)
+	assert.Error(t, err)
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+
+	srv := server.New(slowHandler(started, release), server.Config{ShutdownTimeout: 20 * time.Millisecond})
+
+	closed := false
+	srv.AddCloser("database", func() error {
+		closed = true
+		return errors.New("already closed")
+	})
+
+	url, cancel, done := start(t, srv)
+	go http.Get(url)
+
+	<-started
+	cancel()
+
+	err := <-done
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "already closed")
+	assert.True(t, closed)
+}
+
+func TestServer_DrainDelay(t *testing.T) {
+	srv := server.New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}), server.Config{ShutdownTimeout: time.Second, DrainDelay: 200 * time.Millisecond})
+
+	drained := make(chan struct{})
+	srv.RegisterOnShutdown(func() { close(drained) })
+
+	url, cancel, done := start(t, srv)
+	cancel()
+	<-drained
+
+	// readiness already fails, but the listener still takes new connections
+	resp, err := http.Get(url)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	assert.NoError(t, <-done)
+	_, err = http.Get(url)
+	assert.Error(t, err)
+}
+
+// TestServer_StopsWorkersBeforeDatabase wires a real background worker the
+// way main does, a job still running at shutdown finishes before the
+// database it uses is closed.
+func TestServer_StopsWorkersBeforeDatabase(t *testing.T) {
+	srv := server.New(http.NotFoundHandler(), server.Config{ShutdownTimeout: time.Second})
+
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	running := make(chan struct{})
+	cleanup := worker.NewPeriodic("cleanup", time.Hour, func(ctx context.Context) error {
+		close(running)
+		<-ctx.Done()
+		record("cleanup finished")
+		return nil
+	})
+	cleanup.Start()
+	<-running
+
+	srv.AddCloser("cleanup", cleanup.Stop)
+	srv.AddCloser("database", func() error {
+		record("database closed")
+		return nil
+	})
+
+	_, cancel, done := start(t, srv)
+	cancel()
+
+	assert.NoError(t, <-done)
+	assert.Equal(t, []string{"cleanup finished", "database closed"}, events)
+}
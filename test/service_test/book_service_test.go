@@ -2,7 +2,10 @@ package service_test
 
 import (
 	"bookstore/internal/model"
+	"bookstore/pkg/money"
+	"bookstore/pkg/utils"
 	"bookstore/test/mocks"
+	"context"
 
 	"bookstore/internal/service"
 	"errors"
@@ -19,23 +22,31 @@ func TestCreateBook(t *testing.T) {
 	mockRepo := mocks.NewMockBookRepository(ctrl)
 	bookService := service.NewBookService(mockRepo)
 
-	book := &model.Book{Title: "Test Book", Author: "Test Author"}
+	book := &model.Book{Title: "Test Book", Author: "Test Author", Price: money.New(1099, money.USD)}
 
 	t.Run("Success", func(t *testing.T) {
-		mockRepo.EXPECT().CreateBook(book).Return(nil)
+		mockRepo.EXPECT().CreateBook(gomock.Any(), book).Return(nil)
 
-		err := bookService.CreateBook(book)
+		err := bookService.CreateBook(context.Background(), book)
 
 		assert.NoError(t, err)
 	})
 
 	t.Run("Error", func(t *testing.T) {
-		mockRepo.EXPECT().CreateBook(book).Return(errors.New("creation error"))
+		mockRepo.EXPECT().CreateBook(gomock.Any(), book).Return(errors.New("creation error"))
 
-		err := bookService.CreateBook(book)
+		err := bookService.CreateBook(context.Background(), book)
 
 		assert.Error(t, err)
 	})
+
+	t.Run("Price must be positive", func(t *testing.T) {
+		for _, price := range []money.Money{money.New(0, money.USD), money.New(-100, money.USD)} {
+			err := bookService.CreateBook(context.Background(), &model.Book{Title: "Test Book", Author: "Test Author", Price: price})
+
+			assert.ErrorIs(t, err, utils.ErrInvalidBookPrice, price.String())
+		}
+	})
 }
 
 func TestGetBookById(t *testing.T) {
@@ -47,18 +58,18 @@ func TestGetBookById(t *testing.T) {
 
 	t.Run("Success", func(t *testing.T) {
 		book := &model.Book{ID: 1, Title: "Test Book"}
-		mockRepo.EXPECT().GetBookById(1).Return(book, nil)
+		mockRepo.EXPECT().GetBookById(gomock.Any(), 1).Return(book, nil)
 
-		result, err := bookService.GetBookById(1)
+		result, err := bookService.GetBookById(context.Background(), 1)
 
 		assert.NoError(t, err)
 		assert.Equal(t, book, result)
 	})
 
 	t.Run("Error", func(t *testing.T) {
-		mockRepo.EXPECT().GetBookById(1).Return(nil, errors.New("not found"))
+		mockRepo.EXPECT().GetBookById(gomock.Any(), 1).Return(nil, errors.New("not found"))
 
-		result, err := bookService.GetBookById(1)
+		result, err := bookService.GetBookById(context.Background(), 1)
 
 		assert.Error(t, err)
 		assert.Nil(t, result)
@@ -72,20 +83,65 @@ func TestGetBooks(t *testing.T) {
 	mockRepo := mocks.NewMockBookRepository(ctrl)
 	bookService := service.NewBookService(mockRepo)
 
-	t.Run("Success", func(t *testing.T) {
-		books := []model.Book{{ID: 1, Title: "Book 1"}, {ID: 2, Title: "Book 2"}}
-		mockRepo.EXPECT().GetBooks().Return(books, nil)
+	t.Run("Success with defaults", func(t *testing.T) {
+		page := &model.BookPage{
+			Books: []model.Book{{ID: 1, Title: "Book 1"}, {ID: 2, Title: "Book 2"}},
+			Total: 2,
+			Limit: service.DefaultBookPageSize,
+		}
+		mockRepo.EXPECT().GetBooks(gomock.Any(), model.BookQuery{
+			SortBy:  model.BookSortID,
+			SortDir: model.SortAsc,
+			Limit:   service.DefaultBookPageSize,
+		}).Return(page, nil)
+
+		result, err := bookService.GetBooks(context.Background(), model.BookQuery{})
 
-		result, err := bookService.GetBooks()
+		assert.NoError(t, err)
+		assert.Equal(t, page, result)
+	})
+
+	t.Run("Keeps explicit query", func(t *testing.T) {
+		query := model.BookQuery{
+			Title:   "gatsby",
+			SortBy:  model.BookSortPrice,
+			SortDir: model.SortDesc,
+			Limit:   5,
+			Cursor:  "abc",
+		}
+		mockRepo.EXPECT().GetBooks(gomock.Any(), query).Return(&model.BookPage{}, nil)
+
+		_, err := bookService.GetBooks(context.Background(), query)
 
 		assert.NoError(t, err)
-		assert.Equal(t, books, result)
+	})
+
+	t.Run("Invalid queries", func(t *testing.T) {
+		low := money.New(1000, money.USD)
+		high := money.New(100, money.USD)
+
+		invalid := map[string]model.BookQuery{
+			"unknown sort":         {SortBy: "isbn"},
+			"unknown direction":    {SortDir: "sideways"},
+			"limit too large":      {Limit: service.MaxBookPageSize + 1},
+			"negative limit":       {Limit: -1},
+			"negative offset":      {Offset: -1},
+			"offset and cursor":    {Offset: 10, Cursor: "abc"},
+			"inverted price range": {MinPrice: &low, MaxPrice: &high},
+		}
+
+		for name, query := range invalid {
+			result, err := bookService.GetBooks(context.Background(), query)
+
+			assert.ErrorIs(t, err, utils.ErrInvalidBookQuery, name)
+			assert.Nil(t, result, name)
+		}
 	})
 
 	t.Run("Error", func(t *testing.T) {
-		mockRepo.EXPECT().GetBooks().Return(nil, errors.New("error"))
+		mockRepo.EXPECT().GetBooks(gomock.Any(), gomock.Any()).Return(nil, errors.New("error"))
 
-		result, err := bookService.GetBooks()
+		result, err := bookService.GetBooks(context.Background(), model.BookQuery{})
 
 		assert.Error(t, err)
 		assert.Nil(t, result)
@@ -99,21 +155,67 @@ func TestUpdateBook(t *testing.T) {
 	mockRepo := mocks.NewMockBookRepository(ctrl)
 	bookService := service.NewBookService(mockRepo)
 
-	book := &model.Book{ID: 1, Title: "Updated Book"}
+	book := &model.Book{ID: 1, Title: "Updated Book", Price: money.New(1099, money.USD)}
 
 	t.Run("Success", func(t *testing.T) {
-		mockRepo.EXPECT().UpdateBook(book).Return(nil)
+		mockRepo.EXPECT().UpdateBook(gomock.Any(), book).Return(nil)
 
-		err := bookService.UpdateBook(book)
+		err := bookService.UpdateBook(context.Background(), book)
 
 		assert.NoError(t, err)
 	})
 
 	t.Run("Error", func(t *testing.T) {
-		mockRepo.EXPECT().UpdateBook(book).Return(errors.New("update error"))
+		mockRepo.EXPECT().UpdateBook(gomock.Any(), book).Return(errors.New("update error"))
 
-		err := bookService.UpdateBook(book)
+		err := bookService.UpdateBook(context.Background(), book)
 
 		assert.Error(t, err)
 	})
+
+	t.Run("Price must be positive", func(t *testing.T) {
+		for _, price := range []money.Money{money.New(0, money.USD), money.New(-100, money.USD)} {
+			err := bookService.UpdateBook(context.Background(), &model.Book{ID: 1, Title: "Updated Book", Price: price})
+
+			assert.ErrorIs(t, err, utils.ErrInvalidBookPrice, price.String())
+		}
+	})
+}
+
+func TestAdjustStock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockBookRepository(ctrl)
+	bookService := service.NewBookService(mockRepo)
+
+	t.Run("Success", func(t *testing.T) {
+		movement := &model.StockMovement{BookID: 1, Change: 5, Reason: model.StockReasonRestock}
+		mockRepo.EXPECT().AdjustStock(gomock.Any(), movement).Return(nil)
+
+		err := bookService.AdjustStock(context.Background(), movement)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("Zero change", func(t *testing.T) {
+		err := bookService.AdjustStock(context.Background(), &model.StockMovement{BookID: 1, Reason: model.StockReasonRestock})
+
+		assert.ErrorIs(t, err, utils.ErrInvalidStockChange)
+	})
+
+	t.Run("Sale reason is reserved for checkout", func(t *testing.T) {
+		err := bookService.AdjustStock(context.Background(), &model.StockMovement{BookID: 1, Change: -1, Reason: model.StockReasonSale})
+
+		assert.ErrorIs(t, err, utils.ErrInvalidStockChange)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		movement := &model.StockMovement{BookID: 1, Change: -5, Reason: model.StockReasonLost}
+		mockRepo.EXPECT().AdjustStock(gomock.Any(), movement).Return(utils.ErrInsufficientStock)
+
+		err := bookService.AdjustStock(context.Background(), movement)
+
+		assert.ErrorIs(t, err, utils.ErrInsufficientStock)
+	})
 }
@@ -0,0 +1,114 @@
+package service_test
+
+import (
+	"bookstore/internal/handler/request"
+	"bookstore/internal/metrics"
+	"bookstore/internal/model"
+	"bookstore/internal/repository"
+	"bookstore/internal/service"
+	"bookstore/pkg/money"
+	"bookstore/test/mocks"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryCartStore keeps carts in memory and enforces one open cart per
+// customer, like the orders_one_open_cart_per_customer index. It only stands
+// in for the repository so the service can be driven concurrently, the SQL of
+// CreateOrderIfNotExists is covered in the repository tests.
+type memoryCartStore struct {
+	repository.OrderRepository // Only the cart methods are implemented
+
+	mu     sync.Mutex
+	nextID int
+	carts  map[int]int // customer ID to cart ID
+	opened int
+	lines  map[int]map[int64]int64 // cart ID to book ID to quantity
+}
+
+func newMemoryCartStore() *memoryCartStore {
+	return &memoryCartStore{carts: map[int]int{}, lines: map[int]map[int64]int64{}}
+}
+
+func (s *memoryCartStore) CreateOrderIfNotExists(_ context.Context, customerID int) (int, bool, error) {
+	s.mu.Lock()
+	id, ok := s.carts[customerID]
+	s.mu.Unlock()
+	if ok {
+		return id, false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.carts[customerID]; ok {
+		// ON CONFLICT DO NOTHING, then the winner's cart is read
+		return id, false, nil
+	}
+	s.nextID++
+	s.carts[customerID] = s.nextID
+	s.lines[s.nextID] = map[int64]int64{}
+	s.opened++
+	return s.nextID, true, nil
+}
+
+func (s *memoryCartStore) AddOrUpdateCart(_ context.Context, detail *model.OrderDetail) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines[int(detail.OrderID)][detail.BookID] = detail.Quantity
+	return nil
+}
+
+// TestAddToCart_ConcurrentSmoke is a service-level smoke test: concurrent
+// AddToCart calls share the cart the store hands back and count it once. It
+// proves nothing about the database, see
+// TestOrderRepository_CreateOrderIfNotExists for the conflict and retry paths.
+func TestAddToCart_ConcurrentSmoke(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := newMemoryCartStore()
+	mockBookRepo := mocks.NewMockBookRepository(ctrl)
+	registry := prometheus.NewRegistry()
+	orderService := service.NewOrderService(store, mockBookRepo, nil, nil, metrics.New(registry), cancelWindow)
+
+	mockBookRepo.EXPECT().
+		GetBookById(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, id int) (*model.Book, error) {
+			return &model.Book{ID: int64(id), Title: "Book", Author: "Author", Price: money.New(500, money.USD)}, nil
+		}).
+		AnyTimes()
+
+	const customerID, calls = 1, 50
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, calls)
+	for book := 1; book <= calls; book++ {
+		wg.Add(1)
+		go func(book int) {
+			defer wg.Done()
+			<-start
+			errs <- orderService.AddToCart(context.Background(), customerID, request.AddToCartRequest{
+				BookId:   int64(book),
+				Quantity: 1,
+			})
+		}(book)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, store.opened, "a single cart is opened")
+	assert.Len(t, store.lines[store.carts[customerID]], calls, "every book lands in that cart")
+	assertMetric(t, registry, "bookstore_carts_created_total", 1)
+	assertMetric(t, registry, "bookstore_cart_items_added_total", calls)
+}
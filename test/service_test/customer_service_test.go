@@ -1,13 +1,19 @@
 package service_test
 
 import (
+	"bookstore/internal/handler/request"
+	"bookstore/internal/mail"
 	"bookstore/internal/model"
 	"bookstore/internal/service"
 	"bookstore/pkg/utils"
 	"bookstore/test/mocks"
+	"context"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
@@ -17,7 +23,8 @@ func TestCustomerService_Register(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockRepo := mocks.NewMockCustomerRepository(ctrl)
-	service := service.NewCustomerService(mockRepo)
+	mockTokenRepo := mocks.NewMockTokenRepository(ctrl)
+	service := service.NewCustomerService(mockRepo, mockTokenRepo, nil, testSigner)
 
 	customer := &model.Customer{
 		ID:       1,
@@ -25,10 +32,32 @@ func TestCustomerService_Register(t *testing.T) {
 		Password: "hashedpassword",
 	}
 
-	mockRepo.EXPECT().Register(customer).Return(nil).Times(1)
+	mockRepo.EXPECT().Register(gomock.Any(), customer).Return(nil).Times(1)
 
-	err := service.Register(customer)
+	err := service.Register(context.Background(), customer)
 	assert.NoError(t, err)
+	assert.Equal(t, model.RoleCustomer, customer.Role)
+}
+
+func TestCustomerService_Register_IgnoresRequestedRole(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockCustomerRepository(ctrl)
+	mockTokenRepo := mocks.NewMockTokenRepository(ctrl)
+	service := service.NewCustomerService(mockRepo, mockTokenRepo, nil, testSigner)
+
+	customer := &model.Customer{
+		Email:    "sneaky@example.com",
+		Password: "password",
+		Role:     model.RoleAdmin,
+	}
+
+	mockRepo.EXPECT().Register(gomock.Any(), customer).Return(nil).Times(1)
+
+	err := service.Register(context.Background(), customer)
+	assert.NoError(t, err)
+	assert.Equal(t, model.RoleCustomer, customer.Role)
 }
 
 func TestCustomerService_Login_Success(t *testing.T) {
@@ -36,7 +65,8 @@ func TestCustomerService_Login_Success(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockRepo := mocks.NewMockCustomerRepository(ctrl)
-	service := service.NewCustomerService(mockRepo)
+	mockTokenRepo := mocks.NewMockTokenRepository(ctrl)
+	service := service.NewCustomerService(mockRepo, mockTokenRepo, nil, testSigner)
 
 	email := "test@example.com"
 	password := "password"
@@ -46,13 +76,35 @@ func TestCustomerService_Login_Success(t *testing.T) {
 		ID:       1,
 		Email:    email,
 		Password: hashedPassword,
+		Role:     model.RoleAdmin,
 	}
 
-	mockRepo.EXPECT().Login(email, password).Return(customer, nil).Times(1)
+	mockRepo.EXPECT().Login(gomock.Any(), email, password).Return(customer, nil).Times(1)
+
+	var stored *model.RefreshToken
+	mockTokenRepo.EXPECT().
+		CreateRefreshToken(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, token *model.RefreshToken) error {
+			stored = token
+			return nil
+		})
+
+	tokens, err := service.Login(context.Background(), email, password)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.Equal(t, int64(utils.AccessTokenTTL/time.Second), tokens.ExpiresIn)
+
+	// only the hash of the refresh token is stored
+	assert.Equal(t, utils.HashToken(tokens.RefreshToken), stored.TokenHash)
+	assert.NotEqual(t, tokens.RefreshToken, stored.TokenHash)
+	assert.Equal(t, customer.ID, stored.CustomerID)
+	assert.NotEmpty(t, stored.FamilyID)
 
-	token, err := service.Login(email, password)
+	claims := &utils.Claims{}
+	_, err = jwt.ParseWithClaims(tokens.AccessToken, claims, testSigner.SigningKey)
 	assert.NoError(t, err)
-	assert.NotEmpty(t, token)
+	assert.Equal(t, model.RoleAdmin, claims.Role)
+	assert.NotEmpty(t, claims.Id)
 }
 
 func TestCustomerService_Login_Failure(t *testing.T) {
@@ -60,14 +112,337 @@ func TestCustomerService_Login_Failure(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockRepo := mocks.NewMockCustomerRepository(ctrl)
-	service := service.NewCustomerService(mockRepo)
+	mockTokenRepo := mocks.NewMockTokenRepository(ctrl)
+	service := service.NewCustomerService(mockRepo, mockTokenRepo, nil, testSigner)
 
 	email := "test@example.com"
 	password := "wrongpassword"
 
-	mockRepo.EXPECT().Login(email, password).Return(nil, errors.New("invalid credentials")).Times(1)
+	mockRepo.EXPECT().Login(gomock.Any(), email, password).Return(nil, errors.New("invalid credentials")).Times(1)
 
-	token, err := service.Login(email, password)
+	tokens, err := service.Login(context.Background(), email, password)
 	assert.Error(t, err)
-	assert.Empty(t, token)
+	assert.Nil(t, tokens)
+}
+
+func TestCustomerService_UpdateRole(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockCustomerRepository(ctrl)
+	mockTokenRepo := mocks.NewMockTokenRepository(ctrl)
+	service := service.NewCustomerService(mockRepo, mockTokenRepo, nil, testSigner)
+
+	t.Run("Success", func(t *testing.T) {
+		mockRepo.EXPECT().UpdateRole(gomock.Any(), 2, model.RoleStaff).Return(nil)
+
+		err := service.UpdateRole(context.Background(), 2, model.RoleStaff)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Invalid role", func(t *testing.T) {
+		err := service.UpdateRole(context.Background(), 2, model.Role("superuser"))
+		assert.ErrorIs(t, err, utils.ErrInvalidRole)
+	})
+}
+
+func TestCustomerService_RefreshToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockCustomerRepository(ctrl)
+	mockTokenRepo := mocks.NewMockTokenRepository(ctrl)
+	service := service.NewCustomerService(mockRepo, mockTokenRepo, nil, testSigner)
+
+	refreshToken := "presented-refresh-token"
+	customer := &model.Customer{ID: 1, Email: "test@example.com", Role: model.RoleCustomer}
+	storedToken := func() *model.RefreshToken {
+		return &model.RefreshToken{
+			ID:         10,
+			CustomerID: 1,
+			FamilyID:   "family-1",
+			TokenHash:  utils.HashToken(refreshToken),
+			ExpiresAt:  time.Now().Add(time.Hour),
+		}
+	}
+
+	t.Run("Rotates the token", func(t *testing.T) {
+		mockTokenRepo.EXPECT().
+			GetRefreshToken(gomock.Any(), utils.HashToken(refreshToken)).
+			Return(storedToken(), nil)
+		mockRepo.EXPECT().GetCustomerById(gomock.Any(), 1).Return(customer, nil)
+
+		var next *model.RefreshToken
+		mockTokenRepo.EXPECT().
+			RotateRefreshToken(gomock.Any(), int64(10), gomock.Any()).
+			DoAndReturn(func(_ context.Context, _ int64, token *model.RefreshToken) error {
+				next = token
+				return nil
+			})
+
+		tokens, err := service.RefreshToken(context.Background(), refreshToken)
+
+		assert.NoError(t, err)
+		assert.NotEmpty(t, tokens.AccessToken)
+		assert.NotEqual(t, refreshToken, tokens.RefreshToken)
+		assert.Equal(t, utils.HashToken(tokens.RefreshToken), next.TokenHash)
+		assert.Equal(t, "family-1", next.FamilyID)
+	})
+
+	t.Run("Reuse of a used token revokes the family", func(t *testing.T) {
+		used := storedToken()
+		usedAt := time.Now().Add(-time.Minute)
+		used.UsedAt = &usedAt
+
+		mockTokenRepo.EXPECT().GetRefreshToken(gomock.Any(), gomock.Any()).Return(used, nil)
+		mockTokenRepo.EXPECT().RevokeTokenFamily(gomock.Any(), "family-1").Return(nil)
+
+		tokens, err := service.RefreshToken(context.Background(), refreshToken)
+
+		assert.ErrorIs(t, err, utils.ErrRefreshTokenReused)
+		assert.Nil(t, tokens)
+	})
+
+	t.Run("Reuse of a revoked token revokes the family", func(t *testing.T) {
+		revoked := storedToken()
+		revokedAt := time.Now().Add(-time.Minute)
+		revoked.RevokedAt = &revokedAt
+
+		mockTokenRepo.EXPECT().GetRefreshToken(gomock.Any(), gomock.Any()).Return(revoked, nil)
+		mockTokenRepo.EXPECT().RevokeTokenFamily(gomock.Any(), "family-1").Return(nil)
+
+		_, err := service.RefreshToken(context.Background(), refreshToken)
+
+		assert.ErrorIs(t, err, utils.ErrRefreshTokenReused)
+	})
+
+	t.Run("Losing a concurrent rotation counts as reuse", func(t *testing.T) {
+		mockTokenRepo.EXPECT().GetRefreshToken(gomock.Any(), gomock.Any()).Return(storedToken(), nil)
+		mockRepo.EXPECT().GetCustomerById(gomock.Any(), 1).Return(customer, nil)
+		mockTokenRepo.EXPECT().
+			RotateRefreshToken(gomock.Any(), int64(10), gomock.Any()).
+			Return(utils.ErrRefreshTokenReused)
+		mockTokenRepo.EXPECT().RevokeTokenFamily(gomock.Any(), "family-1").Return(nil)
+
+		_, err := service.RefreshToken(context.Background(), refreshToken)
+
+		assert.ErrorIs(t, err, utils.ErrRefreshTokenReused)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		expired := storedToken()
+		expired.ExpiresAt = time.Now().Add(-time.Second)
+
+		mockTokenRepo.EXPECT().GetRefreshToken(gomock.Any(), gomock.Any()).Return(expired, nil)
+
+		_, err := service.RefreshToken(context.Background(), refreshToken)
+
+		assert.ErrorIs(t, err, utils.ErrRefreshTokenExpired)
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		mockTokenRepo.EXPECT().
+			GetRefreshToken(gomock.Any(), gomock.Any()).
+			Return(nil, utils.ErrInvalidRefreshToken)
+
+		_, err := service.RefreshToken(context.Background(), "made-up")
+
+		assert.ErrorIs(t, err, utils.ErrInvalidRefreshToken)
+	})
+}
+
+func TestCustomerService_Logout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockCustomerRepository(ctrl)
+	mockTokenRepo := mocks.NewMockTokenRepository(ctrl)
+	service := service.NewCustomerService(mockRepo, mockTokenRepo, nil, testSigner)
+
+	expiresAt := time.Now().Add(utils.AccessTokenTTL)
+
+	t.Run("Revokes access token and refresh family", func(t *testing.T) {
+		mockTokenRepo.EXPECT().RevokeAccessToken(gomock.Any(), "jti-1", expiresAt).Return(nil)
+		mockTokenRepo.EXPECT().
+			GetRefreshToken(gomock.Any(), utils.HashToken("refresh")).
+			Return(&model.RefreshToken{CustomerID: 1, FamilyID: "family-1"}, nil)
+		mockTokenRepo.EXPECT().RevokeTokenFamily(gomock.Any(), "family-1").Return(nil)
+
+		err := service.Logout(context.Background(), 1, "jti-1", expiresAt, "refresh")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Refresh token of another customer", func(t *testing.T) {
+		mockTokenRepo.EXPECT().RevokeAccessToken(gomock.Any(), "jti-1", expiresAt).Return(nil)
+		mockTokenRepo.EXPECT().
+			GetRefreshToken(gomock.Any(), gomock.Any()).
+			Return(&model.RefreshToken{CustomerID: 2, FamilyID: "family-2"}, nil)
+
+		err := service.Logout(context.Background(), 1, "jti-1", expiresAt, "refresh")
+		assert.ErrorIs(t, err, utils.ErrInvalidRefreshToken)
+	})
+}
+
+func TestCustomerService_UpdateProfile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockCustomerRepository(ctrl)
+	customerService := service.NewCustomerService(mockRepo, nil, nil, testSigner)
+
+	t.Run("Only the given fields change", func(t *testing.T) {
+		address := "Elm Street 1"
+		profile := &model.CustomerProfile{ID: 1, Name: "Jane", Address: address}
+		mockRepo.EXPECT().UpdateProfile(gomock.Any(), 1, nil, &address).Return(profile, nil)
+
+		updated, err := customerService.UpdateProfile(context.Background(), 1, request.UpdateProfileRequest{Address: &address})
+
+		assert.NoError(t, err)
+		assert.Equal(t, profile, updated)
+	})
+
+	t.Run("Nothing to update", func(t *testing.T) {
+		_, err := customerService.UpdateProfile(context.Background(), 1, request.UpdateProfileRequest{})
+
+		assert.ErrorIs(t, err, utils.ErrNothingToUpdate)
+	})
+}
+
+func TestCustomerService_ChangePassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockCustomerRepository(ctrl)
+	mockTokenRepo := mocks.NewMockTokenRepository(ctrl)
+	customerService := service.NewCustomerService(mockRepo, mockTokenRepo, nil, testSigner)
+
+	current, _ := utils.HashPassword("old-password")
+
+	t.Run("Re-hashes and logs out every session", func(t *testing.T) {
+		gomock.InOrder(
+			mockRepo.EXPECT().GetPasswordHash(gomock.Any(), 1).Return(current, nil),
+			mockRepo.EXPECT().
+				UpdatePassword(gomock.Any(), 1, gomock.Any()).
+				DoAndReturn(func(_ context.Context, _ int, hash string) error {
+					assert.NotEqual(t, "new-password", hash)
+					assert.True(t, utils.CheckPassword("new-password", hash))
+					return nil
+				}),
+			mockTokenRepo.EXPECT().RevokeCustomerTokens(gomock.Any(), 1).Return(nil),
+		)
+
+		err := customerService.ChangePassword(context.Background(), 1, request.ChangePasswordRequest{
+			CurrentPassword: "old-password",
+			NewPassword:     "new-password",
+		})
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("Wrong current password", func(t *testing.T) {
+		mockRepo.EXPECT().GetPasswordHash(gomock.Any(), 1).Return(current, nil)
+
+		err := customerService.ChangePassword(context.Background(), 1, request.ChangePasswordRequest{
+			CurrentPassword: "guess",
+			NewPassword:     "new-password",
+		})
+
+		assert.ErrorIs(t, err, utils.ErrWrongPassword)
+	})
+}
+
+func TestCustomerService_ChangeEmail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockCustomerRepository(ctrl)
+	mockMailer := mocks.NewMockSender(ctrl)
+	customerService := service.NewCustomerService(mockRepo, nil, mockMailer, testSigner)
+
+	current, _ := utils.HashPassword("password")
+
+	t.Run("Mails a token to the new email", func(t *testing.T) {
+		var stored *model.EmailVerification
+		gomock.InOrder(
+			mockRepo.EXPECT().GetPasswordHash(gomock.Any(), 1).Return(current, nil),
+			mockRepo.EXPECT().
+				CreateEmailVerification(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, verification *model.EmailVerification) error {
+					stored = verification
+					return nil
+				}),
+			mockMailer.EXPECT().
+				Send(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, message mail.Message) error {
+					assert.Equal(t, "new@example.com", message.To)
+					token := message.Body[strings.LastIndex(message.Body, " ")+1:]
+					assert.Equal(t, utils.HashToken(token), stored.TokenHash)
+					return nil
+				}),
+		)
+
+		err := customerService.ChangeEmail(context.Background(), 1, request.ChangeEmailRequest{
+			Email:    "New@Example.com",
+			Password: "password",
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), stored.CustomerID)
+		assert.Equal(t, "new@example.com", stored.Email)
+		assert.WithinDuration(t, time.Now().Add(service.EmailVerificationTTL), stored.ExpiresAt, time.Minute)
+	})
+
+	t.Run("Wrong password", func(t *testing.T) {
+		mockRepo.EXPECT().GetPasswordHash(gomock.Any(), 1).Return(current, nil)
+
+		err := customerService.ChangeEmail(context.Background(), 1, request.ChangeEmailRequest{
+			Email:    "new@example.com",
+			Password: "guess",
+		})
+
+		assert.ErrorIs(t, err, utils.ErrWrongPassword)
+	})
+
+	t.Run("Email taken", func(t *testing.T) {
+		mockRepo.EXPECT().GetPasswordHash(gomock.Any(), 1).Return(current, nil)
+		mockRepo.EXPECT().CreateEmailVerification(gomock.Any(), gomock.Any()).Return(utils.ErrDuplicateEmail)
+
+		err := customerService.ChangeEmail(context.Background(), 1, request.ChangeEmailRequest{
+			Email:    "taken@example.com",
+			Password: "password",
+		})
+
+		assert.ErrorIs(t, err, utils.ErrDuplicateEmail)
+	})
+}
+
+func TestCustomerService_VerifyEmail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockCustomerRepository(ctrl)
+	customerService := service.NewCustomerService(mockRepo, nil, nil, testSigner)
+
+	t.Run("Confirms the new email", func(t *testing.T) {
+		profile := &model.CustomerProfile{ID: 1, Email: "new@example.com"}
+		gomock.InOrder(
+			mockRepo.EXPECT().
+				ConfirmEmail(gomock.Any(), utils.HashToken("token")).
+				Return(&model.EmailVerification{CustomerID: 1, Email: "new@example.com"}, nil),
+			mockRepo.EXPECT().GetProfile(gomock.Any(), 1).Return(profile, nil),
+		)
+
+		confirmed, err := customerService.VerifyEmail(context.Background(), "token")
+
+		assert.NoError(t, err)
+		assert.Equal(t, profile, confirmed)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		mockRepo.EXPECT().ConfirmEmail(gomock.Any(), gomock.Any()).Return(nil, utils.ErrVerificationTokenExpired)
+
+		_, err := customerService.VerifyEmail(context.Background(), "token")
+
+		assert.ErrorIs(t, err, utils.ErrVerificationTokenExpired)
+	})
 }
@@ -0,0 +1,12 @@
+package service_test
+
+import (
+	"bookstore/pkg/utils"
+	"time"
+)
+
+// testSigner signs the access tokens issued and checked by the tests.
+var testSigner = utils.NewTokenSigner("test-secret-key-that-is-long-enough-for-hs256")
+
+// cancelWindow is the cancellation window the order services are built with.
+const cancelWindow = 24 * time.Hour
@@ -0,0 +1,236 @@
+package service_test
+
+import (
+	"bookstore/internal/handler/request"
+	"bookstore/internal/model"
+	"bookstore/internal/service"
+	"bookstore/pkg/money"
+	"bookstore/pkg/utils"
+	"bookstore/test/mocks"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	mockPaymentRepo := mocks.NewMockPaymentRepository(ctrl)
+	mockProvider := mocks.NewMockPaymentProvider(ctrl)
+	orderService := service.NewOrderService(mockRepo, nil, mockPaymentRepo, mockProvider, nil, time.Hour)
+
+	customerID := 1
+	orderID := 7
+	total := money.New(2500, money.USD)
+	paid := &model.Order{
+		ID:         int64(orderID),
+		CustomerID: int64(customerID),
+		OrderState: model.OrderStatePaid,
+		Total:      total,
+	}
+	captured := &model.Payment{ID: 3, OrderID: int64(orderID), ProviderRef: "ref_1", Amount: total, Status: model.PaymentStatusCaptured}
+
+	expectPaidAgo := func(ago time.Duration) {
+		mockRepo.EXPECT().GetOrderSummary(gomock.Any(), orderID).Return(paid, nil)
+		mockRepo.EXPECT().GetOrderTransitions(gomock.Any(), orderID).Return([]model.OrderStateTransition{
+			{From: model.OrderStateCart, To: model.OrderStatePendingPayment, CreatedAt: time.Now().Add(-ago)},
+			{From: model.OrderStatePendingPayment, To: model.OrderStatePaid, CreatedAt: time.Now().Add(-ago)},
+		}, nil)
+	}
+
+	expectStarted := func() *gomock.Call {
+		mockPaymentRepo.EXPECT().GetCapturedPayment(gomock.Any(), orderID).Return(captured, nil)
+		return mockRepo.EXPECT().
+			StartRefund(gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, refund *model.Refund, transition *model.OrderStateTransition) (bool, error) {
+				assert.Equal(t, int64(3), refund.PaymentID)
+				assert.True(t, refund.Restock)
+				assert.Empty(t, refund.Lines)
+				assert.Equal(t, model.OrderStatePaid, transition.From)
+				assert.Equal(t, model.OrderStateCancelled, transition.To)
+				refund.ID = 5
+				refund.Amount = total
+				return true, nil
+			})
+	}
+
+	t.Run("Refunds the payment, restocks and marks the order refunded", func(t *testing.T) {
+		expectPaidAgo(time.Minute)
+		gomock.InOrder(
+			expectStarted(),
+			mockProvider.EXPECT().Refund(gomock.Any(), "ref_1", total).Return(nil),
+			// fully refunded, so the cancelled order becomes refunded too
+			mockRepo.EXPECT().CompleteRefund(gomock.Any(), gomock.Any(), true).Return(nil),
+			expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusRefunded),
+		)
+
+		refund, err := orderService.CancelOrder(context.Background(), customerID, orderID)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), refund.ID)
+		assert.Equal(t, total, refund.Amount)
+	})
+
+	t.Run("Refused refund is given back", func(t *testing.T) {
+		expectPaidAgo(time.Minute)
+		gomock.InOrder(
+			expectStarted(),
+			mockProvider.EXPECT().Refund(gomock.Any(), "ref_1", total).Return(utils.ErrPaymentFailed),
+			mockRepo.EXPECT().
+				FailRefund(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, refund *model.Refund) error {
+					assert.Equal(t, "payment failed", refund.FailureReason)
+					return nil
+				}),
+		)
+
+		_, err := orderService.CancelOrder(context.Background(), customerID, orderID)
+
+		assert.ErrorIs(t, err, utils.ErrPaymentFailed)
+	})
+
+	t.Run("Window is over", func(t *testing.T) {
+		expectPaidAgo(2 * time.Hour)
+
+		_, err := orderService.CancelOrder(context.Background(), customerID, orderID)
+
+		assert.ErrorIs(t, err, utils.ErrCancelWindowOver)
+	})
+
+	t.Run("Order being fulfilled", func(t *testing.T) {
+		fulfilling := *paid
+		fulfilling.OrderState = model.OrderStateFulfilling
+		mockRepo.EXPECT().GetOrderSummary(gomock.Any(), orderID).Return(&fulfilling, nil)
+
+		_, err := orderService.CancelOrder(context.Background(), customerID, orderID)
+
+		var invalid *utils.InvalidTransitionError
+		assert.ErrorAs(t, err, &invalid)
+		assert.Equal(t, model.OrderStateFulfilling, invalid.Current)
+		assert.Equal(t, model.OrderStateCancelled, invalid.Requested)
+	})
+
+	t.Run("Order of another customer", func(t *testing.T) {
+		mockRepo.EXPECT().GetOrderSummary(gomock.Any(), orderID).Return(paid, nil)
+
+		_, err := orderService.CancelOrder(context.Background(), 2, orderID)
+
+		assert.ErrorIs(t, err, utils.ErrOrderNotFound)
+	})
+
+	t.Run("Concurrent fulfilment is rechecked", func(t *testing.T) {
+		fulfilling := *paid
+		fulfilling.OrderState = model.OrderStateFulfilling
+		expectPaidAgo(time.Minute)
+		gomock.InOrder(
+			mockPaymentRepo.EXPECT().GetCapturedPayment(gomock.Any(), orderID).Return(captured, nil),
+			mockRepo.EXPECT().StartRefund(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, utils.ErrOrderStateChanged),
+			mockRepo.EXPECT().GetOrderSummary(gomock.Any(), orderID).Return(&fulfilling, nil),
+		)
+
+		_, err := orderService.CancelOrder(context.Background(), customerID, orderID)
+
+		assert.ErrorIs(t, err, utils.ErrInvalidTransition)
+	})
+}
+
+func TestRefundOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	mockPaymentRepo := mocks.NewMockPaymentRepository(ctrl)
+	mockProvider := mocks.NewMockPaymentProvider(ctrl)
+	orderService := service.NewOrderService(mockRepo, nil, mockPaymentRepo, mockProvider, nil, cancelWindow)
+
+	adminID := 2
+	orderID := 7
+	delivered := &model.Order{ID: int64(orderID), CustomerID: 1, OrderState: model.OrderStateDelivered}
+	captured := &model.Payment{ID: 3, OrderID: int64(orderID), ProviderRef: "ref_1", Status: model.PaymentStatusCaptured}
+
+	expectStarted := func(fullyRefunded bool, amount money.Money) *gomock.Call {
+		mockRepo.EXPECT().GetOrderSummary(gomock.Any(), orderID).Return(delivered, nil)
+		mockPaymentRepo.EXPECT().GetCapturedPayment(gomock.Any(), orderID).Return(captured, nil)
+		return mockRepo.EXPECT().
+			StartRefund(gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, refund *model.Refund, transition *model.OrderStateTransition) (bool, error) {
+				assert.Equal(t, model.OrderStateDelivered, transition.From)
+				assert.Equal(t, model.OrderStateDelivered, transition.To)
+				assert.Equal(t, int64(adminID), refund.CreatedBy)
+				refund.Amount = amount
+				return fullyRefunded, nil
+			})
+	}
+
+	t.Run("Partial refund of a line", func(t *testing.T) {
+		amount := money.New(1000, money.USD)
+		gomock.InOrder(
+			expectStarted(false, amount),
+			mockProvider.EXPECT().Refund(gomock.Any(), "ref_1", amount).Return(nil),
+			mockRepo.EXPECT().CompleteRefund(gomock.Any(), gomock.Any(), false).Return(nil),
+		)
+
+		refund, err := orderService.RefundOrder(context.Background(), orderID, adminID, request.RefundOrderRequest{
+			Lines:   []request.RefundLineRequest{{BookId: 1, Quantity: 1}},
+			Restock: true,
+			Reason:  "damaged in transit",
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []model.RefundLine{{BookID: 1, Quantity: 1}}, refund.Lines)
+		assert.True(t, refund.Restock)
+		assert.Equal(t, "damaged in transit", refund.Reason)
+	})
+
+	t.Run("Refunding the rest marks order and payment refunded", func(t *testing.T) {
+		amount := money.New(1500, money.USD)
+		gomock.InOrder(
+			expectStarted(true, amount),
+			mockProvider.EXPECT().Refund(gomock.Any(), "ref_1", amount).Return(nil),
+			mockRepo.EXPECT().CompleteRefund(gomock.Any(), gomock.Any(), true).Return(nil),
+			expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusRefunded),
+		)
+
+		_, err := orderService.RefundOrder(context.Background(), orderID, adminID, request.RefundOrderRequest{})
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("Order never paid", func(t *testing.T) {
+		cart := *delivered
+		cart.OrderState = model.OrderStateCart
+		mockRepo.EXPECT().GetOrderSummary(gomock.Any(), orderID).Return(&cart, nil)
+
+		_, err := orderService.RefundOrder(context.Background(), orderID, adminID, request.RefundOrderRequest{})
+
+		var invalid *utils.InvalidTransitionError
+		assert.ErrorAs(t, err, &invalid)
+		assert.Equal(t, model.OrderStateRefunded, invalid.Requested)
+	})
+
+	t.Run("Invalid lines never reach the provider", func(t *testing.T) {
+		mockRepo.EXPECT().GetOrderSummary(gomock.Any(), orderID).Return(delivered, nil)
+		mockPaymentRepo.EXPECT().GetCapturedPayment(gomock.Any(), orderID).Return(captured, nil)
+		mockRepo.EXPECT().StartRefund(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, utils.ErrInvalidRefund)
+
+		_, err := orderService.RefundOrder(context.Background(), orderID, adminID, request.RefundOrderRequest{
+			Lines: []request.RefundLineRequest{{BookId: 9, Quantity: 1}},
+		})
+
+		assert.ErrorIs(t, err, utils.ErrInvalidRefund)
+	})
+
+	t.Run("Payment already refunded", func(t *testing.T) {
+		mockRepo.EXPECT().GetOrderSummary(gomock.Any(), orderID).Return(delivered, nil)
+		mockPaymentRepo.EXPECT().GetCapturedPayment(gomock.Any(), orderID).Return(nil, utils.ErrPaymentNotFound)
+
+		_, err := orderService.RefundOrder(context.Background(), orderID, adminID, request.RefundOrderRequest{})
+
+		assert.ErrorIs(t, err, utils.ErrPaymentNotFound)
+	})
+}
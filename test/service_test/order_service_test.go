@@ -2,14 +2,21 @@ package service_test
 
 import (
 	"bookstore/internal/handler/request"
+	"bookstore/internal/metrics"
 	"bookstore/internal/model"
+	"bookstore/internal/payment"
+	"bookstore/pkg/money"
+	"context"
 
 	"bookstore/internal/service"
+	"bookstore/pkg/utils"
 	"bookstore/test/mocks"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,31 +25,107 @@ func TestAddToCart(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockRepo := mocks.NewMockOrderRepository(ctrl)
-	orderService := service.NewOrderService(mockRepo)
+	mockBookRepo := mocks.NewMockBookRepository(ctrl)
+	registry := prometheus.NewRegistry()
+	orderService := service.NewOrderService(mockRepo, mockBookRepo, nil, nil, metrics.New(registry), cancelWindow)
 
 	customerID := 1
 	request := request.AddToCartRequest{
 		BookId:   1,
 		Quantity: 2,
-		Price:    10.0,
+	}
+	book := &model.Book{ID: 1, Title: "1984", Author: "George Orwell", Price: money.New(1000, money.USD)}
+	line := &model.OrderDetail{
+		OrderID:   1,
+		BookID:    1,
+		Quantity:  2,
+		Subtotal:  money.New(2000, money.USD),
+		UnitPrice: money.New(1000, money.USD),
+		Title:     "1984",
+		Author:    "George Orwell",
 	}
 
 	t.Run("Success", func(t *testing.T) {
 		orderID := 1
-		mockRepo.EXPECT().CreateOrderIfNotExists(customerID).Return(orderID, nil)
+		mockBookRepo.EXPECT().GetBookById(gomock.Any(), int(request.BookId)).Return(book, nil)
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(orderID, false, nil)
+		mockRepo.EXPECT().
+			AddOrUpdateCart(gomock.Any(), line).
+			Return(nil)
+
+		err := orderService.AddToCart(context.Background(), customerID, request)
+
+		assert.NoError(t, err)
+		assertMetric(t, registry, "bookstore_cart_items_added_total", 1)
+		assertMetric(t, registry, "bookstore_carts_created_total", 0)
+	})
+
+	t.Run("First item creates the cart", func(t *testing.T) {
+		mockBookRepo.EXPECT().GetBookById(gomock.Any(), int(request.BookId)).Return(book, nil)
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(1, true, nil)
+		mockRepo.EXPECT().AddOrUpdateCart(gomock.Any(), line).Return(nil)
+
+		err := orderService.AddToCart(context.Background(), customerID, request)
+
+		assert.NoError(t, err)
+		assertMetric(t, registry, "bookstore_carts_created_total", 1)
+	})
+
+	t.Run("Client price is ignored", func(t *testing.T) {
+		orderID := 1
+		cheap := 0.01
+		tampered := request
+		tampered.Price = &cheap
+
+		mockBookRepo.EXPECT().GetBookById(gomock.Any(), int(request.BookId)).Return(book, nil)
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(orderID, false, nil)
 		mockRepo.EXPECT().
-			AddOrUpdateCart(orderID, int(request.BookId), int(request.Quantity), gomock.Any()).
+			AddOrUpdateCart(gomock.Any(), line).
 			Return(nil)
 
-		err := orderService.AddToCart(customerID, request)
+		err := orderService.AddToCart(context.Background(), customerID, tampered)
 
 		assert.NoError(t, err)
 	})
 
+	t.Run("Unknown book", func(t *testing.T) {
+		mockBookRepo.EXPECT().
+			GetBookById(gomock.Any(), int(request.BookId)).
+			Return(&model.Book{}, utils.ErrBookNotFound)
+
+		err := orderService.AddToCart(context.Background(), customerID, request)
+
+		assert.ErrorIs(t, err, utils.ErrBookUnavailable)
+	})
+
+	t.Run("Archived book", func(t *testing.T) {
+		archivedAt := time.Now()
+		archived := *book
+		archived.ArchivedAt = &archivedAt
+		mockBookRepo.EXPECT().
+			GetBookById(gomock.Any(), int(request.BookId)).
+			Return(&archived, nil)
+
+		err := orderService.AddToCart(context.Background(), customerID, request)
+
+		assert.ErrorIs(t, err, utils.ErrBookUnavailable)
+	})
+
+	t.Run("Error getting book", func(t *testing.T) {
+		mockBookRepo.EXPECT().
+			GetBookById(gomock.Any(), int(request.BookId)).
+			Return(nil, errors.New("book error"))
+
+		err := orderService.AddToCart(context.Background(), customerID, request)
+
+		assert.EqualError(t, err, "book error")
+	})
+
 	t.Run("Error creating order", func(t *testing.T) {
-		mockRepo.EXPECT().CreateOrderIfNotExists(customerID).Return(0, errors.New("creation error"))
+		mockBookRepo.EXPECT().GetBookById(gomock.Any(), int(request.BookId)).Return(book, nil)
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(0, false, errors.New("creation error"))
 
-		err := orderService.AddToCart(customerID, request)
+		err := orderService.AddToCart(context.Background(), customerID, request)
 
 		assert.Error(t, err)
 		assert.EqualError(t, err, "creation error")
@@ -50,12 +133,13 @@ func TestAddToCart(t *testing.T) {
 
 	t.Run("Error adding to cart", func(t *testing.T) {
 		orderID := 1
-		mockRepo.EXPECT().CreateOrderIfNotExists(customerID).Return(orderID, nil)
+		mockBookRepo.EXPECT().GetBookById(gomock.Any(), int(request.BookId)).Return(book, nil)
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(orderID, false, nil)
 		mockRepo.EXPECT().
-			AddOrUpdateCart(orderID, int(request.BookId), int(request.Quantity), gomock.Any()).
+			AddOrUpdateCart(gomock.Any(), gomock.Any()).
 			Return(errors.New("add error"))
 
-		err := orderService.AddToCart(customerID, request)
+		err := orderService.AddToCart(context.Background(), customerID, request)
 
 		assert.Error(t, err)
 		assert.EqualError(t, err, "add error")
@@ -67,7 +151,8 @@ func TestGetCart(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockRepo := mocks.NewMockOrderRepository(ctrl)
-	orderService := service.NewOrderService(mockRepo)
+	mockBookRepo := mocks.NewMockBookRepository(ctrl)
+	orderService := service.NewOrderService(mockRepo, mockBookRepo, nil, nil, nil, cancelWindow)
 
 	customerID := 1
 
@@ -75,13 +160,13 @@ func TestGetCart(t *testing.T) {
 		orderID := 1
 		detailID := int64(1)
 		quantity := int64(2)
-		subtotal := 20.0
+		subtotal := money.New(2000, money.USD)
 
 		expectedBook := model.Book{
 			ID:     1,
 			Title:  "Example Book",
 			Author: "Author Name",
-			Price:  10.0,
+			Price:  money.New(1000, money.USD),
 		}
 
 		expectedOrderDetailResponse := model.OrderDetailResponse{
@@ -99,10 +184,10 @@ func TestGetCart(t *testing.T) {
 			Total: subtotal,
 		}
 
-		mockRepo.EXPECT().CreateOrderIfNotExists(customerID).Return(orderID, nil)
-		mockRepo.EXPECT().GetCart(orderID).Return(expectedResponse, nil)
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(orderID, false, nil)
+		mockRepo.EXPECT().GetCart(gomock.Any(), orderID).Return(expectedResponse, nil)
 
-		response, err := orderService.GetCart(customerID)
+		response, err := orderService.GetCart(context.Background(), customerID)
 
 		assert.NoError(t, err)
 		assert.Equal(t, expectedResponse, response)
@@ -111,14 +196,14 @@ func TestGetCart(t *testing.T) {
 	t.Run("Cart Empty", func(t *testing.T) {
 		orderID := 1
 
-		mockRepo.EXPECT().CreateOrderIfNotExists(customerID).Return(orderID, nil)
-		mockRepo.EXPECT().GetCart(orderID).Return(&model.OrderResponse{
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(orderID, false, nil)
+		mockRepo.EXPECT().GetCart(gomock.Any(), orderID).Return(&model.OrderResponse{
 			ID:          int64(orderID),
 			OrderDetail: []model.OrderDetailResponse{},
-			Total:       0.0,
+			Total:       money.New(0, money.USD),
 		}, nil)
 
-		response, err := orderService.GetCart(customerID)
+		response, err := orderService.GetCart(context.Background(), customerID)
 
 		assert.Error(t, err)
 		assert.EqualError(t, err, "cart empty")
@@ -126,9 +211,9 @@ func TestGetCart(t *testing.T) {
 	})
 
 	t.Run("Error creating order", func(t *testing.T) {
-		mockRepo.EXPECT().CreateOrderIfNotExists(customerID).Return(0, errors.New("creation error"))
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(0, false, errors.New("creation error"))
 
-		response, err := orderService.GetCart(customerID)
+		response, err := orderService.GetCart(context.Background(), customerID)
 
 		assert.Error(t, err)
 		assert.Nil(t, response)
@@ -136,37 +221,83 @@ func TestGetCart(t *testing.T) {
 
 	t.Run("Error getting cart", func(t *testing.T) {
 		orderID := 1
-		mockRepo.EXPECT().CreateOrderIfNotExists(customerID).Return(orderID, nil)
-		mockRepo.EXPECT().GetCart(orderID).Return(nil, errors.New("get cart error"))
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(orderID, false, nil)
+		mockRepo.EXPECT().GetCart(gomock.Any(), orderID).Return(nil, errors.New("get cart error"))
 
-		response, err := orderService.GetCart(customerID)
+		response, err := orderService.GetCart(context.Background(), customerID)
 
 		assert.Error(t, err)
 		assert.Nil(t, response)
 	})
 }
 
-func TestGetOrderHistory(t *testing.T) {
+func TestRepriceCart(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mockRepo := mocks.NewMockOrderRepository(ctrl)
-	orderService := service.NewOrderService(mockRepo)
+	mockBookRepo := mocks.NewMockBookRepository(ctrl)
+	orderService := service.NewOrderService(mockRepo, mockBookRepo, nil, nil, nil, cancelWindow)
 
 	customerID := 1
-	request := request.HistoryRequest{Limit: 10, Page: 1}
+	orderID := 1
 
 	t.Run("Success", func(t *testing.T) {
-		expectedResponse := []model.OrderResponse{
-			{ID: 1},
-			{ID: 2},
+		repriced := &model.OrderResponse{
+			ID:    int64(orderID),
+			State: model.OrderStateCart,
+			OrderDetail: []model.OrderDetailResponse{
+				{ID: 1, Quantity: 2, Subtotal: money.New(2400, money.USD)},
+			},
+			Total: money.New(2400, money.USD),
+		}
+
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(orderID, false, nil)
+		mockRepo.EXPECT().RepriceCart(gomock.Any(), orderID).Return(nil)
+		mockRepo.EXPECT().GetCart(gomock.Any(), orderID).Return(repriced, nil)
+
+		response, err := orderService.RepriceCart(context.Background(), customerID)
+
+		assert.NoError(t, err)
+		assert.Equal(t, repriced, response)
+	})
+
+	t.Run("Error repricing", func(t *testing.T) {
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(orderID, false, nil)
+		mockRepo.EXPECT().RepriceCart(gomock.Any(), orderID).Return(utils.WarnCartEmpty)
+
+		response, err := orderService.RepriceCart(context.Background(), customerID)
+
+		assert.ErrorIs(t, err, utils.WarnCartEmpty)
+		assert.Nil(t, response)
+	})
+}
+
+func TestGetOrderHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	mockBookRepo := mocks.NewMockBookRepository(ctrl)
+	orderService := service.NewOrderService(mockRepo, mockBookRepo, nil, nil, nil, cancelWindow)
+
+	customerID := 1
+
+	t.Run("Success with default page size", func(t *testing.T) {
+		expectedResponse := &model.OrderHistoryPage{
+			Orders: []model.OrderResponse{{ID: 2}, {ID: 1}},
+			Total:  2,
+			Limit:  service.DefaultOrderPageSize,
 		}
 
 		mockRepo.EXPECT().
-			GetOrderHistory(customerID, request.Limit, request.Page).
+			GetOrderHistory(gomock.Any(), model.OrderHistoryQuery{
+				CustomerID: int64(customerID),
+				Limit:      service.DefaultOrderPageSize,
+			}).
 			Return(expectedResponse, nil)
 
-		response, err := orderService.GetOrderHistory(customerID, request)
+		response, err := orderService.GetOrderHistory(context.Background(), customerID, model.OrderHistoryQuery{})
 
 		assert.NoError(t, err)
 		assert.Equal(t, expectedResponse, response)
@@ -174,14 +305,30 @@ func TestGetOrderHistory(t *testing.T) {
 
 	t.Run("Error", func(t *testing.T) {
 		mockRepo.EXPECT().
-			GetOrderHistory(customerID, request.Limit, request.Page).
+			GetOrderHistory(gomock.Any(), gomock.Any()).
 			Return(nil, errors.New("history error"))
 
-		response, err := orderService.GetOrderHistory(customerID, request)
+		response, err := orderService.GetOrderHistory(context.Background(), customerID, model.OrderHistoryQuery{})
 
 		assert.Error(t, err)
 		assert.Nil(t, response)
 	})
+
+	t.Run("Invalid queries never reach the repository", func(t *testing.T) {
+		from := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+		to := from.Add(-time.Hour)
+		negative := money.New(-1, money.USD)
+
+		for name, query := range map[string]model.OrderHistoryQuery{
+			"limit too large":   {Limit: service.MaxOrderPageSize + 1},
+			"from after to":     {From: &from, To: &to},
+			"cart state":        {States: []model.OrderState{model.OrderStateCart}},
+			"negative minTotal": {MinTotal: &negative},
+		} {
+			_, err := orderService.GetOrderHistory(context.Background(), customerID, query)
+			assert.ErrorIs(t, err, utils.ErrInvalidOrderQuery, name)
+		}
+	})
 }
 
 func TestRemoveFromCart(t *testing.T) {
@@ -189,7 +336,8 @@ func TestRemoveFromCart(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockRepo := mocks.NewMockOrderRepository(ctrl)
-	orderService := service.NewOrderService(mockRepo)
+	mockBookRepo := mocks.NewMockBookRepository(ctrl)
+	orderService := service.NewOrderService(mockRepo, mockBookRepo, nil, nil, nil, cancelWindow)
 
 	customerID := 1
 	bookID := 1
@@ -197,18 +345,18 @@ func TestRemoveFromCart(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
 		orderID := 1
 
-		mockRepo.EXPECT().CreateOrderIfNotExists(customerID).Return(orderID, nil)
-		mockRepo.EXPECT().RemoveFromCart(orderID, bookID).Return(nil)
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(orderID, false, nil)
+		mockRepo.EXPECT().RemoveFromCart(gomock.Any(), orderID, bookID).Return(nil)
 
-		err := orderService.RemoveFromCart(customerID, bookID)
+		err := orderService.RemoveFromCart(context.Background(), customerID, bookID)
 
 		assert.NoError(t, err)
 	})
 
 	t.Run("Error creating order", func(t *testing.T) {
-		mockRepo.EXPECT().CreateOrderIfNotExists(customerID).Return(0, errors.New("creation error"))
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(0, false, errors.New("creation error"))
 
-		err := orderService.RemoveFromCart(customerID, bookID)
+		err := orderService.RemoveFromCart(context.Background(), customerID, bookID)
 
 		assert.Error(t, err)
 		assert.EqualError(t, err, "creation error")
@@ -217,10 +365,10 @@ func TestRemoveFromCart(t *testing.T) {
 	t.Run("Error removing from cart", func(t *testing.T) {
 		orderID := 1
 
-		mockRepo.EXPECT().CreateOrderIfNotExists(customerID).Return(orderID, nil)
-		mockRepo.EXPECT().RemoveFromCart(orderID, bookID).Return(errors.New("remove error"))
+		mockRepo.EXPECT().CreateOrderIfNotExists(gomock.Any(), customerID).Return(orderID, false, nil)
+		mockRepo.EXPECT().RemoveFromCart(gomock.Any(), orderID, bookID).Return(errors.New("remove error"))
 
-		err := orderService.RemoveFromCart(customerID, bookID)
+		err := orderService.RemoveFromCart(context.Background(), customerID, bookID)
 
 		assert.Error(t, err)
 		assert.EqualError(t, err, "remove error")
@@ -232,24 +380,412 @@ func TestPayOrder(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockRepo := mocks.NewMockOrderRepository(ctrl)
-	orderService := service.NewOrderService(mockRepo)
+	mockBookRepo := mocks.NewMockBookRepository(ctrl)
+	mockPaymentRepo := mocks.NewMockPaymentRepository(ctrl)
+	registry := prometheus.NewRegistry()
+	orderService := service.NewOrderService(
+		mockRepo,
+		mockBookRepo,
+		mockPaymentRepo,
+		payment.NewFakeProvider(),
+		metrics.New(registry),
+		cancelWindow,
+	)
+
+	customerID := 1
+	orderID := 7
+	total := money.New(2500, money.USD)
+
+	expectCheckout := func() {
+		mockRepo.EXPECT().
+			StartCheckout(gomock.Any(), customerID).
+			Return(&model.Order{ID: int64(orderID), CustomerID: int64(customerID), Total: total}, nil)
+		mockPaymentRepo.EXPECT().
+			CreatePayment(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, p *model.Payment) error {
+				assert.Equal(t, model.PaymentStatusPending, p.Status)
+				assert.Equal(t, total, p.Amount)
+				p.ID = 3
+				return nil
+			})
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		expectCheckout()
+		gomock.InOrder(
+			expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusAuthorized),
+			expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusCaptured),
+			mockRepo.EXPECT().CompleteCheckout(gomock.Any(), orderID, customerID).Return(nil),
+			mockRepo.EXPECT().GetOrder(gomock.Any(), orderID).Return(&model.OrderResponse{
+				ID:          int64(orderID),
+				State:       model.OrderStatePaid,
+				Total:       total,
+				OrderDetail: []model.OrderDetailResponse{{ID: 1, Quantity: 1, Subtotal: total}},
+			}, nil),
+		)
+
+		paid, err := orderService.PayOrder(context.Background(), customerID, payment.Card{Number: payment.FakeCardSuccess})
+
+		assert.NoError(t, err)
+		assert.Equal(t, model.PaymentStatusCaptured, paid.Payment.Status)
+		assert.Equal(t, payment.FakeProviderName, paid.Payment.Provider)
+		assert.NotEmpty(t, paid.Payment.ProviderRef)
+		assert.Equal(t, int64(orderID), paid.Order.ID)
+		assert.Equal(t, model.OrderStatePaid, paid.Order.State)
+		assert.Len(t, paid.Order.OrderDetail, 1)
+		assertMetric(t, registry, "bookstore_orders_paid_total", 1)
+		assertMetric(t, registry, "bookstore_revenue_minor_units_total", 2500, "currency", money.USD)
+	})
+
+	t.Run("Declined card returns the cart", func(t *testing.T) {
+		expectCheckout()
+		gomock.InOrder(
+			expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusDeclined),
+			mockRepo.EXPECT().AbandonCheckout(gomock.Any(), orderID, customerID, "payment declined").Return(nil),
+		)
+
+		paid, err := orderService.PayOrder(context.Background(), customerID, payment.Card{Number: payment.FakeCardDeclined})
+
+		assert.ErrorIs(t, err, utils.ErrPaymentDeclined)
+		assert.Nil(t, paid)
+		assertMetric(t, registry, "bookstore_payment_failures_total", 1, "reason", metrics.PaymentFailureDeclined)
+	})
+
+	t.Run("Timeout returns the cart", func(t *testing.T) {
+		expectCheckout()
+		gomock.InOrder(
+			expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusFailed),
+			mockRepo.EXPECT().AbandonCheckout(gomock.Any(), orderID, customerID, "payment failed").Return(nil),
+		)
+
+		_, err := orderService.PayOrder(context.Background(), customerID, payment.Card{Number: payment.FakeCardTimeout})
+
+		assert.ErrorIs(t, err, utils.ErrPaymentTimeout)
+		assertMetric(t, registry, "bookstore_payment_failures_total", 1, "reason", metrics.PaymentFailureError)
+		assertMetric(t, registry, "bookstore_orders_paid_total", 1)
+	})
+
+	t.Run("Cart that cannot be returned is reported", func(t *testing.T) {
+		expectCheckout()
+		gomock.InOrder(
+			expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusDeclined),
+			mockRepo.EXPECT().
+				AbandonCheckout(gomock.Any(), orderID, customerID, "payment declined").
+				Return(errors.New("db down")),
+		)
+
+		_, err := orderService.PayOrder(context.Background(), customerID, payment.Card{Number: payment.FakeCardDeclined})
+
+		assert.ErrorIs(t, err, utils.ErrPaymentDeclined)
+		assert.ErrorContains(t, err, "could not return order 7 to the cart: db down")
+	})
+
+	t.Run("Empty cart never reaches the provider", func(t *testing.T) {
+		mockRepo.EXPECT().StartCheckout(gomock.Any(), customerID).Return(nil, utils.WarnCartEmpty)
+
+		_, err := orderService.PayOrder(context.Background(), customerID, payment.Card{Number: payment.FakeCardSuccess})
+
+		assert.ErrorIs(t, err, utils.WarnCartEmpty)
+	})
+
+	t.Run("Unavailable books never reach the provider", func(t *testing.T) {
+		mockRepo.EXPECT().
+			StartCheckout(gomock.Any(), customerID).
+			Return(nil, &utils.UnavailableItemsError{Items: []utils.UnavailableItem{{BookID: 4, Title: "Gone"}}})
+
+		_, err := orderService.PayOrder(context.Background(), customerID, payment.Card{Number: payment.FakeCardSuccess})
+
+		var unavailable *utils.UnavailableItemsError
+		assert.ErrorAs(t, err, &unavailable)
+		assert.ErrorIs(t, err, utils.ErrBookUnavailable)
+	})
+
+	t.Run("Zero total is given back without charging", func(t *testing.T) {
+		mockRepo.EXPECT().
+			StartCheckout(gomock.Any(), customerID).
+			Return(&model.Order{ID: int64(orderID), CustomerID: int64(customerID), Total: money.New(0, money.USD)}, nil)
+		mockRepo.EXPECT().AbandonCheckout(gomock.Any(), orderID, customerID, "order total is not payable").Return(nil)
+
+		_, err := orderService.PayOrder(context.Background(), customerID, payment.Card{Number: payment.FakeCardSuccess})
+
+		assert.ErrorIs(t, err, utils.ErrOrderNotPayable)
+		assertMetric(t, registry, "bookstore_orders_paid_total", 1)
+	})
+
+	t.Run("Paid order is returned even if it cannot be read back", func(t *testing.T) {
+		expectCheckout()
+		gomock.InOrder(
+			expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusAuthorized),
+			expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusCaptured),
+			mockRepo.EXPECT().CompleteCheckout(gomock.Any(), orderID, customerID).Return(nil),
+			mockRepo.EXPECT().GetOrder(gomock.Any(), orderID).Return(nil, errors.New("connection reset")),
+		)
+
+		paid, err := orderService.PayOrder(context.Background(), customerID, payment.Card{Number: payment.FakeCardSuccess})
+
+		assert.NoError(t, err)
+		assert.Equal(t, model.OrderStatePaid, paid.Order.State)
+		assert.Equal(t, total, paid.Order.Total)
+	})
+}
+
+func TestPayOrder_ProviderFailures(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	mockBookRepo := mocks.NewMockBookRepository(ctrl)
+	mockPaymentRepo := mocks.NewMockPaymentRepository(ctrl)
+	mockProvider := mocks.NewMockPaymentProvider(ctrl)
+	orderService := service.NewOrderService(mockRepo, mockBookRepo, mockPaymentRepo, mockProvider, nil, cancelWindow)
 
 	customerID := 1
+	orderID := 7
+	total := money.New(2500, money.USD)
+	card := payment.Card{Number: payment.FakeCardSuccess}
+
+	mockProvider.EXPECT().Name().Return("mock").AnyTimes()
+
+	expectAuthorized := func() {
+		mockRepo.EXPECT().
+			StartCheckout(gomock.Any(), customerID).
+			Return(&model.Order{ID: int64(orderID), Total: total}, nil)
+		mockPaymentRepo.EXPECT().CreatePayment(gomock.Any(), gomock.Any()).Return(nil)
+		mockProvider.EXPECT().
+			Authorize(gomock.Any(), payment.AuthorizeRequest{Reference: "order-7-payment-0", Amount: total, Card: card}).
+			Return("ref_1", nil)
+		expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusAuthorized)
+	}
+
+	t.Run("Failed capture voids the authorization", func(t *testing.T) {
+		expectAuthorized()
+		gomock.InOrder(
+			mockProvider.EXPECT().Capture(gomock.Any(), "ref_1", total).Return(utils.ErrPaymentFailed),
+			mockProvider.EXPECT().Void(gomock.Any(), "ref_1").Return(nil),
+			expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusVoided),
+			mockRepo.EXPECT().AbandonCheckout(gomock.Any(), orderID, customerID, "payment voided").Return(nil),
+		)
+
+		_, err := orderService.PayOrder(context.Background(), customerID, card)
+
+		assert.ErrorIs(t, err, utils.ErrPaymentFailed)
+	})
+
+	t.Run("Order that cannot be marked paid is refunded", func(t *testing.T) {
+		expectAuthorized()
+		gomock.InOrder(
+			mockProvider.EXPECT().Capture(gomock.Any(), "ref_1", total).Return(nil),
+			expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusCaptured),
+			mockRepo.EXPECT().CompleteCheckout(gomock.Any(), orderID, customerID).Return(utils.ErrOrderStateChanged),
+			mockProvider.EXPECT().Refund(gomock.Any(), "ref_1", total).Return(nil),
+			expectPaymentStatus(t, mockPaymentRepo, model.PaymentStatusRefunded),
+			mockRepo.EXPECT().
+				AbandonCheckout(gomock.Any(), orderID, customerID, "payment refunded").
+				Return(utils.ErrOrderStateChanged),
+		)
+
+		_, err := orderService.PayOrder(context.Background(), customerID, card)
+
+		assert.ErrorIs(t, err, utils.ErrOrderStateChanged)
+	})
+}
+
+// assertMetric checks the value of the series of name with the given label
+// pairs, a series never recorded counts as 0.
+func assertMetric(t *testing.T, registry *prometheus.Registry, name string, want float64, labels ...string) {
+	t.Helper()
+
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+
+	got := 0.0
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	series:
+		for _, metric := range family.GetMetric() {
+			for i := 0; i < len(labels); i += 2 {
+				found := false
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == labels[i] && label.GetValue() == labels[i+1] {
+						found = true
+					}
+				}
+				if !found {
+					continue series
+				}
+			}
+			got += metric.GetCounter().GetValue()
+		}
+	}
+	assert.Equal(t, want, got, name)
+}
+
+// expectPaymentStatus expects one UpdatePayment call storing the given status.
+func expectPaymentStatus(t *testing.T, repo *mocks.MockPaymentRepository, status model.PaymentStatus) *gomock.Call {
+	return repo.EXPECT().
+		UpdatePayment(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, p *model.Payment) error {
+			assert.Equal(t, status, p.Status)
+			return nil
+		})
+}
+
+func TestAdvanceOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	mockBookRepo := mocks.NewMockBookRepository(ctrl)
+	orderService := service.NewOrderService(mockRepo, mockBookRepo, nil, nil, nil, cancelWindow)
+
+	orderID, staffID := 7, 2
 
 	t.Run("Success", func(t *testing.T) {
-		mockRepo.EXPECT().PayOrder(customerID).Return(nil)
+		mockRepo.EXPECT().GetOrderState(gomock.Any(), orderID).Return(model.OrderStatePaid, nil)
+		mockRepo.EXPECT().TransitionOrder(gomock.Any(), &model.OrderStateTransition{
+			OrderID:   int64(orderID),
+			From:      model.OrderStatePaid,
+			To:        model.OrderStateFulfilling,
+			ChangedBy: int64(staffID),
+			Note:      "picking",
+		}).Return(nil)
 
-		err := orderService.PayOrder(customerID)
+		transition, err := orderService.AdvanceOrder(context.Background(), orderID, model.OrderStateFulfilling, staffID, "picking")
 
 		assert.NoError(t, err)
+		assert.Equal(t, model.OrderStatePaid, transition.From)
+		assert.Equal(t, model.OrderStateFulfilling, transition.To)
 	})
 
-	t.Run("Error", func(t *testing.T) {
-		mockRepo.EXPECT().PayOrder(customerID).Return(errors.New("payment error"))
+	t.Run("Illegal transition", func(t *testing.T) {
+		mockRepo.EXPECT().GetOrderState(gomock.Any(), orderID).Return(model.OrderStateShipped, nil)
 
-		err := orderService.PayOrder(customerID)
+		_, err := orderService.AdvanceOrder(context.Background(), orderID, model.OrderStatePaid, staffID, "")
 
-		assert.Error(t, err)
-		assert.EqualError(t, err, "payment error")
+		assert.ErrorIs(t, err, utils.ErrInvalidTransition)
+		var invalid *utils.InvalidTransitionError
+		assert.ErrorAs(t, err, &invalid)
+		assert.Equal(t, model.OrderStateShipped, invalid.Current)
+		assert.Equal(t, model.OrderStatePaid, invalid.Requested)
+	})
+
+	t.Run("Checkout moves are refused", func(t *testing.T) {
+		for current, to := range map[model.OrderState]model.OrderState{
+			model.OrderStateCart:           model.OrderStatePaid,
+			model.OrderStatePendingPayment: model.OrderStateCart,
+		} {
+			mockRepo.EXPECT().GetOrderState(gomock.Any(), orderID).Return(current, nil)
+
+			_, err := orderService.AdvanceOrder(context.Background(), orderID, to, staffID, "")
+
+			assert.ErrorIs(t, err, utils.ErrInvalidTransition)
+		}
+	})
+
+	t.Run("Concurrent change is rechecked", func(t *testing.T) {
+		gomock.InOrder(
+			mockRepo.EXPECT().GetOrderState(gomock.Any(), orderID).Return(model.OrderStatePaid, nil),
+			mockRepo.EXPECT().TransitionOrder(gomock.Any(), gomock.Any()).Return(utils.ErrOrderStateChanged),
+			mockRepo.EXPECT().GetOrderState(gomock.Any(), orderID).Return(model.OrderStateRefunded, nil),
+		)
+
+		_, err := orderService.AdvanceOrder(context.Background(), orderID, model.OrderStateFulfilling, staffID, "")
+
+		var invalid *utils.InvalidTransitionError
+		assert.ErrorAs(t, err, &invalid)
+		assert.Equal(t, model.OrderStateRefunded, invalid.Current)
+	})
+
+	t.Run("Order not found", func(t *testing.T) {
+		mockRepo.EXPECT().GetOrderState(gomock.Any(), orderID).Return(model.OrderState(0), utils.ErrOrderNotFound)
+
+		_, err := orderService.AdvanceOrder(context.Background(), orderID, model.OrderStateShipped, staffID, "")
+
+		assert.ErrorIs(t, err, utils.ErrOrderNotFound)
+	})
+}
+
+func TestCancelPendingOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockOrderRepository(ctrl)
+	orderService := service.NewOrderService(mockRepo, nil, nil, nil, nil, cancelWindow)
+
+	orderID, adminID := 7, 2
+
+	t.Run("Stuck order is cancelled", func(t *testing.T) {
+		mockRepo.EXPECT().GetOrderState(gomock.Any(), orderID).Return(model.OrderStatePendingPayment, nil)
+		mockRepo.EXPECT().
+			CancelCheckout(gomock.Any(), &model.OrderStateTransition{
+				OrderID:   int64(orderID),
+				ChangedBy: int64(adminID),
+				Note:      "refunded at the provider",
+			}).
+			DoAndReturn(func(_ context.Context, transition *model.OrderStateTransition) error {
+				transition.From = model.OrderStatePendingPayment
+				transition.To = model.OrderStateCancelled
+				return nil
+			})
+
+		transition, err := orderService.CancelPendingOrder(context.Background(), orderID, adminID, "refunded at the provider")
+
+		assert.NoError(t, err)
+		assert.Equal(t, model.OrderStatePendingPayment, transition.From)
+		assert.Equal(t, model.OrderStateCancelled, transition.To)
 	})
+
+	t.Run("Only pending orders", func(t *testing.T) {
+		mockRepo.EXPECT().GetOrderState(gomock.Any(), orderID).Return(model.OrderStatePaid, nil)
+
+		_, err := orderService.CancelPendingOrder(context.Background(), orderID, adminID, "stuck")
+
+		var invalid *utils.InvalidTransitionError
+		assert.ErrorAs(t, err, &invalid)
+		assert.Equal(t, model.OrderStatePaid, invalid.Current)
+	})
+
+	t.Run("Order paid meanwhile", func(t *testing.T) {
+		gomock.InOrder(
+			mockRepo.EXPECT().GetOrderState(gomock.Any(), orderID).Return(model.OrderStatePendingPayment, nil),
+			mockRepo.EXPECT().CancelCheckout(gomock.Any(), gomock.Any()).Return(utils.ErrOrderStateChanged),
+			mockRepo.EXPECT().GetOrderState(gomock.Any(), orderID).Return(model.OrderStatePaid, nil),
+		)
+
+		_, err := orderService.CancelPendingOrder(context.Background(), orderID, adminID, "stuck")
+
+		var invalid *utils.InvalidTransitionError
+		assert.ErrorAs(t, err, &invalid)
+		assert.Equal(t, model.OrderStatePaid, invalid.Current)
+	})
+}
+
+func TestCanAdvance(t *testing.T) {
+	assert.True(t, service.CanAdvance(model.OrderStatePaid, model.OrderStateFulfilling))
+	assert.True(t, service.CanAdvance(model.OrderStateFulfilling, model.OrderStateShipped))
+	assert.True(t, service.CanAdvance(model.OrderStateShipped, model.OrderStateDelivered))
+
+	// payment and checkout moves keep stock and money in line, staff cannot make them
+	assert.False(t, service.CanAdvance(model.OrderStateCart, model.OrderStatePaid))
+	assert.False(t, service.CanAdvance(model.OrderStatePendingPayment, model.OrderStatePaid))
+	assert.False(t, service.CanAdvance(model.OrderStatePendingPayment, model.OrderStateCart))
+	assert.False(t, service.CanAdvance(model.OrderStatePaid, model.OrderStateShipped))
+}
+
+func TestCanTransition(t *testing.T) {
+	assert.True(t, service.CanTransition(model.OrderStateCart, model.OrderStatePaid))
+	assert.True(t, service.CanTransition(model.OrderStateShipped, model.OrderStateDelivered))
+	assert.True(t, service.CanTransition(model.OrderStateCancelled, model.OrderStateRefunded))
+
+	assert.False(t, service.CanTransition(model.OrderStateDelivered, model.OrderStateCancelled))
+	assert.False(t, service.CanTransition(model.OrderStateShipped, model.OrderStateCancelled))
+	assert.False(t, service.CanTransition(model.OrderStatePaid, model.OrderStateCart))
+
+	for _, to := range []model.OrderState{
+		model.OrderStateCart, model.OrderStatePaid, model.OrderStateCancelled, model.OrderStateRefunded,
+	} {
+		assert.False(t, service.CanTransition(model.OrderStateRefunded, to), "refunded is terminal")
+	}
 }
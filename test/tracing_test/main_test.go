@@ -0,0 +1,48 @@
+package tracing_test
+
+import (
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recorder keeps every span ended by the tests in memory, seen counts the
+// spans already returned by endedSpans.
+var (
+	recorder = tracetest.NewSpanRecorder()
+	seen     int
+)
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	os.Exit(m.Run())
+}
+
+// endedSpans returns the spans ended since the last call.
+func endedSpans() []sdktrace.ReadOnlySpan {
+	spans := recorder.Ended()[seen:]
+	seen += len(spans)
+	return spans
+}
+
+func findSpan(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[string]string {
+	values := map[string]string{}
+	for _, attr := range span.Attributes() {
+		values[string(attr.Key)] = attr.Value.Emit()
+	}
+	return values
+}
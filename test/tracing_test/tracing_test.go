@@ -0,0 +1,165 @@
+package tracing_test
+
+import (
+	"bookstore/internal/middleware"
+	"bookstore/internal/model"
+	"bookstore/internal/repository"
+	"bookstore/internal/service"
+	"bookstore/internal/tracing"
+	"bookstore/test/mocks"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing_Request(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	books := mocks.NewMockBookService(ctrl)
+	svc := service.TraceBookService(books)
+
+	router := gin.New()
+	router.Use(middleware.Tracing())
+	router.GET("/books/:id", func(c *gin.Context) {
+		if _, err := svc.GetBookById(c.Request.Context(), 42); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	send := func(traceparent string) *httptest.ResponseRecorder {
+		endedSpans()
+		req, _ := http.NewRequest(http.MethodGet, "/books/42", http.NoBody)
+		if traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("incoming trace context is continued", func(t *testing.T) {
+		books.EXPECT().GetBookById(gomock.Any(), 42).Return(&model.Book{ID: 42}, nil)
+
+		send("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+		spans := endedSpans()
+		request := findSpan(spans, "GET /books/:id")
+		require.NotNil(t, request)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", request.Parent().SpanID().String())
+		assert.Equal(t, trace.SpanKindServer, request.SpanKind())
+		assert.Equal(t, "/books/:id", attributes(request)["http.route"])
+		assert.Equal(t, "200", attributes(request)["http.response.status_code"])
+
+		call := findSpan(spans, "BookService.GetBookById")
+		require.NotNil(t, call)
+		assert.Equal(t, request.SpanContext().SpanID(), call.Parent().SpanID())
+		assert.Equal(t, "42", attributes(call)["book.id"])
+	})
+
+	t.Run("new trace without incoming context", func(t *testing.T) {
+		books.EXPECT().GetBookById(gomock.Any(), 42).Return(&model.Book{ID: 42}, nil)
+
+		send("")
+
+		request := findSpan(endedSpans(), "GET /books/:id")
+		require.NotNil(t, request)
+		assert.False(t, request.Parent().IsValid())
+	})
+
+	t.Run("errors mark the spans", func(t *testing.T) {
+		books.EXPECT().GetBookById(gomock.Any(), 42).Return(nil, sql.ErrConnDone)
+
+		send("")
+
+		spans := endedSpans()
+		assert.Equal(t, codes.Error, findSpan(spans, "GET /books/:id").Status().Code)
+		call := findSpan(spans, "BookService.GetBookById")
+		assert.Equal(t, codes.Error, call.Status().Code)
+		assert.Equal(t, sql.ErrConnDone.Error(), call.Status().Description)
+	})
+}
+
+func TestTracing_SQL(t *testing.T) {
+	mockDB, mock, err := sqlmock.NewWithDSN("tracing_test")
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	// the repository talks to sqlmock through the instrumented driver
+	sql.Register("sqlmock-traced", tracing.WrapDriver(mockDB.Driver()))
+	db, err := sql.Open("sqlmock-traced", "tracing_test")
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := repository.NewBookRepository(db)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, author, price, stock, archived_at FROM books WHERE id = $1`)).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "price", "stock", "archived_at"}).
+			AddRow(7, "Dune", "Frank Herbert", 1500, 3, nil))
+
+	endedSpans()
+	_, err = repo.GetBookById(context.Background(), 7)
+	require.NoError(t, err)
+
+	query := findSpan(endedSpans(), "sql.conn.query")
+	require.NotNil(t, query)
+	attrs := attributes(query)
+	assert.Equal(t, "postgresql", attrs["db.system"])
+	assert.Equal(t, "SELECT id, title, author, price, stock, archived_at FROM books WHERE id = $1", attrs["db.query.text"])
+	assert.NotContains(t, attrs, "db.statement")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSanitizeSQL(t *testing.T) {
+	tests := map[string]struct {
+		query string
+		want  string
+	}{
+		"placeholders are kept": {
+			query: "SELECT id FROM orders\n\t\tWHERE customer_id = $1 AND order_state = $2",
+			want:  "SELECT id FROM orders WHERE customer_id = $1 AND order_state = $2",
+		},
+		"literals are replaced": {
+			query: "UPDATE customers SET email = 'a@b.c', password = 'it''s' WHERE id = 12 AND total > 9.99",
+			want:  "UPDATE customers SET email = ?, password = ? WHERE id = ? AND total > ?",
+		},
+		"identifiers with digits are kept": {
+			query: "SELECT o2.id FROM orders o2 LIMIT 10",
+			want:  "SELECT o2.id FROM orders o2 LIMIT ?",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tracing.SanitizeSQL(tt.query))
+		})
+	}
+}
+
+func TestSetup_InvalidExporter(t *testing.T) {
+	t.Run("unknown exporter", func(t *testing.T) {
+		_, err := tracing.Setup(context.Background(), tracing.Config{Exporter: "jaeger"})
+		assert.ErrorContains(t, err, `unknown trace exporter "jaeger"`)
+	})
+
+	t.Run("file exporter without a file", func(t *testing.T) {
+		_, err := tracing.Setup(context.Background(), tracing.Config{Exporter: tracing.ExporterFile})
+		assert.ErrorContains(t, err, "needs a file")
+	})
+}
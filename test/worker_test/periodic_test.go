@@ -0,0 +1,53 @@
+package worker_test
+
+import (
+	"bookstore/internal/worker"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriodic_RunsUntilStopped(t *testing.T) {
+	var runs atomic.Int32
+	ran := make(chan struct{}, 10)
+	p := worker.NewPeriodic("test", 5*time.Millisecond, func(ctx context.Context) error {
+		runs.Add(1)
+		ran <- struct{}{}
+		return errors.New("failures do not stop the worker")
+	})
+
+	p.Start()
+	<-ran
+	<-ran
+	assert.NoError(t, p.Stop())
+
+	stopped := runs.Load()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, stopped, runs.Load(), "no run after Stop")
+}
+
+func TestPeriodic_StopWaitsForRunningJob(t *testing.T) {
+	started := make(chan struct{})
+	finished := false
+	p := worker.NewPeriodic("test", time.Hour, func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		finished = true
+		return ctx.Err()
+	})
+
+	p.Start()
+	<-started
+	assert.NoError(t, p.Stop())
+
+	assert.True(t, finished, "Stop returned while the job was running")
+}
+
+func TestPeriodic_StopWithoutStart(t *testing.T) {
+	assert.NoError(t, worker.NewPeriodic("test", time.Hour, nil).Stop())
+}